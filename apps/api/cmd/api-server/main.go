@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -20,12 +22,14 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "restore-snapshot" {
+		restoreSnapshot(os.Args[2:])
+		return
+	}
+
 	secrets := common.GetSecrets()
 
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = filepath.Join("data", "velld.db")
-	}
+	dbPath := databasePath()
 
 	dbDir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
@@ -102,6 +106,7 @@ func main() {
 
 	backupService := backup.NewBackupService(
 		connRepo,
+		connManager,
 		"./backups",
 		backupRepo,
 		settingsService,
@@ -110,20 +115,27 @@ func main() {
 		s3ProviderService,
 	)
 
+	backupService.ScheduleSelfBackups(dbPath)
+
 	backupHandler := backup.NewBackupHandler(backupService)
 
 	protected.HandleFunc("/backups/stats", backupHandler.GetBackupStats).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/active", backupHandler.GetActiveBackups).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/backups/tombstones", backupHandler.GetTombstones).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/backups/bulk-delete", backupHandler.DeleteBackups).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups/schedule", backupHandler.ScheduleBackup).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups", backupHandler.CreateBackup).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups", backupHandler.ListBackups).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}", backupHandler.GetBackup).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/backups/{id}", backupHandler.DeleteBackup).Methods("DELETE", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/download", backupHandler.DownloadBackup).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/s3-providers", backupHandler.GetBackupS3Providers).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/share", backupHandler.CreateShareableLink).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/logs", backupHandler.StreamBackupLogs).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/logs/stored", backupHandler.GetBackupLogs).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{id}/stop", backupHandler.StopBackup).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/backups/{id}/pin", backupHandler.PinBackup).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/backups/{id}/pin", backupHandler.UnpinBackup).Methods("DELETE", "OPTIONS")
 	protected.HandleFunc("/backups/restore", backupHandler.RestoreBackup).Methods("POST", "OPTIONS")
 	
 	// Public route for shareable links (no auth required)
@@ -131,6 +143,7 @@ func main() {
 	protected.HandleFunc("/backups/compare/{sourceId}/{targetId}", backupHandler.CompareBackups).Methods("GET", "OPTIONS")
 	protected.HandleFunc("/backups/{connection_id}/schedule/disable", backupHandler.DisableBackupSchedule).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/backups/{connection_id}/schedule", backupHandler.UpdateBackupSchedule).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/backups/{connection_id}/schedule/retention/dry-run", backupHandler.DryRunRetention).Methods("POST", "OPTIONS")
 
 	settingsHandler := settings.NewSettingsHandler(settingsService)
 
@@ -139,6 +152,10 @@ func main() {
 	protected.HandleFunc("/settings/test-s3", settingsHandler.TestS3Connection).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/settings/test-telegram", settingsHandler.TestTelegramConnection).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/settings/telegram-chats", settingsHandler.GetTelegramChats).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/settings/self-backup", backupHandler.GetSelfBackupSettings).Methods("GET", "OPTIONS")
+	protected.HandleFunc("/settings/self-backup", backupHandler.UpdateSelfBackupSettings).Methods("PUT", "OPTIONS")
+	protected.HandleFunc("/settings/self-backup/run", backupHandler.RunSelfBackup).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/settings/self-backup/snapshots", backupHandler.ListSelfBackups).Methods("GET", "OPTIONS")
 
 	s3ProviderHandler := backup.NewS3ProviderHandler(s3ProviderService)
 	protected.HandleFunc("/s3-providers", s3ProviderHandler.ListS3Providers).Methods("GET", "OPTIONS")
@@ -148,6 +165,8 @@ func main() {
 	protected.HandleFunc("/s3-providers/{id}", s3ProviderHandler.DeleteS3Provider).Methods("DELETE", "OPTIONS")
 	protected.HandleFunc("/s3-providers/{id}/set-default", s3ProviderHandler.SetDefaultProvider).Methods("POST", "OPTIONS")
 	protected.HandleFunc("/s3-providers/{id}/test", s3ProviderHandler.TestS3Provider).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/s3-providers/{id}/reconcile", backupHandler.ReconcileProvider).Methods("POST", "OPTIONS")
+	protected.HandleFunc("/s3-providers/{id}/import", backupHandler.ImportCatalog).Methods("POST", "OPTIONS")
 
 	notificationService := notification.NewNotificationService(notificationRepo)
 	notificationHandler := notification.NewNotificationHandler(notificationService)
@@ -160,3 +179,48 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// databasePath returns where velld's database is kept: DB_PATH, or data/velld.db
+func databasePath() string {
+	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+	return filepath.Join("data", "velld.db")
+}
+
+// restoreSnapshot restores velld's database from a snapshot its self-backup took,
+// decrypting it with ENCRYPTION_KEY. Velld must be stopped while it runs.
+func restoreSnapshot(args []string) {
+	flags := flag.NewFlagSet("restore-snapshot", flag.ExitOnError)
+	dbPath := flags.String("db", databasePath(), "database to restore to")
+	force := flags.Bool("force", false, "replace the database when it exists")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: main restore-snapshot [-db path] [-force] <snapshot file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	encryptionKey, err := common.GetEncryptionKey()
+	if err != nil {
+		log.Fatal("ENCRYPTION_KEY must be set to the key the snapshot was taken with")
+	}
+	cryptoService, err := common.NewEncryptionService(encryptionKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	snapshot, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	if err := backup.RestoreSelfBackup(snapshot, cryptoService, *dbPath, *force); err != nil {
+		log.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	log.Printf("Restored %s from %s; start velld to migrate it to the current schema", *dbPath, flags.Arg(0))
+}
@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+)
+
+const (
+	// azureBlockSize is the size of the blocks a stream is staged in. Blobs hold up to
+	// 50000 blocks, so streams of up to about 780 GiB fit.
+	azureBlockSize = multipartPartSize
+	// azureUploadConcurrency blocks are staged at once; each is buffered in memory
+	azureUploadConcurrency = 4
+)
+
+// AzureConfig is the account, login and container of an Azure Blob Storage
+// destination. Endpoint is the service URL, for Azurite or sovereign clouds; it
+// defaults to the account's public endpoint.
+type AzureConfig struct {
+	Endpoint    string
+	AccountName string
+	AccountKey  string
+	SASToken    string
+	Container   string
+	Prefix      string
+}
+
+// AzureStorage stores backups as block blobs in an Azure Blob Storage container
+type AzureStorage struct {
+	client    *container.Client
+	container string
+	prefix    string
+	// sharedKey is set when the client signs in with the account key, which is needed
+	// to sign presigned URLs
+	sharedKey bool
+	blockSize int64
+}
+
+func NewAzureStorage(config AzureConfig) (*AzureStorage, error) {
+	config.AccountName = strings.TrimSpace(config.AccountName)
+	config.Container = strings.TrimSpace(config.Container)
+	if config.Container == "" {
+		return nil, fmt.Errorf("Azure container is required")
+	}
+
+	serviceURL := strings.TrimSpace(config.Endpoint)
+	if serviceURL == "" {
+		if config.AccountName == "" {
+			return nil, fmt.Errorf("Azure account name is required")
+		}
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net", config.AccountName)
+	} else if !strings.Contains(serviceURL, "://") {
+		serviceURL = "https://" + serviceURL
+	}
+	containerURL := strings.TrimSuffix(serviceURL, "/") + "/" + config.Container
+
+	// Requests are retried by the SDK with exponential backoff
+	options := &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Retry: policy.RetryOptions{
+				MaxRetries: uploadRetries,
+				RetryDelay: uploadRetryDelay,
+			},
+		},
+	}
+
+	var client *container.Client
+	var err error
+	switch {
+	case config.SASToken != "":
+		client, err = container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(config.SASToken, "?"), options)
+	case config.AccountKey != "":
+		if config.AccountName == "" {
+			return nil, fmt.Errorf("Azure account name is required")
+		}
+		credential, credErr := container.NewSharedKeyCredential(config.AccountName, config.AccountKey)
+		if credErr != nil {
+			return nil, fmt.Errorf("invalid Azure account key: %w", credErr)
+		}
+		client, err = container.NewClientWithSharedKeyCredential(containerURL, credential, options)
+	default:
+		return nil, fmt.Errorf("Azure account key or SAS token is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+
+	return &AzureStorage{
+		client:    client,
+		container: config.Container,
+		prefix:    config.Prefix,
+		sharedKey: config.SASToken == "",
+		blockSize: azureBlockSize,
+	}, nil
+}
+
+// newProviderAzureStorage creates an Azure client for a provider
+func newProviderAzureStorage(provider *S3Provider) (*AzureStorage, error) {
+	prefix := ""
+	if provider.PathPrefix != nil {
+		prefix = strings.TrimSpace(*provider.PathPrefix)
+	}
+
+	return NewAzureStorage(AzureConfig{
+		Endpoint:    provider.Endpoint,
+		AccountName: provider.AccessKey,
+		AccountKey:  provider.SecretKey,
+		SASToken:    provider.SASToken,
+		Container:   provider.Bucket,
+		Prefix:      prefix,
+	})
+}
+
+func (s *AzureStorage) UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error) {
+	if logFunc != nil {
+		logFunc("[INFO] Opening backup file for upload...")
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Failed to open file: %v", err))
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, filepath.Base(localPath), connectionNameFromPath(localPath), "application/octet-stream", logFunc)
+}
+
+// UploadStream stages reader as the blocks of a block blob and commits them once
+// the stream ends. Blocks of an upload that fails are never committed, and Azure
+// discards them after a week.
+func (s *AzureStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	finalKey := joinObjectKey(s.prefix, connectionName, objectKey)
+
+	if logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] Starting streaming upload to Azure container '%s' with blob '%s'...", s.container, finalKey))
+	}
+
+	_, err := s.client.NewBlockBlobClient(finalKey).UploadStream(ctx, reader, &blockblob.UploadStreamOptions{
+		BlockSize:   s.blockSize,
+		Concurrency: azureUploadConcurrency,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+	})
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Azure streaming upload failed: %v", err))
+		}
+		return "", fmt.Errorf("failed to stream upload to Azure: %w", err)
+	}
+
+	if logFunc != nil {
+		logFunc("[INFO] Streaming upload completed successfully")
+		logFunc(fmt.Sprintf("[INFO] File available at: azure://%s/%s", s.container, finalKey))
+	}
+
+	return finalKey, nil
+}
+
+// GetObject returns an io.ReadCloser for streaming download from Azure. Reads that
+// fail midway resume from where they stopped.
+func (s *AzureStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return s.GetObjectRange(ctx, objectKey, 0, -1)
+}
+
+// GetObjectRange returns an io.ReadCloser for streaming part of a blob from Azure
+func (s *AzureStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	// A count of 0 reads to the end of the blob
+	options := &blob.DownloadStreamOptions{Range: blob.HTTPRange{Offset: offset}}
+	if length > 0 {
+		options.Range.Count = length
+	}
+
+	response, err := s.client.NewBlobClient(objectKey).DownloadStream(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob from Azure: %w", err)
+	}
+	return response.NewRetryReader(ctx, &blob.RetryReaderOptions{MaxRetries: uploadRetries}), nil
+}
+
+func (s *AzureStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	_, err := s.client.NewBlobClient(objectKey).Delete(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete blob from Azure: %w", err)
+	}
+	return nil
+}
+
+func (s *AzureStorage) ListFiles(ctx context.Context) ([]string, error) {
+	var files []string
+
+	options := &container.ListBlobsFlatOptions{}
+	if s.prefix != "" {
+		options.Prefix = &s.prefix
+	}
+
+	pager := s.client.NewListBlobsFlatPager(options)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list blobs: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name != nil {
+				files = append(files, *item.Name)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func (s *AzureStorage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
+	properties, err := s.client.NewBlobClient(objectKey).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+		}
+		return nil, fmt.Errorf("failed to get blob properties: %w", err)
+	}
+
+	info := &ObjectInfo{Key: objectKey}
+	if properties.ContentLength != nil {
+		info.Size = *properties.ContentLength
+	}
+	if properties.LastModified != nil {
+		info.LastModified = *properties.LastModified
+	}
+	return info, nil
+}
+
+// PresignGetObject returns a read-only SAS URL for the blob. It is signed with the
+// account key, so providers using a SAS token can't hand them out.
+func (s *AzureStorage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	if !s.sharedKey {
+		return "", ErrPresignNotSupported
+	}
+
+	sasURL, err := s.client.NewBlobClient(objectKey).GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(expiry), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign blob: %w", err)
+	}
+	return sasURL, nil
+}
+
+// TestConnection lists the container, which SAS tokens for backups must be allowed
+// to do anyway for retention cleanup
+func (s *AzureStorage) TestConnection(ctx context.Context) error {
+	maxResults := int32(1)
+	pager := s.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{MaxResults: &maxResults})
+	if _, err := pager.NextPage(ctx); err != nil {
+		return fmt.Errorf("failed to access container %s: %w", s.container, err)
+	}
+	return nil
+}
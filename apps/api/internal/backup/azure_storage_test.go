@@ -0,0 +1,396 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAzureAccount   = "devstoreaccount1"
+	testAzureContainer = "backups"
+	// testAzureKey is Azurite's well-known account key
+	testAzureKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	testAzureSAS = "sv=2022-11-02&ss=b&srt=sco&sp=rwdlac&sig=c2lnbmF0dXJl"
+)
+
+// azureBlob is a committed blob of the fake Blob service
+type azureBlob struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// fakeAzureBlobs serves the Blob REST calls AzureStorage makes for one container,
+// as Azurite does at /devstoreaccount1/backups. Requests must carry a shared-key
+// signature of the account or the SAS token's signature.
+type fakeAzureBlobs struct {
+	mu     sync.Mutex
+	blobs  map[string]*azureBlob
+	staged map[string]map[string][]byte
+	// requests counts the requests of each kind, such as "stage block" or "commit"
+	requests map[string]int
+	// auths counts the requests signed with a shared key and with the SAS token
+	auths map[string]int
+}
+
+func newFakeAzureBlobs(t *testing.T) (*fakeAzureBlobs, string) {
+	t.Helper()
+	fake := &fakeAzureBlobs{
+		blobs:    make(map[string]*azureBlob),
+		staged:   make(map[string]map[string][]byte),
+		requests: make(map[string]int),
+		auths:    make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL + "/" + testAzureAccount
+}
+
+func (f *fakeAzureBlobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch authorization := r.Header.Get("Authorization"); {
+	case strings.HasPrefix(authorization, "SharedKey "+testAzureAccount+":"):
+		f.auths["shared key"]++
+	case authorization == "" && query.Get("sig") == "c2lnbmF0dXJl":
+		f.auths["sas"]++
+	default:
+		azureError(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	containerPath := "/" + testAzureAccount + "/" + testAzureContainer
+	if r.URL.Path == containerPath {
+		if r.Method != http.MethodGet || query.Get("comp") != "list" {
+			azureError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+			return
+		}
+		f.list(w, query.Get("prefix"))
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, containerPath+"/")
+	if !ok {
+		azureError(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		f.requests["stage block"]++
+		data, _ := io.ReadAll(r.Body)
+		if f.staged[name] == nil {
+			f.staged[name] = make(map[string][]byte)
+		}
+		f.staged[name][query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		f.requests["commit"]++
+		var blockList struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&blockList); err != nil {
+			azureError(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var data []byte
+		for _, id := range blockList.Latest {
+			block, ok := f.staged[name][id]
+			if !ok {
+				azureError(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data = append(data, block...)
+		}
+		delete(f.staged, name)
+		f.commit(w, name, data, r.Header.Get("x-ms-blob-content-type"))
+	case r.Method == http.MethodPut:
+		f.requests["put blob"]++
+		data, _ := io.ReadAll(r.Body)
+		f.commit(w, name, data, r.Header.Get("x-ms-blob-content-type"))
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		blob, ok := f.blobs[name]
+		if !ok {
+			azureError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		f.get(w, r, blob)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			azureError(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		azureError(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func (f *fakeAzureBlobs) commit(w http.ResponseWriter, name string, data []byte, contentType string) {
+	blob := &azureBlob{data: data, contentType: contentType, lastModified: time.Now().UTC().Truncate(time.Second)}
+	f.blobs[name] = blob
+	w.Header().Set("ETag", `"`+strconv.Itoa(len(f.blobs))+`"`)
+	w.Header().Set("Last-Modified", blob.lastModified.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// get serves a blob's properties or content, or the range of it in x-ms-range
+func (f *fakeAzureBlobs) get(w http.ResponseWriter, r *http.Request, blob *azureBlob) {
+	w.Header().Set("ETag", `"blob"`)
+	w.Header().Set("Last-Modified", blob.lastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Type", blob.contentType)
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+
+	data, status := blob.data, http.StatusOK
+	if spec, ok := strings.CutPrefix(r.Header.Get("x-ms-range"), "bytes="); ok {
+		first, last, _ := strings.Cut(spec, "-")
+		start, _ := strconv.Atoi(first)
+		end := len(data) - 1
+		if last != "" {
+			end, _ = strconv.Atoi(last)
+			end = min(end, len(data)-1)
+		}
+		f.requests["ranged read"]++
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data, status = data[start:end+1], http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func (f *fakeAzureBlobs) list(w http.ResponseWriter, prefix string) {
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, name := range names {
+		fmt.Fprintf(&body, "<Blob><Name>%s</Name><Properties><Content-Length>%d</Content-Length><BlobType>BlockBlob</BlobType></Properties></Blob>", name, len(f.blobs[name].data))
+	}
+	body.WriteString(`</Blobs><NextMarker /></EnumerationResults>`)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body.Bytes())
+}
+
+func azureError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// newTestAzureStorage returns a client of the fake Blob service at endpoint, staging
+// the smallest blocks the SDK allows
+func newTestAzureStorage(t *testing.T, config AzureConfig) *AzureStorage {
+	t.Helper()
+	config.Container = testAzureContainer
+	storage, err := NewAzureStorage(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.blockSize = 1 << 20
+	return storage
+}
+
+func readTestBlob(t *testing.T, storage *AzureStorage, objectKey string, offset, length int64) []byte {
+	t.Helper()
+	reader, err := storage.GetObjectRange(context.Background(), objectKey, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAzureStorageObjects(t *testing.T) {
+	fake, endpoint := newFakeAzureBlobs(t)
+	storage := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, AccountName: testAzureAccount, AccountKey: testAzureKey, Prefix: "velld"})
+	other := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, AccountName: testAzureAccount, AccountKey: testAzureKey, Prefix: "other"})
+	ctx := context.Background()
+
+	// Three full blocks and a partial one, committed together once the stream ends
+	data := randomBytes(t, 3<<20+512<<10)
+	key, err := storage.UploadStream(ctx, bytes.NewReader(data), "app_20250101_000000.sql.gz", "app", "application/gzip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "velld/app/app_20250101_000000.sql.gz" {
+		t.Errorf("uploaded to %q", key)
+	}
+	if fake.requests["stage block"] != 4 || fake.requests["commit"] != 1 {
+		t.Errorf("staged %d blocks in %d commits, want 4 in 1", fake.requests["stage block"], fake.requests["commit"])
+	}
+	if blob := fake.blobs[key]; blob == nil || !bytes.Equal(blob.data, data) || blob.contentType != "application/gzip" {
+		t.Error("committed blob differs from the upload")
+	}
+	if len(fake.staged) != 0 {
+		t.Errorf("blocks of %d blobs were left uncommitted", len(fake.staged))
+	}
+
+	// A stream smaller than a block is uploaded in one request
+	if _, err := storage.UploadStream(ctx, strings.NewReader("{}"), "app_20250101_000000.sql.gz"+manifestSuffix, "app", "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests["put blob"] != 1 {
+		t.Errorf("small stream was uploaded in %d requests, want 1", fake.requests["put blob"])
+	}
+	if _, err := other.UploadStream(ctx, strings.NewReader("other"), "dump.sql", "tool", "application/sql", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := storage.ListFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{key, key + manifestSuffix}; strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("listed %v, want %v", files, want)
+	}
+
+	info, err := storage.StatObject(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(data)) || info.LastModified.IsZero() {
+		t.Errorf("stat returned %+v, want a size of %d", info, len(data))
+	}
+	if !bytes.Equal(readTestBlob(t, storage, key, 0, -1), data) {
+		t.Error("downloaded blob differs from the upload")
+	}
+	if !bytes.Equal(readTestBlob(t, storage, key, 1<<20-500, 1000), data[1<<20-500:1<<20+500]) {
+		t.Error("ranged read differs from the upload")
+	}
+	if !bytes.Equal(readTestBlob(t, storage, key, 3<<20, -1), data[3<<20:]) {
+		t.Error("read from an offset differs from the upload")
+	}
+	if got := readTestBlob(t, storage, key, 10, 0); len(got) != 0 {
+		t.Errorf("read of no bytes returned %d", len(got))
+	}
+	if fake.requests["ranged read"] != 2 {
+		t.Errorf("sent %d ranged reads, want 2", fake.requests["ranged read"])
+	}
+
+	if err := storage.DeleteFile(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.StatObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat of a deleted blob returned %v, want %v", err, ErrObjectNotFound)
+	}
+	if _, err := storage.GetObject(ctx, key); err == nil {
+		t.Error("download of a deleted blob succeeded")
+	}
+	if err := storage.DeleteFile(ctx, key); err == nil {
+		t.Error("deleting a deleted blob succeeded")
+	}
+	if err := storage.TestConnection(ctx); err != nil {
+		t.Errorf("connection test failed: %v", err)
+	}
+}
+
+func TestAzureStorageAbortsFailedUpload(t *testing.T) {
+	fake, endpoint := newFakeAzureBlobs(t)
+	storage := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, AccountName: testAzureAccount, AccountKey: testAzureKey})
+	ctx := context.Background()
+
+	reader := &failingReader{data: bytes.NewReader(randomBytes(t, 2<<20+1000))}
+	if _, err := storage.UploadStream(ctx, reader, "app_20250101_000000.sql.gz", "app", "application/gzip", nil); err == nil {
+		t.Fatal("upload of a failing stream succeeded")
+	}
+	if fake.requests["commit"] != 0 {
+		t.Errorf("blocks of a failed upload were committed %d times", fake.requests["commit"])
+	}
+	if _, err := storage.StatObject(ctx, "app/app_20250101_000000.sql.gz"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat after a failed upload returned %v, want %v", err, ErrObjectNotFound)
+	}
+}
+
+func TestAzureStorageAuth(t *testing.T) {
+	fake, endpoint := newFakeAzureBlobs(t)
+	ctx := context.Background()
+
+	// An account key signs every request, and presigned URLs
+	sharedKey := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, AccountName: testAzureAccount, AccountKey: testAzureKey})
+	key, err := sharedKey.UploadStream(ctx, strings.NewReader("dump"), "app_20250101_000000.sql", "app", "application/sql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sharedKey.StatObject(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if fake.auths["shared key"] != 2 || fake.auths["sas"] != 0 {
+		t.Errorf("requests were signed %v, want 2 with the shared key", fake.auths)
+	}
+
+	presigned, err := sharedKey.PresignGetObject(ctx, key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := endpoint + "/" + testAzureContainer + "/" + key; parsed.Scheme+"://"+parsed.Host+parsed.Path != want {
+		t.Errorf("presigned URL %s doesn't point at %s", presigned, want)
+	}
+	if query := parsed.Query(); query.Get("sp") != "r" || query.Get("sig") == "" {
+		t.Errorf("presigned URL %s isn't a read-only SAS", presigned)
+	}
+
+	// A SAS token is sent with every request in place of a signature, and can't sign
+	// URLs of its own
+	clear(fake.auths)
+	sas := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, SASToken: "?" + testAzureSAS})
+	if err := sas.TestConnection(ctx); err != nil {
+		t.Fatalf("connection test with a SAS token failed: %v", err)
+	}
+	if data := readTestBlob(t, sas, key, 0, -1); string(data) != "dump" {
+		t.Errorf("downloaded %q with a SAS token", data)
+	}
+	if fake.auths["sas"] != 2 || fake.auths["shared key"] != 0 {
+		t.Errorf("requests were signed %v, want 2 with the SAS token", fake.auths)
+	}
+	if _, err := sas.PresignGetObject(ctx, key, time.Hour); !errors.Is(err, ErrPresignNotSupported) {
+		t.Errorf("presign with a SAS token returned %v, want %v", err, ErrPresignNotSupported)
+	}
+
+	wrongSAS := newTestAzureStorage(t, AzureConfig{Endpoint: endpoint, SASToken: strings.Replace(testAzureSAS, "sig=", "sig=x", 1)})
+	if err := wrongSAS.TestConnection(ctx); err == nil {
+		t.Error("connection test with a wrong SAS token succeeded")
+	}
+
+	for _, config := range []AzureConfig{
+		{Endpoint: endpoint, Container: testAzureContainer},
+		{Endpoint: endpoint, AccountKey: testAzureKey, Container: testAzureContainer},
+		{AccountName: testAzureAccount, AccountKey: testAzureKey},
+	} {
+		if _, err := NewAzureStorage(config); err == nil {
+			t.Errorf("client with config %+v was created", config)
+		}
+	}
+}
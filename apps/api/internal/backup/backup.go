@@ -1,8 +1,10 @@
 package backup
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/common/response"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
@@ -115,8 +118,8 @@ func (h *BackupHandler) ScheduleBackup(w http.ResponseWriter, r *http.Request) {
 		response.SendError(w, http.StatusBadRequest, "cron_schedule is required")
 		return
 	}
-	if req.RetentionDays <= 0 {
-		response.SendError(w, http.StatusBadRequest, "retention_days must be greater than 0")
+	if err := validateRetention(req.RetentionDays, req.Retention); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -160,8 +163,8 @@ func (h *BackupHandler) UpdateBackupSchedule(w http.ResponseWriter, r *http.Requ
 		response.SendError(w, http.StatusBadRequest, "cron_schedule is required")
 		return
 	}
-	if req.RetentionDays <= 0 {
-		response.SendError(w, http.StatusBadRequest, "retention_days must be greater than 0")
+	if err := validateRetention(req.RetentionDays, req.Retention); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -178,6 +181,371 @@ func (h *BackupHandler) UpdateBackupSchedule(w http.ResponseWriter, r *http.Requ
 	response.SendSuccess(w, "Backup schedule updated successfully", nil)
 }
 
+// DryRunRetention shows which of a connection's backups retention would delete, and
+// the rules keeping the others. The body can set rules to try instead of the
+// schedule's.
+func (h *BackupHandler) DryRunRetention(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	connectionID := vars["connection_id"]
+
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conn, err := h.backupService.GetConnection(connectionID)
+	if err != nil || conn.UserID != userID {
+		response.SendError(w, http.StatusNotFound, "Connection not found")
+		return
+	}
+
+	var req RetentionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	dryRun, err := h.backupService.DryRunRetention(connectionID, &req)
+	if err != nil {
+		if errors.Is(err, errInvalidRetention) {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Retention dry run completed", dryRun)
+}
+
+// PinBackup pins a backup, or holds it until a date, so retention never deletes it
+func (h *BackupHandler) PinBackup(w http.ResponseWriter, r *http.Request) {
+	backup, userID, ok := h.getUserBackup(w, r)
+	if !ok {
+		return
+	}
+
+	var req PinBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		response.SendError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+	if req.HoldUntil != nil && !req.HoldUntil.After(time.Now()) {
+		response.SendError(w, http.StatusBadRequest, "hold_until must be in the future")
+		return
+	}
+	if backup.ParentID != nil {
+		response.SendError(w, http.StatusBadRequest, "Per-database backups are pinned with their all-databases backup")
+		return
+	}
+	if backup.Status == "in_progress" {
+		response.SendError(w, http.StatusConflict, "Backup is still running")
+		return
+	}
+
+	result, err := h.backupService.PinBackup(backup, userID, &req)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	message := "Backup pinned successfully"
+	if req.HoldUntil != nil {
+		message = "Backup held successfully"
+	}
+	response.SendSuccess(w, message, result)
+}
+
+// UnpinBackup removes the pin or hold of a backup
+func (h *BackupHandler) UnpinBackup(w http.ResponseWriter, r *http.Request) {
+	backup, userID, ok := h.getUserBackup(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.backupService.UnpinBackup(backup, userID)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Backup unpinned successfully", result)
+}
+
+// DeleteBackup deletes a backup with its per-database backups, from disk and from
+// every provider it was uploaded to
+func (h *BackupHandler) DeleteBackup(w http.ResponseWriter, r *http.Request) {
+	backup, userID, ok := h.getUserBackup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := checkDeletable(backup, time.Now()); err != nil {
+		status := http.StatusConflict
+		if err == errChildBackup {
+			status = http.StatusBadRequest
+		}
+		response.SendError(w, status, err.Error())
+		return
+	}
+
+	// Not the request's context: a delete stopped halfway leaves objects behind
+	deletion := h.backupService.DeleteBackup(context.Background(), backup, userID)
+	if !deletion.Deleted {
+		response.SendError(w, http.StatusInternalServerError, deletion.Error)
+		return
+	}
+
+	response.SendSuccess(w, "Backup deleted successfully", deletion)
+}
+
+// DeleteBackups deletes several backups, reporting how each went
+func (h *BackupHandler) DeleteBackups(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req DeleteBackupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.BackupIDs) == 0 {
+		response.SendError(w, http.StatusBadRequest, "backup_ids is required")
+		return
+	}
+	if len(req.BackupIDs) > maxBulkDelete {
+		response.SendError(w, http.StatusBadRequest, fmt.Sprintf("at most %d backups can be deleted at once", maxBulkDelete))
+		return
+	}
+
+	deletions := h.backupService.DeleteBackups(context.Background(), req.BackupIDs, userID)
+
+	deleted := 0
+	for _, deletion := range deletions {
+		if deletion.Deleted {
+			deleted++
+		}
+	}
+	response.SendSuccess(w, fmt.Sprintf("Deleted %d of %d backups", deleted, len(deletions)), deletions)
+}
+
+// GetTombstones lists the objects of deleted backups still waiting to be deleted
+func (h *BackupHandler) GetTombstones(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tombstones, err := h.backupService.GetTombstones(userID)
+	if err != nil {
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response.SendSuccess(w, "Tombstones retrieved successfully", tombstones)
+}
+
+// ReconcileProvider compares a provider's objects with the uploads recorded for it,
+// fixing what the request asks for
+func (h *BackupHandler) ReconcileProvider(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ReconcileRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	report, err := h.backupService.ReconcileProvider(r.Context(), mux.Vars(r)["id"], userID, &req)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			response.SendError(w, http.StatusNotFound, "S3 provider not found")
+		case errCatalogBusy:
+			response.SendError(w, http.StatusConflict, err.Error())
+		case errOrphansUnscoped:
+			response.SendError(w, http.StatusBadRequest, err.Error())
+		default:
+			response.SendError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	response.SendSuccess(w, "Provider reconciled successfully", report)
+}
+
+// ImportCatalog recreates the backups stored on a provider from their manifests
+func (h *BackupHandler) ImportCatalog(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	result, err := h.backupService.ImportCatalog(r.Context(), mux.Vars(r)["id"], userID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			response.SendError(w, http.StatusNotFound, "S3 provider not found")
+		case errCatalogBusy:
+			response.SendError(w, http.StatusConflict, err.Error())
+		default:
+			response.SendError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	response.SendSuccess(w, fmt.Sprintf("Imported %d backups from %d manifests", result.Imported, result.ManifestsFound), result)
+}
+
+// GetSelfBackupSettings returns the schedule backing up velld's own database
+func (h *BackupHandler) GetSelfBackupSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	settings, err := h.backupService.GetSelfBackupSettings(userID)
+	if err != nil {
+		sendSelfBackupError(w, err)
+		return
+	}
+
+	response.SendSuccess(w, "Self-backup settings retrieved successfully", settings)
+}
+
+// UpdateSelfBackupSettings sets up the schedule backing up velld's own database
+func (h *BackupHandler) UpdateSelfBackupSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req SelfBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ProviderID == "" {
+		response.SendError(w, http.StatusBadRequest, "s3_provider_id is required")
+		return
+	}
+	if req.CronSchedule == "" {
+		response.SendError(w, http.StatusBadRequest, "cron_schedule is required")
+		return
+	}
+	if err := validateRetention(req.RetentionDays, req.Retention); err != nil {
+		response.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	settings, err := h.backupService.UpdateSelfBackupSettings(userID, &req)
+	if err != nil {
+		sendSelfBackupError(w, err)
+		return
+	}
+
+	response.SendSuccess(w, "Self-backup settings updated successfully", settings)
+}
+
+// RunSelfBackup backs up velld's own database now
+func (h *BackupHandler) RunSelfBackup(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	run, err := h.backupService.RunSelfBackup(r.Context(), userID)
+	if err != nil {
+		sendSelfBackupError(w, err)
+		return
+	}
+
+	response.SendSuccess(w, "Database backed up successfully", run)
+}
+
+// ListSelfBackups lists the snapshots of velld's own database
+func (h *BackupHandler) ListSelfBackups(w http.ResponseWriter, r *http.Request) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	snapshots, err := h.backupService.ListSelfBackups(r.Context(), userID)
+	if err != nil {
+		sendSelfBackupError(w, err)
+		return
+	}
+
+	response.SendSuccess(w, "Snapshots retrieved successfully", snapshots)
+}
+
+// sendSelfBackupError sends the status of an error of the self-backup
+func sendSelfBackupError(w http.ResponseWriter, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		response.SendError(w, http.StatusNotFound, "S3 provider not found")
+	case errSelfBackupNotSetUp:
+		response.SendError(w, http.StatusNotFound, err.Error())
+	case errSelfBackupOwner:
+		response.SendError(w, http.StatusForbidden, err.Error())
+	case errSelfBackupRunning:
+		response.SendError(w, http.StatusConflict, err.Error())
+	default:
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// getUserBackup returns the backup of the request's id, sending an error unless it
+// belongs to a connection of the user
+func (h *BackupHandler) getUserBackup(w http.ResponseWriter, r *http.Request) (*Backup, uuid.UUID, bool) {
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, uuid.Nil, false
+	}
+
+	backup, err := h.backupService.GetBackup(mux.Vars(r)["id"])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			response.SendError(w, http.StatusNotFound, "Backup not found")
+			return nil, uuid.Nil, false
+		}
+		response.SendError(w, http.StatusInternalServerError, err.Error())
+		return nil, uuid.Nil, false
+	}
+
+	conn, err := h.backupService.GetConnection(backup.ConnectionID)
+	if err != nil || conn.UserID != userID {
+		response.SendError(w, http.StatusNotFound, "Backup not found")
+		return nil, uuid.Nil, false
+	}
+
+	return backup, userID, true
+}
+
 func (h *BackupHandler) GetBackupStats(w http.ResponseWriter, r *http.Request) {
 	userID, err := common.GetUserIDFromContext(r.Context())
 	if err != nil {
@@ -211,6 +579,11 @@ func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isServerBackup(backup) {
+		response.SendError(w, http.StatusBadRequest, "All-databases backups are downloaded one database at a time from their child backups")
+		return
+	}
+
 	userID, err := common.GetUserIDFromContext(r.Context())
 	if err != nil {
 		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
@@ -224,15 +597,14 @@ func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			// Find the specified provider
 			for _, p := range providers {
-				if p.ProviderID == providerID {
+				if p.ProviderID == providerID && p.Stored() {
 					s3Storage, err := h.backupService.GetS3ProviderForDownload(providerID, userID)
 					if err == nil {
 						ctx := r.Context()
-						object, err := s3Storage.GetObject(ctx, p.ObjectKey)
+						object, filename, err := h.backupService.openBackupDownload(ctx, s3Storage, backup, p.ObjectKey)
 						if err == nil {
 							defer object.Close()
-							
-							filename := filepath.Base(backup.Path)
+
 							w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 							w.Header().Set("Content-Type", "application/octet-stream")
 							
@@ -257,11 +629,10 @@ func (h *BackupHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
 		if err == nil {
 			// Download from S3
 			ctx := r.Context()
-			object, err := s3Storage.GetObject(ctx, *backup.S3ObjectKey)
+			object, filename, err := h.backupService.openBackupDownload(ctx, s3Storage, backup, *backup.S3ObjectKey)
 			if err == nil {
 				defer object.Close()
-				
-				filename := filepath.Base(backup.Path)
+
 				w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 				w.Header().Set("Content-Type", "application/octet-stream")
 				
@@ -311,7 +682,7 @@ func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.backupService.RestoreBackup(req.BackupID, req.ConnectionID, req.TargetDatabaseName, req.SkipChecksumVerification)
+	err := h.backupService.RestoreBackup(req)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -511,6 +882,9 @@ func (h *BackupHandler) DownloadViaShareableLink(w http.ResponseWriter, r *http.
 	// Find the provider and object key
 	var objectKey string
 	for _, p := range providers {
+		if !p.Stored() {
+			continue
+		}
 		if providerID == "" || p.ProviderID == providerID {
 			objectKey = p.ObjectKey
 			if providerID == "" {
@@ -539,16 +913,15 @@ func (h *BackupHandler) DownloadViaShareableLink(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Download from S3
+	// Download from S3, decrypting and decompressing it
 	ctx := r.Context()
-	object, err := s3Storage.GetObject(ctx, objectKey)
+	object, filename, err := h.backupService.openBackupDownload(ctx, s3Storage, backup, objectKey)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, "Failed to download from S3")
 		return
 	}
 	defer object.Close()
 
-	filename := filepath.Base(backup.Path)
 	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 	w.Header().Set("Content-Type", "application/octet-stream")
 
@@ -0,0 +1,301 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// tombstoneRetrySchedule is when failed object deletions are retried, in the
+	// cron format schedules use
+	tombstoneRetrySchedule = "0 */15 * * * *"
+	// tombstoneRetryDelay is the wait before the first retry of a failed object
+	// deletion; it doubles after each one, up to tombstoneMaxRetryDelay
+	tombstoneRetryDelay    = 15 * time.Minute
+	tombstoneMaxRetryDelay = 24 * time.Hour
+	// maxBulkDelete is how many backups one request can delete
+	maxBulkDelete = 100
+)
+
+var (
+	errChildBackup     = errors.New("per-database backups are deleted with their all-databases backup")
+	errBackupRunning   = errors.New("backup is still running")
+	errBackupProtected = errors.New("backup is pinned or held; unpin it first")
+)
+
+// checkDeletable returns why a backup can't be deleted, or nil if it can
+func checkDeletable(backup *Backup, now time.Time) error {
+	switch {
+	case backup.ParentID != nil:
+		return errChildBackup
+	case backup.Status == "in_progress":
+		return errBackupRunning
+	case backup.Pinned || backup.heldAt(now):
+		return errBackupProtected
+	}
+	return nil
+}
+
+// DeleteBackup deletes a backup with its per-database backups: their local files,
+// their objects on every provider, then their records. Objects that fail to delete
+// are left as tombstones and retried later, so the records can go without orphaning
+// them.
+func (s *BackupService) DeleteBackup(ctx context.Context, backup *Backup, userID uuid.UUID) *BackupDeletion {
+	deletion := &BackupDeletion{BackupID: backup.ID.String(), Objects: []ObjectDeletion{}}
+
+	children, err := s.backupRepo.GetChildBackups(deletion.BackupID)
+	if err != nil {
+		deletion.Error = fmt.Sprintf("failed to get child backups: %v", err)
+		return deletion
+	}
+
+	// Children go first, as they reference their parent
+	for _, b := range append(children, backup) {
+		if b.Path != "" {
+			if err := os.Remove(b.Path); err != nil && !os.IsNotExist(err) {
+				fmt.Printf("Warning: Failed to remove local backup file %s: %v\n", b.Path, err)
+			}
+		}
+
+		objects, err := s.deleteBackupObjects(ctx, b, userID)
+		deletion.Objects = append(deletion.Objects, objects...)
+		if err != nil {
+			deletion.Error = err.Error()
+			return deletion
+		}
+
+		if err := s.backupRepo.DeleteBackup(b.ID.String()); err != nil {
+			deletion.Error = fmt.Sprintf("failed to delete backup record: %v", err)
+			return deletion
+		}
+	}
+
+	deletion.Deleted = true
+	return deletion
+}
+
+// DeleteBackups deletes several backups of a user, reporting how each went. Backups
+// that can't be deleted are reported and skipped.
+func (s *BackupService) DeleteBackups(ctx context.Context, backupIDs []string, userID uuid.UUID) []*BackupDeletion {
+	deletions := make([]*BackupDeletion, 0, len(backupIDs))
+	now := time.Now()
+	for _, id := range backupIDs {
+		backup, err := s.backupRepo.GetBackup(id)
+		if err == sql.ErrNoRows || (err == nil && !s.ownsBackup(backup, userID)) {
+			deletions = append(deletions, &BackupDeletion{BackupID: id, Error: "backup not found", Objects: []ObjectDeletion{}})
+			continue
+		}
+		if err == nil {
+			err = checkDeletable(backup, now)
+		}
+		if err != nil {
+			deletions = append(deletions, &BackupDeletion{BackupID: id, Error: err.Error(), Objects: []ObjectDeletion{}})
+			continue
+		}
+
+		deletions = append(deletions, s.DeleteBackup(ctx, backup, userID))
+	}
+	return deletions
+}
+
+// ownsBackup reports whether a backup belongs to a connection of the user
+func (s *BackupService) ownsBackup(backup *Backup, userID uuid.UUID) bool {
+	conn, err := s.connStorage.GetConnection(backup.ConnectionID)
+	return err == nil && conn.UserID == userID
+}
+
+// deleteBackupObjects deletes the stored objects of a backup from every provider.
+// Objects that fail to delete are saved as tombstones.
+func (s *BackupService) deleteBackupObjects(ctx context.Context, backup *Backup, userID uuid.UUID) ([]ObjectDeletion, error) {
+	uploads, err := s.backupRepo.GetBackupS3Providers(backup.ID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup uploads: %v", err)
+	}
+
+	objects := make([]ObjectDeletion, 0, len(uploads))
+	for _, upload := range uploads {
+		if !upload.Stored() {
+			continue
+		}
+
+		object := ObjectDeletion{
+			BackupID:   backup.ID,
+			ProviderID: upload.ProviderID,
+			ObjectKey:  upload.ObjectKey,
+			Status:     ObjectDeleted,
+		}
+
+		storage, err := s.GetS3ProviderForDownload(upload.ProviderID, userID)
+		if err == sql.ErrNoRows {
+			object.Status = ObjectSkipped
+			object.Error = "provider no longer exists"
+			objects = append(objects, object)
+			continue
+		}
+		if err == nil {
+			err = deleteBackupObject(ctx, storage, upload.ObjectKey)
+		}
+		if err != nil {
+			fmt.Printf("Warning: Failed to delete S3 backup %s from provider %s, retrying later: %v\n",
+				upload.ObjectKey, upload.ProviderID, err)
+
+			tombstone, err := s.saveTombstone(ctx, storage, backup, userID, upload.ProviderID, upload.ObjectKey, err)
+			object.Status = ObjectPending
+			object.Error = tombstone.LastError
+			if err != nil {
+				return append(objects, object), err
+			}
+		} else {
+			fmt.Printf("Deleted S3 backup %s from provider %s\n", upload.ObjectKey, upload.ProviderID)
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// saveTombstone records a failed deletion of one of backup's objects, so it is
+// retried later
+func (s *BackupService) saveTombstone(ctx context.Context, storage Storage, backup *Backup, userID uuid.UUID, providerID, objectKey string, deleteErr error) (*BackupTombstone, error) {
+	tombstone := &BackupTombstone{
+		ID:         uuid.New().String(),
+		BackupID:   backup.ID.String(),
+		UserID:     userID.String(),
+		ProviderID: providerID,
+		ObjectKey:  objectKey,
+		Attempts:   1,
+	}
+	scheduleTombstone(ctx, storage, tombstone, deleteErr, time.Now())
+	if err := s.backupRepo.SaveTombstone(tombstone); err != nil {
+		return tombstone, fmt.Errorf("failed to save tombstone for %s: %v", objectKey, err)
+	}
+	return tombstone, nil
+}
+
+// discardUpload deletes an object uploaded for backup that isn't a usable copy of
+// it, such as one of a dump that failed. A deletion that fails is retried later.
+func (s *BackupService) discardUpload(ctx context.Context, backup *Backup, userID uuid.UUID, upload *providerUpload) {
+	err := deleteBackupObject(ctx, upload.storage, upload.objectKey)
+	if err == nil {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Deleted the incomplete upload from %s", upload.provider.Name))
+		return
+	}
+
+	s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to delete the incomplete upload from %s, retrying later: %v", upload.provider.Name, err))
+	if _, err := s.saveTombstone(ctx, upload.storage, backup, userID, upload.provider.ID.String(), upload.objectKey, err); err != nil {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] %v", err))
+	}
+}
+
+// deleteStoredObject deletes an object from a storage. An object that is already
+// gone counts as deleted, as storages disagree on whether that is an error; any
+// other failure is returned so the deletion can be retried. Storages with Object
+// Lock delete every version of an object, and one that stats as gone may still
+// have locked versions behind a delete marker, so only their delete error counts.
+func deleteStoredObject(ctx context.Context, storage Storage, objectKey string) error {
+	err := storage.DeleteFile(ctx, objectKey)
+	if err == nil {
+		return nil
+	}
+	if locker, ok := storage.(ObjectLocker); ok && locker.ObjectLockEnabled() {
+		return err
+	}
+	if _, statErr := storage.StatObject(ctx, objectKey); errors.Is(statErr, ErrObjectNotFound) {
+		return nil
+	}
+	return err
+}
+
+// nextTombstoneAttempt returns when a tombstone that failed attempts times is retried
+func nextTombstoneAttempt(attempts int, now time.Time) time.Time {
+	delay := tombstoneRetryDelay
+	for i := 1; i < attempts && delay < tombstoneMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > tombstoneMaxRetryDelay {
+		delay = tombstoneMaxRetryDelay
+	}
+	return now.Add(delay)
+}
+
+// scheduleTombstone records a failed deletion attempt on a tombstone and when to
+// retry it. An object under Object Lock retention can't be deleted before the
+// retention ends, so its retry waits for that rather than backing off.
+func scheduleTombstone(ctx context.Context, storage Storage, tombstone *BackupTombstone, err error, now time.Time) {
+	tombstone.LastError = err.Error()
+	tombstone.NextAttemptAt = nextTombstoneAttempt(tombstone.Attempts, now)
+
+	if until := retainedUntil(ctx, storage, tombstone.ObjectKey); until.After(tombstone.NextAttemptAt) {
+		tombstone.LastError = fmt.Sprintf("%v (object is retained until %s)", err, until.UTC().Format(time.RFC3339))
+		tombstone.NextAttemptAt = until
+	}
+}
+
+// retainedUntil returns when an object's Object Lock retention ends, or the zero
+// time when it has none or it can't be told
+func retainedUntil(ctx context.Context, storage Storage, objectKey string) time.Time {
+	locker, ok := storage.(ObjectLocker)
+	if !ok || !locker.ObjectLockEnabled() {
+		return time.Time{}
+	}
+	until, err := locker.RetainedUntil(ctx, objectKey)
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// retryTombstones retries deleting the objects of deleted backups whose retry is
+// due. Tombstones of providers that were removed since are dropped, and those of
+// objects still under Object Lock retention wait until it ends.
+func (s *BackupService) retryTombstones() {
+	if !s.tombstoneMutex.TryLock() {
+		return
+	}
+	defer s.tombstoneMutex.Unlock()
+
+	now := time.Now()
+	tombstones, err := s.backupRepo.GetDueTombstones(now)
+	if err != nil {
+		fmt.Printf("Error getting tombstones: %v\n", err)
+		return
+	}
+
+	ctx := context.Background()
+	for _, tombstone := range tombstones {
+		userID, err := uuid.Parse(tombstone.UserID)
+		if err != nil {
+			fmt.Printf("Error parsing user of tombstone %s: %v\n", tombstone.ID, err)
+			continue
+		}
+
+		storage, err := s.GetS3ProviderForDownload(tombstone.ProviderID, userID)
+		if err == nil {
+			err = deleteBackupObject(ctx, storage, tombstone.ObjectKey)
+		}
+		if err == nil || err == sql.ErrNoRows {
+			if err := s.backupRepo.DeleteTombstone(tombstone.ID); err != nil {
+				fmt.Printf("Error deleting tombstone %s: %v\n", tombstone.ID, err)
+			}
+			continue
+		}
+
+		tombstone.Attempts++
+		scheduleTombstone(ctx, storage, tombstone, err, now)
+		if err := s.backupRepo.UpdateTombstone(tombstone); err != nil {
+			fmt.Printf("Error updating tombstone %s: %v\n", tombstone.ID, err)
+		}
+	}
+}
+
+// GetTombstones returns the objects of a user's deleted backups still waiting to be
+// deleted
+func (s *BackupService) GetTombstones(userID uuid.UUID) ([]*BackupTombstone, error) {
+	return s.backupRepo.GetTombstones(userID)
+}
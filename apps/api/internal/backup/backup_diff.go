@@ -2,12 +2,16 @@ package backup
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
 
+	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/common/response"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
@@ -45,13 +49,19 @@ func (h *BackupHandler) CompareBackups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sourceContent, err := readBackupFile(sourceBackup.Path)
+	userID, err := common.GetUserIDFromContext(r.Context())
+	if err != nil {
+		response.SendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sourceContent, err := h.backupService.readBackupFile(r.Context(), sourceBackup, userID)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read source backup: %v", err))
 		return
 	}
 
-	targetContent, err := readBackupFile(targetBackup.Path)
+	targetContent, err := h.backupService.readBackupFile(r.Context(), targetBackup, userID)
 	if err != nil {
 		response.SendError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read target backup: %v", err))
 		return
@@ -62,14 +72,33 @@ func (h *BackupHandler) CompareBackups(w http.ResponseWriter, r *http.Request) {
 	response.SendSuccess(w, "Backup comparison completed", diff)
 }
 
-// readBackupFile reads a backup file and returns its content
-func readBackupFile(path string) (string, error) {
-	file, err := os.Open(path)
+// readBackupFile reads a backup's dump and returns its content, from the local file
+// or, once that has been removed, from S3
+func (s *BackupService) readBackupFile(ctx context.Context, backup *Backup, userID uuid.UUID) (string, error) {
+	file, err := os.Open(backup.Path)
+	if err == nil {
+		defer file.Close()
+		return readBackupContent(file)
+	}
+	if !os.IsNotExist(err) || backup.S3ObjectKey == nil || backup.S3ProviderID == nil {
+		return "", err
+	}
+
+	s3Storage, err := s.GetS3ProviderForDownload(*backup.S3ProviderID, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get S3 provider: %w", err)
+	}
+
+	object, err := s.openBackupObject(ctx, s3Storage, backup, *backup.S3ObjectKey, true, nil)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	defer object.Close()
+	return readBackupContent(object)
+}
 
+// readBackupContent reads a dump line by line and returns its content
+func readBackupContent(file io.Reader) (string, error) {
 	var content strings.Builder
 	scanner := bufio.NewScanner(file)
 
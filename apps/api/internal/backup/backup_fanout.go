@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// providerUpload is the outcome of streaming a backup to one provider
+type providerUpload struct {
+	provider  *S3Provider
+	storage   Storage
+	objectKey string
+	size      int64
+	err       error
+}
+
+// record returns the backup_s3_providers entry of the upload
+func (u *providerUpload) record() BackupS3Provider {
+	record := BackupS3Provider{
+		ProviderID: u.provider.ID.String(),
+		ObjectKey:  u.objectKey,
+		Status:     "success",
+		Size:       u.size,
+	}
+	if u.err != nil {
+		record.Status = "failed"
+		record.Error = u.err.Error()
+	}
+	return record
+}
+
+// streamToProviders uploads reader to every provider at once, as objectKey in the
+// connection's folder. Each provider reads its own copy of the stream, so one that
+// fails doesn't stop the others; the stream goes as fast as the slowest upload.
+func (s *BackupService) streamToProviders(ctx context.Context, backupID string, providers []*S3Provider, reader io.Reader, objectKey, connectionName, contentType string) []*providerUpload {
+	uploads := make([]*providerUpload, len(providers))
+	var writers []*io.PipeWriter
+	var wg sync.WaitGroup
+
+	for i, provider := range providers {
+		upload := &providerUpload{provider: provider}
+		uploads[i] = upload
+
+		storage, err := newProviderStorage(provider)
+		if err != nil {
+			upload.err = fmt.Errorf("failed to create storage client: %w", err)
+			s.sendLog(backupID, fmt.Sprintf("[ERROR] Failed to create storage client for %s: %v", provider.Name, err))
+			continue
+		}
+		upload.storage = storage
+		s.sendLog(backupID, fmt.Sprintf("[INFO] Streaming to %s", provider.Name))
+
+		pr, pw := io.Pipe()
+		writers = append(writers, pw)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			counter := &countingReader{reader: pr}
+			key, err := upload.storage.UploadStream(ctx, counter, objectKey, connectionName, contentType, func(message string) {
+				s.sendLog(backupID, fmt.Sprintf("[%s] %s", upload.provider.Name, message))
+			})
+			// Stop feeding a provider whose upload failed
+			pr.CloseWithError(errors.New("upload stopped"))
+
+			upload.objectKey, upload.size, upload.err = key, counter.n, err
+		}()
+	}
+
+	fanOut := &fanOutWriter{writers: make([]io.Writer, len(writers)), errs: make([]error, len(writers))}
+	for i, w := range writers {
+		fanOut.writers[i] = w
+	}
+	_, err := io.Copy(fanOut, reader)
+	for _, w := range writers {
+		w.CloseWithError(err)
+	}
+	wg.Wait()
+
+	return uploads
+}
+
+// fanOutWriter writes to every writer that hasn't failed yet. It only fails once
+// all of them have.
+type fanOutWriter struct {
+	writers []io.Writer
+	errs    []error
+}
+
+func (f *fanOutWriter) Write(p []byte) (int, error) {
+	written := false
+	for i, w := range f.writers {
+		if f.errs[i] != nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.errs[i] = err
+			continue
+		}
+		written = true
+	}
+	if !written {
+		return 0, errors.New("every upload failed")
+	}
+	return len(p), nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+// committingStorage keeps whatever an upload read before its stream failed, as a
+// backend that commits partial uploads would
+type committingStorage struct {
+	*MemoryStorage
+	deleteErr error
+}
+
+func (s *committingStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	data, _ := io.ReadAll(reader)
+	return s.MemoryStorage.UploadStream(ctx, bytes.NewReader(data), objectKey, connectionName, contentType, logFunc)
+}
+
+func (s *committingStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	if s.deleteErr != nil {
+		return s.deleteErr
+	}
+	return s.MemoryStorage.DeleteFile(ctx, objectKey)
+}
+
+// failingDumpDriver writes part of a dump, then fails like a dump tool that exits
+// with an error
+type failingDumpDriver struct {
+	connection.DumpDriver
+}
+
+func (d failingDumpDriver) DumpStream(ctx context.Context, conn *connection.StoredConnection, w io.Writer, logFunc func(string)) error {
+	if _, err := w.Write([]byte("CREATE TABLE items (value TEXT);\n")); err != nil {
+		return err
+	}
+	return errors.New("connection to server lost")
+}
+
+func TestFailedDumpDiscardsUploads(t *testing.T) {
+	service, connRepo, providerService := newTestBackupService(t)
+	userID := uuid.New()
+
+	deletable := &committingStorage{MemoryStorage: NewMemoryStorage("")}
+	undeletable := &committingStorage{MemoryStorage: NewMemoryStorage(""), deleteErr: errors.New("access denied")}
+	RegisterStorage("test-deletable", func(provider *S3Provider) (Storage, error) { return deletable, nil })
+	RegisterStorage("test-undeletable", func(provider *S3Provider) (Storage, error) { return undeletable, nil })
+
+	var providerIDs []string
+	for _, providerType := range []string{"test-deletable", "test-undeletable"} {
+		provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: providerType, Type: providerType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		providerIDs = append(providerIDs, provider.ID.String())
+	}
+
+	conn := &connection.StoredConnection{ID: uuid.NewString(), Name: "App", Type: "sqlite", DatabaseName: filepath.Join(t.TempDir(), "app.sqlite"), UserID: userID}
+	if err := connRepo.Save(*conn); err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := connection.GetDriver("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	backup := &Backup{ID: uuid.New(), ConnectionID: conn.ID, Status: "in_progress", StartedTime: now, CreatedAt: now, UpdatedAt: now}
+	if err := service.backupRepo.CreateBackup(backup); err != nil {
+		t.Fatal(err)
+	}
+	filename := "app_20250101_000000.sqlite"
+	service.runBackup(context.Background(), backup, conn, failingDumpDriver{sqlite}, filepath.Join(service.backupDir, "app", filename), filename, providerIDs)
+
+	if backup.Status != "failed" {
+		t.Errorf("backup status is %q, want failed", backup.Status)
+	}
+	uploads, err := service.backupRepo.GetBackupS3Providers(backup.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 2 {
+		t.Fatalf("recorded %d uploads, want 2", len(uploads))
+	}
+	for _, upload := range uploads {
+		if upload.Stored() {
+			t.Errorf("upload of the failed dump to %s is recorded as stored", upload.ProviderID)
+		}
+	}
+
+	if objects, _ := deletable.ListFiles(context.Background()); len(objects) != 0 {
+		t.Errorf("the failed dump's upload was left on the provider: %v", objects)
+	}
+	tombstones, err := service.GetTombstones(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 1 || tombstones[0].ProviderID != providerIDs[1] {
+		t.Errorf("left tombstones %+v, want one for the provider the upload couldn't be deleted from", tombstones)
+	}
+}
@@ -0,0 +1,408 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+const (
+	// manifestSuffix is appended to the key of an object for the key of its manifest
+	manifestSuffix = ".manifest.json"
+	// manifestVersion is the version of the manifests written; newer ones aren't
+	// imported
+	manifestVersion = 1
+	// maxManifestSize is the size of the largest manifest that is imported
+	maxManifestSize = 1 << 20
+)
+
+// manifestKey returns the key of the manifest of an object
+func manifestKey(objectKey string) string {
+	return objectKey + manifestSuffix
+}
+
+func isManifestKey(key string) bool {
+	return strings.HasSuffix(key, manifestSuffix)
+}
+
+// newBackupManifest describes a backup of conn
+func newBackupManifest(backup *Backup, conn *connection.StoredConnection) *BackupManifest {
+	databaseName := conn.DatabaseName
+	if backup.DatabaseName != nil {
+		databaseName = *backup.DatabaseName
+	}
+
+	return &BackupManifest{
+		Version:             manifestVersion,
+		BackupID:            backup.ID.String(),
+		ParentID:            backup.ParentID,
+		ConnectionID:        backup.ConnectionID,
+		ConnectionName:      conn.Name,
+		ConnectionType:      conn.Type,
+		DatabaseName:        databaseName,
+		Status:              backup.Status,
+		Format:              backup.Format,
+		Size:                backup.Size,
+		MD5Hash:             backup.MD5Hash,
+		SHA256Hash:          backup.SHA256Hash,
+		Compression:         backup.Compression,
+		CompressionLevel:    backup.CompressionLevel,
+		EncryptionAlgorithm: backup.EncryptionAlgorithm,
+		EncryptionKeyID:     backup.EncryptionKeyID,
+		EncryptedDataKey:    backup.EncryptedDataKey,
+		StartedTime:         backup.StartedTime,
+		CompletedTime:       backup.CompletedTime,
+		CreatedAt:           backup.CreatedAt,
+	}
+}
+
+// writeManifests writes the manifest of a backup next to each of its stored objects.
+// connectionName is the folder the objects were uploaded to.
+func (s *BackupService) writeManifests(ctx context.Context, backup *Backup, conn *connection.StoredConnection, connectionName string) {
+	backupID := backup.ID.String()
+	uploads, err := s.backupRepo.GetBackupS3Providers(backupID)
+	if err != nil {
+		s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to get uploads for manifests: %v", err))
+		return
+	}
+
+	data, err := json.MarshalIndent(newBackupManifest(backup, conn), "", "  ")
+	if err != nil {
+		s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to encode manifest: %v", err))
+		return
+	}
+
+	for _, upload := range uploads {
+		if !upload.Stored() {
+			continue
+		}
+		storage, err := s.GetS3ProviderForDownload(upload.ProviderID, conn.UserID)
+		if err == nil {
+			err = putManifest(ctx, storage, upload.ObjectKey, connectionName, data)
+		}
+		if err != nil {
+			s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to write manifest of %s: %v", upload.ObjectKey, err))
+		}
+	}
+}
+
+// putManifest uploads data as the manifest of an object in the connection's folder
+func putManifest(ctx context.Context, storage Storage, objectKey, connectionName string, data []byte) error {
+	key, err := storage.UploadStream(ctx, bytes.NewReader(data), path.Base(manifestKey(objectKey)), connectionName, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	if key != manifestKey(objectKey) {
+		deleteStoredObject(ctx, storage, key)
+		return fmt.Errorf("manifest landed at %s, not next to the object", key)
+	}
+	return nil
+}
+
+// copyManifest copies the manifest of an object, if it has one, next to its copy on
+// another storage
+func copyManifest(ctx context.Context, source, destination Storage, sourceKey, destinationKey, connectionName string) error {
+	data, err := readManifest(ctx, source, manifestKey(sourceKey))
+	if err != nil {
+		return err
+	}
+	return putManifest(ctx, destination, destinationKey, connectionName, data)
+}
+
+func readManifest(ctx context.Context, storage Storage, key string) ([]byte, error) {
+	object, err := storage.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("manifest is larger than %d bytes", maxManifestSize)
+	}
+	return data, nil
+}
+
+// deleteBackupObject deletes a stored object of a backup with its manifest
+func deleteBackupObject(ctx context.Context, storage Storage, objectKey string) error {
+	if err := deleteStoredObject(ctx, storage, objectKey); err != nil {
+		return err
+	}
+	return deleteStoredObject(ctx, storage, manifestKey(objectKey))
+}
+
+// ImportCatalog recreates the backups stored on a provider from the manifests next
+// to their objects. Backups that exist already get the provider's copy added;
+// backups of connections the user doesn't have, by ID or by name and type, are
+// skipped until the connection is recreated.
+func (s *BackupService) ImportCatalog(ctx context.Context, providerID string, userID uuid.UUID) (*CatalogImport, error) {
+	if !s.catalogMutex.TryLock() {
+		return nil, errCatalogBusy
+	}
+	defer s.catalogMutex.Unlock()
+
+	provider, err := s.s3ProviderService.GetS3ProviderForDownload(providerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := newProviderStorage(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	keys, err := storage.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+
+	conns, err := s.connStorage.ListByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connections: %v", err)
+	}
+
+	result := &CatalogImport{ProviderID: providerID, ProviderName: provider.Name, Results: []ManifestImport{}}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !isManifestKey(key) {
+			continue
+		}
+		result.ManifestsFound++
+
+		imported := ManifestImport{ManifestKey: key}
+		objectKey := strings.TrimSuffix(key, manifestSuffix)
+		if !listed[objectKey] {
+			imported.Status = ManifestSkipped
+			imported.Error = "object is missing"
+		} else {
+			imported.BackupID, imported.Status, err = s.importManifest(ctx, storage, providerID, objectKey, userID, conns)
+			if err != nil {
+				imported.Status = ManifestSkipped
+				imported.Error = err.Error()
+			}
+		}
+		if imported.Status == ManifestImported {
+			result.Imported++
+		}
+		result.Results = append(result.Results, imported)
+	}
+
+	return result, nil
+}
+
+// importManifest imports the backup of an object on a provider from its manifest,
+// returning the backup's ID and the import status
+func (s *BackupService) importManifest(ctx context.Context, storage Storage, providerID, objectKey string, userID uuid.UUID, conns []connection.ConnectionListItem) (string, string, error) {
+	data, err := readManifest(ctx, storage, manifestKey(objectKey))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read manifest: %v", err)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", "", fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Version < 1 || manifest.Version > manifestVersion {
+		return manifest.BackupID, "", fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	backupID, err := uuid.Parse(manifest.BackupID)
+	if err != nil {
+		return manifest.BackupID, "", fmt.Errorf("invalid backup ID: %v", err)
+	}
+	if err := s.checkManifest(&manifest); err != nil {
+		return manifest.BackupID, "", err
+	}
+
+	info, err := storage.StatObject(ctx, objectKey)
+	if err != nil {
+		return manifest.BackupID, "", fmt.Errorf("failed to stat object: %v", err)
+	}
+	upload := BackupS3Provider{ProviderID: providerID, ObjectKey: objectKey, Status: "success", Size: info.Size}
+
+	existing, err := s.backupRepo.GetBackup(manifest.BackupID)
+	if err != nil && err != sql.ErrNoRows {
+		return manifest.BackupID, "", fmt.Errorf("failed to get backup: %v", err)
+	}
+	if err == nil {
+		if !s.ownsBackup(existing, userID) {
+			return manifest.BackupID, "", fmt.Errorf("backup belongs to another user")
+		}
+		uploads, err := s.backupRepo.GetBackupS3Providers(manifest.BackupID)
+		if err != nil {
+			return manifest.BackupID, "", fmt.Errorf("failed to get backup uploads: %v", err)
+		}
+		for _, u := range uploads {
+			if u.ProviderID == providerID && u.Stored() {
+				return manifest.BackupID, ManifestExists, nil
+			}
+		}
+		if err := s.backupRepo.AddBackupS3Provider(manifest.BackupID, upload); err != nil {
+			return manifest.BackupID, "", fmt.Errorf("failed to record upload: %v", err)
+		}
+		return manifest.BackupID, ManifestLinked, nil
+	}
+
+	connectionID := matchManifestConnection(&manifest, conns)
+	if connectionID == "" {
+		return manifest.BackupID, "", fmt.Errorf("no %s connection named %q", manifest.ConnectionType, manifest.ConnectionName)
+	}
+
+	if manifest.ParentID != nil {
+		if err := s.importParentBackup(&manifest, connectionID); err != nil {
+			return manifest.BackupID, "", err
+		}
+	}
+
+	backup := &Backup{
+		ID:                  backupID,
+		ConnectionID:        connectionID,
+		Status:              manifest.Status,
+		S3ObjectKey:         &objectKey,
+		S3ProviderID:        &providerID,
+		Size:                manifest.Size,
+		MD5Hash:             manifest.MD5Hash,
+		SHA256Hash:          manifest.SHA256Hash,
+		Format:              manifest.Format,
+		StartedTime:         manifest.StartedTime,
+		CompletedTime:       manifest.CompletedTime,
+		CreatedAt:           manifest.CreatedAt,
+		UpdatedAt:           manifest.CreatedAt,
+		ParentID:            manifest.ParentID,
+		EncryptionAlgorithm: manifest.EncryptionAlgorithm,
+		EncryptionKeyID:     manifest.EncryptionKeyID,
+		EncryptedDataKey:    manifest.EncryptedDataKey,
+		Compression:         manifest.Compression,
+		CompressionLevel:    manifest.CompressionLevel,
+	}
+	if manifest.ParentID != nil && manifest.DatabaseName != "" {
+		backup.DatabaseName = &manifest.DatabaseName
+	}
+	backup.Path = s.importedBackupPath(backup, manifest.ConnectionName, objectKey)
+	if err := s.backupRepo.CreateBackup(backup); err != nil {
+		return manifest.BackupID, "", fmt.Errorf("failed to create backup: %v", err)
+	}
+	if err := s.backupRepo.AddBackupS3Provider(manifest.BackupID, upload); err != nil {
+		return manifest.BackupID, "", fmt.Errorf("failed to record upload: %v", err)
+	}
+
+	return manifest.BackupID, ManifestImported, nil
+}
+
+// importedBackupPath returns where an imported backup is downloaded to when it is
+// restored: the folder of its connection, under the name the driver wrote it with
+func (s *BackupService) importedBackupPath(backup *Backup, connectionName, objectKey string) string {
+	name := path.Base(objectKey)
+	if backup.EncryptionAlgorithm != nil {
+		name = strings.TrimSuffix(name, encryptionExtensions[*backup.EncryptionAlgorithm])
+	}
+	compression := common.Compression{Codec: objectCompression(backup, name)}
+	name = strings.TrimSuffix(name, compression.Extension())
+	return filepath.Join(s.backupDir, common.SanitizeConnectionName(connectionName), name)
+}
+
+// checkManifest returns why the backup a manifest describes can't be imported, or
+// nil if it can. Manifests are only written for backups with stored objects, and an
+// encrypted backup's data key must unwrap with this server's master key.
+func (s *BackupService) checkManifest(manifest *BackupManifest) error {
+	switch manifest.Status {
+	case "success", "partial", "completed_with_errors":
+	default:
+		return fmt.Errorf("unsupported backup status %q", manifest.Status)
+	}
+	if manifest.ParentID != nil {
+		if _, err := uuid.Parse(*manifest.ParentID); err != nil {
+			return fmt.Errorf("invalid parent backup ID: %v", err)
+		}
+	}
+
+	if manifest.EncryptionAlgorithm == nil {
+		if manifest.EncryptedDataKey != nil {
+			return fmt.Errorf("manifest has a data key but no encryption algorithm")
+		}
+		return nil
+	}
+	algorithm := *manifest.EncryptionAlgorithm
+	if _, ok := encryptionExtensions[algorithm]; !ok {
+		return fmt.Errorf("unsupported backup encryption algorithm: %s", algorithm)
+	}
+	if algorithm != backupEncryptionAlgorithm {
+		if manifest.EncryptedDataKey != nil {
+			return fmt.Errorf("%s backups have no data key", algorithm)
+		}
+		return nil
+	}
+	_, err := s.backupDataKey(&Backup{
+		EncryptionAlgorithm: manifest.EncryptionAlgorithm,
+		EncryptionKeyID:     manifest.EncryptionKeyID,
+		EncryptedDataKey:    manifest.EncryptedDataKey,
+	})
+	return err
+}
+
+// importParentBackup creates the all-databases backup of a per-database manifest,
+// unless an earlier one did. An existing parent must be an all-databases backup of
+// the same connection.
+func (s *BackupService) importParentBackup(manifest *BackupManifest, connectionID string) error {
+	existing, err := s.backupRepo.GetBackup(*manifest.ParentID)
+	if err == nil {
+		if !isServerBackup(existing) || existing.ConnectionID != connectionID {
+			return fmt.Errorf("parent backup %s isn't an all-databases backup of this connection", *manifest.ParentID)
+		}
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get parent backup: %v", err)
+	}
+
+	parentID, err := uuid.Parse(*manifest.ParentID)
+	if err != nil {
+		return fmt.Errorf("invalid parent backup ID: %v", err)
+	}
+	format := serverBackupFormat
+	parent := &Backup{
+		ID:            parentID,
+		ConnectionID:  connectionID,
+		Status:        "success",
+		Format:        &format,
+		StartedTime:   manifest.StartedTime,
+		CompletedTime: manifest.CompletedTime,
+		CreatedAt:     manifest.CreatedAt,
+		UpdatedAt:     manifest.CreatedAt,
+	}
+	if err := s.backupRepo.CreateBackup(parent); err != nil {
+		return fmt.Errorf("failed to create parent backup: %v", err)
+	}
+	return nil
+}
+
+// matchManifestConnection returns the connection a manifest's backup belongs to: the
+// one it was taken of, or else one with the same name and type
+func matchManifestConnection(manifest *BackupManifest, conns []connection.ConnectionListItem) string {
+	for _, conn := range conns {
+		if conn.ID == manifest.ConnectionID {
+			return conn.ID
+		}
+	}
+	for _, conn := range conns {
+		if conn.Name == manifest.ConnectionName && conn.Type == manifest.ConnectionType {
+			return conn.ID
+		}
+	}
+	return ""
+}
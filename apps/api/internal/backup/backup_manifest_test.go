@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/dendianugerah/velld/internal/notification"
+	"github.com/dendianugerah/velld/internal/settings"
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose"
+)
+
+const testEncryptionKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// newTestBackupService returns a backup service on a migrated database in a
+// temporary folder, with the connection and provider services it uses
+func newTestBackupService(t *testing.T) (*BackupService, *connection.ConnectionRepository, *S3ProviderService) {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "velld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(db, "../database/migrations"); err != nil {
+		t.Fatal(err)
+	}
+
+	crypto, err := common.NewEncryptionService(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connRepo := connection.NewConnectionRepository(db, crypto)
+	providerService := NewS3ProviderService(NewS3ProviderRepository(db), crypto)
+	service := NewBackupService(
+		connRepo,
+		connection.NewConnectionManager(),
+		filepath.Join(dir, "backups"),
+		NewBackupRepository(db),
+		settings.NewSettingsService(settings.NewSettingsRepository(db), crypto),
+		notification.NewNotificationRepository(db),
+		crypto,
+		providerService,
+	)
+	t.Cleanup(func() { <-service.cronManager.Stop().Done() })
+	return service, connRepo, providerService
+}
+
+// createTestSQLite creates a SQLite database at path holding one row
+func createTestSQLite(t *testing.T, path, value string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE items (value TEXT); INSERT INTO items VALUES (?)`, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestSQLite returns the row of a database createTestSQLite created
+func readTestSQLite(t *testing.T, path string) string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var value string
+	if err := db.QueryRow(`SELECT value FROM items`).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// writeTestObject stores a gzip-compressed copy of a local file at objectKey under
+// a filesystem provider's root, with manifest next to it
+func writeTestObject(t *testing.T, root, objectKey, localPath string, manifest *BackupManifest) {
+	t.Helper()
+	objectPath := filepath.Join(root, filepath.FromSlash(objectKey))
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := os.Create(objectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor := gzip.NewWriter(object)
+	if _, err := compressor.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := object.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(objectPath+manifestSuffix, encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportCatalogThenRestore(t *testing.T) {
+	service, connRepo, providerService := newTestBackupService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	dir := t.TempDir()
+
+	target := filepath.Join(dir, "app.sqlite")
+	conn := connection.StoredConnection{ID: uuid.NewString(), Name: "App", Type: "sqlite", DatabaseName: target, UserID: userID}
+	if err := connRepo.Save(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "provider")
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Disk", Type: ProviderTypeFilesystem, PathPrefix: &root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup was taken by another server, of a connection with the same name
+	dump := filepath.Join(dir, "dump.sqlite")
+	createTestSQLite(t, dump, "restored")
+	_, sha256Hash, err := CalculateFileChecksums(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compression := common.CompressionGzip
+	now := time.Now().UTC().Truncate(time.Second)
+	manifest := &BackupManifest{
+		Version:        manifestVersion,
+		BackupID:       uuid.NewString(),
+		ConnectionID:   uuid.NewString(),
+		ConnectionName: conn.Name,
+		ConnectionType: conn.Type,
+		Status:         "success",
+		SHA256Hash:     &sha256Hash,
+		Compression:    &compression,
+		StartedTime:    now,
+		CompletedTime:  &now,
+		CreatedAt:      now,
+	}
+	writeTestObject(t, root, "app/app_20250101_000000.sqlite.gz", dump, manifest)
+
+	result, err := service.ImportCatalog(ctx, provider.ID.String(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 1 {
+		t.Fatalf("imported %d backups, want 1: %+v", result.Imported, result.Results)
+	}
+
+	backup, err := service.backupRepo.GetBackup(manifest.BackupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPath := filepath.Join(service.backupDir, "app", "app_20250101_000000.sqlite")
+	if backup.Path != wantPath {
+		t.Errorf("imported backup path is %q, want %q", backup.Path, wantPath)
+	}
+
+	if err := service.RestoreBackup(RestoreRequest{BackupID: manifest.BackupID, ConnectionID: conn.ID}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if value := readTestSQLite(t, target); value != "restored" {
+		t.Errorf("restored database holds %q, want %q", value, "restored")
+	}
+}
+
+func TestImportCatalogChecksManifests(t *testing.T) {
+	service, connRepo, providerService := newTestBackupService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	dir := t.TempDir()
+
+	conn := connection.StoredConnection{ID: uuid.NewString(), Name: "App", Type: "sqlite", DatabaseName: filepath.Join(dir, "app.sqlite"), UserID: userID}
+	if err := connRepo.Save(conn); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "provider")
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Disk", Type: ProviderTypeFilesystem, PathPrefix: &root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	single := &Backup{ID: uuid.New(), ConnectionID: conn.ID, Status: "success", StartedTime: now, CreatedAt: now, UpdatedAt: now}
+	if err := service.backupRepo.CreateBackup(single); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := filepath.Join(dir, "dump.sqlite")
+	createTestSQLite(t, dump, "imported")
+	newManifest := func(modify func(*BackupManifest)) *BackupManifest {
+		manifest := &BackupManifest{
+			Version:        manifestVersion,
+			BackupID:       uuid.NewString(),
+			ConnectionID:   conn.ID,
+			ConnectionName: conn.Name,
+			ConnectionType: conn.Type,
+			Status:         "success",
+			StartedTime:    now,
+			CreatedAt:      now,
+		}
+		modify(manifest)
+		return manifest
+	}
+	algorithm, keyID, dataKey := backupEncryptionAlgorithm, "0000000000000000", "not-a-wrapped-key"
+	singleID, parentID := single.ID.String(), uuid.NewString()
+	manifests := map[string]*BackupManifest{
+		"app/running.sqlite": newManifest(func(m *BackupManifest) { m.Status = "in_progress" }),
+		"app/foreign-key.sqlite": newManifest(func(m *BackupManifest) {
+			m.EncryptionAlgorithm, m.EncryptionKeyID, m.EncryptedDataKey = &algorithm, &keyID, &dataKey
+		}),
+		"app/not-a-child.sqlite": newManifest(func(m *BackupManifest) { m.ParentID, m.DatabaseName = &singleID, "db" }),
+		"app/child.sqlite":       newManifest(func(m *BackupManifest) { m.ParentID, m.DatabaseName = &parentID, "db" }),
+	}
+	for key, manifest := range manifests {
+		writeTestObject(t, root, key, dump, manifest)
+	}
+
+	result, err := service.ImportCatalog(ctx, provider.ID.String(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, imported := range result.Results {
+		want := ManifestSkipped
+		if imported.ManifestKey == "app/child.sqlite"+manifestSuffix {
+			want = ManifestImported
+		}
+		if imported.Status != want {
+			t.Errorf("%s was %s (%s), want %s", imported.ManifestKey, imported.Status, imported.Error, want)
+		}
+	}
+
+	parent, err := service.backupRepo.GetBackup(parentID)
+	if err != nil {
+		t.Fatalf("parent backup wasn't created: %v", err)
+	}
+	if !isServerBackup(parent) {
+		t.Errorf("parent backup has format %v, want %q", parent.Format, serverBackupFormat)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dendianugerah/velld/internal/mail"
@@ -15,7 +16,41 @@ import (
 )
 
 func (s *BackupService) createFailureNotification(connID string, backupErr error) error {
+	return s.notifyBackupProblem(connID, "Backup Failed", notification.BackupFailed, "failed", backupErr, nil)
+}
+
+// createPartialNotification warns that a backup reached only some of its storage
+// providers, listing those it failed on
+func (s *BackupService) createPartialNotification(connID string, backup *Backup) error {
+	failed := []string{}
+	uploads, err := s.backupRepo.GetBackupS3Providers(backup.ID.String())
+	if err != nil {
+		return fmt.Errorf("failed to get backup uploads: %v", err)
+	}
+	for _, upload := range uploads {
+		if upload.Status != "failed" {
+			continue
+		}
+		name := upload.ProviderID
+		if conn, err := s.connStorage.GetConnection(connID); err == nil {
+			if provider, err := s.s3ProviderService.GetS3Provider(upload.ProviderID, conn.UserID); err == nil {
+				name = provider.Name
+			}
+		}
+		if upload.Error != "" {
+			name = fmt.Sprintf("%s (%s)", name, upload.Error)
+		}
+		failed = append(failed, name)
+	}
 
+	uploadErr := fmt.Errorf("backup was only uploaded to some storage providers; failed on: %s", strings.Join(failed, "; "))
+	return s.notifyBackupProblem(connID, "Backup Partially Uploaded", notification.BackupPartial, "partial", uploadErr,
+		map[string]interface{}{"failed_providers": failed})
+}
+
+// notifyBackupProblem sends a notification about a failed or partial backup on each
+// channel the connection's user enabled. extra is added to the metadata.
+func (s *BackupService) notifyBackupProblem(connID string, title string, notificationType notification.NotificationType, status string, backupErr error, extra map[string]interface{}) error {
 	conn, err := s.connStorage.GetConnection(connID)
 	if err != nil {
 		log.Printf("Failed to get connection details: %v", err)
@@ -47,9 +82,13 @@ func (s *BackupService) createFailureNotification(connID string, backupErr error
 		"connection_id": connID,
 		"database_name": conn.DatabaseName,
 		"database_type": conn.Type,
+		"status":        status,
 		"error":         backupErr.Error(),
 		"timestamp":     time.Now().Format(time.RFC3339),
 	}
+	for key, value := range extra {
+		metadata[key] = value
+	}
 
 	metadataJSON, _ := json.Marshal(metadata)
 
@@ -58,9 +97,9 @@ func (s *BackupService) createFailureNotification(connID string, backupErr error
 		notification := &notification.Notification{
 			ID:        uuid.New(),
 			UserID:    conn.UserID,
-			Title:     "Backup Failed",
-			Message:   fmt.Sprintf("Backup failed for database '%s': %v", conn.DatabaseName, backupErr),
-			Type:      notification.BackupFailed,
+			Title:     title,
+			Message:   fmt.Sprintf("%s for database '%s': %v", title, conn.DatabaseName, backupErr),
+			Type:      notificationType,
 			Status:    notification.StatusUnread,
 			Metadata:  metadataJSON,
 			CreatedAt: time.Now(),
@@ -82,7 +121,7 @@ func (s *BackupService) createFailureNotification(connID string, backupErr error
 		log.Printf("Attempting to send email notification to: %s", *userSettings.Email)
 		// Use separate goroutine for email to prevent blocking
 		go func(emailAddr string, userSettings *settings.UserSettings, meta map[string]interface{}) {
-			if err := s.sendEmailNotification(emailAddr, userSettings, meta); err != nil {
+			if err := s.sendEmailNotification(emailAddr, title, userSettings, meta); err != nil {
 				log.Printf("Failed to send email notification: %v", err)
 			}
 		}(*userSettings.Email, userSettings, metadata)
@@ -95,10 +134,10 @@ func (s *BackupService) createFailureNotification(connID string, backupErr error
 	if userSettings.NotifyTelegram && userSettings.TelegramBotToken != nil && userSettings.TelegramChatID != nil {
 		go func(botToken string, chatID string, meta map[string]interface{}) {
 			message := formatTelegramMessage(
-				"Backup Failed",
+				title,
 				meta["database_name"].(string),
 				meta["database_type"].(string),
-				"failed",
+				status,
 				meta,
 			)
 			if err := s.sendTelegramNotification(botToken, chatID, message); err != nil {
@@ -132,7 +171,7 @@ func (s *BackupService) sendWebhookNotification(webhookURL string, data map[stri
 	}
 }
 
-func (s *BackupService) sendEmailNotification(email string, userSettings *settings.UserSettings, data map[string]interface{}) error {
+func (s *BackupService) sendEmailNotification(email string, title string, userSettings *settings.UserSettings, data map[string]interface{}) error {
 	if userSettings == nil {
 		return fmt.Errorf("settings cannot be nil")
 	}
@@ -165,8 +204,8 @@ func (s *BackupService) sendEmailNotification(email string, userSettings *settin
 	msg := &mail.Message{
 		From:    *userSettings.SMTPUsername,
 		To:      email,
-		Subject: "Velld - Backup Failed",
-		Body:    fmt.Sprintf("Backup failed for database '%s'. Error: %v", data["database_name"], data["error"]),
+		Subject: "Velld - " + title,
+		Body:    fmt.Sprintf("%s for database '%s'. Error: %v", title, data["database_name"], data["error"]),
 	}
 
 	if err := mail.SendEmail(smtpConfig, msg); err != nil {
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// heldAt reports whether the backup is held past now
+func (b *Backup) heldAt(now time.Time) bool {
+	return b.HoldUntil != nil && b.HoldUntil.After(now)
+}
+
+// PinBackup pins a backup so retention never deletes it, or holds it until
+// req.HoldUntil. The objects of the backup, and of its per-database backups, are
+// locked too on providers with Object Lock: a legal hold for a pin, retention until
+// the date for a hold.
+func (s *BackupService) PinBackup(backup *Backup, userID uuid.UUID, req *PinBackupRequest) (*PinResult, error) {
+	if err := s.backupRepo.SetBackupPin(backup.ID.String(), req.HoldUntil, req.Reason, userID.String()); err != nil {
+		return nil, fmt.Errorf("failed to pin backup: %v", err)
+	}
+
+	ctx := context.Background()
+	locks := s.lockBackupObjects(ctx, backup, userID, func(locker ObjectLocker, objectKey string) error {
+		if req.HoldUntil == nil {
+			return locker.SetLegalHold(ctx, objectKey, true)
+		}
+		// A backup pinned before is held until the date from now on
+		if err := locker.RetainUntil(ctx, objectKey, *req.HoldUntil); err != nil {
+			return err
+		}
+		return locker.SetLegalHold(ctx, objectKey, false)
+	})
+
+	pinned, err := s.GetBackup(backup.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	return &PinResult{Backup: pinned, ObjectLocks: locks}, nil
+}
+
+// UnpinBackup removes the pin or hold of a backup, and the legal holds on its
+// objects. Object Lock retention set by a hold can't be shortened, so held objects
+// stay locked until their date.
+func (s *BackupService) UnpinBackup(backup *Backup, userID uuid.UUID) (*PinResult, error) {
+	if err := s.backupRepo.ClearBackupPin(backup.ID.String()); err != nil {
+		return nil, fmt.Errorf("failed to unpin backup: %v", err)
+	}
+
+	ctx := context.Background()
+	locks := s.lockBackupObjects(ctx, backup, userID, func(locker ObjectLocker, objectKey string) error {
+		return locker.SetLegalHold(ctx, objectKey, false)
+	})
+
+	unpinned, err := s.GetBackup(backup.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	return &PinResult{Backup: unpinned, ObjectLocks: locks}, nil
+}
+
+// lockBackupObjects runs lock on each stored object of a backup and its children,
+// on the providers that lock objects
+func (s *BackupService) lockBackupObjects(ctx context.Context, backup *Backup, userID uuid.UUID, lock func(locker ObjectLocker, objectKey string) error) []ObjectLockResult {
+	backups := []*Backup{backup}
+	if children, err := s.backupRepo.GetChildBackups(backup.ID.String()); err == nil {
+		backups = append(backups, children...)
+	}
+
+	results := []ObjectLockResult{}
+	lockers := make(map[string]ObjectLocker)
+	for _, b := range backups {
+		uploads, err := s.backupRepo.GetBackupS3Providers(b.ID.String())
+		if err != nil {
+			continue
+		}
+
+		for _, upload := range uploads {
+			if !upload.Stored() {
+				continue
+			}
+
+			locker, ok := lockers[upload.ProviderID]
+			if !ok {
+				storage, err := s.GetS3ProviderForDownload(upload.ProviderID, userID)
+				if err == nil {
+					if l, isLocker := storage.(ObjectLocker); isLocker && l.ObjectLockEnabled() {
+						locker = l
+					}
+				}
+				lockers[upload.ProviderID] = locker
+			}
+			if locker == nil {
+				continue
+			}
+
+			result := ObjectLockResult{
+				BackupID:   b.ID,
+				ProviderID: upload.ProviderID,
+				ObjectKey:  upload.ObjectKey,
+				Success:    true,
+			}
+			if err := lock(locker, upload.ObjectKey); err != nil {
+				result.Success = false
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
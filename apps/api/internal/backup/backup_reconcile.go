@@ -0,0 +1,331 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+const (
+	// orphanGracePeriod is how old an object no backup references must be to be an
+	// orphan, as uploads are only recorded once they finish. Manifests of referenced
+	// objects and snapshots of velld's own database aren't orphans.
+	orphanGracePeriod = 24 * time.Hour
+
+	// Statuses of uploads whose object reconciling found broken
+	uploadMissing   = "missing"
+	uploadCorrupted = "corrupted"
+
+	// backupUnrestorable is the status of backups without any intact copy left
+	backupUnrestorable = "unrestorable"
+)
+
+var (
+	// errCatalogBusy is returned while another reconcile or import runs
+	errCatalogBusy = errors.New("a reconcile or import is already running")
+	// errOrphansUnscoped is returned when deleting orphans on a provider whose objects
+	// velld doesn't have a folder of its own for
+	errOrphansUnscoped = errors.New("orphans can only be deleted on providers with a path prefix or base path")
+)
+
+// ReconcileProvider compares the objects on a provider with the uploads recorded for
+// it. It reports orphans, objects no backup references, and uploads whose object is
+// missing or has the wrong size, then fixes what req asks for. Only orphans velld
+// uploaded are deleted, and only on providers with a prefix of their own.
+func (s *BackupService) ReconcileProvider(ctx context.Context, providerID string, userID uuid.UUID, req *ReconcileRequest) (*ReconcileReport, error) {
+	if !s.catalogMutex.TryLock() {
+		return nil, errCatalogBusy
+	}
+	defer s.catalogMutex.Unlock()
+
+	provider, err := s.s3ProviderService.GetS3ProviderForDownload(providerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	keyPrefix, scoped := orphanScope(provider)
+	if req.DeleteOrphans && !scoped {
+		return nil, errOrphansUnscoped
+	}
+	storage, err := newProviderStorage(provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+
+	report := &ReconcileReport{
+		ProviderID:     providerID,
+		ProviderName:   provider.Name,
+		Orphans:        []OrphanObject{},
+		Missing:        []ObjectIssue{},
+		SizeMismatches: []ObjectIssue{},
+		Unrestorable:   []string{},
+		StartedAt:      time.Now(),
+	}
+
+	keys, err := storage.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	report.ObjectsListed = len(keys)
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+
+	uploads, err := s.backupRepo.GetProviderUploads(providerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get uploads: %v", err)
+	}
+	referenced, err := s.backupRepo.GetReferencedObjectKeys(providerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referenced objects: %v", err)
+	}
+
+	for _, upload := range uploads {
+		if !upload.Stored() || upload.BackupStatus == "in_progress" {
+			continue
+		}
+		report.UploadsChecked++
+
+		issue := ObjectIssue{BackupID: upload.BackupID, ObjectKey: upload.ObjectKey, ExpectedSize: upload.Size}
+		if !listed[upload.ObjectKey] {
+			s.fixObject(ctx, storage, upload, &issue, uploadMissing, userID, req, report)
+			report.Missing = append(report.Missing, issue)
+			continue
+		}
+
+		info, err := storage.StatObject(ctx, upload.ObjectKey)
+		if err != nil {
+			issue.Error = fmt.Sprintf("failed to stat object: %v", err)
+			report.SizeMismatches = append(report.SizeMismatches, issue)
+			continue
+		}
+		// Uploads recorded before sizes were don't have one
+		if upload.Size > 0 && info.Size != upload.Size {
+			issue.ActualSize = info.Size
+			s.fixObject(ctx, storage, upload, &issue, uploadCorrupted, userID, req, report)
+			report.SizeMismatches = append(report.SizeMismatches, issue)
+		}
+	}
+
+	for _, key := range keys {
+		if referenced[key] || (isManifestKey(key) && referenced[strings.TrimSuffix(key, manifestSuffix)]) || isSelfBackupKey(key) {
+			continue
+		}
+		info, err := storage.StatObject(ctx, key)
+		if err != nil || time.Since(info.LastModified) < orphanGracePeriod {
+			continue
+		}
+
+		orphan := OrphanObject{ObjectKey: key, Size: info.Size, LastModified: info.LastModified}
+		if req.DeleteOrphans {
+			if !isVelldObject(key, keyPrefix, listed) {
+				orphan.Error = "not uploaded by velld; left in place"
+			} else if err := deleteStoredObject(ctx, storage, key); err != nil {
+				orphan.Error = err.Error()
+			} else {
+				orphan.Deleted = true
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// orphanScope returns the prefix of the keys velld uploads to a provider, and
+// whether velld has a folder of its own there: a path prefix, or an SFTP or
+// filesystem base path other than the server's root. Filesystem keys are relative
+// to the base path, so they have no prefix.
+func orphanScope(provider *S3Provider) (string, bool) {
+	if provider.PathPrefix == nil {
+		return "", false
+	}
+	prefix := strings.Trim(path.Clean("/"+strings.TrimSpace(*provider.PathPrefix)), "/")
+	if prefix == "" {
+		return "", false
+	}
+	if provider.Type == ProviderTypeFilesystem {
+		return "", true
+	}
+	return prefix, true
+}
+
+// isVelldObject reports whether velld uploaded an object: a manifest, an object
+// with a manifest next to it, or a backup file in a connection's folder under
+// prefix. listed holds the keys on the provider.
+func isVelldObject(key, prefix string, listed map[string]bool) bool {
+	if isManifestKey(key) || listed[manifestKey(key)] {
+		return true
+	}
+
+	relative := strings.TrimPrefix(key, "/")
+	if prefix != "" {
+		if !strings.HasPrefix(relative, prefix+"/") {
+			return false
+		}
+		relative = strings.TrimPrefix(relative, prefix+"/")
+	}
+	folder, name, found := strings.Cut(relative, "/")
+	if !found || folder == "" || strings.Contains(name, "/") {
+		return false
+	}
+
+	for _, extension := range encryptionExtensions {
+		name = strings.TrimSuffix(name, extension)
+	}
+	compression := common.Compression{Codec: common.CompressionFromExtension(name)}
+	return connection.HasBackupExtension(strings.TrimSuffix(name, compression.Extension()))
+}
+
+// fixObject repairs or marks the broken object of an upload, as req asks. An object
+// is repaired by copying it from another provider with an intact copy; one that
+// can't be is marked with status, which leaves its backup unrestorable once no
+// intact copy is left.
+func (s *BackupService) fixObject(ctx context.Context, storage Storage, upload ProviderUpload, issue *ObjectIssue, status string, userID uuid.UUID, req *ReconcileRequest, report *ReconcileReport) {
+	if req.RepairMissing {
+		sourceID, err := s.repairObject(ctx, storage, upload, userID)
+		if err == nil {
+			issue.Repaired = true
+			issue.RepairedFrom = sourceID
+			return
+		}
+		issue.Error = err.Error()
+	}
+	if !req.MarkUnrestorable {
+		return
+	}
+
+	broken := upload.BackupS3Provider
+	broken.Status = status
+	broken.Error = "object is missing"
+	if status == uploadCorrupted {
+		broken.Error = fmt.Sprintf("object is %d bytes, expected %d", issue.ActualSize, issue.ExpectedSize)
+	}
+	if err := s.backupRepo.AddBackupS3Provider(upload.BackupID, broken); err != nil {
+		issue.Error = fmt.Sprintf("failed to mark upload: %v", err)
+		return
+	}
+	issue.Marked = true
+
+	if restorable, err := s.hasIntactCopy(upload.BackupID); err != nil || restorable {
+		return
+	}
+	if err := s.backupRepo.UpdateBackupStatus(upload.BackupID, backupUnrestorable); err != nil {
+		issue.Error = fmt.Sprintf("failed to mark backup unrestorable: %v", err)
+		return
+	}
+	report.Unrestorable = append(report.Unrestorable, upload.BackupID)
+}
+
+// hasIntactCopy reports whether a backup still has a stored upload or a local file
+func (s *BackupService) hasIntactCopy(backupID string) (bool, error) {
+	uploads, err := s.backupRepo.GetBackupS3Providers(backupID)
+	if err != nil {
+		return false, err
+	}
+	for _, upload := range uploads {
+		if upload.Stored() {
+			return true, nil
+		}
+	}
+
+	backup, err := s.backupRepo.GetBackup(backupID)
+	if err != nil {
+		return false, err
+	}
+	if backup.Path != "" {
+		if _, err := os.Stat(backup.Path); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// repairObject copies the object of an upload back to storage from another provider
+// the backup was stored on, returning that provider's ID
+func (s *BackupService) repairObject(ctx context.Context, storage Storage, upload ProviderUpload, userID uuid.UUID) (string, error) {
+	backup, err := s.backupRepo.GetBackup(upload.BackupID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get backup: %v", err)
+	}
+	conn, err := s.connStorage.GetConnection(backup.ConnectionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection: %v", err)
+	}
+
+	others, err := s.backupRepo.GetBackupS3Providers(upload.BackupID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get backup uploads: %v", err)
+	}
+	for _, other := range others {
+		if other.ProviderID == upload.ProviderID || !other.Stored() {
+			continue
+		}
+		source, err := s.GetS3ProviderForDownload(other.ProviderID, userID)
+		if err != nil {
+			continue
+		}
+		info, err := source.StatObject(ctx, other.ObjectKey)
+		if err != nil || (other.Size > 0 && info.Size != other.Size) {
+			continue
+		}
+
+		connectionName := common.SanitizeConnectionName(conn.Name)
+		objectKey, size, err := copyObject(ctx, source, storage, other.ObjectKey, connectionName)
+		if err != nil {
+			return "", fmt.Errorf("failed to copy from provider %s: %w", other.ProviderID, err)
+		}
+		if size != info.Size {
+			return "", fmt.Errorf("copied %d of %d bytes from provider %s", size, info.Size, other.ProviderID)
+		}
+		if err := copyManifest(ctx, source, storage, other.ObjectKey, objectKey, connectionName); err != nil {
+			fmt.Printf("Warning: Failed to copy manifest of %s from provider %s: %v\n", other.ObjectKey, other.ProviderID, err)
+		}
+
+		repaired := upload.BackupS3Provider
+		repaired.ObjectKey = objectKey
+		repaired.Size = size
+		repaired.Status = "success"
+		repaired.Error = ""
+		if err := s.backupRepo.AddBackupS3Provider(upload.BackupID, repaired); err != nil {
+			return "", fmt.Errorf("failed to record repaired upload: %v", err)
+		}
+
+		// The copy lands in the connection's current folder, which moves with renames
+		if objectKey != upload.ObjectKey && backup.S3ProviderID != nil && *backup.S3ProviderID == upload.ProviderID {
+			backup.S3ObjectKey = &objectKey
+			if err := s.backupRepo.UpdateBackup(backup); err != nil {
+				return "", fmt.Errorf("failed to update backup: %v", err)
+			}
+		}
+		return other.ProviderID, nil
+	}
+
+	return "", errors.New("no provider has an intact copy")
+}
+
+// copyObject copies an object between storages into the connection's folder,
+// returning its key on the destination and how many bytes were copied
+func copyObject(ctx context.Context, source, destination Storage, objectKey, connectionName string) (string, int64, error) {
+	object, err := source.GetObject(ctx, objectKey)
+	if err != nil {
+		return "", 0, err
+	}
+	defer object.Close()
+
+	counter := &countingReader{reader: object}
+	key, err := destination.UploadStream(ctx, counter, path.Base(objectKey), connectionName, "application/octet-stream", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	return key, counter.n, nil
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestReconcileDeletesOnlyVelldOrphans(t *testing.T) {
+	service, _, providerService := newTestBackupService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	root := t.TempDir()
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Disk", Type: ProviderTypeFilesystem, PathPrefix: &root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := map[string]bool{
+		"app/app_20250101_000000.sql.gz":       true,
+		"app/app_20250102_000000.dump.zst.enc": true,
+		"legacy/notes.txt":                     true, // Has a manifest
+		"legacy/notes.txt" + manifestSuffix:    true,
+		"notes.txt":                            false,
+		"app/readme.md":                        false,
+		"other/tool/dump.sql":                  false,
+	}
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	for key := range deleted {
+		objectPath := filepath.Join(root, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(objectPath, []byte(key), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(objectPath, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := service.ReconcileProvider(ctx, provider.ID.String(), userID, &ReconcileRequest{DeleteOrphans: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != len(deleted) {
+		t.Errorf("found %d orphans, want %d", len(report.Orphans), len(deleted))
+	}
+	for _, orphan := range report.Orphans {
+		if orphan.Deleted != deleted[orphan.ObjectKey] {
+			t.Errorf("%s deleted is %v (%s), want %v", orphan.ObjectKey, orphan.Deleted, orphan.Error, deleted[orphan.ObjectKey])
+		}
+		_, err := os.Stat(filepath.Join(root, filepath.FromSlash(orphan.ObjectKey)))
+		if exists := err == nil; exists == orphan.Deleted {
+			t.Errorf("%s exists is %v after reconciling", orphan.ObjectKey, exists)
+		}
+	}
+}
+
+func TestReconcileRefusesUnscopedOrphanDeletion(t *testing.T) {
+	service, _, providerService := newTestBackupService(t)
+	userID := uuid.New()
+
+	root := "/"
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Server root", Type: ProviderTypeFilesystem, PathPrefix: &root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.ReconcileProvider(context.Background(), provider.ID.String(), userID, &ReconcileRequest{DeleteOrphans: true})
+	if err != errOrphansUnscoped {
+		t.Fatalf("reconcile returned %v, want %v", err, errOrphansUnscoped)
+	}
+}
@@ -2,6 +2,7 @@ package backup
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -39,11 +40,14 @@ func (r *BackupRepository) CreateBackupSchedule(schedule *BackupSchedule) error
 	_, err := r.db.Exec(`
 		INSERT INTO backup_schedules (
 			id, connection_id, enabled, cron_schedule, retention_days,
-			next_run_time, last_backup_time, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			next_run_time, last_backup_time, created_at, updated_at,
+			keep_last, keep_daily, keep_weekly, keep_monthly, keep_yearly, retention_enforced
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
 		schedule.ID, schedule.ConnectionID, schedule.Enabled,
 		schedule.CronSchedule, schedule.RetentionDays,
-		nextRunStr, lastBackupStr, now, now)
+		nextRunStr, lastBackupStr, now, now,
+		schedule.Retention.KeepLast, schedule.Retention.KeepDaily, schedule.Retention.KeepWeekly,
+		schedule.Retention.KeepMonthly, schedule.Retention.KeepYearly, schedule.RetentionEnforced)
 	return err
 }
 
@@ -67,8 +71,14 @@ func (r *BackupRepository) UpdateBackupSchedule(schedule *BackupSchedule) error
 		    retention_days = $3, 
 		    next_run_time = $4,
 		    last_backup_time = $5,
-		    updated_at = $6
-		WHERE id = $7
+		    updated_at = $6,
+		    keep_last = $7,
+		    keep_daily = $8,
+		    keep_weekly = $9,
+		    keep_monthly = $10,
+		    keep_yearly = $11,
+		    retention_enforced = $12
+		WHERE id = $13
 	`
 
 	_, err := r.db.Exec(query,
@@ -78,6 +88,12 @@ func (r *BackupRepository) UpdateBackupSchedule(schedule *BackupSchedule) error
 		nextRunStr,
 		lastBackupStr,
 		time.Now(),
+		schedule.Retention.KeepLast,
+		schedule.Retention.KeepDaily,
+		schedule.Retention.KeepWeekly,
+		schedule.Retention.KeepMonthly,
+		schedule.Retention.KeepYearly,
+		schedule.RetentionEnforced,
 		schedule.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update backup schedule: %v", err)
@@ -96,14 +112,17 @@ func (r *BackupRepository) GetBackupSchedule(connectionID string) (*BackupSchedu
 	schedule := &BackupSchedule{}
 	err := r.db.QueryRow(`
 		SELECT id, connection_id, enabled, cron_schedule, retention_days,
-		       next_run_time, last_backup_time, created_at, updated_at 
+		       next_run_time, last_backup_time, created_at, updated_at,
+		       keep_last, keep_daily, keep_weekly, keep_monthly, keep_yearly, retention_enforced
 		FROM backup_schedules 
 		WHERE connection_id = $1
 		ORDER BY created_at DESC LIMIT 1`,
 		connectionID).Scan(
 		&schedule.ID, &schedule.ConnectionID, &schedule.Enabled,
 		&schedule.CronSchedule, &schedule.RetentionDays,
-		&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr)
+		&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr,
+		&schedule.Retention.KeepLast, &schedule.Retention.KeepDaily, &schedule.Retention.KeepWeekly,
+		&schedule.Retention.KeepMonthly, &schedule.Retention.KeepYearly, &schedule.RetentionEnforced)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +164,8 @@ func (r *BackupRepository) GetBackupSchedule(connectionID string) (*BackupSchedu
 func (r *BackupRepository) GetAllActiveSchedules() ([]*BackupSchedule, error) {
 	rows, err := r.db.Query(`
 		SELECT id, connection_id, enabled, cron_schedule, retention_days,
-		       next_run_time, last_backup_time, created_at, updated_at 
+		       next_run_time, last_backup_time, created_at, updated_at,
+		       keep_last, keep_daily, keep_weekly, keep_monthly, keep_yearly, retention_enforced
 		FROM backup_schedules 
 		WHERE enabled = true
 		ORDER BY created_at DESC`)
@@ -166,7 +186,9 @@ func (r *BackupRepository) GetAllActiveSchedules() ([]*BackupSchedule, error) {
 		err := rows.Scan(
 			&schedule.ID, &schedule.ConnectionID, &schedule.Enabled,
 			&schedule.CronSchedule, &schedule.RetentionDays,
-			&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr)
+			&nextRunStr, &lastBackupStr, &createdAtStr, &updatedAtStr,
+			&schedule.Retention.KeepLast, &schedule.Retention.KeepDaily, &schedule.Retention.KeepWeekly,
+			&schedule.Retention.KeepMonthly, &schedule.Retention.KeepYearly, &schedule.RetentionEnforced)
 		if err != nil {
 			return nil, err
 		}
@@ -211,15 +233,27 @@ func (r *BackupRepository) GetAllActiveSchedules() ([]*BackupSchedule, error) {
 // Backup Methods
 
 func (r *BackupRepository) CreateBackup(backup *Backup) error {
+	var dumpFilters *string
+	if !backup.DumpFilters.IsEmpty() {
+		data, err := json.Marshal(backup.DumpFilters)
+		if err != nil {
+			return fmt.Errorf("failed to encode dump filters: %v", err)
+		}
+		str := string(data)
+		dumpFilters = &str
+	}
+
 	_, err := r.db.Exec(`
 		INSERT INTO backups (
-			id, connection_id, schedule_id, status, path, s3_object_key, s3_provider_id, size, md5_hash, sha256_hash, logs,
-			started_time, completed_time, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+			id, connection_id, schedule_id, status, path, s3_object_key, s3_provider_id, size, md5_hash, sha256_hash, format, logs,
+			started_time, completed_time, created_at, updated_at, parent_id, database_name, dump_filters,
+			encryption_algorithm, encryption_key_id, encrypted_data_key, compression, compression_level
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`,
 		backup.ID, backup.ConnectionID, backup.ScheduleID,
-		backup.Status, backup.Path, backup.S3ObjectKey, backup.S3ProviderID, backup.Size, backup.MD5Hash, backup.SHA256Hash, backup.Logs,
+		backup.Status, backup.Path, backup.S3ObjectKey, backup.S3ProviderID, backup.Size, backup.MD5Hash, backup.SHA256Hash, backup.Format, backup.Logs,
 		backup.StartedTime, backup.CompletedTime,
-		backup.CreatedAt, backup.UpdatedAt)
+		backup.CreatedAt, backup.UpdatedAt, backup.ParentID, backup.DatabaseName, dumpFilters,
+		backup.EncryptionAlgorithm, backup.EncryptionKeyID, backup.EncryptedDataKey, backup.Compression, backup.CompressionLevel)
 	return err
 }
 
@@ -263,11 +297,17 @@ func (r *BackupRepository) UpdateBackup(backup *Backup) error {
 				logs = $8,
 				started_time = $9,
 				completed_time = $10,
-				updated_at = $11
-			WHERE id = $12`,
+				updated_at = $11,
+				encryption_algorithm = $12,
+				encryption_key_id = $13,
+				encrypted_data_key = $14,
+				compression = $15,
+				compression_level = $16
+			WHERE id = $17`,
 			backup.Status, backup.Path, backup.S3ObjectKey, backup.S3ProviderID, backup.Size, backup.MD5Hash, backup.SHA256Hash, logsValue,
 			backup.StartedTime.Format(time.RFC3339), completedTimeStr,
-			time.Now().Format(time.RFC3339), backup.ID)
+			time.Now().Format(time.RFC3339),
+			backup.EncryptionAlgorithm, backup.EncryptionKeyID, backup.EncryptedDataKey, backup.Compression, backup.CompressionLevel, backup.ID)
 		return err
 	} else {
 		// Don't update logs field - preserve existing logs
@@ -282,23 +322,33 @@ func (r *BackupRepository) UpdateBackup(backup *Backup) error {
 				sha256_hash = $7,
 				started_time = $8,
 				completed_time = $9,
-				updated_at = $10
-			WHERE id = $11`,
+				updated_at = $10,
+				encryption_algorithm = $11,
+				encryption_key_id = $12,
+				encrypted_data_key = $13,
+				compression = $14,
+				compression_level = $15
+			WHERE id = $16`,
 			backup.Status, backup.Path, backup.S3ObjectKey, backup.S3ProviderID, backup.Size, backup.MD5Hash, backup.SHA256Hash,
 			backup.StartedTime.Format(time.RFC3339), completedTimeStr,
-			time.Now().Format(time.RFC3339), backup.ID)
+			time.Now().Format(time.RFC3339),
+			backup.EncryptionAlgorithm, backup.EncryptionKeyID, backup.EncryptedDataKey, backup.Compression, backup.CompressionLevel, backup.ID)
 		return err
 	}
 }
 
-func (r *BackupRepository) GetBackupsOlderThan(connectionID string, cutoffTime time.Time) ([]*Backup, error) {
+// GetRetentionCandidates returns the connection's finished backups retention
+// applies to, newest first. All-databases children go with their parent, and failed
+// backups are left alone.
+func (r *BackupRepository) GetRetentionCandidates(connectionID string) ([]*Backup, error) {
 	rows, err := r.db.Query(`
-		SELECT id, path, created_at 
+		SELECT id, path, status, created_at, pinned, hold_until
 		FROM backups 
 		WHERE connection_id = $1 
-		AND created_at < $2 
-		AND status = 'completed'`,
-		connectionID, cutoffTime)
+		AND parent_id IS NULL
+		AND status IN ('success', 'partial', 'completed_with_errors')
+		ORDER BY created_at DESC`,
+		connectionID)
 	if err != nil {
 		return nil, err
 	}
@@ -306,9 +356,10 @@ func (r *BackupRepository) GetBackupsOlderThan(connectionID string, cutoffTime t
 
 	var backups []*Backup
 	for rows.Next() {
-		backup := &Backup{}
+		backup := &Backup{ConnectionID: connectionID}
 		var createdAtStr string
-		err := rows.Scan(&backup.ID, &backup.Path, &createdAtStr)
+		var holdUntilStr sql.NullString
+		err := rows.Scan(&backup.ID, &backup.Path, &backup.Status, &createdAtStr, &backup.Pinned, &holdUntilStr)
 		if err != nil {
 			return nil, err
 		}
@@ -317,14 +368,39 @@ func (r *BackupRepository) GetBackupsOlderThan(connectionID string, cutoffTime t
 			return nil, fmt.Errorf("error parsing created_at: %v", err)
 		}
 		backup.CreatedAt = createdAt
+		if holdUntilStr.Valid {
+			holdUntil, err := common.ParseTime(holdUntilStr.String)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing hold_until: %v", err)
+			}
+			backup.HoldUntil = &holdUntil
+		}
 		backups = append(backups, backup)
 	}
 	return backups, rows.Err()
 }
 
+// DeleteBackup deletes a backup record with its logs, uploads and shareable links,
+// as SQLite doesn't enforce their ON DELETE CASCADE
 func (r *BackupRepository) DeleteBackup(id string) error {
-	_, err := r.db.Exec("DELETE FROM backups WHERE id = $1", id)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM backup_logs WHERE backup_id = $1",
+		"DELETE FROM backup_s3_providers WHERE backup_id = $1",
+		"DELETE FROM shareable_links WHERE backup_id = $1",
+		"DELETE FROM backups WHERE id = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (r *BackupRepository) GetBackup(id string) (*Backup, error) {
@@ -338,15 +414,26 @@ func (r *BackupRepository) GetBackup(id string) (*Backup, error) {
 	var s3ProviderIDStr sql.NullString
 	var md5HashStr sql.NullString
 	var sha256HashStr sql.NullString
+	var formatStr sql.NullString
+	var parentIDStr, databaseNameStr sql.NullString
+	var dumpFiltersStr sql.NullString
+	var encryptionAlgorithmStr, encryptionKeyIDStr, encryptedDataKeyStr sql.NullString
+	var compressionStr sql.NullString
+	var compressionLevel sql.NullInt64
+	var holdUntilStr, pinReasonStr, pinnedByStr, pinnedAtStr sql.NullString
 	backup := &Backup{}
 	err := r.db.QueryRow(`
-		SELECT id, connection_id, schedule_id, status, path, s3_object_key, s3_provider_id, size, md5_hash, sha256_hash, logs,
-			   started_time, completed_time, created_at, updated_at 
+		SELECT id, connection_id, schedule_id, status, path, s3_object_key, s3_provider_id, size, md5_hash, sha256_hash, format, logs,
+			   started_time, completed_time, created_at, updated_at, parent_id, database_name, dump_filters,
+			   encryption_algorithm, encryption_key_id, encrypted_data_key, compression, compression_level,
+			   pinned, hold_until, pin_reason, pinned_by, pinned_at
 		FROM backups WHERE id = $1`, id).
 		Scan(&backup.ID, &backup.ConnectionID, &backup.ScheduleID,
-			&backup.Status, &backup.Path, &backup.S3ObjectKey, &s3ProviderIDStr, &backup.Size, &md5HashStr, &sha256HashStr, &logsStr,
+			&backup.Status, &backup.Path, &backup.S3ObjectKey, &s3ProviderIDStr, &backup.Size, &md5HashStr, &sha256HashStr, &formatStr, &logsStr,
 			&startedTimeStr, &completedTimeStr,
-			&createdAtStr, &updatedAtStr)
+			&createdAtStr, &updatedAtStr, &parentIDStr, &databaseNameStr, &dumpFiltersStr,
+			&encryptionAlgorithmStr, &encryptionKeyIDStr, &encryptedDataKeyStr, &compressionStr, &compressionLevel,
+			&backup.Pinned, &holdUntilStr, &pinReasonStr, &pinnedByStr, &pinnedAtStr)
 	if err != nil {
 		return nil, err
 	}
@@ -398,11 +485,125 @@ func (r *BackupRepository) GetBackup(id string) (*Backup, error) {
 		backup.SHA256Hash = &sha256HashStr.String
 	}
 
+	if formatStr.Valid {
+		backup.Format = &formatStr.String
+	}
+
+	if parentIDStr.Valid {
+		backup.ParentID = &parentIDStr.String
+	}
+	if databaseNameStr.Valid {
+		backup.DatabaseName = &databaseNameStr.String
+	}
+
+	if dumpFiltersStr.Valid && dumpFiltersStr.String != "" {
+		if err := json.Unmarshal([]byte(dumpFiltersStr.String), &backup.DumpFilters); err != nil {
+			return nil, fmt.Errorf("error parsing dump_filters: %v", err)
+		}
+	}
+
+	if encryptionAlgorithmStr.Valid {
+		backup.EncryptionAlgorithm = &encryptionAlgorithmStr.String
+	}
+	if encryptionKeyIDStr.Valid {
+		backup.EncryptionKeyID = &encryptionKeyIDStr.String
+	}
+	if encryptedDataKeyStr.Valid {
+		backup.EncryptedDataKey = &encryptedDataKeyStr.String
+	}
+	if compressionStr.Valid {
+		backup.Compression = &compressionStr.String
+	}
+	if compressionLevel.Valid {
+		level := int(compressionLevel.Int64)
+		backup.CompressionLevel = &level
+	}
+
+	if holdUntilStr.Valid {
+		holdUntil, err := common.ParseTime(holdUntilStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing hold_until: %v", err)
+		}
+		backup.HoldUntil = &holdUntil
+	}
+	if pinReasonStr.Valid {
+		backup.PinReason = &pinReasonStr.String
+	}
+	if pinnedByStr.Valid {
+		backup.PinnedBy = &pinnedByStr.String
+	}
+	if pinnedAtStr.Valid {
+		pinnedAt, err := common.ParseTime(pinnedAtStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing pinned_at: %v", err)
+		}
+		backup.PinnedAt = &pinnedAt
+	}
+
 	return backup, nil
 }
 
+// SetBackupPin pins a backup, or holds it until a date when holdUntil is set
+func (r *BackupRepository) SetBackupPin(id string, holdUntil *time.Time, reason string, userID string) error {
+	var holdUntilStr *string
+	if holdUntil != nil {
+		formatted := holdUntil.Format(time.RFC3339)
+		holdUntilStr = &formatted
+	}
+	now := time.Now().Format(time.RFC3339)
+	_, err := r.db.Exec(`
+		UPDATE backups SET pinned = $1, hold_until = $2, pin_reason = $3, pinned_by = $4, pinned_at = $5, updated_at = $6
+		WHERE id = $7`,
+		holdUntil == nil, holdUntilStr, reason, userID, now, now, id)
+	return err
+}
+
+// ClearBackupPin removes the pin or hold of a backup
+func (r *BackupRepository) ClearBackupPin(id string) error {
+	_, err := r.db.Exec(`
+		UPDATE backups SET pinned = 0, hold_until = NULL, pin_reason = NULL, pinned_by = NULL, pinned_at = NULL, updated_at = $1
+		WHERE id = $2`,
+		time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// GetChildBackups returns the per-database backups of an all-databases backup
+func (r *BackupRepository) GetChildBackups(parentID string) ([]*Backup, error) {
+	rows, err := r.db.Query(`
+		SELECT id FROM backups
+		WHERE parent_id = $1
+		ORDER BY database_name`, parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children := make([]*Backup, 0, len(ids))
+	for _, id := range ids {
+		child, err := r.GetBackup(id)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
 func (r *BackupRepository) GetAllBackupsWithPagination(opts BackupListOptions) ([]*BackupList, int, error) {
-	whereClause := "WHERE c.user_id = $1"
+	whereClause := "WHERE c.user_id = $1 AND b.parent_id IS NULL"
 	args := []interface{}{opts.UserID}
 	argCount := 2
 
@@ -427,7 +628,7 @@ func (r *BackupRepository) GetAllBackupsWithPagination(opts BackupListOptions) (
 		SELECT 
 			b.id, b.connection_id, c.type, b.schedule_id, b.status, b.path, b.s3_object_key, b.size,
 			b.started_time, b.completed_time, b.created_at, b.updated_at,
-			c.database_name
+			c.database_name, b.pinned, b.hold_until
 		FROM backups b
 		INNER JOIN connections c ON b.connection_id = c.id
 		%s
@@ -456,7 +657,7 @@ func (r *BackupRepository) GetAllBackupsWithPagination(opts BackupListOptions) (
 			&backup.ScheduleID, &backup.Status, &backup.Path, &backup.S3ObjectKey, &backup.Size,
 			&startedTimeStr, &completedTimeStr,
 			&createdAtStr, &updatedAtStr,
-			&backup.DatabaseName,
+			&backup.DatabaseName, &backup.Pinned, &backup.HoldUntil,
 		)
 		if err != nil {
 			return nil, 0, err
@@ -478,10 +679,10 @@ func (r *BackupRepository) GetActiveBackups(userID uuid.UUID) ([]*BackupList, er
 		SELECT 
 			b.id, b.connection_id, c.type, b.schedule_id, b.status, b.path, b.s3_object_key, b.size,
 			b.started_time, b.completed_time, b.created_at, b.updated_at,
-			c.database_name
+			c.database_name, b.pinned, b.hold_until
 		FROM backups b
 		INNER JOIN connections c ON b.connection_id = c.id
-		WHERE c.user_id = $1 AND b.status = 'in_progress'
+		WHERE c.user_id = $1 AND b.status = 'in_progress' AND b.parent_id IS NULL
 		ORDER BY b.started_time DESC
 	`
 
@@ -505,7 +706,7 @@ func (r *BackupRepository) GetActiveBackups(userID uuid.UUID) ([]*BackupList, er
 			&backup.ScheduleID, &backup.Status, &backup.Path, &backup.S3ObjectKey, &backup.Size,
 			&startedTimeStr, &completedTimeStr,
 			&createdAtStr, &updatedAtStr,
-			&backup.DatabaseName,
+			&backup.DatabaseName, &backup.Pinned, &backup.HoldUntil,
 		)
 		if err != nil {
 			return nil, err
@@ -764,7 +965,7 @@ func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error
 				COALESCE(SUM(b.size), 0) as total_size
 		FROM backups b
 		INNER JOIN connections c ON b.connection_id = c.id
-		WHERE c.user_id = $1
+		WHERE c.user_id = $1 AND b.parent_id IS NULL
 	`, userID).Scan(&stats.TotalBackups, &stats.FailedBackups, &stats.TotalSize)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -789,6 +990,7 @@ func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error
 		FROM backups b
 		INNER JOIN connections c ON b.connection_id = c.id
 		WHERE c.user_id = $1 
+		AND b.parent_id IS NULL
 		AND b.status = 'completed'
 		AND b.completed_time IS NOT NULL
 	`, userID)
@@ -825,14 +1027,19 @@ func (r *BackupRepository) GetBackupStats(userID uuid.UUID) (*BackupStats, error
 	return stats, nil
 }
 
-// AddBackupS3Provider adds an S3 provider record for a backup
-func (r *BackupRepository) AddBackupS3Provider(backupID, providerID, objectKey string) error {
+// AddBackupS3Provider records how the upload of a backup to an S3 provider went
+func (r *BackupRepository) AddBackupS3Provider(backupID string, upload BackupS3Provider) error {
 	id := uuid.New().String()
+	var uploadErr *string
+	if upload.Error != "" {
+		uploadErr = &upload.Error
+	}
 	_, err := r.db.Exec(`
-		INSERT INTO backup_s3_providers (id, backup_id, s3_provider_id, s3_object_key, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT(backup_id, s3_provider_id) DO UPDATE SET s3_object_key = $3, created_at = $5`,
-		id, backupID, providerID, objectKey, time.Now().Format(time.RFC3339))
+		INSERT INTO backup_s3_providers (id, backup_id, s3_provider_id, s3_object_key, status, size, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT(backup_id, s3_provider_id) DO UPDATE SET
+			s3_object_key = $4, status = $5, size = $6, error = $7, created_at = $8`,
+		id, backupID, upload.ProviderID, upload.ObjectKey, upload.Status, upload.Size, uploadErr, time.Now().Format(time.RFC3339))
 	return err
 }
 
@@ -840,12 +1047,21 @@ func (r *BackupRepository) AddBackupS3Provider(backupID, providerID, objectKey s
 type BackupS3Provider struct {
 	ProviderID string `json:"provider_id"`
 	ObjectKey  string `json:"object_key"`
+	Status     string `json:"status"` // success or failed; only successful uploads have an object
+	Size       int64  `json:"size"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Stored reports whether the backup was uploaded to the provider
+func (p BackupS3Provider) Stored() bool {
+	return p.Status == "success"
 }
 
-// GetBackupS3Providers returns all S3 providers for a backup
+// GetBackupS3Providers returns all S3 providers for a backup, including those the
+// upload failed for
 func (r *BackupRepository) GetBackupS3Providers(backupID string) ([]BackupS3Provider, error) {
 	rows, err := r.db.Query(`
-		SELECT s3_provider_id, s3_object_key
+		SELECT s3_provider_id, s3_object_key, status, size, error
 		FROM backup_s3_providers
 		WHERE backup_id = $1
 		ORDER BY created_at ASC`,
@@ -858,19 +1074,87 @@ func (r *BackupRepository) GetBackupS3Providers(backupID string) ([]BackupS3Prov
 	var providers []BackupS3Provider
 
 	for rows.Next() {
-		var providerID, objectKey string
-		if err := rows.Scan(&providerID, &objectKey); err != nil {
+		var providerID, objectKey, status string
+		var size sql.NullInt64
+		var uploadErr sql.NullString
+		if err := rows.Scan(&providerID, &objectKey, &status, &size, &uploadErr); err != nil {
 			return nil, err
 		}
 		providers = append(providers, BackupS3Provider{
 			ProviderID: providerID,
 			ObjectKey:  objectKey,
+			Status:     status,
+			Size:       size.Int64,
+			Error:      uploadErr.String,
 		})
 	}
 
 	return providers, rows.Err()
 }
 
+// ProviderUpload is an upload recorded for a provider, with the backup it's of
+type ProviderUpload struct {
+	BackupS3Provider
+	BackupID     string
+	BackupStatus string
+}
+
+// GetProviderUploads returns every upload recorded for a provider that has an object
+// key, whatever its status
+func (r *BackupRepository) GetProviderUploads(providerID string) ([]ProviderUpload, error) {
+	rows, err := r.db.Query(`
+		SELECT bsp.backup_id, b.status, bsp.s3_object_key, bsp.status, bsp.size, bsp.error
+		FROM backup_s3_providers bsp
+		JOIN backups b ON b.id = bsp.backup_id
+		WHERE bsp.s3_provider_id = $1 AND bsp.s3_object_key != ''
+		ORDER BY bsp.created_at ASC`,
+		providerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []ProviderUpload
+	for rows.Next() {
+		upload := ProviderUpload{BackupS3Provider: BackupS3Provider{ProviderID: providerID}}
+		var size sql.NullInt64
+		var uploadErr sql.NullString
+		if err := rows.Scan(&upload.BackupID, &upload.BackupStatus, &upload.ObjectKey, &upload.Status, &size, &uploadErr); err != nil {
+			return nil, err
+		}
+		upload.Size = size.Int64
+		upload.Error = uploadErr.String
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, rows.Err()
+}
+
+// GetReferencedObjectKeys returns the keys of the objects on a provider that a
+// backup, an upload or a tombstone references
+func (r *BackupRepository) GetReferencedObjectKeys(providerID string) (map[string]bool, error) {
+	rows, err := r.db.Query(`
+		SELECT s3_object_key FROM backups WHERE s3_provider_id = $1 AND s3_object_key IS NOT NULL
+		UNION SELECT s3_object_key FROM backup_s3_providers WHERE s3_provider_id = $1
+		UNION SELECT s3_object_key FROM backup_tombstones WHERE s3_provider_id = $1`,
+		providerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+
+	return keys, rows.Err()
+}
+
 // CreateShareableLink creates a shareable download link for a backup
 func (r *BackupRepository) CreateShareableLink(backupID, providerID, token string, expiresAt time.Time) error {
 	id := uuid.New().String()
@@ -912,3 +1196,158 @@ func (r *BackupRepository) GetShareableLink(token string) (backupID, providerID
 
 	return backupID, providerID, err
 }
+
+// SaveTombstone records a stored object of a deleted backup whose deletion is
+// retried later. Saving an object again counts another attempt.
+func (r *BackupRepository) SaveTombstone(tombstone *BackupTombstone) error {
+	_, err := r.db.Exec(`
+		INSERT INTO backup_tombstones (id, backup_id, user_id, s3_provider_id, s3_object_key, attempts, last_error, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT(s3_provider_id, s3_object_key) DO UPDATE SET
+			attempts = backup_tombstones.attempts + 1, last_error = $7, next_attempt_at = $8`,
+		tombstone.ID, tombstone.BackupID, tombstone.UserID, tombstone.ProviderID, tombstone.ObjectKey,
+		tombstone.Attempts, tombstone.LastError, tombstone.NextAttemptAt.UTC().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// UpdateTombstone records another failed attempt to delete a tombstone's object
+func (r *BackupRepository) UpdateTombstone(tombstone *BackupTombstone) error {
+	_, err := r.db.Exec(`
+		UPDATE backup_tombstones SET attempts = $1, last_error = $2, next_attempt_at = $3
+		WHERE id = $4`,
+		tombstone.Attempts, tombstone.LastError, tombstone.NextAttemptAt.UTC().Format(time.RFC3339), tombstone.ID)
+	return err
+}
+
+func (r *BackupRepository) DeleteTombstone(id string) error {
+	_, err := r.db.Exec(`DELETE FROM backup_tombstones WHERE id = $1`, id)
+	return err
+}
+
+// GetDueTombstones returns the tombstones whose deletion is due to be retried
+func (r *BackupRepository) GetDueTombstones(now time.Time) ([]*BackupTombstone, error) {
+	return r.queryTombstones(`
+		SELECT id, backup_id, user_id, s3_provider_id, s3_object_key, attempts, last_error, next_attempt_at, created_at
+		FROM backup_tombstones
+		WHERE next_attempt_at <= $1
+		ORDER BY next_attempt_at ASC`, now.UTC().Format(time.RFC3339))
+}
+
+// GetTombstones returns the objects of a user's deleted backups still waiting to be
+// deleted
+func (r *BackupRepository) GetTombstones(userID uuid.UUID) ([]*BackupTombstone, error) {
+	return r.queryTombstones(`
+		SELECT id, backup_id, user_id, s3_provider_id, s3_object_key, attempts, last_error, next_attempt_at, created_at
+		FROM backup_tombstones
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID.String())
+}
+
+func (r *BackupRepository) queryTombstones(query string, args ...interface{}) ([]*BackupTombstone, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tombstones := make([]*BackupTombstone, 0)
+	for rows.Next() {
+		var lastErr sql.NullString
+		var nextAttemptAtStr, createdAtStr string
+		tombstone := &BackupTombstone{}
+		err := rows.Scan(&tombstone.ID, &tombstone.BackupID, &tombstone.UserID, &tombstone.ProviderID, &tombstone.ObjectKey,
+			&tombstone.Attempts, &lastErr, &nextAttemptAtStr, &createdAtStr)
+		if err != nil {
+			return nil, err
+		}
+		tombstone.LastError = lastErr.String
+
+		nextAttemptAt, err := common.ParseTime(nextAttemptAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing next_attempt_at: %v", err)
+		}
+		tombstone.NextAttemptAt = nextAttemptAt
+
+		createdAt, err := common.ParseTime(createdAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing created_at: %v", err)
+		}
+		tombstone.CreatedAt = createdAt
+
+		tombstones = append(tombstones, tombstone)
+	}
+
+	return tombstones, rows.Err()
+}
+
+// GetSelfBackupSettings returns the schedule of velld's own database backups, or
+// sql.ErrNoRows when none was set up
+func (r *BackupRepository) GetSelfBackupSettings() (*SelfBackupSettings, error) {
+	var (
+		lastRunStr, lastStatus, lastError, lastObjectKey sql.NullString
+		lastSize                                         sql.NullInt64
+		updatedAtStr                                     string
+	)
+	settings := &SelfBackupSettings{}
+	err := r.db.QueryRow(`
+		SELECT user_id, s3_provider_id, enabled, cron_schedule, retention_days,
+		       keep_last, keep_daily, keep_weekly, keep_monthly, keep_yearly,
+		       last_run_at, last_status, last_error, last_object_key, last_size, updated_at
+		FROM self_backup_settings
+		WHERE id = 1`).Scan(
+		&settings.UserID, &settings.ProviderID, &settings.Enabled, &settings.CronSchedule, &settings.RetentionDays,
+		&settings.Retention.KeepLast, &settings.Retention.KeepDaily, &settings.Retention.KeepWeekly,
+		&settings.Retention.KeepMonthly, &settings.Retention.KeepYearly,
+		&lastRunStr, &lastStatus, &lastError, &lastObjectKey, &lastSize, &updatedAtStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastRunStr.Valid {
+		lastRun, err := common.ParseTime(lastRunStr.String)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing last_run_at: %v", err)
+		}
+		settings.LastRunAt = &lastRun
+	}
+	settings.LastStatus = lastStatus.String
+	settings.LastError = lastError.String
+	settings.LastObjectKey = lastObjectKey.String
+	settings.LastSize = lastSize.Int64
+
+	updatedAt, err := common.ParseTime(updatedAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing updated_at: %v", err)
+	}
+	settings.UpdatedAt = updatedAt
+
+	return settings, nil
+}
+
+// SaveSelfBackupSettings saves the schedule of velld's own database backups,
+// keeping how its last run went
+func (r *BackupRepository) SaveSelfBackupSettings(settings *SelfBackupSettings) error {
+	_, err := r.db.Exec(`
+		INSERT INTO self_backup_settings (id, user_id, s3_provider_id, enabled, cron_schedule, retention_days,
+			keep_last, keep_daily, keep_weekly, keep_monthly, keep_yearly, updated_at)
+		VALUES (1, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT(id) DO UPDATE SET
+			user_id = $1, s3_provider_id = $2, enabled = $3, cron_schedule = $4, retention_days = $5,
+			keep_last = $6, keep_daily = $7, keep_weekly = $8, keep_monthly = $9, keep_yearly = $10, updated_at = $11`,
+		settings.UserID, settings.ProviderID, settings.Enabled, settings.CronSchedule, settings.RetentionDays,
+		settings.Retention.KeepLast, settings.Retention.KeepDaily, settings.Retention.KeepWeekly,
+		settings.Retention.KeepMonthly, settings.Retention.KeepYearly,
+		settings.UpdatedAt.UTC().Format(time.RFC3339))
+	return err
+}
+
+// UpdateSelfBackupRun records how the last backup of velld's own database went
+func (r *BackupRepository) UpdateSelfBackupRun(runAt time.Time, status, errMsg, objectKey string, size int64) error {
+	_, err := r.db.Exec(`
+		UPDATE self_backup_settings
+		SET last_run_at = $1, last_status = $2, last_error = $3, last_object_key = $4, last_size = $5
+		WHERE id = 1`,
+		runAt.UTC().Format(time.RFC3339), status, errMsg, objectKey, size)
+	return err
+}
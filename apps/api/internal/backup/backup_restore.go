@@ -3,12 +3,11 @@ package backup
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
-	"github.com/dendianugerah/velld/internal/common"
 	"github.com/dendianugerah/velld/internal/connection"
 )
 
@@ -17,59 +16,37 @@ type RestoreRequest struct {
 	ConnectionID     string `json:"connection_id"`
 	TargetDatabaseName string `json:"target_database_name,omitempty"` // Optional: restore to different database name
 	SkipChecksumVerification bool `json:"skip_checksum_verification,omitempty"` // Optional: skip checksum verification
+	Jobs int `json:"jobs,omitempty"` // Optional: parallel restore jobs (PostgreSQL custom-format backups)
+	Databases []string `json:"databases,omitempty"` // Optional: databases to restore from an all-databases backup, all of them when empty
+	RestoreGlobals bool `json:"restore_globals,omitempty"` // Optional: also restore roles and users from an all-databases backup
+	PrivateKey string `json:"private_key,omitempty"` // Required for backups encrypted to public keys: age identities or an armored OpenPGP private key
+	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"` // Optional: unlocks a protected OpenPGP private key
 }
 
-var restoreTools = map[string]string{
-	"postgresql": "psql",
-	"mysql":      "mysql",
-	"mariadb":    "mysql",
-	"mongodb":    "mongorestore",
-}
-
-// RestoreBackup restores a backup to a target database connection
-// If targetDatabaseName is provided, restores to that database name instead of the connection's database name
-// If skipChecksumVerification is true, skips checksum verification even if checksum is valid
-func (s *BackupService) RestoreBackup(backupID string, connectionID string, targetDatabaseName string, skipChecksumVerification bool) error {
-	backup, err := s.backupRepo.GetBackup(backupID)
-	if err != nil {
-		return fmt.Errorf("failed to get backup: %v", err)
+// privateKey returns the private key given with the request, if any
+func (r RestoreRequest) privateKey() *BackupPrivateKey {
+	if strings.TrimSpace(r.PrivateKey) == "" {
+		return nil
 	}
+	return &BackupPrivateKey{Key: r.PrivateKey, Passphrase: r.PrivateKeyPassphrase}
+}
 
-	// Check if local file exists, if not try to download from S3
-	if _, err := os.Stat(backup.Path); os.IsNotExist(err) {
-		if backup.S3ObjectKey != nil && backup.S3ProviderID != nil {
-			// Get connection to find user ID
-			conn, err := s.connStorage.GetConnection(connectionID)
-			if err != nil {
-				return fmt.Errorf("failed to get connection: %v", err)
-			}
+// maxRestoreJobs caps the parallel restore jobs a request can ask for
+const maxRestoreJobs = 16
 
-			// Get S3 storage for download
-			s3Storage, err := s.GetS3ProviderForDownload(*backup.S3ProviderID, conn.UserID)
-			if err != nil {
-				return fmt.Errorf("failed to get S3 provider: %v", err)
-			}
+// RestoreBackup restores a backup to a target database connection
+// If TargetDatabaseName is provided, restores to that database name instead of the connection's database name
+// If SkipChecksumVerification is true, skips checksum verification even if checksum is valid
+func (s *BackupService) RestoreBackup(req RestoreRequest) error {
+	backupID, connectionID, targetDatabaseName := req.BackupID, req.ConnectionID, req.TargetDatabaseName
 
-			// Download from S3 to local path
-			ctx := context.Background()
-			if err := s3Storage.DownloadFile(ctx, *backup.S3ObjectKey, backup.Path); err != nil {
-				return fmt.Errorf("failed to download backup from S3: %v", err)
-			}
-		} else {
-			return fmt.Errorf("backup file not found: %s", backup.Path)
-		}
+	if req.Jobs < 0 || req.Jobs > maxRestoreJobs {
+		return fmt.Errorf("jobs must be between 1 and %d, or 0 for the default", maxRestoreJobs)
 	}
 
-	// Pre-restore verification: Verify backup file integrity before restore
-	// Invalid checksum format is handled inside verifyBackupBeforeRestore (logs warning and returns nil)
-	// Only actual checksum mismatches or other errors will fail the restore
-	// Skip verification if explicitly requested
-	if !skipChecksumVerification {
-		if err := s.verifyBackupBeforeRestore(backup, backup.Path); err != nil {
-			return fmt.Errorf("backup integrity verification failed: %w", err)
-		}
-	} else {
-		fmt.Printf("INFO: Skipping checksum verification as requested by user.\n")
+	backup, err := s.backupRepo.GetBackup(backupID)
+	if err != nil {
+		return fmt.Errorf("failed to get backup: %v", err)
 	}
 
 	conn, err := s.connStorage.GetConnection(connectionID)
@@ -77,216 +54,220 @@ func (s *BackupService) RestoreBackup(backupID string, connectionID string, targ
 		return fmt.Errorf("failed to get connection: %v", err)
 	}
 
-	if err := s.verifyRestoreTools(conn.Type); err != nil {
+	driver, err := connection.GetDriver(conn.Type)
+	if err != nil {
 		return err
 	}
 
-	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
-	if err != nil {
-		return fmt.Errorf("failed to setup SSH tunnel: %v", err)
+	if isServerBackup(backup) {
+		return s.restoreServerBackup(req, backup, conn, driver)
 	}
-	if tunnel != nil {
-		defer tunnel.Stop()
-		conn.Host = effectiveHost
-		conn.Port = effectivePort
+	if isRecipientEncrypted(backup) && req.privateKey() == nil {
+		return ErrPrivateKeyRequired
 	}
 
 	// Use target database name if provided, otherwise use connection's database name
 	databaseName := conn.DatabaseName
 	if targetDatabaseName != "" {
 		databaseName = targetDatabaseName
+	} else if databaseName == "" && backup.DatabaseName != nil {
+		// All-databases connections have no database of their own
+		databaseName = *backup.DatabaseName
 	}
 
-	var cmd *exec.Cmd
-	switch conn.Type {
-	case "postgresql":
-		cmd = s.createPsqlRestoreCmd(conn, backup.Path, databaseName)
-	case "mysql", "mariadb":
-		cmd = s.createMySQLRestoreCmd(conn, backup.Path, databaseName)
-	case "mongodb":
-		cmd = s.createMongoRestoreCmd(conn, backup.Path, databaseName)
-	default:
-		return fmt.Errorf("unsupported database type for restore: %s", conn.Type)
+	opts := connection.RestoreOptions{
+		Database:       databaseName,
+		SourceDatabase: s.backupSourceDatabase(backup, conn),
+		Jobs:           req.Jobs,
+	}
+	if backup.Format != nil {
+		opts.Format = *backup.Format
 	}
 
-	if cmd == nil {
-		return fmt.Errorf("restore tool not found for %s. Please ensure %s is installed", conn.Type, restoreTools[conn.Type])
+	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
+	if err != nil {
+		return fmt.Errorf("failed to setup SSH tunnel: %v", err)
+	}
+	if tunnel != nil {
+		defer tunnel.Stop()
+		conn.Host = effectiveHost
+		conn.Port = effectivePort
 	}
 
-	output, err := cmd.CombinedOutput()
-	return s.validateRestoreOutput(conn.Type, databaseName, output, err)
+	return s.restoreBackupFile(context.Background(), backup, conn, driver, opts, req.SkipChecksumVerification, req.privateKey())
 }
 
-func (s *BackupService) validateRestoreOutput(dbType, dbName string, output []byte, cmdErr error) error {
-	switch dbType {
-	case "postgresql":
-		return s.validatePostgreSQLRestore(output, cmdErr)
-	case "mysql", "mariadb":
-		return s.validateMySQLRestore(dbName, output, cmdErr)
-	case "mongodb":
-		return s.validateMongoDBRestore(dbName, output, cmdErr)
-	default:
-		return fmt.Errorf("unsupported database type: %s", dbType)
+// restoreBackupFile restores a single backup file, reading it from S3 when it isn't
+// stored locally. conn must already point at the SSH tunnel, if any. privateKey
+// decrypts backups encrypted to public keys.
+func (s *BackupService) restoreBackupFile(ctx context.Context, backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver, opts connection.RestoreOptions, skipVerification bool, privateKey *BackupPrivateKey) error {
+	// Server-wide objects are restored through their own driver
+	if opts.Format == connection.GlobalsFormat {
+		globals, ok := connection.GlobalsDriver(driver)
+		if !ok {
+			return fmt.Errorf("%s backups of server-wide objects can't be restored", conn.Type)
+		}
+		driver = globals
 	}
-}
-
-func (s *BackupService) validatePostgreSQLRestore(output []byte, cmdErr error) error {
-	outputStr := string(output)
-	lines := strings.Split(outputStr, "\n")
-
-	var criticalErrors []string
 
-	for _, line := range lines {
-		if !strings.Contains(line, "ERROR:") {
-			continue
-		}
+	// Check if local file exists, if not try to download from S3
+	_, statErr := os.Stat(backup.Path)
+	localMissing := os.IsNotExist(statErr)
+	if localMissing && (backup.S3ObjectKey == nil || backup.S3ProviderID == nil) {
+		return fmt.Errorf("backup file not found: %s", backup.Path)
+	}
 
-		if isCriticalPostgreSQLError(line) {
-			criticalErrors = append(criticalErrors, line)
+	// Drivers that restore from a stream read the backup straight from S3
+	backupPath := backup.Path
+	streamRestorer, canStream := driver.(connection.StreamRestorer)
+	if localMissing && !canStream {
+		// Get S3 storage for download
+		s3Storage, err := s.GetS3ProviderForDownload(*backup.S3ProviderID, conn.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to get S3 provider: %v", err)
 		}
-	}
 
-	if len(criticalErrors) > 0 {
-		for _, errLine := range criticalErrors {
-			if strings.Contains(errLine, "already exists") {
-				return fmt.Errorf("restore failed: target database must be empty. See documentation for restore best practices")
+		// Download from S3 to local path, decrypting and decompressing it. Backups
+		// encrypted to public keys are only decrypted into a private temporary file,
+		// so the server keeps no copy it can read once the restore is done.
+		if isRecipientEncrypted(backup) {
+			backupPath, err = s.downloadBackupObjectTemp(ctx, s3Storage, backup, privateKey)
+			if err == nil {
+				defer os.Remove(backupPath)
 			}
+		} else {
+			err = s.downloadBackupObject(ctx, s3Storage, backup, backupPath, privateKey)
 		}
-		return fmt.Errorf("restore failed with %d error(s)", len(criticalErrors))
+		if err != nil {
+			return fmt.Errorf("failed to download backup from S3: %v", err)
+		}
+		localMissing = false
 	}
 
-	return nil
-}
-
-func (s *BackupService) validateMySQLRestore(dbName string, output []byte, cmdErr error) error {
-	if cmdErr != nil {
-		outputStr := string(output)
-		if outputStr == "" {
-			outputStr = cmdErr.Error()
+	// Pre-restore verification: Verify backup file integrity before restore
+	// Invalid checksum format is handled inside verifyBackupBeforeRestore (logs warning and returns nil)
+	// Only actual checksum mismatches or other errors will fail the restore
+	// Skip verification if explicitly requested
+	// Streamed restores are verified as the data is read instead
+	if skipVerification {
+		fmt.Printf("INFO: Skipping checksum verification as requested by user.\n")
+	} else if !localMissing {
+		if err := s.verifyBackupBeforeRestore(backup, backupPath); err != nil {
+			return fmt.Errorf("backup integrity verification failed: %w", err)
 		}
-		return fmt.Errorf("restore failed for database '%s': %s", dbName, outputStr)
 	}
-	return nil
-}
 
-func (s *BackupService) validateMongoDBRestore(dbName string, output []byte, cmdErr error) error {
-	if cmdErr != nil {
-		outputStr := string(output)
-		if outputStr == "" {
-			outputStr = cmdErr.Error()
-		}
-		return fmt.Errorf("restore failed for database '%s': %s", dbName, outputStr)
+	if localMissing {
+		return s.restoreFromS3Stream(ctx, backup, conn, streamRestorer, opts, !skipVerification, privateKey)
 	}
-	return nil
+
+	return driver.Restore(ctx, conn, backupPath, opts)
 }
 
-func isCriticalPostgreSQLError(line string) bool {
-	nonCriticalPatterns := []string{
-		"WARNING:",
-		"must be member of role",
-		"no privileges",
-		"NOTICE:",
+// downloadBackupObject stores the dump in backup's S3 object at localPath
+func (s *BackupService) downloadBackupObject(ctx context.Context, s3Storage Storage, backup *Backup, localPath string, privateKey *BackupPrivateKey) error {
+	object, err := s.openBackupObject(ctx, s3Storage, backup, *backup.S3ObjectKey, true, privateKey)
+	if err != nil {
+		return err
 	}
+	defer object.Close()
 
-	for _, pattern := range nonCriticalPatterns {
-		if strings.Contains(line, pattern) {
-			return false
-		}
+	// Imported backups have never been in their connection's folder
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create backup folder: %w", err)
 	}
-
-	return true
-}
-
-func (s *BackupService) verifyRestoreTools(dbType string) error {
-	if _, exists := restoreTools[dbType]; !exists {
-		return fmt.Errorf("unsupported database type: %s", dbType)
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
 	}
-	return nil
-}
 
-func (s *BackupService) findDatabaseRestorePath(dbType string) string {
-	if path := common.FindBinaryPath(dbType, restoreTools[dbType]); path != "" {
-		return path
+	if _, err := io.Copy(file, object); err != nil {
+		file.Close()
+		os.Remove(localPath)
+		return err
 	}
-	return ""
+	return file.Close()
 }
 
-func (s *BackupService) createPsqlRestoreCmd(conn *connection.StoredConnection, backupPath string, databaseName string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath("postgresql")
-	if binaryPath == "" {
-		fmt.Printf("ERROR: psql binary not found. Please install PostgreSQL client tools.\n")
-		return nil
+// downloadBackupObjectTemp stores the dump in backup's S3 object in a temporary file
+// only the server's user can read, and returns its path. The caller removes it.
+func (s *BackupService) downloadBackupObjectTemp(ctx context.Context, s3Storage Storage, backup *Backup, privateKey *BackupPrivateKey) (string, error) {
+	object, err := s.openBackupObject(ctx, s3Storage, backup, *backup.S3ObjectKey, true, privateKey)
+	if err != nil {
+		return "", err
 	}
+	defer object.Close()
 
-	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools["postgresql"]))
-
-	// Use -v ON_ERROR_STOP=1 to exit immediately on first error
-	// This ensures errors are properly caught
-	cmd := exec.Command(binPath,
-		"-h", conn.Host,
-		"-p", fmt.Sprintf("%d", conn.Port),
-		"-U", conn.Username,
-		"-d", databaseName,
-		"-f", backupPath,
-		"-v", "ON_ERROR_STOP=1", // Exit on first error
-	)
+	// The backup's file name is kept, as some restore tools go by its extension
+	file, err := os.CreateTemp("", "velld-restore-*-"+filepath.Base(backup.Path))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
 
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
-	return cmd
+	if _, err := io.Copy(file, object); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
 }
 
-func (s *BackupService) createMySQLRestoreCmd(conn *connection.StoredConnection, backupPath string, databaseName string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath(conn.Type)
-	if binaryPath == "" {
-		fmt.Printf("ERROR: mysql binary not found. Please install MySQL/MariaDB client tools.\n")
-		return nil
+// backupSourceDatabase returns the database the backup was taken from, falling back
+// to the restore connection's database when the original connection is gone
+func (s *BackupService) backupSourceDatabase(backup *Backup, conn *connection.StoredConnection) string {
+	if backup.DatabaseName != nil && *backup.DatabaseName != "" {
+		return *backup.DatabaseName
 	}
+	if backup.ConnectionID != conn.ID {
+		if source, err := s.connStorage.GetConnection(backup.ConnectionID); err == nil {
+			return source.DatabaseName
+		}
+	}
+	return conn.DatabaseName
+}
 
-	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools[conn.Type]))
-
-	cmd := exec.Command(binPath,
-		"-h", conn.Host,
-		"-P", fmt.Sprintf("%d", conn.Port),
-		"-u", conn.Username,
-		fmt.Sprintf("-p%s", conn.Password),
-		databaseName,
-	)
+// restoreFromS3Stream pipes a backup from S3 into the driver without writing it to
+// disk. The checksum is calculated as the data is read, so a mismatch can only be
+// reported once the restore has finished.
+func (s *BackupService) restoreFromS3Stream(ctx context.Context, backup *Backup, conn *connection.StoredConnection, restorer connection.StreamRestorer, opts connection.RestoreOptions, verify bool, privateKey *BackupPrivateKey) error {
+	s3Storage, err := s.GetS3ProviderForDownload(*backup.S3ProviderID, conn.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 provider: %v", err)
+	}
 
-	file, err := os.Open(backupPath)
+	object, err := s.openBackupObject(ctx, s3Storage, backup, *backup.S3ObjectKey, true, privateKey)
 	if err != nil {
-		fmt.Printf("ERROR: failed to open backup file: %v\n", err)
-		return nil
+		return fmt.Errorf("failed to download backup from S3: %v", err)
 	}
+	defer object.Close()
 
-	cmd.Stdin = file
-	return cmd
-}
+	checksumReader, getChecksums := CalculateStreamChecksums(object)
+	if err := restorer.RestoreStream(ctx, conn, checksumReader, opts); err != nil {
+		return err
+	}
 
-func (s *BackupService) createMongoRestoreCmd(conn *connection.StoredConnection, backupPath string, databaseName string) *exec.Cmd {
-	binaryPath := s.findDatabaseRestorePath("mongodb")
-	if binaryPath == "" {
-		fmt.Printf("ERROR: mongorestore binary not found. Please install MongoDB Database Tools.\n")
+	if !verify || backup.SHA256Hash == nil || !isValidSHA256Hash(strings.TrimSpace(*backup.SHA256Hash)) {
 		return nil
 	}
 
-	binPath := filepath.Join(binaryPath, common.GetPlatformExecutableName(restoreTools["mongodb"]))
-
-	backupDir := filepath.Dir(backupPath)
-
-	args := []string{
-		"--host", conn.Host,
-		"--port", fmt.Sprintf("%d", conn.Port),
-		"--db", databaseName,
-		backupDir,
+	// The restore tool may stop before the end of the object; hash the rest of it
+	if _, err := io.Copy(io.Discard, checksumReader); err != nil {
+		return fmt.Errorf("failed to read backup for checksum verification: %v", err)
 	}
 
-	if conn.Username != "" {
-		args = append(args, "--username", conn.Username)
+	_, calculatedSHA256, err := getChecksums()
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum: %w", err)
 	}
 
-	if conn.Password != "" {
-		args = append(args, "--password", conn.Password)
+	storedHash := strings.TrimSpace(*backup.SHA256Hash)
+	if !strings.EqualFold(calculatedSHA256, storedHash) {
+		return fmt.Errorf("backup integrity verification failed: checksum mismatch: expected %s, got %s. The restored data may be incomplete", storedHash, calculatedSHA256)
 	}
 
-	return exec.Command(binPath, args...)
+	return nil
 }
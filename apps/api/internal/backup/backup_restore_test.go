@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+func TestRestoreRecipientEncryptedBackupLeavesNoPlaintext(t *testing.T) {
+	service, connRepo, providerService := newTestBackupService(t)
+	userID := uuid.New()
+	dir := t.TempDir()
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	target := filepath.Join(dir, "app.sqlite")
+	conn := connection.StoredConnection{ID: uuid.NewString(), Name: "App", Type: "sqlite", DatabaseName: target, UserID: userID}
+	if err := connRepo.Save(conn); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "provider")
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Disk", Type: ProviderTypeFilesystem, PathPrefix: &root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload a dump gzipped and encrypted to an age recipient
+	dump := filepath.Join(dir, "dump.sqlite")
+	createTestSQLite(t, dump, "restored")
+	_, sha256Hash, err := CalculateFileChecksums(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := common.ParseRecipients([]string{identity.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectKey := "app/app_20250101_000000.sqlite.gz.age"
+	if err := os.MkdirAll(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	object, err := os.Create(filepath.Join(root, filepath.FromSlash(objectKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter, err := recipients.Encrypt(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor := gzip.NewWriter(encrypter)
+	data, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressor.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, closer := range []interface{ Close() error }{compressor, encrypter, object} {
+		if err := closer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	providerID := provider.ID.String()
+	algorithm, compression := common.RecipientAge, common.CompressionGzip
+	backup := &Backup{
+		ID:                  uuid.New(),
+		ConnectionID:        conn.ID,
+		Status:              "success",
+		Path:                filepath.Join(service.backupDir, "app", "app_20250101_000000.sqlite"),
+		S3ObjectKey:         &objectKey,
+		S3ProviderID:        &providerID,
+		SHA256Hash:          &sha256Hash,
+		EncryptionAlgorithm: &algorithm,
+		EncryptionKeyID:     &recipients.IDs[0],
+		Compression:         &compression,
+		StartedTime:         now,
+		CompletedTime:       &now,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if err := service.backupRepo.CreateBackup(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	req := RestoreRequest{BackupID: backup.ID.String(), ConnectionID: conn.ID}
+	if err := service.RestoreBackup(req); err != ErrPrivateKeyRequired {
+		t.Fatalf("restore without the private key returned %v, want %v", err, ErrPrivateKeyRequired)
+	}
+
+	req.PrivateKey = identity.String()
+	if err := service.RestoreBackup(req); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if value := readTestSQLite(t, target); value != "restored" {
+		t.Errorf("restored database holds %q, want %q", value, "restored")
+	}
+
+	// Neither the backup's local path nor a temporary file holds the decrypted dump
+	if _, err := os.Stat(backup.Path); !os.IsNotExist(err) {
+		t.Errorf("decrypted dump left at %s (%v)", backup.Path, err)
+	}
+	if entries, err := os.ReadDir(tempDir); err != nil || len(entries) != 0 {
+		t.Errorf("restore left %d temporary files (%v)", len(entries), err)
+	}
+}
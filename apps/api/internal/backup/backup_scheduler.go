@@ -4,7 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,6 +30,12 @@ func (s *BackupService) ScheduleBackup(req *ScheduleBackupRequest) error {
 		existingSchedule.Enabled = true
 		existingSchedule.CronSchedule = req.CronSchedule
 		existingSchedule.RetentionDays = req.RetentionDays
+		if req.Retention != nil {
+			existingSchedule.Retention = *req.Retention
+		}
+		if req.EnforceRetention != nil {
+			existingSchedule.RetentionEnforced = *req.EnforceRetention
+		}
 		existingSchedule.NextRunTime = &nextRun
 		existingSchedule.UpdatedAt = time.Now()
 
@@ -55,16 +60,24 @@ func (s *BackupService) ScheduleBackup(req *ScheduleBackupRequest) error {
 		return nil
 	}
 
-	// Create new schedule if none exists
+	// Create new schedule if none exists. New schedules enforce retention unless
+	// asked not to.
 	backupSchedule := &BackupSchedule{
-		ID:            uuid.New(),
-		ConnectionID:  req.ConnectionID,
-		Enabled:       true,
-		CronSchedule:  req.CronSchedule,
-		RetentionDays: req.RetentionDays,
-		NextRunTime:   &nextRun,
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
+		ID:                uuid.New(),
+		ConnectionID:      req.ConnectionID,
+		Enabled:           true,
+		CronSchedule:      req.CronSchedule,
+		RetentionDays:     req.RetentionDays,
+		RetentionEnforced: true,
+		NextRunTime:       &nextRun,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+	if req.Retention != nil {
+		backupSchedule.Retention = *req.Retention
+	}
+	if req.EnforceRetention != nil {
+		backupSchedule.RetentionEnforced = *req.EnforceRetention
 	}
 
 	if err := s.backupRepo.CreateBackupSchedule(backupSchedule); err != nil {
@@ -122,16 +135,26 @@ func (s *BackupService) executeCronBackup(schedule *BackupSchedule) {
 		fmt.Printf("Error updating backup schedule: %v\n", err)
 	}
 
-	if schedule.RetentionDays > 0 {
-		s.cleanupOldBackups(schedule.ConnectionID, schedule.RetentionDays)
+	if schedule.RetentionEnforced && (schedule.RetentionDays > 0 || !schedule.Retention.isEmpty()) {
+		s.cleanupOldBackups(schedule.ConnectionID, schedule.RetentionDays, schedule.Retention)
 	}
 }
 
-func (s *BackupService) cleanupOldBackups(connectionID string, retentionDays int) {
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
-	oldBackups, err := s.backupRepo.GetBackupsOlderThan(connectionID, cutoffTime)
+// cleanupOldBackups deletes the connection's backups its retention doesn't keep
+func (s *BackupService) cleanupOldBackups(connectionID string, retentionDays int, policy RetentionPolicy) {
+	candidates, err := s.backupRepo.GetRetentionCandidates(connectionID)
 	if err != nil {
-		fmt.Printf("Error getting old backups for cleanup: %v\n", err)
+		fmt.Printf("Error getting backups for cleanup: %v\n", err)
+		return
+	}
+
+	var oldBackups []*Backup
+	for _, decision := range evaluateRetention(candidates, retentionDays, policy, time.Now()) {
+		if !decision.Keep {
+			oldBackups = append(oldBackups, decision.backup)
+		}
+	}
+	if len(oldBackups) == 0 {
 		return
 	}
 
@@ -142,34 +165,12 @@ func (s *BackupService) cleanupOldBackups(connectionID string, retentionDays int
 		return
 	}
 
+	// All-databases backups are removed together with their per-database backups
 	ctx := context.Background()
 	for _, backup := range oldBackups {
-		// Delete local file if it exists
-		if backup.Path != "" {
-			if err := os.Remove(backup.Path); err != nil && !os.IsNotExist(err) {
-				fmt.Printf("Warning: Failed to remove local backup file %s: %v\n", backup.Path, err)
-			}
-		}
-
-		// Delete S3 backups
-		s3Providers, err := s.backupRepo.GetBackupS3Providers(backup.ID.String())
-		if err == nil && len(s3Providers) > 0 {
-			for _, providerInfo := range s3Providers {
-				s3Storage, err := s.GetS3ProviderForDownload(providerInfo.ProviderID, conn.UserID)
-				if err == nil {
-					if err := s3Storage.DeleteFile(ctx, providerInfo.ObjectKey); err != nil {
-						fmt.Printf("Warning: Failed to delete S3 backup %s from provider %s: %v\n", 
-							providerInfo.ObjectKey, providerInfo.ProviderID, err)
-					} else {
-						fmt.Printf("Deleted S3 backup %s from provider %s\n", providerInfo.ObjectKey, providerInfo.ProviderID)
-					}
-				}
-			}
-		}
-
-		// Delete backup record from database
-		if err := s.backupRepo.DeleteBackup(backup.ID.String()); err != nil {
-			fmt.Printf("Error deleting backup record %s: %v\n", backup.ID.String(), err)
+		deletion := s.DeleteBackup(ctx, backup, conn.UserID)
+		if deletion.Error != "" {
+			fmt.Printf("Error deleting backup %s: %s\n", deletion.BackupID, deletion.Error)
 		}
 	}
 }
@@ -209,6 +210,12 @@ func (s *BackupService) UpdateBackupSchedule(connectionID string, req *UpdateSch
 
 	schedule.CronSchedule = req.CronSchedule
 	schedule.RetentionDays = req.RetentionDays
+	if req.Retention != nil {
+		schedule.Retention = *req.Retention
+	}
+	if req.EnforceRetention != nil {
+		schedule.RetentionEnforced = *req.EnforceRetention
+	}
 	err = s.backupRepo.UpdateBackupSchedule(schedule)
 	if err != nil {
 		return err
@@ -233,3 +240,46 @@ func (s *BackupService) UpdateBackupSchedule(connectionID string, req *UpdateSch
 
 	return nil
 }
+
+// DryRunRetention evaluates the retention of a connection's schedule without
+// deleting anything. Rules set in req are evaluated instead of the schedule's, and
+// are checked like a schedule's.
+func (s *BackupService) DryRunRetention(connectionID string, req *RetentionRequest) (*RetentionDryRun, error) {
+	dryRun := &RetentionDryRun{ConnectionID: connectionID}
+
+	schedule, err := s.backupRepo.GetBackupSchedule(connectionID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get backup schedule: %v", err)
+	}
+	if schedule != nil {
+		dryRun.RetentionDays = schedule.RetentionDays
+		dryRun.Retention = schedule.Retention
+		dryRun.Enforced = schedule.RetentionEnforced
+	}
+	if req.RetentionDays != nil {
+		dryRun.RetentionDays = *req.RetentionDays
+	}
+	if req.Retention != nil {
+		dryRun.Retention = *req.Retention
+	}
+	if err := validateRetention(dryRun.RetentionDays, &dryRun.Retention); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRetention, err)
+	}
+
+	candidates, err := s.backupRepo.GetRetentionCandidates(connectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backups: %v", err)
+	}
+
+	dryRun.Keep = []RetentionDecision{}
+	dryRun.Delete = []RetentionDecision{}
+	for _, decision := range evaluateRetention(candidates, dryRun.RetentionDays, dryRun.Retention, time.Now()) {
+		if decision.Keep {
+			dryRun.Keep = append(dryRun.Keep, decision)
+		} else {
+			dryRun.Delete = append(dryRun.Delete, decision)
+		}
+	}
+
+	return dryRun, nil
+}
@@ -0,0 +1,281 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+// serverBackupFormat is recorded on the parent backup of an all-databases run. Its
+// path is a folder, and the dumps are stored on its child backups.
+const serverBackupFormat = "server"
+
+func isServerBackup(backup *Backup) bool {
+	return backup.Format != nil && *backup.Format == serverBackupFormat
+}
+
+// serverBackupItem is one dump of an all-databases run
+type serverBackupItem struct {
+	database string // Empty for server-wide objects
+	driver   connection.DumpDriver
+}
+
+// executeServerBackup backs up every selected database on the server of an
+// all-databases connection, plus its roles and users where the driver supports it.
+// Each one is dumped and uploaded like a single-database backup into a child of
+// backup, which records the combined size and status.
+func (s *BackupService) executeServerBackup(ctx context.Context, backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver, tunneled bool, s3ProviderIDs []string) {
+	parentID := backup.ID.String()
+	s.sendLog(parentID, fmt.Sprintf("Starting backup of all %s databases on %s:%d", conn.Type, conn.Host, conn.Port))
+
+	databases, err := s.listServerDatabases(conn, tunneled)
+	if err != nil {
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("Failed to list databases: %v", err))
+		return
+	}
+
+	selected := conn.SelectDatabases(databases)
+	if len(selected) == 0 {
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("None of the %d database(s) on the server match the include and exclude patterns", len(databases)))
+		return
+	}
+	s.sendLog(parentID, fmt.Sprintf("[INFO] Backing up %d of %d database(s): %s", len(selected), len(databases), strings.Join(selected, ", ")))
+
+	if err := os.MkdirAll(backup.Path, 0755); err != nil {
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("Failed to create backup folder: %v", err))
+		return
+	}
+
+	var items []serverBackupItem
+	if globals, ok := connection.GlobalsDriver(driver); ok {
+		items = append(items, serverBackupItem{driver: globals})
+	} else {
+		s.sendLog(parentID, fmt.Sprintf("[INFO] %s has no server-wide objects to back up", conn.Type))
+	}
+	for _, database := range selected {
+		items = append(items, serverBackupItem{database: database, driver: driver})
+	}
+
+	failed, partial := 0, 0
+	for i, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+
+		label := fmt.Sprintf("database '%s'", item.database)
+		if item.database == "" {
+			label = "roles and users"
+		}
+		s.sendLog(parentID, fmt.Sprintf("[INFO] (%d/%d) Backing up %s", i+1, len(items), label))
+
+		child, err := s.runServerBackupItem(ctx, backup, conn, item, s3ProviderIDs)
+		if err != nil {
+			s.sendLog(parentID, fmt.Sprintf("[ERROR] Failed to back up %s: %v", label, err))
+			failed++
+			continue
+		}
+
+		backup.Size += child.Size
+		switch child.Status {
+		case "success":
+			s.sendLog(parentID, fmt.Sprintf("[SUCCESS] Backed up %s (%s)", label, s.formatBytes(child.Size)))
+		case "partial", "completed_with_errors":
+			s.sendLog(parentID, fmt.Sprintf("[WARNING] Backed up %s, but some uploads failed; see backup %s", label, child.ID))
+			partial++
+		default:
+			s.sendLog(parentID, fmt.Sprintf("[ERROR] Backup of %s failed; see backup %s", label, child.ID))
+			failed++
+		}
+	}
+
+	// The folder is left empty when every dump was uploaded and removed locally
+	os.Remove(backup.Path)
+
+	// StopBackup has already recorded the backup as cancelled
+	if ctx.Err() != nil {
+		s.sendLog(parentID, "[INFO] Backup process terminated")
+		s.cleanupLogStream(parentID)
+		return
+	}
+
+	now := time.Now()
+	backup.CompletedTime = &now
+	switch {
+	case failed == len(items):
+		backup.Status = "failed"
+		s.sendLog(parentID, "[ERROR] Backup failed for every database")
+	case failed > 0 || partial > 0:
+		backup.Status = "completed_with_errors"
+		s.sendLog(parentID, fmt.Sprintf("[WARNING] Backup completed with errors: %d of %d failed, %d with upload errors", failed, len(items), partial))
+	default:
+		backup.Status = "success"
+		s.sendLog(parentID, fmt.Sprintf("[SUCCESS] Backed up %d database(s). Total size: %s", len(selected), s.formatBytes(backup.Size)))
+	}
+
+	if err := s.backupRepo.UpdateBackup(backup); err != nil {
+		s.sendLog(parentID, fmt.Sprintf("[ERROR] Failed to update backup: %v", err))
+	}
+
+	go func() {
+		time.Sleep(2 * time.Second)
+		s.cleanupLogStream(parentID)
+	}()
+}
+
+// runServerBackupItem creates the child backup for one item and runs it. The child's
+// logs are stored on the child, not streamed with the parent's.
+func (s *BackupService) runServerBackupItem(ctx context.Context, parent *Backup, conn *connection.StoredConnection, item serverBackupItem, s3ProviderIDs []string) (*Backup, error) {
+	itemConn := *conn
+	itemConn.DatabaseName = item.database
+	itemConn.AllDatabases = false
+
+	filename := backupFilename(&itemConn, item.driver)
+	format, _ := connection.BackupFormat(item.driver, &itemConn)
+	parentID := parent.ID.String()
+	database := item.database
+
+	child := &Backup{
+		ID:           uuid.New(),
+		ConnectionID: parent.ConnectionID,
+		ScheduleID:   parent.ScheduleID,
+		StartedTime:  time.Now(),
+		Status:       "in_progress",
+		Path:         filepath.Join(parent.Path, filename),
+		Format:       &format,
+		DumpFilters:  conn.DumpFilters,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+		ParentID:     &parentID,
+		DatabaseName: &database,
+	}
+	if err := s.backupRepo.CreateBackup(child); err != nil {
+		return nil, fmt.Errorf("failed to create backup record: %w", err)
+	}
+
+	s.runBackup(ctx, child, &itemConn, item.driver, child.Path, filename, s3ProviderIDs)
+
+	// runBackup leaves stopped backups as they were, so close this one here
+	if ctx.Err() != nil && child.Status == "in_progress" {
+		child.Status = "cancelled"
+		now := time.Now()
+		child.CompletedTime = &now
+		if err := s.backupRepo.UpdateBackup(child); err != nil {
+			fmt.Printf("Warning: Failed to update backup %s: %v\n", child.ID, err)
+		}
+	}
+	return child, nil
+}
+
+// listServerDatabases lists the databases on a connection's server. When tunneled,
+// conn already points at the SSH tunnel, so the session connects to it directly.
+func (s *BackupService) listServerDatabases(conn *connection.StoredConnection, tunneled bool) ([]string, error) {
+	config := conn.Config()
+	config.ID = uuid.New().String()
+	if tunneled {
+		config.SSHEnabled = false
+	}
+
+	if err := s.connManager.Connect(config); err != nil {
+		return nil, err
+	}
+	defer s.connManager.Disconnect(config.ID)
+
+	return s.connManager.ListDatabases(config.ID)
+}
+
+// restoreServerBackup restores the databases of an all-databases backup under their
+// original names, or only those in req.Databases. Server-wide objects are restored
+// first, and only when req.RestoreGlobals is set. The target databases must exist.
+func (s *BackupService) restoreServerBackup(req RestoreRequest, backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver) error {
+	children, err := s.backupRepo.GetChildBackups(backup.ID.String())
+	if err != nil {
+		return fmt.Errorf("failed to get child backups: %v", err)
+	}
+
+	wanted := make(map[string]bool, len(req.Databases))
+	for _, database := range req.Databases {
+		wanted[database] = true
+	}
+
+	var selected []*Backup
+	for _, child := range children {
+		if child.Status != "success" && child.Status != "partial" && child.Status != "completed_with_errors" {
+			continue
+		}
+		database := ""
+		if child.DatabaseName != nil {
+			database = *child.DatabaseName
+		}
+		if database == "" {
+			if req.RestoreGlobals {
+				// Roles must exist before the databases that reference them
+				selected = append([]*Backup{child}, selected...)
+			}
+			continue
+		}
+		if len(wanted) > 0 && !wanted[database] {
+			continue
+		}
+		delete(wanted, database)
+		selected = append(selected, child)
+	}
+
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for database := range wanted {
+			missing = append(missing, database)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("backup has no successful backup of: %s", strings.Join(missing, ", "))
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("backup has no databases to restore")
+	}
+	if req.TargetDatabaseName != "" && len(req.Databases) != 1 {
+		return fmt.Errorf("target_database_name can only be used when restoring a single database")
+	}
+
+	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
+	if err != nil {
+		return fmt.Errorf("failed to setup SSH tunnel: %v", err)
+	}
+	if tunnel != nil {
+		defer tunnel.Stop()
+		conn.Host = effectiveHost
+		conn.Port = effectivePort
+	}
+
+	ctx := context.Background()
+	var errs []error
+	for _, child := range selected {
+		opts := connection.RestoreOptions{
+			Database:       *child.DatabaseName,
+			SourceDatabase: *child.DatabaseName,
+			Jobs:           req.Jobs,
+		}
+		if req.TargetDatabaseName != "" && opts.Database != "" {
+			opts.Database = req.TargetDatabaseName
+		}
+		if child.Format != nil {
+			opts.Format = *child.Format
+		}
+
+		if err := s.restoreBackupFile(ctx, child, conn, driver, opts, req.SkipChecksumVerification, req.privateKey()); err != nil {
+			label := opts.SourceDatabase
+			if label == "" {
+				label = "roles and users"
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,12 +1,10 @@
 package backup
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -38,6 +36,7 @@ func cleanS3Credential(cred string) string {
 
 type BackupService struct {
 	connStorage       *connection.ConnectionRepository
+	connManager       *connection.ConnectionManager
 	backupDir         string
 	backupRepo        *BackupRepository
 	cronManager       *cron.Cron
@@ -54,15 +53,20 @@ type BackupService struct {
 	concurrencySemaphore chan struct{} // Semaphore for limiting concurrent backups
 	concurrencyLimit     int           // Current concurrency limit
 	concurrencyMutex     sync.RWMutex  // Protects concurrency limit updates
-	// Command tracking for cancellation
-	runningCommands    map[string]*exec.Cmd // map[backupID]*exec.Cmd
-	runningCommandsMutex sync.RWMutex       // Protects running commands map
+	// Context tracking for cancellation
 	runningContexts     map[string]context.CancelFunc // map[backupID]cancelFunc
 	runningContextsMutex sync.RWMutex                 // Protects running contexts map
+	tombstoneMutex       sync.Mutex                   // Held while failed object deletions are retried
+	catalogMutex         sync.Mutex                   // Held while a provider is reconciled or imported
+	// Self-backup of velld's own database
+	dbPath          string
+	selfBackupEntry cron.EntryID
+	selfBackupMutex sync.Mutex // Held while the database is backed up
 }
 
 func NewBackupService(
 	connStorage *connection.ConnectionRepository,
+	connManager *connection.ConnectionManager,
 	backupDir string,
 	backupRepo *BackupRepository,
 	settingsService *settings.SettingsService,
@@ -80,6 +84,7 @@ func NewBackupService(
 	defaultLimit := 3
 	service := &BackupService{
 		connStorage:       connStorage,
+		connManager:       connManager,
 		backupDir:         backupDir,
 		backupRepo:        backupRepo,
 		settingsService:   settingsService,
@@ -92,7 +97,6 @@ func NewBackupService(
 		logWriteQueue:     make(map[string][]string),
 		concurrencyLimit:   defaultLimit,
 		concurrencySemaphore: make(chan struct{}, defaultLimit),
-		runningCommands:    make(map[string]*exec.Cmd),
 		runningContexts:     make(map[string]context.CancelFunc),
 	}
 
@@ -101,6 +105,10 @@ func NewBackupService(
 		fmt.Printf("Error recovering schedules: %v\n", err)
 	}
 
+	if _, err := cronManager.AddFunc(tombstoneRetrySchedule, service.retryTombstones); err != nil {
+		fmt.Printf("Error scheduling deletion retries: %v\n", err)
+	}
+
 	cronManager.Start()
 	return service
 }
@@ -143,13 +151,18 @@ func (s *BackupService) StartBackup(connectionID string, s3ProviderIDs []string)
 		return nil, fmt.Errorf("failed to get connection: %v", err)
 	}
 
-	if err := s.verifyBackupTools(conn.Type); err != nil {
+	driver, err := s.verifyBackupTools(conn.Type)
+	if err != nil {
 		return nil, err
 	}
 
 	backupID := uuid.New()
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.sql", conn.DatabaseName, timestamp)
+	filename := backupFilename(conn, driver)
+	format, _ := connection.BackupFormat(driver, conn)
+	if conn.AllDatabases {
+		// The databases are backed up as children into a folder named by filename
+		format = serverBackupFormat
+	}
 
 	connectionFolder := filepath.Join(s.backupDir, common.SanitizeConnectionName(conn.Name))
 	if err := os.MkdirAll(connectionFolder, 0755); err != nil {
@@ -164,6 +177,8 @@ func (s *BackupService) StartBackup(connectionID string, s3ProviderIDs []string)
 		StartedTime:  time.Now(),
 		Status:       "in_progress",
 		Path:         backupPath,
+		Format:       &format,
+		DumpFilters:  conn.DumpFilters,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
@@ -203,23 +218,20 @@ func (s *BackupService) StartBackup(connectionID string, s3ProviderIDs []string)
 		sem <- struct{}{} // Acquire slot
 		defer func() { <-sem }() // Release slot when done
 
-		s.executeBackup(backup, conn, backupPath, filename, s3ProviderIDs)
+		s.executeBackup(backup, conn, driver, backupPath, filename, s3ProviderIDs)
 	}()
 
 	return backup, nil
 }
 
-// StopBackup stops a running backup by killing its command
+// StopBackup stops a running backup by cancelling its context. Cancelling interrupts
+// the dump tool and kills it if it hasn't exited within two seconds.
 func (s *BackupService) StopBackup(backupID string) error {
-	s.runningCommandsMutex.Lock()
-	cmd, cmdExists := s.runningCommands[backupID]
-	s.runningCommandsMutex.Unlock()
-
 	s.runningContextsMutex.Lock()
 	cancel, ctxExists := s.runningContexts[backupID]
 	s.runningContextsMutex.Unlock()
 
-	if !cmdExists && !ctxExists {
+	if !ctxExists {
 		return fmt.Errorf("backup %s is not running", backupID)
 	}
 
@@ -234,31 +246,8 @@ func (s *BackupService) StopBackup(backupID string) error {
 	}
 
 	s.sendLog(backupID, "[INFO] Stopping backup...")
-
-	// Cancel the context first (this signals cancellation to any context-aware operations)
-	if cancel != nil {
-		cancel()
-		s.sendLog(backupID, "[INFO] Backup context cancelled")
-	}
-
-	// Try graceful termination first (SIGTERM)
-	if cmd != nil && cmd.Process != nil {
-		// Send SIGTERM for graceful shutdown
-		if err := cmd.Process.Signal(os.Interrupt); err == nil {
-			s.sendLog(backupID, "[INFO] Sent interrupt signal to backup process")
-			// Wait a moment for graceful shutdown
-			time.Sleep(2 * time.Second)
-		}
-		
-		// Force kill if still running (SIGKILL)
-		if err := cmd.Process.Kill(); err != nil {
-			s.sendLog(backupID, fmt.Sprintf("[ERROR] Failed to kill backup process: %v", err))
-			return fmt.Errorf("failed to kill backup process: %v", err)
-		}
-		s.sendLog(backupID, "[INFO] Backup process terminated")
-	}
-
-	s.sendLog(backupID, "[INFO] Backup stopped by user - PostgreSQL server will cancel the query")
+	cancel()
+	s.sendLog(backupID, "[INFO] Backup stopped by user")
 
 	// Update backup status
 	backup.Status = "cancelled"
@@ -270,10 +259,6 @@ func (s *BackupService) StopBackup(backupID string) error {
 	}
 
 	// Clean up tracking
-	s.runningCommandsMutex.Lock()
-	delete(s.runningCommands, backupID)
-	s.runningCommandsMutex.Unlock()
-
 	s.runningContextsMutex.Lock()
 	delete(s.runningContexts, backupID)
 	s.runningContextsMutex.Unlock()
@@ -338,7 +323,7 @@ func (s *BackupService) SetConcurrencyLimit(limit int) {
 }
 
 // executeBackup executes the actual backup process
-func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredConnection, backupPath string, filename string, s3ProviderIDs []string) {
+func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver, backupPath string, filename string, s3ProviderIDs []string) {
 	// Create a cancellable context for this backup
 	ctx, cancel := context.WithCancel(context.Background())
 	
@@ -358,8 +343,7 @@ func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredCon
 	// Setup SSH tunnel if enabled
 	tunnel, effectiveHost, effectivePort, err := s.setupSSHTunnelIfNeeded(conn)
 	if err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to setup SSH tunnel: %v", err))
-		s.cleanupLogStream(backup.ID.String())
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("Failed to setup SSH tunnel: %v", err))
 		return
 	}
 	if tunnel != nil {
@@ -369,10 +353,43 @@ func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredCon
 		conn.Port = effectivePort
 	}
 
+	if conn.AllDatabases {
+		s.executeServerBackup(ctx, backup, conn, driver, tunnel != nil, s3ProviderIDs)
+	} else {
+		s.runBackup(ctx, backup, conn, driver, backupPath, filename, s3ProviderIDs)
+	}
+
+	// Send success notification, or warn about the providers a partial backup missed
+	switch backup.Status {
+	case "success":
+		if err := s.createSuccessNotification(backup.ConnectionID, backup); err != nil {
+			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to send success notification: %v", err))
+		}
+	case "partial":
+		if err := s.createPartialNotification(backup.ConnectionID, backup); err != nil {
+			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to send partial backup notification: %v", err))
+		}
+	}
+}
+
+// runBackup dumps a single database and stores it on the configured S3 providers,
+// or locally when there are none. The outcome is recorded on backup.
+func (s *BackupService) runBackup(ctx context.Context, backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver, backupPath string, filename string, s3ProviderIDs []string) {
 	// Send initial log
-	s.sendLog(backup.ID.String(), fmt.Sprintf("Starting streaming backup for %s database '%s' on %s:%d", conn.Type, conn.DatabaseName, conn.Host, conn.Port))
-	s.sendLog(backup.ID.String(), fmt.Sprintf("Backup will be streamed directly to S3: %s", filename))
-	s.sendLog(backup.ID.String(), "[INFO] Using streaming mode - no local file will be created")
+	s.sendLog(backup.ID.String(), fmt.Sprintf("Starting backup for %s database '%s' on %s:%d", conn.Type, conn.DatabaseName, conn.Host, conn.Port))
+	if !backup.DumpFilters.IsEmpty() {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Dump filters: %s", backup.DumpFilters))
+	}
+
+	if err := s.prepareBackupEncryption(backup, conn); err != nil {
+		s.markBackupFailed(ctx, backup, err.Error())
+		return
+	}
+	if backup.recipients != nil {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Uploads will be encrypted to %d %s recipient(s); the private key is needed to restore", len(backup.recipients.IDs), backup.recipients.Kind))
+	} else if backup.EncryptionAlgorithm != nil {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Uploads will be encrypted with %s (master key %s)", *backup.EncryptionAlgorithm, *backup.EncryptionKeyID))
+	}
 
 	// Check if we have S3 providers configured
 	var providers []*S3Provider
@@ -408,238 +425,105 @@ func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredCon
 	// If no S3 providers, fall back to file-based backup
 	if len(providers) == 0 {
 		s.sendLog(backup.ID.String(), "[INFO] No S3 providers configured, falling back to file-based backup")
-		s.executeFileBasedBackup(backup, conn, backupPath, filename, s3ProviderIDs)
+		s.executeFileBasedBackup(ctx, backup, conn, driver, backupPath, s3ProviderIDs)
 		return
 	}
 
-	// Check versions for PostgreSQL backups
-	if conn.Type == "postgresql" {
-		// Check client version
-		clientVersion := "unknown"
-		if version, err := s.getPgDumpVersion(); err == nil {
-			clientVersion = version
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] pg_dump client version: %s", version))
-		} else {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Could not determine pg_dump version: %v", err))
-		}
-		
-		// Check server version
-		if serverVersion, err := s.getPostgreSQLServerVersion(conn); err == nil {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] PostgreSQL server version: %s", serverVersion))
-			
-			// Extract major version numbers for comparison
-			clientMajor := extractPostgreSQLMajorVersion(clientVersion)
-			serverMajor := extractPostgreSQLMajorVersion(serverVersion)
-			
-			if clientMajor != "" && serverMajor != "" && clientMajor != serverMajor {
-				s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Version mismatch detected! Client: %s, Server: %s", clientMajor, serverMajor))
-				s.sendLog(backup.ID.String(), "[WARNING] The backup may fail. Please install PostgreSQL client tools matching your server version.")
-			}
-		} else {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Could not determine server version: %v (this is not critical)", err))
-		}
-
-		// Check if TimescaleDB is installed
-		if s.isTimescaleDBInstalled(conn) {
-			s.sendLog(backup.ID.String(), "[INFO] TimescaleDB extension detected in database")
-			s.sendLog(backup.ID.String(), "[INFO] Warnings about circular foreign keys in hypertable, chunk, and continuous_agg tables are expected and safe to ignore")
-			s.sendLog(backup.ID.String(), "[INFO] These warnings are part of TimescaleDB's internal architecture and do not affect backup integrity")
-		}
-	}
-
-	// Create streaming command (outputs to stdout)
-	var cmd *exec.Cmd
-	switch conn.Type {
-	case "postgresql":
-		// Use plain format for streaming (custom format doesn't support stdout)
-		cmd = s.createPgDumpCmdForStreaming(conn)
-	case "mysql", "mariadb":
-		// Output to stdout for streaming
-		cmd = s.createMySQLDumpCmdForStreaming(conn)
-	case "mongodb":
-		// MongoDB doesn't support stdout streaming easily, fall back to file-based
-		s.sendLog(backup.ID.String(), "[INFO] MongoDB doesn't support stdout streaming, using file-based backup")
-		s.executeFileBasedBackup(backup, conn, backupPath, filename, s3ProviderIDs)
-		return
-	case "redis":
-		// Redis doesn't support stdout streaming, fall back to file-based
-		s.sendLog(backup.ID.String(), "[INFO] Redis doesn't support stdout streaming, using file-based backup")
-		s.executeFileBasedBackup(backup, conn, backupPath, filename, s3ProviderIDs)
-		return
-	default:
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Unsupported database type: %s", conn.Type))
-		s.cleanupLogStream(backup.ID.String())
+	// Drivers whose tools can't write to stdout are dumped to a local file and uploaded afterwards
+	streamer, ok := driver.(connection.StreamDumper)
+	if !ok {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] %s doesn't support stdout streaming, using file-based backup", conn.Type))
+		s.executeFileBasedBackup(ctx, backup, conn, driver, backupPath, s3ProviderIDs)
 		return
 	}
 
-	if cmd == nil {
-		errMsg := fmt.Sprintf("backup tool not found for %s. Please ensure %s is installed and available in PATH", conn.Type, requiredTools[conn.Type])
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] %s", errMsg))
-		s.cleanupLogStream(backup.ID.String())
-		return
-	}
-
-	// Capture stdout and stderr separately
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to create stdout pipe: %v", err))
-		s.cleanupLogStream(backup.ID.String())
-		return
-	}
+	s.sendLog(backup.ID.String(), fmt.Sprintf("Backup will be streamed directly to S3: %s", filename))
+	s.sendLog(backup.ID.String(), "[INFO] Using streaming mode - no local file will be created")
 
-	stderrPipe, err := cmd.StderrPipe()
+	encrypt, err := s.backupEncrypter(backup)
 	if err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to create stderr pipe: %v", err))
-		s.cleanupLogStream(backup.ID.String())
-		return
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to start backup command: %v", err))
-		s.cleanupLogStream(backup.ID.String())
+		s.markBackupFailed(ctx, backup, err.Error())
 		return
 	}
+	compression := backupCompression(conn, driver, true)
+	setBackupCompression(backup, compression)
 
-	// Track the running command for cancellation
-	s.runningCommandsMutex.Lock()
-	s.runningCommands[backup.ID.String()] = cmd
-	s.runningCommandsMutex.Unlock()
-
-	// Clean up command tracking when done
-	defer func() {
-		s.runningCommandsMutex.Lock()
-		delete(s.runningCommands, backup.ID.String())
-		s.runningCommandsMutex.Unlock()
-	}()
-
-	// Stream stderr for logs
-	var wg sync.WaitGroup
-	var outputErr error
-	var outputLines []string
-
-	wg.Add(1)
+	// Run the dump in the background, writing into a pipe that feeds the upload
+	pr, pw := io.Pipe()
+	dumpErrCh := make(chan error, 1)
 	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			outputLines = append(outputLines, line)
+		err := streamer.DumpStream(ctx, conn, pw, func(line string) {
 			s.sendLog(backup.ID.String(), line)
-		}
-		if err := scanner.Err(); err != nil && outputErr == nil {
-			outputErr = err
-		}
+		})
+		pw.CloseWithError(err)
+		dumpErrCh <- err
 	}()
 
 	// Stream backup data directly to S3 providers
-	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Starting streaming upload to %d S3 provider(s)...", len(providers)))
-	
-	// Calculate checksums as data streams through
-	checksumReader, getChecksums := CalculateStreamChecksums(stdoutPipe)
-	
-	// Create a pipe to stream backup data
-	pr, pw := io.Pipe()
-	
-	// Start goroutine to copy stdout to pipe with checksum calculation
-	var copyErr error
-	go func() {
-		defer pw.Close()
-		_, copyErr = io.Copy(pw, checksumReader)
-	}()
-
-	// Stream to first provider, then copy to others
-	firstProvider := providers[0]
-	
-	region := "us-east-1"
-	if firstProvider.Region != nil && *firstProvider.Region != "" {
-		region = *firstProvider.Region
+	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Starting streaming upload to %d S3 provider(s) in parallel...", len(providers)))
+	if compression.Codec != common.CompressionNone {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Compressing data on-the-fly with %s during upload...", compression))
 	}
 
-	pathPrefix := ""
-	if firstProvider.PathPrefix != nil {
-		pathPrefix = *firstProvider.PathPrefix
-	}
-
-	accessKey := cleanS3Credential(firstProvider.AccessKey)
-	secretKey := cleanS3Credential(firstProvider.SecretKey)
-	endpoint := strings.TrimSpace(firstProvider.Endpoint)
-	bucket := cleanS3Credential(firstProvider.Bucket)
-
-	s3Config := S3Config{
-		Endpoint:   endpoint,
-		Region:     region,
-		Bucket:     bucket,
-		AccessKey:  accessKey,
-		SecretKey:  secretKey,
-		UseSSL:     firstProvider.UseSSL,
-		PathPrefix: pathPrefix,
-	}
-
-	s3Storage, err := NewS3Storage(s3Config)
-	if err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to create S3 client: %v", err))
-		pr.Close()
-		cmd.Wait()
-		wg.Wait()
-		s.cleanupLogStream(backup.ID.String())
-		return
-	}
+	// Calculate checksums as data streams through
+	checksumReader, getChecksums := CalculateStreamChecksums(pr)
 
-	// Stream compressed data to S3
-	// UploadCompressedStream will add .gz extension and apply path prefix
+	// The dump is compressed and encrypted once, and the result is sent to every provider
 	// Use the existing ctx from the function start (can be cancelled)
+	encoded := encodeStream(checksumReader, compression, encrypt)
 	sanitizedConnectionName := common.SanitizeConnectionName(conn.Name)
-	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Streaming compressed backup to %s", firstProvider.Name))
-	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Bucket: %s", s3Storage.GetBucket()))
 	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Connection folder: %s", sanitizedConnectionName))
-	
-	uploadedKey, err := s3Storage.UploadCompressedStream(ctx, pr, filename, sanitizedConnectionName, func(message string) {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[%s] %s", firstProvider.Name, message))
-	})
 
-	// Wait for command and copy to complete
-	cmdErr := cmd.Wait()
-	wg.Wait()
+	uploads := s.streamToProviders(ctx, backup.ID.String(), providers, encoded, encodedObjectKey(filename, compression, encrypt),
+		sanitizedConnectionName, encodedContentType(compression, encrypt))
+
+	// Unblock the dump if every upload stopped reading early, then wait for it to finish
+	encoded.Close()
 	pr.Close()
+	dumpErr := <-dumpErrCh
+
+	// Uploads of a dump that failed hold a truncated copy, so they are deleted and
+	// recorded as failed rather than kept as copies of the backup
+	if dumpErr != nil {
+		for _, upload := range uploads {
+			if upload.err == nil {
+				upload.err = fmt.Errorf("dump failed: %w", dumpErr)
+				s.discardUpload(context.WithoutCancel(ctx), backup, conn.UserID, upload)
+			}
+		}
+	}
 
-	if cmdErr != nil || outputErr != nil || copyErr != nil || err != nil {
-		errorMsg := ""
-		if cmdErr != nil {
-			errorMsg = cmdErr.Error()
-		} else if outputErr != nil {
-			errorMsg = outputErr.Error()
-		} else if copyErr != nil {
-			errorMsg = copyErr.Error()
-		} else if err != nil {
-			errorMsg = err.Error()
+	// Record how the upload to each provider went
+	var stored []*providerUpload
+	var uploadErrors []string
+	for _, upload := range uploads {
+		if upload.err != nil {
+			uploadErrors = append(uploadErrors, fmt.Sprintf("%s: %v", upload.provider.Name, upload.err))
+		} else {
+			stored = append(stored, upload)
 		}
-		
-		if len(outputLines) > 0 && errorMsg == "" {
-			errorMsg = outputLines[len(outputLines)-1]
+		if err := s.backupRepo.AddBackupS3Provider(backup.ID.String(), upload.record()); err != nil {
+			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to track S3 provider %s: %v", upload.provider.Name, err))
 		}
+	}
 
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Backup failed: %s", errorMsg))
-		backup.Status = "failed"
-		now := time.Now()
-		backup.CompletedTime = &now
-		if err := s.backupRepo.UpdateBackup(backup); err != nil {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to update backup: %v", err))
-		}
-		s.cleanupLogStream(backup.ID.String())
+	if dumpErr != nil {
+		s.markBackupFailed(ctx, backup, dumpErr.Error())
+		return
+	}
+	if len(stored) == 0 {
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("failed to upload to any S3 provider: %s", strings.Join(uploadErrors, "; ")))
 		return
 	}
 
 	// Get uploaded file size from S3 and verify it exists
-	uploadedSize := int64(0)
-	if size, err := s3Storage.GetFileSize(ctx, uploadedKey); err == nil {
-		uploadedSize = size
-		backup.Size = size
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[SUCCESS] Backup streamed successfully. Size: %s", s.formatBytes(size)))
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] File verified in S3: s3://%s/%s", s3Storage.GetBucket(), uploadedKey))
+	first := stored[0]
+	backup.Size = first.size
+	if info, err := first.storage.StatObject(ctx, first.objectKey); err == nil {
+		backup.Size = info.Size
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[SUCCESS] Backup streamed successfully. Size: %s", s.formatBytes(info.Size)))
 	} else {
 		s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Could not verify file size in S3: %v", err))
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] File should be at: s3://%s/%s", s3Storage.GetBucket(), uploadedKey))
 	}
 
 	// Calculate and store checksums
@@ -653,49 +537,41 @@ func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredCon
 	}
 
 	// Post-upload verification: Download and verify file integrity
-	s.sendLog(backup.ID.String(), "[INFO] Starting post-upload integrity verification...")
-	if err := s.verifyUploadedBackup(ctx, s3Storage, uploadedKey, backup); err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Post-upload verification failed: %v", err))
-		s.sendLog(backup.ID.String(), "[WARNING] Backup uploaded but integrity verification failed. Please verify manually.")
-	} else {
-		s.sendLog(backup.ID.String(), "[SUCCESS] Post-upload integrity verification passed")
+	for _, upload := range stored {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Verifying uploaded backup integrity on %s...", upload.provider.Name))
+		if err := s.verifyUploadedBackup(ctx, upload.storage, upload.objectKey, backup); err != nil {
+			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Post-upload verification failed for %s: %v", upload.provider.Name, err))
+			s.sendLog(backup.ID.String(), "[WARNING] Backup uploaded but integrity verification failed. Please verify manually.")
+		} else {
+			s.sendLog(backup.ID.String(), fmt.Sprintf("[SUCCESS] Post-upload verification passed for %s", upload.provider.Name))
+		}
 	}
 
 	// Store S3 info
-	backup.S3ObjectKey = &uploadedKey
-	providerIDStr := firstProvider.ID.String()
+	backup.S3ObjectKey = &first.objectKey
+	providerIDStr := first.provider.ID.String()
 	backup.S3ProviderID = &providerIDStr
 	
-	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] S3 Object Key stored: %s", uploadedKey))
-
-	// Track S3 provider
-	if err := s.backupRepo.AddBackupS3Provider(backup.ID.String(), firstProvider.ID.String(), uploadedKey); err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to track S3 provider: %v", err))
-	}
-
-	// Upload to additional providers in parallel (copy from first)
-	if len(providers) > 1 {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] Copying backup to %d additional S3 provider(s)...", len(providers)-1))
-		uploadErr := s.uploadToAdditionalS3Providers(backup, conn.UserID, providers[1:], uploadedKey, uploadedSize)
-		if uploadErr != nil {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Some additional S3 uploads failed: %v", uploadErr))
-		}
-	}
+	s.sendLog(backup.ID.String(), fmt.Sprintf("[INFO] S3 Object Key stored: %s", first.objectKey))
 
 	now := time.Now()
 	backup.CompletedTime = &now
-	backup.Status = "success"
-	s.sendLog(backup.ID.String(), "[SUCCESS] Backup completed and streamed to all S3 providers successfully")
+	if len(uploadErrors) > 0 {
+		// At least one copy landed, so the backup is usable
+		backup.Status = "partial"
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Backup uploaded to %d/%d providers. Errors: %s",
+			len(stored), len(uploads), strings.Join(uploadErrors, "; ")))
+	} else {
+		backup.Status = "success"
+		s.sendLog(backup.ID.String(), "[SUCCESS] Backup completed and streamed to all S3 providers successfully")
+	}
 
 	// Update backup record
 	if err := s.backupRepo.UpdateBackup(backup); err != nil {
 		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to update backup: %v", err))
 	}
 
-	// Send success notification
-	if err := s.createSuccessNotification(backup.ConnectionID, backup); err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to send success notification: %v", err))
-	}
+	s.writeManifests(ctx, backup, conn, sanitizedConnectionName)
 
 	// Clean up log stream
 	go func() {
@@ -704,18 +580,67 @@ func (s *BackupService) executeBackup(backup *Backup, conn *connection.StoredCon
 	}()
 }
 
-// executeFileBasedBackup is the fallback method for file-based backups
-// Used for MongoDB, Redis, or when no S3 providers are configured
-func (s *BackupService) executeFileBasedBackup(backup *Backup, conn *connection.StoredConnection, backupPath string, filename string, s3ProviderIDs []string) {
-	// This uses the original file-based backup logic
-	// For simplicity, we'll just call uploadToS3Providers which handles file-based uploads
-	// The backup file should already be created by the calling code
-	
+// markBackupFailed records a backup as failed and closes its log stream.
+// Backups stopped through StopBackup are left as cancelled.
+func (s *BackupService) markBackupFailed(ctx context.Context, backup *Backup, errMsg string) {
+	if ctx.Err() != nil {
+		s.sendLog(backup.ID.String(), "[INFO] Backup process terminated")
+		s.cleanupLogStream(backup.ID.String())
+		return
+	}
+
+	s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Backup failed: %s", errMsg))
+	backup.Status = "failed"
+	now := time.Now()
+	backup.CompletedTime = &now
+	if err := s.backupRepo.UpdateBackup(backup); err != nil {
+		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to update backup: %v", err))
+	}
+	s.cleanupLogStream(backup.ID.String())
+}
+
+// dumpToFile writes a backup to outputPath, using the driver's own file dump when it has one
+func (s *BackupService) dumpToFile(ctx context.Context, driver connection.DumpDriver, conn *connection.StoredConnection, outputPath string, logFunc func(string)) error {
+	if fileDumper, ok := driver.(connection.FileDumper); ok {
+		return fileDumper.DumpFile(ctx, conn, outputPath, logFunc)
+	}
+
+	streamer, ok := driver.(connection.StreamDumper)
+	if !ok {
+		return fmt.Errorf("backups are not supported for %s", driver.Type())
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	if err := streamer.DumpStream(ctx, conn, file, logFunc); err != nil {
+		file.Close()
+		os.Remove(outputPath)
+		return err
+	}
+
+	return file.Close()
+}
+
+// executeFileBasedBackup dumps to a local file and then uploads it
+// Used for drivers without stdout streaming, or when no S3 providers are configured
+func (s *BackupService) executeFileBasedBackup(ctx context.Context, backup *Backup, conn *connection.StoredConnection, driver connection.DumpDriver, backupPath string, s3ProviderIDs []string) {
+	s.sendLog(backup.ID.String(), fmt.Sprintf("Backup file: %s", backupPath))
+
+	err := s.dumpToFile(ctx, driver, conn, backupPath, func(line string) {
+		s.sendLog(backup.ID.String(), line)
+	})
+	if err != nil {
+		s.markBackupFailed(ctx, backup, err.Error())
+		return
+	}
+
 	// Get file size
 	fileInfo, err := os.Stat(backupPath)
 	if err != nil {
-		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to get backup file info: %v", err))
-		s.cleanupLogStream(backup.ID.String())
+		s.markBackupFailed(ctx, backup, fmt.Sprintf("Failed to get backup file info: %v", err))
 		return
 	}
 
@@ -738,11 +663,20 @@ func (s *BackupService) executeFileBasedBackup(backup *Backup, conn *connection.
 	}
 
 	// Upload to S3 providers and determine final status
-	uploadErr := s.uploadToS3Providers(backup, conn.UserID, s3ProviderIDs)
+	setBackupCompression(backup, backupCompression(conn, driver, false))
+	uploadErr := s.uploadToS3Providers(ctx, backup, conn.UserID, s3ProviderIDs)
+
+	// StopBackup has already recorded the backup as cancelled, and the uploads were aborted
+	if ctx.Err() != nil {
+		s.sendLog(backup.ID.String(), "[INFO] Backup process terminated")
+		s.cleanupLogStream(backup.ID.String())
+		return
+	}
+
 	if uploadErr != nil {
 		errMsg := uploadErr.Error()
 		if strings.Contains(errMsg, "partial upload failure") {
-			backup.Status = "completed_with_errors"
+			backup.Status = "partial"
 			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Backup completed but some S3 uploads failed: %v", uploadErr))
 		} else if strings.Contains(errMsg, "No S3 providers configured") {
 			backup.Status = "success"
@@ -761,12 +695,7 @@ func (s *BackupService) executeFileBasedBackup(backup *Backup, conn *connection.
 		s.sendLog(backup.ID.String(), fmt.Sprintf("[ERROR] Failed to update backup: %v", err))
 	}
 
-	// Send success notification if backup was successful
-	if backup.Status == "success" {
-		if err := s.createSuccessNotification(backup.ConnectionID, backup); err != nil {
-			s.sendLog(backup.ID.String(), fmt.Sprintf("[WARNING] Failed to send success notification: %v", err))
-		}
-	}
+	s.writeManifests(ctx, backup, conn, connectionNameFromPath(backup.Path))
 
 	// Clean up local backup file after successful S3 upload
 	if backup.S3ObjectKey != nil && backup.S3ProviderID != nil {
@@ -784,14 +713,19 @@ func (s *BackupService) executeFileBasedBackup(backup *Backup, conn *connection.
 	}()
 }
 
-// CreateBackup is kept for backward compatibility but now calls StartBackup
+// CreateBackup runs a backup synchronously and returns once it has finished
 func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 	conn, err := s.connStorage.GetConnection(connectionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %v", err)
 	}
 
-	if err := s.verifyBackupTools(conn.Type); err != nil {
+	if conn.AllDatabases {
+		return nil, fmt.Errorf("connection %s backs up all databases, which only runs in the background", conn.Name)
+	}
+
+	driver, err := s.verifyBackupTools(conn.Type)
+	if err != nil {
 		return nil, err
 	}
 
@@ -808,8 +742,8 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 	}
 
 	backupID := uuid.New()
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s.sql", conn.DatabaseName, timestamp)
+	filename := backupFilename(conn, driver)
+	format, _ := connection.BackupFormat(driver, conn)
 
 	connectionFolder := filepath.Join(s.backupDir, common.SanitizeConnectionName(conn.Name))
 	if err := os.MkdirAll(connectionFolder, 0755); err != nil {
@@ -824,28 +758,12 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 		StartedTime:  time.Now(),
 		Status:       "in_progress",
 		Path:         backupPath,
+		Format:       &format,
+		DumpFilters:  conn.DumpFilters,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
-	var cmd *exec.Cmd
-	switch conn.Type {
-	case "postgresql":
-		cmd = s.createPgDumpCmd(conn, backupPath)
-	case "mysql", "mariadb":
-		cmd = s.createMySQLDumpCmd(conn, backupPath)
-	case "mongodb":
-		cmd = s.createMongoDumpCmd(conn, backupPath)
-	case "redis":
-		cmd = s.createRedisDumpCmd(conn, backupPath)
-	default:
-		return nil, fmt.Errorf("unsupported database type for backup: %s", conn.Type)
-	}
-
-	if cmd == nil {
-		return nil, fmt.Errorf("backup tool not found for %s. Please ensure %s is installed and available in PATH", conn.Type, requiredTools[conn.Type])
-	}
-
 	// Create log stream channel for this backup
 	logChan := make(chan string, 100)
 	s.logStreamsMutex.Lock()
@@ -856,89 +774,14 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 	s.sendLog(backupID.String(), fmt.Sprintf("Starting backup for %s database '%s' on %s:%d", conn.Type, conn.DatabaseName, conn.Host, conn.Port))
 	s.sendLog(backupID.String(), fmt.Sprintf("Backup file: %s", filename))
 
-	// Capture stdout and stderr separately for streaming
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		s.cleanupLogStream(backupID.String())
-		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
-	}
-
-	stderrPipe, err := cmd.StderrPipe()
+	err = s.dumpToFile(context.Background(), driver, conn, backupPath, func(line string) {
+		s.sendLog(backupID.String(), line)
+	})
 	if err != nil {
+		s.sendLog(backupID.String(), fmt.Sprintf("[ERROR] Backup failed: %v", err))
 		s.cleanupLogStream(backupID.String())
-		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		s.cleanupLogStream(backupID.String())
-		return nil, fmt.Errorf("failed to start backup command: %v", err)
-	}
-
-	// Track the running command for cancellation
-	s.runningCommandsMutex.Lock()
-	s.runningCommands[backupID.String()] = cmd
-	s.runningCommandsMutex.Unlock()
-
-	// Clean up command tracking when done
-	defer func() {
-		s.runningCommandsMutex.Lock()
-		delete(s.runningCommands, backupID.String())
-		s.runningCommandsMutex.Unlock()
-	}()
-
-	// Stream stdout and stderr
-	var wg sync.WaitGroup
-	var outputErr error
-	var outputLines []string
-
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			outputLines = append(outputLines, line)
-			s.sendLog(backupID.String(), line)
-		}
-		if err := scanner.Err(); err != nil {
-			outputErr = err
-		}
-	}()
-
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			outputLines = append(outputLines, line)
-			s.sendLog(backupID.String(), "[STDERR] "+line)
-		}
-		if err := scanner.Err(); err != nil && outputErr == nil {
-			outputErr = err
-		}
-	}()
-
-	// Wait for command to complete
-	cmdErr := cmd.Wait()
-	wg.Wait()
-
-	// Check for errors
-	if cmdErr != nil || outputErr != nil {
-		errorMsg := ""
-		if len(outputLines) > 0 {
-			errorMsg = outputLines[len(outputLines)-1]
-		}
-		if errorMsg == "" && cmdErr != nil {
-			errorMsg = cmdErr.Error()
-		}
-		if errorMsg == "" && outputErr != nil {
-			errorMsg = outputErr.Error()
-		}
-		s.sendLog(backupID.String(), fmt.Sprintf("[ERROR] Backup failed: %s", errorMsg))
-		s.cleanupLogStream(backupID.String())
-		return nil, fmt.Errorf("backup failed for %s database '%s' on %s:%d - %s",
-			conn.Type, conn.DatabaseName, conn.Host, conn.Port, errorMsg)
+		return nil, fmt.Errorf("backup failed for %s database '%s' on %s:%d - %v",
+			conn.Type, conn.DatabaseName, conn.Host, conn.Port, err)
 	}
 
 	// Get file size
@@ -953,14 +796,20 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 
 	s.sendLog(backupID.String(), fmt.Sprintf("Backup completed successfully. Size: %d bytes", backup.Size))
 
+	if err := s.prepareBackupEncryption(backup, conn); err != nil {
+		s.cleanupLogStream(backupID.String())
+		return nil, err
+	}
+	setBackupCompression(backup, backupCompression(conn, driver, false))
+
 	// Upload to S3 providers and determine final status
-	uploadErr := s.uploadToS3Providers(backup, conn.UserID, []string{})
+	uploadErr := s.uploadToS3Providers(context.Background(), backup, conn.UserID, []string{})
 	if uploadErr != nil {
 		// Check if it's a partial failure (some succeeded, some failed) or complete failure
 		errMsg := uploadErr.Error()
 		if strings.Contains(errMsg, "partial upload failure") {
 			// Some S3 uploads succeeded, some failed
-			backup.Status = "completed_with_errors"
+			backup.Status = "partial"
 			s.sendLog(backupID.String(), fmt.Sprintf("[WARNING] Backup completed but some S3 uploads failed: %v", uploadErr))
 		} else {
 			// All S3 uploads failed or no providers configured
@@ -986,6 +835,8 @@ func (s *BackupService) CreateBackup(connectionID string) (*Backup, error) {
 		return nil, fmt.Errorf("failed to update backup: %v", err)
 	}
 
+	s.writeManifests(context.Background(), backup, conn, connectionNameFromPath(backup.Path))
+
 	// Clean up local backup file after successful S3 upload
 	if backup.S3ObjectKey != nil && backup.S3ProviderID != nil {
 		// Only delete if at least one S3 upload succeeded
@@ -1125,7 +976,17 @@ func (s *BackupService) cleanupLogStream(backupID string) {
 }
 
 func (s *BackupService) GetBackup(id string) (*Backup, error) {
-	return s.backupRepo.GetBackup(id)
+	backup, err := s.backupRepo.GetBackup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if isServerBackup(backup) {
+		if backup.Children, err = s.backupRepo.GetChildBackups(id); err != nil {
+			return nil, fmt.Errorf("failed to get child backups: %v", err)
+		}
+	}
+	return backup, nil
 }
 
 func (s *BackupService) GetAllBackupsWithPagination(opts BackupListOptions) ([]*BackupList, int, error) {
@@ -1154,13 +1015,18 @@ func (s *BackupService) GetBackupLogs(backupID string) (string, error) {
 	return s.backupRepo.GetBackupLogs(backupID)
 }
 
-// GetS3ProviderForDownload gets an S3 provider for download operations
-func (s *BackupService) GetS3ProviderForDownload(providerID string, userID uuid.UUID) (*S3Storage, error) {
+// GetS3ProviderForDownload gets a provider's storage for download operations
+func (s *BackupService) GetS3ProviderForDownload(providerID string, userID uuid.UUID) (Storage, error) {
 	provider, err := s.s3ProviderService.GetS3ProviderForDownload(providerID, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	return newProviderStorage(provider)
+}
+
+// newProviderS3Storage creates an S3 client for a provider
+func newProviderS3Storage(provider *S3Provider) (*S3Storage, error) {
 	region := "us-east-1"
 	if provider.Region != nil && *provider.Region != "" {
 		region = *provider.Region
@@ -1185,6 +1051,8 @@ func (s *BackupService) GetS3ProviderForDownload(providerID string, userID uuid.
 		SecretKey:  secretKey,
 		UseSSL:     provider.UseSSL,
 		PathPrefix: pathPrefix,
+		ObjectLockMode: provider.ObjectLockMode,
+		ObjectLockDays: provider.ObjectLockDays,
 	}
 
 	return NewS3Storage(s3Config)
@@ -1223,54 +1091,44 @@ func (s *BackupService) GetConnection(connectionID string) (*connection.StoredCo
 	return s.connStorage.GetConnection(connectionID)
 }
 
-// isPostgreSQLVersionMismatchError checks if the error is a PostgreSQL version mismatch
-func (s *BackupService) isPostgreSQLVersionMismatchError(outputLines []string) bool {
-	for _, line := range outputLines {
-		if strings.Contains(strings.ToLower(line), "server version mismatch") ||
-			strings.Contains(strings.ToLower(line), "aborting because of server version mismatch") {
-			return true
+// verifyUploadedBackup downloads the uploaded backup from S3 and verifies its integrity.
+// The object is decrypted and decompressed as it is read, so its contents can be
+// checked against the checksum of the dump; without a valid checksum it is only
+// checked to have content. Backups encrypted to public keys can't be read by the
+// server, so only their size is checked.
+func (s *BackupService) verifyUploadedBackup(ctx context.Context, s3Storage Storage, objectKey string, backup *Backup) error {
+	if isRecipientEncrypted(backup) {
+		info, err := s3Storage.StatObject(ctx, objectKey)
+		if err != nil {
+			return fmt.Errorf("failed to check uploaded file: %w", err)
 		}
+		if info.Size == 0 {
+			return fmt.Errorf("uploaded file is empty")
+		}
+		return nil
 	}
-	return false
-}
 
-// verifyUploadedBackup downloads the uploaded backup from S3 and verifies its integrity
-// by checking that the file exists and has a valid size. This ensures the file was uploaded correctly.
-// Note: For compressed files uploaded via streaming, we verify the compressed file exists.
-// For uncompressed files, we can verify against the stored checksum.
-func (s *BackupService) verifyUploadedBackup(ctx context.Context, s3Storage *S3Storage, objectKey string, backup *Backup) error {
-	// Create a temporary file for download
-	tmpFile, err := os.CreateTemp("", "backup_verify_*")
+	object, err := s.openBackupObject(ctx, s3Storage, backup, objectKey, true, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
-	}
-	tmpPath := tmpFile.Name()
-	tmpFile.Close()
-	defer os.Remove(tmpPath)
-
-	// Download the file from S3
-	if err := s3Storage.DownloadFile(ctx, objectKey, tmpPath); err != nil {
 		return fmt.Errorf("failed to download file for verification: %w", err)
 	}
+	defer object.Close()
 
-	// Verify the file was downloaded successfully
-	fileInfo, err := os.Stat(tmpPath)
+	checksumReader, getChecksums := CalculateStreamChecksums(object)
+	size, err := io.Copy(io.Discard, checksumReader)
 	if err != nil {
-		return fmt.Errorf("failed to stat downloaded file: %w", err)
+		return fmt.Errorf("failed to download file for verification: %w", err)
 	}
 
-	if fileInfo.Size() == 0 {
+	if size == 0 {
 		return fmt.Errorf("downloaded file is empty")
 	}
 
-	// For uncompressed files (file-based backups), verify checksum matches
-	// For compressed files (streaming backups), we just verify the file exists and has content
-	if !strings.HasSuffix(objectKey, ".gz") && backup.SHA256Hash != nil && *backup.SHA256Hash != "" {
+	if backup.SHA256Hash != nil && *backup.SHA256Hash != "" {
 		storedHash := strings.TrimSpace(*backup.SHA256Hash)
 		// Only verify if stored checksum is valid (64 hex characters)
 		if isValidSHA256Hash(storedHash) {
-			// This is an uncompressed file, verify checksum
-			_, downloadedSHA256, err := CalculateFileChecksums(tmpPath)
+			_, downloadedSHA256, err := getChecksums()
 			if err != nil {
 				return fmt.Errorf("failed to calculate checksum of downloaded file: %w", err)
 			}
@@ -1351,37 +1209,21 @@ func (s *BackupService) formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.2f %s", size, sizes[i])
 }
 
-// extractPostgreSQLMajorVersion extracts the major version number from a PostgreSQL version string
-func extractPostgreSQLMajorVersion(versionStr string) string {
-	// Look for patterns like "16.1", "PostgreSQL 16.1", "pg_dump (PostgreSQL) 16.1", etc.
-	parts := strings.Fields(versionStr)
-	for _, part := range parts {
-		// Check if part starts with a number (major version)
-		if len(part) > 0 && part[0] >= '0' && part[0] <= '9' {
-			// Extract just the major version (first number before dot)
-			if dotIndex := strings.Index(part, "."); dotIndex > 0 {
-				return part[:dotIndex]
-			}
-			// If no dot, return first digit sequence
-			var major strings.Builder
-			for _, r := range part {
-				if r >= '0' && r <= '9' {
-					major.WriteRune(r)
-				} else {
-					break
-				}
-			}
-			if major.Len() > 0 {
-				return major.String()
-			}
-		}
-	}
-	return ""
-}
-
-// uploadToS3Providers uploads backup to specified S3 providers or falls back to default/legacy settings
-func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3ProviderIDs []string) error {
+// uploadToS3Providers uploads backup to specified S3 providers or falls back to default/legacy settings.
+// Cancelling ctx stops the uploads.
+func (s *BackupService) uploadToS3Providers(ctx context.Context, backup *Backup, userID uuid.UUID, s3ProviderIDs []string) error {
 	backupID := backup.ID.String()
+
+	encrypt, err := s.backupEncrypter(backup)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if backup.S3ObjectKey == nil {
+			clearBackupEncryption(backup)
+			clearBackupCompression(backup)
+		}
+	}()
 	
 	var providers []*S3Provider
 	
@@ -1414,7 +1256,7 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 			
 			// Fallback to legacy settings if no providers
 			if len(providers) == 0 {
-				return s.uploadToS3IfEnabled(backup, userID)
+				return s.uploadToS3IfEnabled(ctx, backup, userID, encrypt)
 			}
 		}
 	}
@@ -1429,6 +1271,7 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 	type uploadResult struct {
 		provider  *S3Provider
 		objectKey string
+		size      int64
 		err       error
 	}
 
@@ -1441,46 +1284,17 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 		go func(p *S3Provider) {
 			defer uploadWg.Done()
 
-			s.sendLog(backupID, fmt.Sprintf("[INFO] Starting S3 upload to provider: %s", p.Name))
+			s.sendLog(backupID, fmt.Sprintf("[INFO] Starting upload to provider: %s", p.Name))
 
-			region := "us-east-1"
-			if p.Region != nil && *p.Region != "" {
-				region = *p.Region
-			}
-
-			pathPrefix := ""
-			if p.PathPrefix != nil {
-				pathPrefix = *p.PathPrefix
-			}
-
-			// Credentials should already be cleaned by GetS3ProviderForUpload, but clean again for safety
-			accessKey := cleanS3Credential(p.AccessKey)
-			secretKey := cleanS3Credential(p.SecretKey)
-			endpoint := strings.TrimSpace(p.Endpoint)
-			bucket := cleanS3Credential(p.Bucket)
-
-			s3Config := S3Config{
-				Endpoint:   endpoint,
-				Region:     region,
-				Bucket:     bucket,
-				AccessKey:  accessKey,
-				SecretKey:  secretKey,
-				UseSSL:     p.UseSSL,
-				PathPrefix: pathPrefix,
-			}
-
-			s.sendLog(backupID, fmt.Sprintf("[INFO] S3 Configuration: Provider=%s, Endpoint=%s, Bucket=%s, Region=%s",
-				p.Name, p.Endpoint, p.Bucket, region))
-
-			s3Storage, err := NewS3Storage(s3Config)
+			s3Storage, err := newProviderStorage(p)
 			if err != nil {
-				errMsg := fmt.Sprintf("Failed to create S3 client for %s: %v", p.Name, err)
+				errMsg := fmt.Sprintf("Failed to create storage client for %s: %v", p.Name, err)
 				s.sendLog(backupID, fmt.Sprintf("[ERROR] %s", errMsg))
-				uploadChan <- uploadResult{provider: p, err: fmt.Errorf(errMsg)}
+				uploadChan <- uploadResult{provider: p, err: fmt.Errorf("%s", errMsg)}
 				return
 			}
 
-			s.sendLog(backupID, fmt.Sprintf("[INFO] Successfully connected to S3 storage: %s", p.Name))
+			s.sendLog(backupID, fmt.Sprintf("[INFO] Successfully connected to storage: %s", p.Name))
 
 			fileInfo, err := os.Stat(backup.Path)
 			fileSize := int64(0)
@@ -1490,25 +1304,34 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 					p.Name, filepath.Base(backup.Path), fileSize))
 			}
 
-			ctx := context.Background()
-			objectKey, err := s3Storage.UploadFileWithLogging(ctx, backup.Path, func(message string) {
+			logFunc := func(message string) {
 				s.sendLog(backupID, fmt.Sprintf("[%s] %s", p.Name, message))
-			})
+			}
+			var objectKey string
+			if compression := uploadCompression(backup); encrypt != nil || compression.Codec != common.CompressionNone {
+				objectKey, err = uploadEncodedFile(ctx, s3Storage, backup.Path, compression, encrypt, logFunc)
+			} else {
+				objectKey, err = s3Storage.UploadFileWithLogging(ctx, backup.Path, logFunc)
+			}
 
 			if err != nil {
 				errMsg := fmt.Sprintf("Failed to upload to %s: %v", p.Name, err)
 				s.sendLog(backupID, fmt.Sprintf("[ERROR] %s", errMsg))
-				uploadChan <- uploadResult{provider: p, err: fmt.Errorf(errMsg)}
+				uploadChan <- uploadResult{provider: p, err: fmt.Errorf("%s", errMsg)}
 				return
 			}
 
 			s.sendLog(backupID, fmt.Sprintf("[SUCCESS] Backup successfully uploaded to %s: %s", p.Name, objectKey))
-			if fileSize > 0 {
+			uploadedSize := fileSize
+			if info, err := s3Storage.StatObject(ctx, objectKey); err == nil {
+				uploadedSize = info.Size
+			}
+			if uploadedSize > 0 {
 				s.sendLog(backupID, fmt.Sprintf("[INFO] Uploaded file size to %s: %d bytes (%.2f MB)",
-					p.Name, fileSize, float64(fileSize)/(1024*1024)))
+					p.Name, uploadedSize, float64(uploadedSize)/(1024*1024)))
 			}
 
-			uploadChan <- uploadResult{provider: p, objectKey: objectKey, err: nil}
+			uploadChan <- uploadResult{provider: p, objectKey: objectKey, size: uploadedSize, err: nil}
 		}(provider)
 	}
 
@@ -1524,6 +1347,21 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 	totalProviders := len(providers)
 
 	for result := range uploadChan {
+		// Track every S3 provider for this backup, with how its upload went
+		record := BackupS3Provider{
+			ProviderID: result.provider.ID.String(),
+			ObjectKey:  result.objectKey,
+			Status:     "success",
+			Size:       result.size,
+		}
+		if result.err != nil {
+			record.Status = "failed"
+			record.Error = result.err.Error()
+		}
+		if err := s.backupRepo.AddBackupS3Provider(backupID, record); err != nil {
+			s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to track S3 provider %s: %v", result.provider.Name, err))
+		}
+
 		if result.err != nil {
 			uploadErrors = append(uploadErrors, result.err.Error())
 		} else {
@@ -1535,37 +1373,10 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 				backup.S3ProviderID = &providerIDStr
 			}
 
-			// Track all successful S3 providers for this backup
-			if err := s.backupRepo.AddBackupS3Provider(backupID, result.provider.ID.String(), result.objectKey); err != nil {
-				s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to track S3 provider %s: %v", result.provider.Name, err))
-			}
-
 			// Post-upload verification for file-based backups
 			if backup.SHA256Hash != nil && *backup.SHA256Hash != "" {
-				ctx := context.Background()
-				// Recreate S3 storage for verification
-				region := "us-east-1"
-				if result.provider.Region != nil && *result.provider.Region != "" {
-					region = *result.provider.Region
-				}
-				pathPrefix := ""
-				if result.provider.PathPrefix != nil {
-					pathPrefix = *result.provider.PathPrefix
-				}
-				accessKey := cleanS3Credential(result.provider.AccessKey)
-				secretKey := cleanS3Credential(result.provider.SecretKey)
-				endpoint := strings.TrimSpace(result.provider.Endpoint)
-				bucket := cleanS3Credential(result.provider.Bucket)
-				s3Config := S3Config{
-					Endpoint:   endpoint,
-					Region:     region,
-					Bucket:     bucket,
-					AccessKey:  accessKey,
-					SecretKey:  secretKey,
-					UseSSL:     result.provider.UseSSL,
-					PathPrefix: pathPrefix,
-				}
-				verifyStorage, err := NewS3Storage(s3Config)
+				// Recreate the provider's storage for verification
+				verifyStorage, err := newProviderStorage(result.provider)
 				if err == nil {
 					s.sendLog(backupID, fmt.Sprintf("[INFO] Verifying uploaded backup integrity on %s...", result.provider.Name))
 					if err := s.verifyUploadedBackup(ctx, verifyStorage, result.objectKey, backup); err != nil {
@@ -1597,7 +1408,7 @@ func (s *BackupService) uploadToS3Providers(backup *Backup, userID uuid.UUID, s3
 }
 
 // uploadToS3IfEnabled is the legacy function for backward compatibility
-func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID) error {
+func (s *BackupService) uploadToS3IfEnabled(ctx context.Context, backup *Backup, userID uuid.UUID, encrypt StreamEncrypter) error {
 	backupID := backup.ID.String()
 	
 	userSettings, err := s.settingsService.GetUserSettings(userID)
@@ -1687,10 +1498,16 @@ func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID) er
 		s.sendLog(backupID, fmt.Sprintf("[INFO] Preparing to upload backup file: %s (Size: %d bytes)", filepath.Base(backup.Path), fileSize))
 	}
 
-	ctx := context.Background()
-	objectKey, err := s3Storage.UploadFileWithLogging(ctx, backup.Path, func(message string) {
-		s.sendLog(backupID, message)
-	})
+	var objectKey string
+	if compression := uploadCompression(backup); encrypt != nil || compression.Codec != common.CompressionNone {
+		objectKey, err = uploadEncodedFile(ctx, s3Storage, backup.Path, compression, encrypt, func(message string) {
+			s.sendLog(backupID, message)
+		})
+	} else {
+		objectKey, err = s3Storage.UploadFileWithLogging(ctx, backup.Path, func(message string) {
+			s.sendLog(backupID, message)
+		})
+	}
 	if err != nil {
 		s.sendLog(backupID, fmt.Sprintf("[ERROR] S3 upload failed: %v", err))
 		return fmt.Errorf("failed to upload backup to S3: %w", err)
@@ -1705,147 +1522,3 @@ func (s *BackupService) uploadToS3IfEnabled(backup *Backup, userID uuid.UUID) er
 	fmt.Printf("Successfully uploaded backup %s to S3: %s\n", backup.ID, objectKey)
 	return nil
 }
-
-// uploadToAdditionalS3Providers copies the backup from the first provider to additional providers
-func (s *BackupService) uploadToAdditionalS3Providers(backup *Backup, userID uuid.UUID, providers []*S3Provider, sourceObjectKey string, sourceSize int64) error {
-	backupID := backup.ID.String()
-	
-	if len(providers) == 0 {
-		return nil
-	}
-
-	// Download from source provider
-	if backup.S3ProviderID == nil {
-		return fmt.Errorf("no source S3 provider ID available")
-	}
-	
-	sourceStorage, err := s.GetS3ProviderForDownload(*backup.S3ProviderID, userID)
-	if err != nil {
-		return fmt.Errorf("failed to create source S3 storage: %w", err)
-	}
-
-	ctx := context.Background()
-	sourceObject, err := sourceStorage.GetObject(ctx, sourceObjectKey)
-	if err != nil {
-		return fmt.Errorf("failed to get source object: %w", err)
-	}
-	defer sourceObject.Close()
-
-	// Upload to additional providers in parallel
-	type copyResult struct {
-		provider  *S3Provider
-		objectKey string
-		err       error
-	}
-
-	copyChan := make(chan copyResult, len(providers))
-	var copyWg sync.WaitGroup
-
-	for _, provider := range providers {
-		copyWg.Add(1)
-		go func(p *S3Provider) {
-			defer copyWg.Done()
-
-			s.sendLog(backupID, fmt.Sprintf("[INFO] Copying backup to provider: %s", p.Name))
-
-			region := "us-east-1"
-			if p.Region != nil && *p.Region != "" {
-				region = *p.Region
-			}
-
-			pathPrefix := ""
-			if p.PathPrefix != nil {
-				pathPrefix = *p.PathPrefix
-			}
-
-			accessKey := cleanS3Credential(p.AccessKey)
-			secretKey := cleanS3Credential(p.SecretKey)
-			endpoint := strings.TrimSpace(p.Endpoint)
-			bucket := cleanS3Credential(p.Bucket)
-
-			s3Config := S3Config{
-				Endpoint:   endpoint,
-				Region:     region,
-				Bucket:     bucket,
-				AccessKey:  accessKey,
-				SecretKey:  secretKey,
-				UseSSL:     p.UseSSL,
-				PathPrefix: pathPrefix,
-			}
-
-			destStorage, err := NewS3Storage(s3Config)
-			if err != nil {
-				copyChan <- copyResult{provider: p, err: fmt.Errorf("failed to create S3 client: %w", err)}
-				return
-			}
-
-			// Read source object into memory (for small files) or stream it
-			// For large files, we should stream, but for simplicity, let's read it
-			// Actually, we need to re-read the source for each provider
-			// Let's create a new reader from source
-			sourceObject2, err := sourceStorage.GetObject(ctx, sourceObjectKey)
-			if err != nil {
-				copyChan <- copyResult{provider: p, err: fmt.Errorf("failed to get source object: %w", err)}
-				return
-			}
-			defer sourceObject2.Close()
-
-			// Extract connection name from source object key
-			// Format: prefix/connection_name/filename or connection_name/filename
-			connectionName := ""
-			keyParts := strings.Split(sourceObjectKey, "/")
-			if len(keyParts) >= 2 {
-				// Connection name is usually second-to-last part (before filename)
-				// Skip prefix if present, then connection name, then filename
-				for i := len(keyParts) - 2; i >= 0; i-- {
-					if keyParts[i] != "" && !strings.HasSuffix(keyParts[i], ".gz") && !strings.HasSuffix(keyParts[i], ".sql") {
-						connectionName = keyParts[i]
-						break
-					}
-				}
-			}
-			
-			objectKey := filepath.Base(sourceObjectKey)
-			
-			// Upload the stream
-			uploadedKey, err := destStorage.UploadStream(ctx, sourceObject2, objectKey, connectionName, func(message string) {
-				s.sendLog(backupID, fmt.Sprintf("[%s] %s", p.Name, message))
-			})
-
-			if err != nil {
-				copyChan <- copyResult{provider: p, err: fmt.Errorf("failed to upload: %w", err)}
-				return
-			}
-
-			// Track S3 provider
-			if err := s.backupRepo.AddBackupS3Provider(backupID, p.ID.String(), uploadedKey); err != nil {
-				s.sendLog(backupID, fmt.Sprintf("[WARNING] Failed to track S3 provider %s: %v", p.Name, err))
-			}
-
-			s.sendLog(backupID, fmt.Sprintf("[SUCCESS] Backup copied to %s: %s", p.Name, uploadedKey))
-			copyChan <- copyResult{provider: p, objectKey: uploadedKey, err: nil}
-		}(provider)
-	}
-
-	go func() {
-		copyWg.Wait()
-		close(copyChan)
-	}()
-
-	var errors []string
-	successCount := 0
-
-	for result := range copyChan {
-		if result.err != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", result.provider.Name, result.err))
-		} else {
-			successCount++
-		}
-	}
-
-	if len(errors) > 0 {
-		return fmt.Errorf("partial copy failure: %d/%d succeeded, errors: %s", successCount, len(providers), strings.Join(errors, "; "))
-	}
-
-	return nil
-}
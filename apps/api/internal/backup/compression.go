@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+// backupCompression returns the compression of conn's uploads. Unless the connection
+// picks a codec, streamed dumps are gzipped, except for formats the dump tool already
+// compresses, and dumps written to a local file are uploaded as they are.
+func backupCompression(conn *connection.StoredConnection, driver connection.DumpDriver, streaming bool) common.Compression {
+	codec := conn.CompressionCodec
+	if codec == "" {
+		codec = common.CompressionNone
+		if _, compressed := driver.(connection.CompressedDumpDriver); streaming && !compressed {
+			codec = common.CompressionGzip
+		}
+	}
+	return common.Compression{Codec: codec, Level: conn.CompressionLevel}
+}
+
+// setBackupCompression records the compression of backup's uploads. It is saved by
+// the next UpdateBackup.
+func setBackupCompression(backup *Backup, compression common.Compression) {
+	backup.Compression = &compression.Codec
+	backup.CompressionLevel = &compression.Level
+}
+
+// clearBackupCompression drops the compression of a backup that wasn't uploaded, as
+// local backup files are kept as they were dumped
+func clearBackupCompression(backup *Backup) {
+	backup.Compression = nil
+	backup.CompressionLevel = nil
+}
+
+// uploadCompression returns the compression recorded for backup's uploads
+func uploadCompression(backup *Backup) common.Compression {
+	compression := common.Compression{Codec: common.CompressionNone}
+	if backup.Compression != nil {
+		compression.Codec = *backup.Compression
+	}
+	if backup.CompressionLevel != nil {
+		compression.Level = *backup.CompressionLevel
+	}
+	return compression
+}
+
+// objectCompression returns the codec one of backup's objects was compressed with.
+// Backups from before the codec was recorded were only gzipped when streamed, which
+// the ".gz" of their object key shows.
+func objectCompression(backup *Backup, objectKey string) string {
+	if backup.Compression != nil {
+		return *backup.Compression
+	}
+	return common.CompressionFromExtension(objectKey)
+}
@@ -0,0 +1,40 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+func TestBackupCompressionSkipsCompressedDumps(t *testing.T) {
+	postgres, err := connection.GetDriver("postgresql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mysql, err := connection.GetDriver("mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals, _ := connection.GlobalsDriver(postgres)
+
+	tests := []struct {
+		name      string
+		driver    connection.DumpDriver
+		codec     string
+		streaming bool
+		want      string
+	}{
+		{"custom format streamed", postgres, "", true, common.CompressionNone},
+		{"custom format with a codec", postgres, common.CompressionZstd, true, common.CompressionZstd},
+		{"plain SQL streamed", mysql, "", true, common.CompressionGzip},
+		{"globals streamed", globals, "", true, common.CompressionGzip},
+		{"plain SQL file", mysql, "", false, common.CompressionNone},
+	}
+	for _, tt := range tests {
+		conn := &connection.StoredConnection{CompressionCodec: tt.codec}
+		if got := backupCompression(conn, tt.driver, tt.streaming); got.Codec != tt.want {
+			t.Errorf("%s: compressed with %s, want %s", tt.name, got.Codec, tt.want)
+		}
+	}
+}
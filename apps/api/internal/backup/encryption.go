@@ -0,0 +1,459 @@
+package backup
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+)
+
+const (
+	// backupEncryptionAlgorithm is recorded on backups whose objects were written by
+	// newEncryptWriter
+	backupEncryptionAlgorithm = "aes-256-gcm-stream"
+	// encryptedExtension is appended to the object key of encrypted backups
+	encryptedExtension = ".enc"
+
+	encryptionMagic     = "VELLDENC"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 * 1024
+	dataKeySize         = 32
+)
+
+// encryptionExtensions are appended to the object keys of backups encrypted with
+// each algorithm
+var encryptionExtensions = map[string]string{
+	backupEncryptionAlgorithm: encryptedExtension,
+	common.RecipientAge:       ".age",
+	common.RecipientOpenPGP:   ".gpg",
+}
+
+// ErrPrivateKeyRequired is returned when reading a backup encrypted to public keys
+// without the matching private key
+var ErrPrivateKeyRequired = errors.New("backup is encrypted to public keys; provide the private key to read it")
+
+// StreamEncrypter encrypts the objects of a backup as they are uploaded
+type StreamEncrypter interface {
+	// Encrypt wraps the writer an object is uploaded through, encrypting what is
+	// written to it. Closing the returned writer finishes the object but doesn't
+	// close the underlying writer.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+	// Extension is appended to the object key
+	Extension() string
+}
+
+// BackupPrivateKey decrypts backups encrypted to public keys. It is only used for
+// the request it comes with and is never stored.
+type BackupPrivateKey struct {
+	Key        string
+	Passphrase string
+}
+
+// dataKeyEncrypter encrypts with a backup's data key
+type dataKeyEncrypter []byte
+
+func (k dataKeyEncrypter) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	return newEncryptWriter(w, k)
+}
+
+func (k dataKeyEncrypter) Extension() string {
+	return encryptedExtension
+}
+
+// recipientEncrypter encrypts to a connection's recipients
+type recipientEncrypter struct {
+	*common.Recipients
+}
+
+func (r recipientEncrypter) Extension() string {
+	return encryptionExtensions[r.Kind]
+}
+
+// isRecipientEncrypted reports whether backup was encrypted to public keys, so
+// the server can't read it without being given a private key
+func isRecipientEncrypted(backup *Backup) bool {
+	return backup.EncryptionAlgorithm != nil &&
+		(*backup.EncryptionAlgorithm == common.RecipientAge || *backup.EncryptionAlgorithm == common.RecipientOpenPGP)
+}
+
+// The encrypted stream starts with a header of encryptionMagic, the version and a
+// random nonce prefix, followed by records of a final flag, the ciphertext length
+// and the ciphertext of up to encryptionChunkSize bytes. Each chunk's nonce is the
+// prefix and the chunk's index, and the flag is authenticated with it, so chunks
+// can't be reordered and a stream cut short at a chunk boundary is detected.
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+// newEncryptWriter returns a writer that encrypts what is written to it with key
+// and writes it to w
+func newEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, aead.NonceSize()-8)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := append([]byte(encryptionMagic), encryptionVersion)
+	if _, err := w.Write(append(header, prefix...)); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypted stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as the last one is marked final
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the final chunk
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+
+	ciphertext := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter), e.buf, flag)
+	e.counter++
+	e.buf = e.buf[:0]
+
+	record := make([]byte, 5, 5+len(ciphertext))
+	record[0] = flag[0]
+	binary.BigEndian.PutUint32(record[1:], uint32(len(ciphertext)))
+	_, err := e.w.Write(append(record, ciphertext...))
+	return err
+}
+
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	plain   []byte
+	done    bool
+}
+
+// newDecryptReader returns a reader of the data encrypted in r with key. Reads fail
+// if the data was modified or is incomplete.
+func newDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newChunkAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encryptionMagic)+1+aead.NonceSize()-8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.New("backup is not encrypted by velld")
+	}
+	if version := header[len(encryptionMagic)]; version != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", version)
+	}
+
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		prefix: header[len(encryptionMagic)+1:],
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	record := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, record); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("encrypted backup is truncated")
+		}
+		return err
+	}
+
+	flag, size := record[0], binary.BigEndian.Uint32(record[1:])
+	if flag > 1 || size > encryptionChunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("encrypted backup is corrupted")
+	}
+
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(d.r, ciphertext); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("encrypted backup is truncated")
+		}
+		return err
+	}
+
+	plain, err := d.aead.Open(ciphertext[:0], chunkNonce(d.prefix, d.counter), ciphertext, []byte{flag})
+	if err != nil {
+		return errors.New("failed to decrypt backup: the data key is wrong or the backup was modified")
+	}
+	d.counter++
+	d.plain = plain
+
+	if flag == 1 {
+		d.done = true
+		// Nothing may follow the final chunk
+		if n, _ := d.r.Read(make([]byte, 1)); n > 0 {
+			return errors.New("encrypted backup has data after its final chunk")
+		}
+	}
+	return nil
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key size: must be %d bytes", dataKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint64) []byte {
+	nonce := make([]byte, len(prefix)+8)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[len(prefix):], counter)
+	return nonce
+}
+
+// prepareBackupEncryption sets up the encryption of backup's uploads for conn: to
+// the connection's recipients when it has any, otherwise with a new data key
+// wrapped with the master key when it encrypts backups. It is recorded on the
+// backup by the next UpdateBackup.
+func (s *BackupService) prepareBackupEncryption(backup *Backup, conn *connection.StoredConnection) error {
+	if len(conn.BackupRecipients) > 0 {
+		recipients, err := common.ParseRecipients(conn.BackupRecipients)
+		if err != nil {
+			return fmt.Errorf("invalid backup recipients: %w", err)
+		}
+		keyIDs := strings.Join(recipients.IDs, ",")
+		backup.EncryptionAlgorithm = &recipients.Kind
+		backup.EncryptionKeyID = &keyIDs
+		backup.EncryptedDataKey = nil
+		backup.recipients = recipients
+		return nil
+	}
+	if !conn.EncryptBackups {
+		return nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := s.cryptoService.Encrypt(base64.StdEncoding.EncodeToString(dataKey))
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	algorithm := backupEncryptionAlgorithm
+	keyID := s.cryptoService.KeyID()
+	backup.EncryptionAlgorithm = &algorithm
+	backup.EncryptionKeyID = &keyID
+	backup.EncryptedDataKey = &wrapped
+	return nil
+}
+
+// clearBackupEncryption drops the encryption of a backup that wasn't uploaded, as
+// local backup files are kept as they were dumped
+func clearBackupEncryption(backup *Backup) {
+	backup.EncryptionAlgorithm = nil
+	backup.EncryptionKeyID = nil
+	backup.EncryptedDataKey = nil
+	backup.recipients = nil
+}
+
+// backupEncrypter returns the encrypter for backup's objects, or nil if they aren't encrypted
+func (s *BackupService) backupEncrypter(backup *Backup) (StreamEncrypter, error) {
+	if backup.recipients != nil {
+		return recipientEncrypter{backup.recipients}, nil
+	}
+	if backup.EncryptedDataKey == nil {
+		return nil, nil
+	}
+
+	dataKey, err := s.backupDataKey(backup)
+	if err != nil {
+		return nil, err
+	}
+	return dataKeyEncrypter(dataKey), nil
+}
+
+// backupDataKey unwraps the data key of an encrypted backup
+func (s *BackupService) backupDataKey(backup *Backup) ([]byte, error) {
+	if backup.EncryptedDataKey == nil || backup.EncryptionAlgorithm == nil {
+		return nil, errors.New("backup is encrypted but has no data key")
+	}
+	if *backup.EncryptionAlgorithm != backupEncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported backup encryption algorithm: %s", *backup.EncryptionAlgorithm)
+	}
+	if backup.EncryptionKeyID != nil && *backup.EncryptionKeyID != s.cryptoService.KeyID() {
+		return nil, fmt.Errorf("backup was encrypted with master key %s, but this server uses key %s", *backup.EncryptionKeyID, s.cryptoService.KeyID())
+	}
+
+	encoded, err := s.cryptoService.Decrypt(*backup.EncryptedDataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// openBackupObject opens one of backup's objects in S3, decrypting it when it is
+// encrypted and, if decompress is set, decompressing streamed backups, so the
+// reader returns the dump as the driver wrote it. Backups encrypted to public keys
+// are decrypted with privateKey; without one they can only be opened as they are
+// stored, with decompress unset.
+func (s *BackupService) openBackupObject(ctx context.Context, storage Storage, backup *Backup, objectKey string, decompress bool, privateKey *BackupPrivateKey) (io.ReadCloser, error) {
+	extension := ""
+	if backup.EncryptionAlgorithm != nil {
+		extension = encryptionExtensions[*backup.EncryptionAlgorithm]
+	}
+	encrypted := extension != "" && strings.HasSuffix(objectKey, extension)
+	if encrypted && isRecipientEncrypted(backup) && privateKey == nil && decompress {
+		return nil, ErrPrivateKeyRequired
+	}
+
+	object, err := storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = object
+	if encrypted && isRecipientEncrypted(backup) && privateKey == nil {
+		return &objectReader{Reader: reader, closers: []io.Closer{object}}, nil
+	}
+	if encrypted {
+		if reader, err = s.decryptBackupObject(reader, backup, privateKey); err != nil {
+			object.Close()
+			return nil, err
+		}
+		objectKey = strings.TrimSuffix(objectKey, extension)
+	}
+
+	closers := []io.Closer{object}
+	if decompress {
+		decompressor, err := common.NewDecompressReader(objectCompression(backup, objectKey), reader)
+		if err != nil {
+			object.Close()
+			return nil, fmt.Errorf("failed to read compressed backup: %w", err)
+		}
+		reader = decompressor
+		closers = append([]io.Closer{decompressor}, closers...)
+	}
+
+	return &objectReader{Reader: reader, closers: closers}, nil
+}
+
+// openBackupDownload opens one of backup's objects for download as the dump the
+// driver wrote, and names the download. Backups encrypted to public keys are
+// downloaded as they are stored, so they keep the object's extensions.
+func (s *BackupService) openBackupDownload(ctx context.Context, storage Storage, backup *Backup, objectKey string) (io.ReadCloser, string, error) {
+	if isRecipientEncrypted(backup) {
+		object, err := s.openBackupObject(ctx, storage, backup, objectKey, false, nil)
+		return object, filepath.Base(objectKey), err
+	}
+	object, err := s.openBackupObject(ctx, storage, backup, objectKey, true, nil)
+	return object, filepath.Base(backup.Path), err
+}
+
+// decryptBackupObject decrypts an encrypted object of backup
+func (s *BackupService) decryptBackupObject(r io.Reader, backup *Backup, privateKey *BackupPrivateKey) (io.Reader, error) {
+	if isRecipientEncrypted(backup) {
+		if privateKey == nil {
+			return nil, ErrPrivateKeyRequired
+		}
+		return common.DecryptWithPrivateKey(*backup.EncryptionAlgorithm, r, privateKey.Key, privateKey.Passphrase)
+	}
+
+	dataKey, err := s.backupDataKey(backup)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(r, dataKey)
+}
+
+// objectReader closes the decompressor and the S3 object under a chain of readers
+type objectReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *objectReader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
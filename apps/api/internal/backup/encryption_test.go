@@ -0,0 +1,160 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newTestDataKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encryptTest encrypts data with key, writing it in writes of writeSize bytes
+func encryptTest(t *testing.T, key, data []byte, writeSize int) []byte {
+	t.Helper()
+	var encrypted bytes.Buffer
+	writer, err := newEncryptWriter(&encrypted, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		n := min(writeSize, len(data))
+		if _, err := writer.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return encrypted.Bytes()
+}
+
+func decryptTest(key, encrypted []byte) ([]byte, error) {
+	reader, err := newDecryptReader(bytes.NewReader(encrypted), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// encryptedRecords splits an encrypted stream into its header and chunk records
+func encryptedRecords(t *testing.T, encrypted []byte) (header []byte, records [][]byte) {
+	t.Helper()
+	headerSize := len(encryptionMagic) + 1 + 4
+	header, rest := encrypted[:headerSize], encrypted[headerSize:]
+	for len(rest) > 0 {
+		size := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		records = append(records, rest[:size])
+		rest = rest[size:]
+	}
+	return header, records
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	key := newTestDataKey(t)
+	data := make([]byte, 3*encryptionChunkSize+7)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		records int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"just under a chunk", encryptionChunkSize - 1, 1},
+		{"exactly a chunk", encryptionChunkSize, 1},
+		{"just over a chunk", encryptionChunkSize + 1, 2},
+		{"exactly two chunks", 2 * encryptionChunkSize, 2},
+		{"several chunks", len(data), 4},
+	}
+	for _, tt := range tests {
+		for _, writeSize := range []int{1000, encryptionChunkSize, len(data)} {
+			encrypted := encryptTest(t, key, data[:tt.size], writeSize)
+			if _, records := encryptedRecords(t, encrypted); len(records) != tt.records {
+				t.Errorf("%s in writes of %d bytes: encrypted into %d chunks, want %d", tt.name, writeSize, len(records), tt.records)
+			}
+
+			decrypted, err := decryptTest(key, encrypted)
+			if err != nil {
+				t.Errorf("%s in writes of %d bytes: %v", tt.name, writeSize, err)
+				continue
+			}
+			if !bytes.Equal(decrypted, data[:tt.size]) {
+				t.Errorf("%s in writes of %d bytes: decrypted %d bytes that differ from the %d encrypted", tt.name, writeSize, len(decrypted), tt.size)
+			}
+		}
+	}
+}
+
+func TestEncryptionNoncesDiffer(t *testing.T) {
+	key := newTestDataKey(t)
+	data := []byte("INSERT INTO items VALUES ('velld');\n")
+	if bytes.Equal(encryptTest(t, key, data, len(data)), encryptTest(t, key, data, len(data))) {
+		t.Error("encrypting the same data twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptDamagedStream(t *testing.T) {
+	key := newTestDataKey(t)
+	data := bytes.Repeat([]byte("INSERT INTO items VALUES ('velld');\n"), 3*encryptionChunkSize/36+1)
+	encrypted := encryptTest(t, key, data, len(data))
+	header, records := encryptedRecords(t, encrypted)
+	if len(records) != 4 {
+		t.Fatalf("encrypted into %d chunks, want 4", len(records))
+	}
+
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	modified := func(b []byte, i int, value byte) []byte {
+		b = bytes.Clone(b)
+		b[i] = value
+		return b
+	}
+
+	damaged := map[string]struct {
+		stream []byte
+		want   string
+	}{
+		"final chunk lost":       {join(header, records[0], records[1], records[2]), "truncated"},
+		"cut inside a chunk":     {encrypted[:len(encrypted)-10], "truncated"},
+		"header only":            {header, "truncated"},
+		"chunks swapped":         {join(header, records[1], records[0], records[2], records[3]), "failed to decrypt"},
+		"chunk repeated":         {join(header, records[0], records[0], records[1], records[2], records[3]), "failed to decrypt"},
+		"chunk marked final":     {join(header, modified(records[0], 0, 1)), "failed to decrypt"},
+		"final chunk not final":  {join(header, records[0], records[1], records[2], modified(records[3], 0, 0)), "failed to decrypt"},
+		"unknown chunk flag":     {join(header, modified(records[0], 0, 2)), "corrupted"},
+		"oversized chunk":        {join(header, modified(records[0], 1, 0xFF)), "corrupted"},
+		"ciphertext modified":    {join(header, modified(records[0], 100, records[0][100]^1), records[1], records[2], records[3]), "failed to decrypt"},
+		"data after final chunk": {join(encrypted, []byte{0}), "data after its final chunk"},
+		"stream appended":        {join(encrypted, encrypted), "data after its final chunk"},
+		"nonce prefix modified":  {join(modified(header, len(header)-1, header[len(header)-1]^1), records[0], records[1], records[2], records[3]), "failed to decrypt"},
+		"wrong magic":            {join(modified(header, 0, 'X'), records[0]), "not encrypted by velld"},
+		"unsupported version":    {join(modified(header, len(encryptionMagic), 2), records[0]), "unsupported encryption version 2"},
+		"short header":           {header[:5], "failed to read encryption header"},
+	}
+	for name, tt := range damaged {
+		decrypted, err := decryptTest(key, tt.stream)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: decrypted %d bytes with error %v, want an error containing %q", name, len(decrypted), err, tt.want)
+		}
+	}
+
+	if _, err := decryptTest(newTestDataKey(t), encrypted); err == nil || !strings.Contains(err.Error(), "data key is wrong") {
+		t.Errorf("decrypting with another data key returned %v", err)
+	}
+	if _, err := newDecryptReader(bytes.NewReader(encrypted), key[:16]); err == nil {
+		t.Error("decrypting with a 16-byte data key succeeded")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package backup
+
+// freeSpace is not supported on this platform, so free space isn't checked
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnsupported
+}
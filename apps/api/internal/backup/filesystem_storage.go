@@ -0,0 +1,292 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// filesystemMinFreeSpace is kept free on the filesystem; uploads that would use it
+	// fail instead of filling the disk
+	filesystemMinFreeSpace = 1 << 30
+	// filesystemSpaceCheckInterval bytes are written between free space checks
+	filesystemSpaceCheckInterval = 64 << 20
+)
+
+var errFreeSpaceUnsupported = errors.New("free space checks are not supported on this platform")
+
+// FilesystemStorage stores backups as files under a root directory, such as a
+// mounted NAS or NFS share. Object keys are paths relative to the root.
+type FilesystemStorage struct {
+	root string
+}
+
+func NewFilesystemStorage(root string) (*FilesystemStorage, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		return nil, fmt.Errorf("filesystem root path is required")
+	}
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("filesystem root path must be absolute: %s", root)
+	}
+
+	return &FilesystemStorage{root: filepath.Clean(root)}, nil
+}
+
+// newProviderFilesystemStorage creates a filesystem storage for a provider, whose
+// PathPrefix is the root path
+func newProviderFilesystemStorage(provider *S3Provider) (*FilesystemStorage, error) {
+	root := ""
+	if provider.PathPrefix != nil {
+		root = *provider.PathPrefix
+	}
+	return NewFilesystemStorage(root)
+}
+
+// filePath returns the path of an object. Keys are cleaned as absolute paths first,
+// so they can't point outside the root.
+func (s *FilesystemStorage) filePath(objectKey string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+objectKey)))
+}
+
+func (s *FilesystemStorage) UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error) {
+	if logFunc != nil {
+		logFunc("[INFO] Opening backup file for upload...")
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Failed to open file: %v", err))
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, filepath.Base(localPath), connectionNameFromPath(localPath), "application/octet-stream", logFunc)
+}
+
+// UploadStream writes reader to a .partial file next to the object, syncs it and
+// renames it into place, so an interrupted upload never leaves a truncated backup
+// behind
+func (s *FilesystemStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	finalKey := joinObjectKey("", connectionName, objectKey)
+
+	if logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] Starting streaming upload to directory '%s' with path '%s'...", s.root, finalKey))
+	}
+
+	if err := s.putFile(ctx, reader, s.filePath(finalKey), logFunc); err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Filesystem streaming upload failed: %v", err))
+		}
+		return "", fmt.Errorf("failed to stream upload to filesystem: %w", err)
+	}
+
+	if logFunc != nil {
+		logFunc("[INFO] Streaming upload completed successfully")
+		logFunc(fmt.Sprintf("[INFO] File available at: %s", s.filePath(finalKey)))
+	}
+
+	return finalKey, nil
+}
+
+func (s *FilesystemStorage) putFile(ctx context.Context, reader io.Reader, filePath string, logFunc func(string)) error {
+	// The root must already exist, so nothing is written to the local disk while a
+	// share isn't mounted
+	if err := s.checkRoot(); err != nil {
+		return err
+	}
+	free, err := s.checkFreeSpace()
+	if err != nil {
+		return err
+	}
+	if free >= 0 && logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] %s free on %s", formatBytes(free), s.root))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	partialPath := filePath + ".partial"
+	file, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	_, err = io.Copy(&filesystemWriter{ctx: ctx, storage: s, file: file}, reader)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partialPath, filePath)
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	// Sync the directory too, so the rename survives a crash
+	if dir, err := os.Open(filepath.Dir(filePath)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return nil
+}
+
+// filesystemWriter writes an upload to its .partial file, stopping when ctx is done
+// or the filesystem is running out of space
+type filesystemWriter struct {
+	ctx       context.Context
+	storage   *FilesystemStorage
+	file      *os.File
+	unchecked int64
+}
+
+func (w *filesystemWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	w.unchecked += int64(len(p))
+	if w.unchecked >= filesystemSpaceCheckInterval {
+		w.unchecked = 0
+		if _, err := w.storage.checkFreeSpace(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.file.Write(p)
+}
+
+// checkFreeSpace returns the free space under the root, or -1 if it can't be
+// checked on this platform. It fails when less than filesystemMinFreeSpace is left.
+func (s *FilesystemStorage) checkFreeSpace() (int64, error) {
+	free, err := freeSpace(s.root)
+	if errors.Is(err, errFreeSpaceUnsupported) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to check free space: %w", err)
+	}
+	if free < filesystemMinFreeSpace {
+		return free, fmt.Errorf("not enough free space on %s: %s left, %s must stay free", s.root, formatBytes(free), formatBytes(filesystemMinFreeSpace))
+	}
+	return free, nil
+}
+
+// checkRoot checks that the root path is a directory
+func (s *FilesystemStorage) checkRoot() error {
+	info, err := os.Stat(s.root)
+	if err != nil {
+		return fmt.Errorf("failed to access root path: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("root path is not a directory: %s", s.root)
+	}
+	return nil
+}
+
+// GetObject returns an io.ReadCloser for streaming the file
+func (s *FilesystemStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return s.GetObjectRange(ctx, objectKey, 0, -1)
+}
+
+// GetObjectRange returns an io.ReadCloser for streaming part of the file
+func (s *FilesystemStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(s.filePath(objectKey))
+	if err == nil && offset > 0 {
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return limitObject(file, length), nil
+}
+
+func (s *FilesystemStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	if err := os.Remove(s.filePath(objectKey)); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+func (s *FilesystemStorage) ListFiles(ctx context.Context) ([]string, error) {
+	if err := s.checkRoot(); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(filePath, ".partial") {
+			return nil
+		}
+
+		key, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(key))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return files, nil
+}
+
+func (s *FilesystemStorage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
+	info, err := os.Stat(s.filePath(objectKey))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return &ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+// PresignGetObject is not supported, as files are only reachable through the API
+func (s *FilesystemStorage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// TestConnection checks that the root path is a writable directory with enough free
+// space
+func (s *FilesystemStorage) TestConnection(ctx context.Context) error {
+	if err := s.checkRoot(); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(s.root, ".velld-test-*")
+	if err != nil {
+		return fmt.Errorf("root path is not writable: %w", err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	_, err = s.checkFreeSpace()
+	return err
+}
@@ -0,0 +1,296 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const (
+	// gcsChunkSize is the size of the chunks of a resumable upload. A chunk is spooled
+	// in memory until it is stored, so a chunk that fails can be sent again.
+	gcsChunkSize = multipartPartSize
+	// gcsChunkRetryDeadline is how long a chunk is retried for before the upload fails
+	gcsChunkRetryDeadline = 2 * time.Minute
+	// gcsMaxPresignExpiry is the longest a V4 signed URL can be valid for
+	gcsMaxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// GCSConfig is the service account, bucket and prefix of a Google Cloud Storage
+// destination. Endpoint is only set for emulators such as fake-gcs-server, which
+// are used without credentials.
+type GCSConfig struct {
+	Endpoint        string
+	CredentialsJSON string
+	Bucket          string
+	Prefix          string
+}
+
+// GCSStorage stores backups in a Google Cloud Storage bucket. Uploads are
+// resumable, so a chunk that fails is sent again from what GCS stored.
+type GCSStorage struct {
+	client    *storage.Client
+	bucket    string
+	prefix    string
+	chunkSize int
+	// account signs presigned URLs; it is nil for emulators used without credentials
+	account *gcsServiceAccount
+	// hostname and insecure are where presigned URLs point, when not at GCS itself
+	hostname string
+	insecure bool
+}
+
+// gcsServiceAccount is the part of a service-account key file used to sign URLs
+type gcsServiceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// parseGCSServiceAccount reads a service-account key file
+func parseGCSServiceAccount(credentialsJSON string) (*gcsServiceAccount, error) {
+	var account gcsServiceAccount
+	if err := json.Unmarshal([]byte(credentialsJSON), &account); err != nil {
+		return nil, fmt.Errorf("invalid GCS service account key: %w", err)
+	}
+	if account.Type != "service_account" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("invalid GCS service account key: not a service account key file")
+	}
+	return &account, nil
+}
+
+func NewGCSStorage(config GCSConfig) (*GCSStorage, error) {
+	return newGCSStorage(config)
+}
+
+// newGCSStorage creates a GCS client; tests pass options to reach the emulator
+// through their own transport
+func newGCSStorage(config GCSConfig, options ...option.ClientOption) (*GCSStorage, error) {
+	config.Bucket = strings.TrimSpace(config.Bucket)
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("GCS bucket is required")
+	}
+
+	endpoint := strings.TrimSuffix(strings.TrimSpace(config.Endpoint), "/")
+	if endpoint != "" && !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+
+	gcs := &GCSStorage{
+		bucket:    config.Bucket,
+		prefix:    config.Prefix,
+		chunkSize: gcsChunkSize,
+	}
+	switch {
+	case config.CredentialsJSON != "":
+		account, err := parseGCSServiceAccount(config.CredentialsJSON)
+		if err != nil {
+			return nil, err
+		}
+		gcs.account = account
+		options = append(options, option.WithCredentialsJSON([]byte(config.CredentialsJSON)))
+	case endpoint == "":
+		return nil, fmt.Errorf("GCS service account key is required")
+	default:
+		options = append(options, option.WithoutAuthentication())
+	}
+
+	if endpoint != "" {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GCS endpoint: %w", err)
+		}
+		gcs.hostname = endpointURL.Host
+		gcs.insecure = endpointURL.Scheme == "http"
+		options = append(options, option.WithEndpoint(endpoint+"/storage/v1/"))
+	}
+
+	client, err := storage.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	gcs.client = client
+
+	return gcs, nil
+}
+
+// newProviderGCSStorage creates a GCS client for a provider
+func newProviderGCSStorage(provider *S3Provider) (*GCSStorage, error) {
+	prefix := ""
+	if provider.PathPrefix != nil {
+		prefix = strings.TrimSpace(*provider.PathPrefix)
+	}
+
+	return NewGCSStorage(GCSConfig{
+		Endpoint:        provider.Endpoint,
+		CredentialsJSON: provider.CredentialsJSON,
+		Bucket:          provider.Bucket,
+		Prefix:          prefix,
+	})
+}
+
+func (s *GCSStorage) UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error) {
+	if logFunc != nil {
+		logFunc("[INFO] Opening backup file for upload...")
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Failed to open file: %v", err))
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, filepath.Base(localPath), connectionNameFromPath(localPath), "application/octet-stream", logFunc)
+}
+
+// UploadStream uploads reader to GCS as a resumable upload
+func (s *GCSStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	finalKey := joinObjectKey(s.prefix, connectionName, objectKey)
+
+	if logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] Starting streaming upload to GCS bucket '%s' with key '%s'...", s.bucket, finalKey))
+	}
+
+	if err := s.putResumable(ctx, reader, finalKey, contentType); err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] GCS streaming upload failed: %v", err))
+		}
+		return "", fmt.Errorf("failed to stream upload to GCS: %w", err)
+	}
+
+	if logFunc != nil {
+		logFunc("[INFO] Streaming upload completed successfully")
+		logFunc(fmt.Sprintf("[INFO] File available at: gs://%s/%s", s.bucket, finalKey))
+	}
+
+	return finalKey, nil
+}
+
+// putResumable sends reader to a resumable upload chunk by chunk. When the upload
+// fails or ctx is cancelled, nothing is stored; GCS drops the incomplete upload.
+func (s *GCSStorage) putResumable(ctx context.Context, reader io.Reader, key, contentType string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	writer := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	writer.ContentType = contentType
+	writer.ChunkSize = s.chunkSize
+	writer.ChunkRetryDeadline = gcsChunkRetryDeadline
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		// Cancelling the context before Close aborts the upload
+		cancel()
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// GetObject returns an io.ReadCloser for streaming download from GCS
+func (s *GCSStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return s.GetObjectRange(ctx, objectKey, 0, -1)
+}
+
+// GetObjectRange returns an io.ReadCloser for streaming part of an object from GCS
+func (s *GCSStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	reader, err := s.client.Bucket(s.bucket).Object(objectKey).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from GCS: %w", err)
+	}
+	return reader, nil
+}
+
+func (s *GCSStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	if err := s.client.Bucket(s.bucket).Object(objectKey).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete object from GCS: %w", err)
+	}
+	return nil
+}
+
+func (s *GCSStorage) ListFiles(ctx context.Context) ([]string, error) {
+	query := &storage.Query{Prefix: s.prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	objects := s.client.Bucket(s.bucket).Objects(ctx, query)
+	for {
+		object, err := objects.Next()
+		if err == iterator.Done {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		files = append(files, object.Name)
+	}
+}
+
+func (s *GCSStorage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
+	attrs, err := s.client.Bucket(s.bucket).Object(objectKey).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return &ObjectInfo{Key: objectKey, Size: attrs.Size, LastModified: attrs.Updated}, nil
+}
+
+// PresignGetObject returns a V4 signed URL for the object, signed with the service
+// account's private key
+func (s *GCSStorage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	if s.account == nil {
+		return "", ErrPresignNotSupported
+	}
+	if expiry > gcsMaxPresignExpiry {
+		return "", fmt.Errorf("GCS signed URLs expire after at most %s", gcsMaxPresignExpiry)
+	}
+
+	signedURL, err := s.client.Bucket(s.bucket).SignedURL(objectKey, &storage.SignedURLOptions{
+		GoogleAccessID: s.account.ClientEmail,
+		PrivateKey:     []byte(s.account.PrivateKey),
+		Method:         "GET",
+		Expires:        time.Now().Add(expiry),
+		Scheme:         storage.SigningSchemeV4,
+		Hostname:       s.hostname,
+		Insecure:       s.insecure,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign URL: %w", err)
+	}
+	return signedURL, nil
+}
+
+// TestConnection lists the bucket, which retention cleanup needs to be allowed too
+func (s *GCSStorage) TestConnection(ctx context.Context) error {
+	query := &storage.Query{Prefix: s.prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return err
+	}
+	objects := s.client.Bucket(s.bucket).Objects(ctx, query)
+	objects.PageInfo().MaxSize = 1
+	if _, err := objects.Next(); err != nil && err != iterator.Done {
+		return fmt.Errorf("failed to access bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"google.golang.org/api/option"
+)
+
+const (
+	testGCSBucket   = "backups"
+	testGCSEndpoint = "http://127.0.0.1:4443"
+)
+
+// newTestGCSServer starts fake-gcs-server with an empty bucket. It serves requests
+// in memory, through its HTTP client, at testGCSEndpoint.
+func newTestGCSServer(t *testing.T) *fakestorage.Server {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:      "http",
+		Host:        "127.0.0.1",
+		Port:        4443,
+		NoListener:  true,
+		ExternalURL: testGCSEndpoint,
+		PublicHost:  "127.0.0.1:4443",
+		Writer:      io.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: testGCSBucket})
+	return server
+}
+
+// newTestGCSStorage returns a client of server storing under prefix, sending its
+// requests through transport when it isn't nil
+func newTestGCSStorage(t *testing.T, server *fakestorage.Server, config GCSConfig, transport http.RoundTripper) *GCSStorage {
+	t.Helper()
+	client := server.HTTPClient()
+	if transport != nil {
+		client.Transport = transport
+	}
+	config.Endpoint = testGCSEndpoint
+	config.Bucket = testGCSBucket
+
+	storage, err := newGCSStorage(config, option.WithHTTPClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The smallest chunks GCS takes, so small uploads have several
+	storage.chunkSize = 256 << 10
+	return storage
+}
+
+// newTestServiceAccount returns a service-account key file with a new RSA key
+func newTestServiceAccount(t *testing.T) string {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "velld-test",
+		"client_email": "velld@velld-test.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    testGCSEndpoint + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(credentials)
+}
+
+// randomBytes returns n random bytes
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// readTestObject returns the content of an object, or of length bytes of it from
+// offset on
+func readTestObject(t *testing.T, storage *GCSStorage, objectKey string, offset, length int64) []byte {
+	t.Helper()
+	reader, err := storage.GetObjectRange(context.Background(), objectKey, offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestGCSStorageObjects(t *testing.T) {
+	server := newTestGCSServer(t)
+	storage := newTestGCSStorage(t, server, GCSConfig{Prefix: "velld"}, nil)
+	other := newTestGCSStorage(t, server, GCSConfig{Prefix: "other"}, nil)
+	ctx := context.Background()
+
+	// Several chunks, the last one partial
+	data := randomBytes(t, 600<<10)
+	key, err := storage.UploadStream(ctx, bytes.NewReader(data), "app_20250101_000000.sql.gz", "app", "application/gzip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "velld/app/app_20250101_000000.sql.gz" {
+		t.Errorf("uploaded to %q", key)
+	}
+	if _, err := storage.UploadStream(ctx, strings.NewReader("{}"), "app_20250101_000000.sql.gz"+manifestSuffix, "app", "application/json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.UploadStream(ctx, strings.NewReader("other"), "dump.sql", "tool", "application/sql", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := storage.ListFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	if want := []string{key, key + manifestSuffix}; strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("listed %v, want %v", files, want)
+	}
+
+	info, err := storage.StatObject(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("object size is %d, want %d", info.Size, len(data))
+	}
+	if !bytes.Equal(readTestObject(t, storage, key, 0, -1), data) {
+		t.Error("downloaded object differs from the upload")
+	}
+	if !bytes.Equal(readTestObject(t, storage, key, 300<<10, 1000), data[300<<10:300<<10+1000]) {
+		t.Error("ranged read differs from the upload")
+	}
+	if !bytes.Equal(readTestObject(t, storage, key, 500<<10, -1), data[500<<10:]) {
+		t.Error("read from an offset differs from the upload")
+	}
+
+	if err := storage.DeleteFile(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.StatObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat of a deleted object returned %v, want %v", err, ErrObjectNotFound)
+	}
+	if err := storage.TestConnection(ctx); err != nil {
+		t.Errorf("connection test failed: %v", err)
+	}
+}
+
+// failingChunks fails the chunks of resumable uploads it is told to with 503,
+// before they reach the server
+type failingChunks struct {
+	next   http.RoundTripper
+	chunks atomic.Int32
+	fail   map[int32]bool
+}
+
+func (f *failingChunks) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Content-Range") != "" {
+		if f.fail[f.chunks.Add(1)] {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"error":{"code":503,"message":"try again"}}`)),
+				Request:    req,
+			}, nil
+		}
+	}
+	return f.next.RoundTrip(req)
+}
+
+func TestGCSStorageResumesFailedChunk(t *testing.T) {
+	server := newTestGCSServer(t)
+	transport := &failingChunks{next: server.HTTPClient().Transport, fail: map[int32]bool{2: true}}
+	storage := newTestGCSStorage(t, server, GCSConfig{}, transport)
+	ctx := context.Background()
+
+	data := randomBytes(t, 700<<10)
+	key, err := storage.UploadStream(ctx, bytes.NewReader(data), "app_20250101_000000.sql.gz", "app", "application/gzip", nil)
+	if err != nil {
+		t.Fatalf("upload with a failed chunk failed: %v", err)
+	}
+	if transport.chunks.Load() < 4 {
+		t.Errorf("upload sent %d chunks, want the 3 chunks and a retry", transport.chunks.Load())
+	}
+	if !bytes.Equal(readTestObject(t, storage, key, 0, -1), data) {
+		t.Error("object uploaded with a retried chunk differs from the upload")
+	}
+}
+
+// failingReader returns data, then fails
+type failingReader struct {
+	data io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("dump failed")
+	}
+	return n, err
+}
+
+func TestGCSStorageAbortsFailedUpload(t *testing.T) {
+	server := newTestGCSServer(t)
+	storage := newTestGCSStorage(t, server, GCSConfig{}, nil)
+	ctx := context.Background()
+
+	reader := &failingReader{data: bytes.NewReader(randomBytes(t, 600<<10))}
+	if _, err := storage.UploadStream(ctx, reader, "app_20250101_000000.sql.gz", "app", "application/gzip", nil); err == nil {
+		t.Fatal("upload of a failing stream succeeded")
+	}
+	if _, err := storage.StatObject(ctx, "app/app_20250101_000000.sql.gz"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat after a failed upload returned %v, want %v", err, ErrObjectNotFound)
+	}
+}
+
+func TestGCSStoragePresign(t *testing.T) {
+	server := newTestGCSServer(t)
+	storage := newTestGCSStorage(t, server, GCSConfig{CredentialsJSON: newTestServiceAccount(t)}, nil)
+	ctx := context.Background()
+
+	key, err := storage.UploadStream(ctx, strings.NewReader("dump"), "app_20250101_000000.sql", "app", "application/sql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedURL, err := storage.PresignGetObject(ctx, key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(signedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Scheme+"://"+parsed.Host != testGCSEndpoint || parsed.Path != "/"+testGCSBucket+"/"+key {
+		t.Errorf("signed URL %s doesn't point at the object on the endpoint", signedURL)
+	}
+	query := parsed.Query()
+	expires, _ := strconv.Atoi(query.Get("X-Goog-Expires"))
+	if query.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" || expires < 3590 || expires > 3600 || query.Get("X-Goog-Signature") == "" {
+		t.Errorf("signed URL %s isn't a V4 signed URL valid for an hour", signedURL)
+	}
+	if !strings.HasPrefix(query.Get("X-Goog-Credential"), storage.account.ClientEmail+"/") {
+		t.Errorf("signed URL credential is %q", query.Get("X-Goog-Credential"))
+	}
+
+	resp, err := server.HTTPClient().Get(signedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "dump" {
+		t.Errorf("signed URL returned %d %q", resp.StatusCode, body)
+	}
+
+	if _, err := storage.PresignGetObject(ctx, key, 8*24*time.Hour); err == nil {
+		t.Error("signed a URL valid for longer than GCS allows")
+	}
+	anonymous := newTestGCSStorage(t, server, GCSConfig{}, nil)
+	if _, err := anonymous.PresignGetObject(ctx, key, time.Hour); !errors.Is(err, ErrPresignNotSupported) {
+		t.Errorf("presign without a service account returned %v, want %v", err, ErrPresignNotSupported)
+	}
+}
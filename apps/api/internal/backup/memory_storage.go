@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory. It isn't a provider type users can pick;
+// tests register it with RegisterStorage to run backups without a real destination.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	prefix  string
+	objects map[string]memoryObject
+}
+
+// memoryObject is a stored object with the time it was uploaded
+type memoryObject struct {
+	data     []byte
+	modified time.Time
+}
+
+func NewMemoryStorage(prefix string) *MemoryStorage {
+	return &MemoryStorage{
+		prefix:  prefix,
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (s *MemoryStorage) UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, filepath.Base(localPath), connectionNameFromPath(localPath), "application/octet-stream", logFunc)
+}
+
+func (s *MemoryStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	finalKey := joinObjectKey(s.prefix, connectionName, objectKey)
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.objects[finalKey] = memoryObject{data: data, modified: time.Now()}
+	s.mu.Unlock()
+
+	if logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] File available at: memory://%s", finalKey))
+	}
+	return finalKey, nil
+}
+
+func (s *MemoryStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return s.GetObjectRange(ctx, objectKey, 0, -1)
+}
+
+func (s *MemoryStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	s.mu.RLock()
+	object, ok := s.objects[objectKey]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("object not found: %s", objectKey)
+	}
+
+	data := object.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemoryStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[objectKey]; !ok {
+		return fmt.Errorf("object not found: %s", objectKey)
+	}
+	delete(s.objects, objectKey)
+	return nil
+}
+
+func (s *MemoryStorage) ListFiles(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var files []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, s.prefix) {
+			files = append(files, key)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *MemoryStorage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	object, ok := s.objects[objectKey]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+	}
+	return &ObjectInfo{Key: objectKey, Size: int64(len(object.data)), LastModified: object.modified}, nil
+}
+
+func (s *MemoryStorage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func (s *MemoryStorage) TestConnection(ctx context.Context) error {
+	return nil
+}
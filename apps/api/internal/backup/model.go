@@ -3,20 +3,24 @@ package backup
 import (
 	"time"
 
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
 	"github.com/google/uuid"
 )
 
 // BackupSchedule represents a backup schedule configuration
 type BackupSchedule struct {
-	ID             uuid.UUID  `json:"id"`
-	ConnectionID   string     `json:"connection_id"`
-	Enabled        bool       `json:"enabled"`
-	CronSchedule   string     `json:"cron_schedule"`
-	RetentionDays  int        `json:"retention_days"`
-	NextRunTime    *time.Time `json:"next_run_time"`
-	LastBackupTime *time.Time `json:"last_backup_time"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	ID                uuid.UUID       `json:"id"`
+	ConnectionID      string          `json:"connection_id"`
+	Enabled           bool            `json:"enabled"`
+	CronSchedule      string          `json:"cron_schedule"`
+	RetentionDays     int             `json:"retention_days"`
+	Retention         RetentionPolicy `json:"retention"`
+	RetentionEnforced bool            `json:"retention_enforced"` // Off for schedules from before retention deleted anything, until turned on
+	NextRunTime       *time.Time      `json:"next_run_time"`
+	LastBackupTime    *time.Time      `json:"last_backup_time"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
 }
 
 // Backup represents a single backup record
@@ -31,11 +35,35 @@ type Backup struct {
 	Size          int64      `json:"size"`
 	MD5Hash       *string    `json:"md5_hash,omitempty"`
 	SHA256Hash    *string    `json:"sha256_hash,omitempty"`
+	Format        *string    `json:"format,omitempty"` // Dump format written by the driver, nil for older backups
 	Logs          *string    `json:"logs,omitempty"`
 	StartedTime   time.Time  `json:"started_time"`
 	CompletedTime *time.Time `json:"completed_time"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
+	// All-databases backups are a parent with one child per database
+	ParentID     *string   `json:"parent_id,omitempty"`
+	DatabaseName *string   `json:"database_name,omitempty"` // Set on children; empty for server-wide objects
+	Children     []*Backup `json:"children,omitempty"`
+	// Table and schema rules the dump was taken with
+	DumpFilters *connection.DumpFilters `json:"dump_filters,omitempty"`
+	// Set when the uploaded objects are encrypted with a per-backup data key, or to
+	// the connection's recipients ("age" or "openpgp")
+	EncryptionAlgorithm *string `json:"encryption_algorithm,omitempty"`
+	EncryptionKeyID     *string `json:"encryption_key_id,omitempty"` // Master key the data key is wrapped with, or the recipients
+	EncryptedDataKey    *string `json:"-"`
+	// Codec and level the uploaded objects are compressed with
+	Compression      *string `json:"compression,omitempty"`
+	CompressionLevel *int    `json:"compression_level,omitempty"`
+	// Pinned backups are kept forever, and held backups until HoldUntil; retention
+	// skips both
+	Pinned    bool       `json:"pinned"`
+	HoldUntil *time.Time `json:"hold_until,omitempty"`
+	PinReason *string    `json:"pin_reason,omitempty"`
+	PinnedBy  *string    `json:"pinned_by,omitempty"` // User who pinned or held the backup
+	PinnedAt  *time.Time `json:"pinned_at,omitempty"`
+	// Recipients the uploads are encrypted to, while the backup runs
+	recipients *common.Recipients
 }
 
 // BackupList represents a backup in list view with additional info
@@ -53,6 +81,8 @@ type BackupList struct {
 	CompletedTime string    `json:"completed_time"`
 	CreatedAt     string    `json:"created_at"`
 	UpdatedAt     string    `json:"updated_at"`
+	Pinned        bool      `json:"pinned"`
+	HoldUntil     *string   `json:"hold_until,omitempty"`
 }
 
 // BackupRequest represents a request to create a backup
@@ -63,9 +93,11 @@ type BackupRequest struct {
 
 // ScheduleBackupRequest represents a request to create a backup schedule
 type ScheduleBackupRequest struct {
-	ConnectionID  string `json:"connection_id"`
-	CronSchedule  string `json:"cron_schedule"`
-	RetentionDays int    `json:"retention_days"`
+	ConnectionID     string           `json:"connection_id"`
+	CronSchedule     string           `json:"cron_schedule"`
+	RetentionDays    int              `json:"retention_days"`
+	Retention        *RetentionPolicy `json:"retention,omitempty"`         // Existing rules are kept when not set
+	EnforceRetention *bool            `json:"enforce_retention,omitempty"` // Existing setting is kept when not set; new schedules enforce retention
 }
 
 // BackupStats represents backup statistics
@@ -102,6 +134,266 @@ type BackupListOptions struct {
 }
 
 type UpdateScheduleRequest struct {
-	CronSchedule  string `json:"cron_schedule"`
-	RetentionDays int    `json:"retention_days"`
+	CronSchedule     string           `json:"cron_schedule"`
+	RetentionDays    int              `json:"retention_days"`
+	Retention        *RetentionPolicy `json:"retention,omitempty"`         // Existing rules are kept when not set
+	EnforceRetention *bool            `json:"enforce_retention,omitempty"` // Existing setting is kept when not set
+}
+
+// RetentionPolicy is a schedule's grandfather-father-son retention rules, applied
+// together with its retention days. A backup is kept when any rule keeps it.
+type RetentionPolicy struct {
+	KeepLast    int `json:"keep_last"`    // Newest backups, whatever their age
+	KeepDaily   int `json:"keep_daily"`   // Days whose newest backup is kept
+	KeepWeekly  int `json:"keep_weekly"`  // ISO weeks whose newest backup is kept
+	KeepMonthly int `json:"keep_monthly"` // Months whose newest backup is kept
+	KeepYearly  int `json:"keep_yearly"`  // Years whose newest backup is kept
+}
+
+// RetentionRequest evaluates retention without deleting anything, with the rules of
+// the connection's schedule unless they are set
+type RetentionRequest struct {
+	RetentionDays *int             `json:"retention_days,omitempty"`
+	Retention     *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// RetentionDecision is whether retention keeps a backup, and the rules keeping it
+type RetentionDecision struct {
+	BackupID  uuid.UUID `json:"backup_id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	Keep      bool      `json:"keep"`
+	KeptBy    []string  `json:"kept_by,omitempty"`
+	backup    *Backup
+}
+
+// PinBackupRequest pins a backup, or holds it until HoldUntil when set
+type PinBackupRequest struct {
+	Reason    string     `json:"reason"`
+	HoldUntil *time.Time `json:"hold_until,omitempty"`
+}
+
+// PinResult is a pinned backup, with how locking its stored objects went
+type PinResult struct {
+	Backup      *Backup            `json:"backup"`
+	ObjectLocks []ObjectLockResult `json:"object_locks"`
+}
+
+// ObjectLockResult is whether an object of a backup was locked, or unlocked, on a
+// provider that supports Object Lock
+type ObjectLockResult struct {
+	BackupID   uuid.UUID `json:"backup_id"`
+	ProviderID string    `json:"provider_id"`
+	ObjectKey  string    `json:"object_key"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DeleteBackupsRequest deletes several backups at once
+type DeleteBackupsRequest struct {
+	BackupIDs []string `json:"backup_ids"`
+}
+
+// BackupDeletion is how deleting a backup went. Deleted is set once the record is
+// gone; objects that couldn't be deleted yet are left as tombstones.
+type BackupDeletion struct {
+	BackupID string           `json:"backup_id"`
+	Deleted  bool             `json:"deleted"`
+	Error    string           `json:"error,omitempty"`
+	Objects  []ObjectDeletion `json:"objects"`
+}
+
+// Object deletion statuses
+const (
+	ObjectDeleted = "deleted"
+	// ObjectPending objects are retried from a tombstone
+	ObjectPending = "pending"
+	// ObjectSkipped objects are on a provider that no longer exists
+	ObjectSkipped = "skipped"
+)
+
+// ObjectDeletion is how deleting a stored object of a backup, or of one of its
+// per-database backups, went
+type ObjectDeletion struct {
+	BackupID   uuid.UUID `json:"backup_id"`
+	ProviderID string    `json:"provider_id"`
+	ObjectKey  string    `json:"object_key"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// BackupTombstone is a stored object of a deleted backup whose deletion failed, and
+// is retried from NextAttemptAt
+type BackupTombstone struct {
+	ID            string    `json:"id"`
+	BackupID      string    `json:"backup_id"`
+	UserID        string    `json:"-"`
+	ProviderID    string    `json:"provider_id"`
+	ObjectKey     string    `json:"object_key"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ReconcileRequest picks what reconciling a provider fixes; with none set, it only
+// reports
+type ReconcileRequest struct {
+	// DeleteOrphans deletes objects no backup references that velld uploaded. It is
+	// refused on providers without a path prefix or base path.
+	DeleteOrphans bool `json:"delete_orphans"`
+	// RepairMissing copies missing or mismatched objects back from a provider that
+	// has an intact copy
+	RepairMissing bool `json:"repair_missing"`
+	// MarkUnrestorable marks objects that can't be repaired as missing, and backups
+	// left without any intact copy as unrestorable
+	MarkUnrestorable bool `json:"mark_unrestorable"`
+}
+
+// ReconcileReport compares a provider's objects with the uploads recorded for it
+type ReconcileReport struct {
+	ProviderID     string         `json:"provider_id"`
+	ProviderName   string         `json:"provider_name"`
+	ObjectsListed  int            `json:"objects_listed"`
+	UploadsChecked int            `json:"uploads_checked"`
+	Orphans        []OrphanObject `json:"orphans"`
+	Missing        []ObjectIssue  `json:"missing"`
+	SizeMismatches []ObjectIssue  `json:"size_mismatches"`
+	// Unrestorable lists the backups marked unrestorable
+	Unrestorable []string  `json:"unrestorable"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// OrphanObject is an object on a provider no backup references
+type OrphanObject struct {
+	ObjectKey    string    `json:"object_key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Deleted      bool      `json:"deleted"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ObjectIssue is a recorded upload whose object is missing or has the wrong size
+type ObjectIssue struct {
+	BackupID     string `json:"backup_id"`
+	ObjectKey    string `json:"object_key"`
+	ExpectedSize int64  `json:"expected_size"`
+	ActualSize   int64  `json:"actual_size"`
+	Repaired     bool   `json:"repaired"`
+	// RepairedFrom is the provider the object was copied back from
+	RepairedFrom string `json:"repaired_from,omitempty"`
+	Marked       bool   `json:"marked"`
+	Error        string `json:"error,omitempty"`
+}
+
+// BackupManifest describes a backup. One is written next to each uploaded object,
+// so the catalog can be rebuilt from a bucket when the database is lost.
+type BackupManifest struct {
+	Version        int     `json:"version"`
+	BackupID       string  `json:"backup_id"`
+	ParentID       *string `json:"parent_id,omitempty"`
+	ConnectionID   string  `json:"connection_id"`
+	ConnectionName string  `json:"connection_name"`
+	ConnectionType string  `json:"connection_type"`
+	DatabaseName   string  `json:"database_name,omitempty"`
+	Status         string  `json:"status"`
+	Format         *string `json:"format,omitempty"`
+	Size           int64   `json:"size"`
+	MD5Hash        *string `json:"md5_hash,omitempty"`
+	SHA256Hash     *string `json:"sha256_hash,omitempty"` // Of the dump, before compression and encryption
+	// Codec and level the object is compressed with
+	Compression      *string `json:"compression,omitempty"`
+	CompressionLevel *int    `json:"compression_level,omitempty"`
+	// The data key is wrapped with the master key, so it is useless without it
+	EncryptionAlgorithm *string    `json:"encryption_algorithm,omitempty"`
+	EncryptionKeyID     *string    `json:"encryption_key_id,omitempty"`
+	EncryptedDataKey    *string    `json:"encrypted_data_key,omitempty"`
+	StartedTime         time.Time  `json:"started_time"`
+	CompletedTime       *time.Time `json:"completed_time,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// CatalogImport is how importing the backups on a provider went
+type CatalogImport struct {
+	ProviderID     string           `json:"provider_id"`
+	ProviderName   string           `json:"provider_name"`
+	ManifestsFound int              `json:"manifests_found"`
+	Imported       int              `json:"imported"`
+	Results        []ManifestImport `json:"results"`
+}
+
+// Manifest import statuses
+const (
+	// ManifestImported backups were recreated from their manifest
+	ManifestImported = "imported"
+	// ManifestLinked backups existed, and the provider's copy was added to them
+	ManifestLinked = "linked"
+	// ManifestExists backups already had the provider's copy
+	ManifestExists = "exists"
+	// ManifestSkipped manifests couldn't be imported; the error says why
+	ManifestSkipped = "skipped"
+)
+
+// ManifestImport is how importing one manifest went
+type ManifestImport struct {
+	ManifestKey string `json:"manifest_key"`
+	BackupID    string `json:"backup_id,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RetentionDryRun lists the backups retention would keep and delete
+type RetentionDryRun struct {
+	ConnectionID  string          `json:"connection_id"`
+	RetentionDays int             `json:"retention_days"`
+	Retention     RetentionPolicy `json:"retention"`
+	// Enforced is whether the schedule's retention deletes the backups in Delete
+	Enforced bool                `json:"enforced"`
+	Keep     []RetentionDecision `json:"keep"`
+	Delete   []RetentionDecision `json:"delete"`
+}
+
+// SelfBackupSettings is the schedule backing up velld's own database to a provider
+// of the user who set it up
+type SelfBackupSettings struct {
+	UserID        string          `json:"-"`
+	ProviderID    string          `json:"s3_provider_id"`
+	Enabled       bool            `json:"enabled"`
+	CronSchedule  string          `json:"cron_schedule"`
+	RetentionDays int             `json:"retention_days"`
+	Retention     RetentionPolicy `json:"retention"`
+	NextRunTime   *time.Time      `json:"next_run_time,omitempty"`
+	LastRunAt     *time.Time      `json:"last_run_at,omitempty"`
+	LastStatus    string          `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	LastObjectKey string          `json:"last_object_key,omitempty"`
+	LastSize      int64           `json:"last_size,omitempty"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// SelfBackupRequest sets up the backups of velld's own database
+type SelfBackupRequest struct {
+	Enabled       bool             `json:"enabled"`
+	ProviderID    string           `json:"s3_provider_id"`
+	CronSchedule  string           `json:"cron_schedule"`
+	RetentionDays int              `json:"retention_days"`
+	Retention     *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// SelfBackupRun is how one backup of velld's own database went
+type SelfBackupRun struct {
+	ObjectKey    string    `json:"object_key"`
+	Size         int64     `json:"size"`          // Of the uploaded snapshot
+	DatabaseSize int64     `json:"database_size"` // Of the snapshot before compression and encryption
+	Pruned       []string  `json:"pruned"`        // Older snapshots retention deleted
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// SelfBackupSnapshot is a stored snapshot of velld's own database
+type SelfBackupSnapshot struct {
+	ObjectKey    string    `json:"object_key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
 }
@@ -0,0 +1,147 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// errInvalidRetention is wrapped by the errors of retention rules that fail
+// validateRetention
+var errInvalidRetention = errors.New("invalid retention")
+
+// retentionBucket is a grandfather-father-son rule: the newest backup of each of
+// the latest count periods is kept
+type retentionBucket struct {
+	rule   string
+	count  int
+	period func(time.Time) string
+}
+
+// isEmpty reports whether the policy has no rules; a nil policy has none
+func (p *RetentionPolicy) isEmpty() bool {
+	return p == nil || *p == RetentionPolicy{}
+}
+
+func (p *RetentionPolicy) validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.KeepYearly < 0 {
+		return fmt.Errorf("retention rules must not be negative")
+	}
+	return nil
+}
+
+// validateRetention checks the retention of a schedule request, which needs either
+// retention days or a retention rule
+func validateRetention(retentionDays int, policy *RetentionPolicy) error {
+	if retentionDays < 0 {
+		return fmt.Errorf("retention_days must not be negative")
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if retentionDays == 0 && policy.isEmpty() {
+		return fmt.Errorf("retention_days or a retention rule is required")
+	}
+	return nil
+}
+
+// evaluateRetention decides which of a connection's backups retention keeps. A
+// backup is kept when it is pinned or held, newer than retentionDays or any rule of
+// policy keeps it; without any rule, every backup is kept. The newest backup is
+// always kept, so retention never deletes every backup.
+func evaluateRetention(backups []*Backup, retentionDays int, policy RetentionPolicy, now time.Time) []RetentionDecision {
+	sorted := make([]*Backup, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	decisions := make([]RetentionDecision, len(sorted))
+	for i, backup := range sorted {
+		decisions[i] = RetentionDecision{
+			BackupID:  backup.ID,
+			Status:    backup.Status,
+			CreatedAt: backup.CreatedAt,
+			backup:    backup,
+		}
+	}
+	if len(decisions) == 0 {
+		return decisions
+	}
+
+	keep := func(i int, rule string) {
+		decisions[i].Keep = true
+		decisions[i].KeptBy = append(decisions[i].KeptBy, rule)
+	}
+
+	for i, backup := range sorted {
+		if backup.Pinned {
+			keep(i, "pinned")
+		} else if backup.heldAt(now) {
+			keep(i, fmt.Sprintf("hold_until (%s)", backup.HoldUntil.Format("2006-01-02")))
+		}
+	}
+
+	if retentionDays == 0 && policy.isEmpty() {
+		for i := range decisions {
+			keep(i, "no_retention_rules")
+		}
+		return decisions
+	}
+
+	if retentionDays > 0 {
+		cutoff := now.AddDate(0, 0, -retentionDays)
+		for i, backup := range sorted {
+			if backup.CreatedAt.After(cutoff) {
+				keep(i, fmt.Sprintf("retention_days (%d)", retentionDays))
+			}
+		}
+	}
+
+	for i := 0; i < policy.KeepLast && i < len(sorted); i++ {
+		keep(i, fmt.Sprintf("keep_last (%d)", i+1))
+	}
+
+	buckets := []retentionBucket{
+		{rule: "keep_daily", count: policy.KeepDaily, period: func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{rule: "keep_weekly", count: policy.KeepWeekly, period: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{rule: "keep_monthly", count: policy.KeepMonthly, period: func(t time.Time) string {
+			return t.Format("2006-01")
+		}},
+		{rule: "keep_yearly", count: policy.KeepYearly, period: func(t time.Time) string {
+			return t.Format("2006")
+		}},
+	}
+	for _, bucket := range buckets {
+		// Backups are newest first, so the first backup of each period is its newest
+		last := ""
+		kept := 0
+		for i, backup := range sorted {
+			if kept == bucket.count {
+				break
+			}
+			period := bucket.period(backup.CreatedAt.Local())
+			if period == last {
+				continue
+			}
+			last = period
+			kept++
+			keep(i, fmt.Sprintf("%s (%s)", bucket.rule, period))
+		}
+	}
+
+	if !decisions[0].Keep {
+		keep(0, "newest_backup")
+	}
+
+	return decisions
+}
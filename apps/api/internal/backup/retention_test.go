@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDryRunRetentionChecksRules(t *testing.T) {
+	service, _, _ := newTestBackupService(t)
+	connectionID := uuid.NewString()
+
+	negativeDays, zeroDays, sevenDays := -1, 0, 7
+	requests := map[string]RetentionRequest{
+		"negative days":   {RetentionDays: &negativeDays},
+		"negative rule":   {RetentionDays: &sevenDays, Retention: &RetentionPolicy{KeepDaily: -1}},
+		"no retention":    {RetentionDays: &zeroDays},
+		"empty rules":     {RetentionDays: &zeroDays, Retention: &RetentionPolicy{}},
+		"no schedule set": {},
+	}
+	for name, req := range requests {
+		if _, err := service.DryRunRetention(connectionID, &req); !errors.Is(err, errInvalidRetention) {
+			t.Errorf("%s: dry run returned %v, want %v", name, err, errInvalidRetention)
+		}
+	}
+
+	dryRun, err := service.DryRunRetention(connectionID, &RetentionRequest{Retention: &RetentionPolicy{KeepLast: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dryRun.Enforced {
+		t.Error("dry run without a schedule is enforced")
+	}
+}
+
+func TestNewSchedulesEnforceRetention(t *testing.T) {
+	service, _, _ := newTestBackupService(t)
+	connectionID := uuid.NewString()
+
+	if err := service.ScheduleBackup(&ScheduleBackupRequest{ConnectionID: connectionID, CronSchedule: "0 0 0 * * *", RetentionDays: 30}); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := service.backupRepo.GetBackupSchedule(connectionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.RetentionEnforced {
+		t.Error("new schedule doesn't enforce retention")
+	}
+
+	off := false
+	if err := service.UpdateBackupSchedule(connectionID, &UpdateScheduleRequest{CronSchedule: "0 0 0 * * *", RetentionDays: 30, EnforceRetention: &off}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UpdateBackupSchedule(connectionID, &UpdateScheduleRequest{CronSchedule: "0 0 0 * * *", RetentionDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+	schedule, err = service.backupRepo.GetBackupSchedule(connectionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.RetentionEnforced {
+		t.Error("update without enforce_retention turned retention back on")
+	}
+}
+
+// parseLocalTime parses a time such as "2025-01-10 18:00" in the local zone, which
+// retention periods are counted in
+func parseLocalTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestEvaluateRetention(t *testing.T) {
+	tests := []struct {
+		name          string
+		history       []string
+		pinned        []string
+		holds         map[string]string // backup to the date it is held until
+		retentionDays int
+		policy        RetentionPolicy
+		now           string
+		// want is the reasons each kept backup is kept for; the others are deleted
+		want map[string][]string
+	}{
+		{
+			name:    "no rules keep every backup",
+			history: []string{"2025-01-10 18:00", "2025-01-01 18:00", "2024-06-01 18:00"},
+			want: map[string][]string{
+				"2025-01-10 18:00": {"no_retention_rules"},
+				"2025-01-01 18:00": {"no_retention_rules"},
+				"2024-06-01 18:00": {"no_retention_rules"},
+			},
+		},
+		{
+			name:    "daily keeps the newest of the latest days with backups",
+			history: []string{"2025-01-10 06:00", "2025-01-10 18:00", "2025-01-08 12:00", "2025-01-06 12:00", "2025-01-05 23:59"},
+			policy:  RetentionPolicy{KeepDaily: 3},
+			want: map[string][]string{
+				"2025-01-10 18:00": {"keep_daily (2025-01-10)"},
+				"2025-01-08 12:00": {"keep_daily (2025-01-08)"},
+				"2025-01-06 12:00": {"keep_daily (2025-01-06)"},
+			},
+		},
+		{
+			name: "weekly counts ISO weeks across the new year",
+			// 2024-12-30 is a Monday, in the first ISO week of 2025
+			history: []string{"2025-01-02 12:00", "2024-12-30 12:00", "2024-12-29 12:00", "2024-12-23 12:00", "2024-12-22 12:00"},
+			policy:  RetentionPolicy{KeepWeekly: 2},
+			want: map[string][]string{
+				"2025-01-02 12:00": {"keep_weekly (2025-W01)"},
+				"2024-12-29 12:00": {"keep_weekly (2024-W52)"},
+			},
+		},
+		{
+			name: "weekly keeps the 53rd ISO week",
+			// 2021-01-03 is a Sunday, in the last ISO week of 2020
+			history: []string{"2021-01-04 12:00", "2021-01-03 12:00", "2020-12-28 12:00", "2020-12-27 12:00"},
+			policy:  RetentionPolicy{KeepWeekly: 3},
+			want: map[string][]string{
+				"2021-01-04 12:00": {"keep_weekly (2021-W01)"},
+				"2021-01-03 12:00": {"keep_weekly (2020-W53)"},
+				"2020-12-27 12:00": {"keep_weekly (2020-W52)"},
+			},
+		},
+		{
+			name:    "monthly",
+			history: []string{"2025-03-01 00:30", "2025-02-28 23:30", "2025-02-01 00:00", "2025-01-31 23:59", "2024-12-31 23:59"},
+			policy:  RetentionPolicy{KeepMonthly: 3},
+			want: map[string][]string{
+				"2025-03-01 00:30": {"keep_monthly (2025-03)"},
+				"2025-02-28 23:30": {"keep_monthly (2025-02)"},
+				"2025-01-31 23:59": {"keep_monthly (2025-01)"},
+			},
+		},
+		{
+			name:    "yearly",
+			history: []string{"2025-01-01 00:00", "2024-12-31 23:59", "2024-01-01 00:00", "2022-06-01 12:00", "2021-06-01 12:00"},
+			policy:  RetentionPolicy{KeepYearly: 3},
+			want: map[string][]string{
+				"2025-01-01 00:00": {"keep_yearly (2025)"},
+				"2024-12-31 23:59": {"keep_yearly (2024)"},
+				"2022-06-01 12:00": {"keep_yearly (2022)"},
+			},
+		},
+		{
+			name:          "rules keep the union of what each keeps",
+			history:       []string{"2025-01-10 08:00", "2025-01-10 02:00", "2025-01-09 08:00", "2025-01-05 12:00", "2025-01-02 12:00", "2024-12-20 12:00", "2024-11-15 12:00"},
+			retentionDays: 7,
+			policy:        RetentionPolicy{KeepLast: 2, KeepDaily: 2, KeepMonthly: 2},
+			now:           "2025-01-10 12:00",
+			want: map[string][]string{
+				"2025-01-10 08:00": {"retention_days (7)", "keep_last (1)", "keep_daily (2025-01-10)", "keep_monthly (2025-01)"},
+				"2025-01-10 02:00": {"retention_days (7)", "keep_last (2)"},
+				"2025-01-09 08:00": {"retention_days (7)", "keep_daily (2025-01-09)"},
+				"2025-01-05 12:00": {"retention_days (7)"},
+				"2024-12-20 12:00": {"keep_monthly (2024-12)"},
+			},
+		},
+		{
+			name:    "pins and holds are kept until the hold ends",
+			history: []string{"2025-01-10 12:00", "2025-01-09 12:00", "2025-01-08 12:00", "2025-01-07 12:00", "2025-01-06 12:00"},
+			pinned:  []string{"2025-01-10 12:00", "2025-01-09 12:00"},
+			holds:   map[string]string{"2025-01-08 12:00": "2025-02-01 00:00", "2025-01-07 12:00": "2025-01-10 00:00"},
+			policy:  RetentionPolicy{KeepLast: 1},
+			now:     "2025-01-10 12:00",
+			want: map[string][]string{
+				"2025-01-10 12:00": {"pinned", "keep_last (1)"},
+				"2025-01-09 12:00": {"pinned"},
+				"2025-01-08 12:00": {"hold_until (2025-02-01)"},
+			},
+		},
+		{
+			name:          "the newest backup is kept when no rule keeps it",
+			history:       []string{"2025-01-09 12:00", "2025-01-10 12:00", "2025-01-08 12:00"},
+			retentionDays: 7,
+			now:           "2025-03-01 12:00",
+			want: map[string][]string{
+				"2025-01-10 12:00": {"newest_backup"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		now := time.Now()
+		if tt.now != "" {
+			now = parseLocalTime(t, tt.now)
+		}
+		pinned := make(map[string]bool)
+		for _, created := range tt.pinned {
+			pinned[created] = true
+		}
+
+		var backups []*Backup
+		for _, created := range tt.history {
+			backup := &Backup{ID: uuid.New(), Status: "success", CreatedAt: parseLocalTime(t, created), Pinned: pinned[created]}
+			if until, ok := tt.holds[created]; ok {
+				holdUntil := parseLocalTime(t, until)
+				backup.HoldUntil = &holdUntil
+			}
+			backups = append(backups, backup)
+		}
+
+		decisions := evaluateRetention(backups, tt.retentionDays, tt.policy, now)
+		if len(decisions) != len(backups) {
+			t.Errorf("%s: %d decisions for %d backups", tt.name, len(decisions), len(backups))
+			continue
+		}
+		for i, decision := range decisions {
+			if i > 0 && decision.CreatedAt.After(decisions[i-1].CreatedAt) {
+				t.Errorf("%s: decisions aren't newest first", tt.name)
+			}
+			created := decision.CreatedAt.Format("2006-01-02 15:04")
+			want, wantKeep := tt.want[created]
+			if decision.Keep != wantKeep || strings.Join(decision.KeptBy, ", ") != strings.Join(want, ", ") {
+				t.Errorf("%s: backup of %s is kept %v by %q, want %v by %q", tt.name, created, decision.Keep, decision.KeptBy, wantKeep, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// multipartPartSize is the size of the first parts of a streamed upload. A part is
+	// spooled in memory until it is stored, so a failed part can be sent again.
+	multipartPartSize = 16 << 20
+	// multipartPartsPerSize parts are sent at each size before it doubles, so streams
+	// of up to about 1 TiB fit in S3's 10000 parts
+	multipartPartsPerSize = 2000
+	multipartMaxParts     = 10000
+	// uploadRetries is how many times each request of an upload is tried
+	uploadRetries = 5
+	// uploadRetryDelay is the wait before the first retry; it doubles after each one
+	uploadRetryDelay = time.Second
+)
+
+// putStream uploads reader to key as a multipart upload, retrying each part with
+// exponential backoff. When the upload fails or ctx is cancelled, the parts already
+// stored are aborted so they don't linger in the bucket.
+func (s *S3Storage) putStream(ctx context.Context, reader io.Reader, key, contentType string, logFunc func(string)) error {
+	core := minio.Core{Client: s.client}
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	s.applyObjectLock(&opts)
+
+	spool := make([]byte, multipartPartSize)
+	n, err := io.ReadFull(reader, spool)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Streams smaller than a part are sent in one request
+		return retryUpload(ctx, logFunc, "upload object", func() error {
+			_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(spool[:n]), int64(n), opts)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	var uploadID string
+	err = retryUpload(ctx, logFunc, "start multipart upload", func() error {
+		var err error
+		uploadID, err = core.NewMultipartUpload(ctx, s.bucket, key, opts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.putParts(ctx, core, reader, key, uploadID, spool, logFunc)
+	if err == nil {
+		err = retryUpload(ctx, logFunc, "complete multipart upload", func() error {
+			_, err := core.CompleteMultipartUpload(ctx, s.bucket, key, uploadID, parts, opts)
+			return err
+		})
+	}
+	if err != nil {
+		// The backup may have been stopped, so abort without its context
+		if abortErr := core.AbortMultipartUpload(context.Background(), s.bucket, key, uploadID); abortErr != nil {
+			if logFunc != nil {
+				logFunc(fmt.Sprintf("[WARNING] Failed to abort incomplete multipart upload: %v", abortErr))
+			}
+		} else if logFunc != nil {
+			logFunc("[INFO] Aborted incomplete multipart upload")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// putParts uploads the parts of a multipart upload, starting with the full spool
+// and reading the rest from reader into it
+func (s *S3Storage) putParts(ctx context.Context, core minio.Core, reader io.Reader, key, uploadID string, spool []byte, logFunc func(string)) ([]minio.CompletePart, error) {
+	var parts []minio.CompletePart
+	part, last := spool, false
+	for partNumber := 1; ; partNumber++ {
+		var etag string
+		partOpts := minio.PutObjectPartOptions{}
+		if s.lockMode != "" {
+			sum := md5.Sum(part)
+			partOpts.Md5Base64 = base64.StdEncoding.EncodeToString(sum[:])
+		}
+		err := retryUpload(ctx, logFunc, fmt.Sprintf("upload part %d", partNumber), func() error {
+			uploaded, err := core.PutObjectPart(ctx, s.bucket, key, uploadID, partNumber, bytes.NewReader(part), int64(len(part)), partOpts)
+			etag = uploaded.ETag
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: etag})
+		if last {
+			return parts, nil
+		}
+		if partNumber == multipartMaxParts {
+			return nil, fmt.Errorf("stream is larger than %d parts", multipartMaxParts)
+		}
+
+		size := multipartPartSize << (partNumber / multipartPartsPerSize)
+		if len(spool) < size {
+			spool = make([]byte, size)
+		}
+		n, err := io.ReadFull(reader, spool[:size])
+		switch err {
+		case nil:
+		case io.EOF:
+			return parts, nil
+		case io.ErrUnexpectedEOF:
+			last = true
+		default:
+			return nil, err
+		}
+		part = spool[:n]
+	}
+}
+
+// retryUpload runs a request of an upload, retrying it with exponential backoff.
+// Requests the server rejected as invalid aren't retried, nor any once ctx is done.
+func retryUpload(ctx context.Context, logFunc func(string), action string, request func() error) error {
+	delay := uploadRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := request()
+		if err == nil || ctx.Err() != nil || attempt == uploadRetries || !isRetryableUploadError(err) {
+			return err
+		}
+
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[WARNING] Failed to %s (attempt %d/%d), retrying in %s: %v", action, attempt, uploadRetries, delay, err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isRetryableUploadError reports whether a failed request may succeed when sent
+// again: network errors, throttling and server errors
+func isRetryableUploadError(err error) bool {
+	status := minio.ToErrorResponse(err).StatusCode
+	if status >= 400 && status < 500 {
+		return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+	}
+	return true
+}
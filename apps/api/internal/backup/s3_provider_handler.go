@@ -158,6 +158,20 @@ func (h *S3ProviderHandler) TestS3Provider(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if provider.Type != ProviderTypeS3 {
+		storage, err := newProviderStorage(provider)
+		if err == nil {
+			err = storage.TestConnection(r.Context())
+		}
+		if err != nil {
+			response.SendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		response.SendSuccess(w, "Provider connection test successful", nil)
+		return
+	}
+
 	// Test the connection using the S3 storage test logic
 	region := "us-east-1"
 	if provider.Region != nil && *provider.Region != "" {
@@ -184,6 +198,8 @@ func (h *S3ProviderHandler) TestS3Provider(w http.ResponseWriter, r *http.Reques
 		SecretKey:  secretKey,
 		UseSSL:     provider.UseSSL,
 		PathPrefix: pathPrefix,
+		ObjectLockMode: provider.ObjectLockMode,
+		ObjectLockDays: provider.ObjectLockDays,
 	}
 
 	// Test the connection
@@ -239,6 +255,13 @@ func testS3Connection(config S3Config) error {
 		break
 	}
 
+	// Uploads would be rejected if the bucket can't lock objects
+	if config.ObjectLockMode != "" {
+		if err := checkObjectLock(ctx, client, config.Bucket); err != nil {
+			return err
+		}
+	}
+
 	// If we got here without error, the connection is valid
 	return nil
 }
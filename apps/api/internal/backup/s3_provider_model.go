@@ -6,11 +6,32 @@ import (
 	"github.com/google/uuid"
 )
 
-// S3Provider represents an S3-compatible storage provider configuration
+// Provider types: where a provider stores its backups
+const (
+	ProviderTypeS3         = "s3"
+	ProviderTypeSFTP       = "sftp"
+	ProviderTypeAzure      = "azure"
+	ProviderTypeGCS        = "gcs"
+	ProviderTypeFilesystem = "filesystem"
+)
+
+// S3Provider represents an S3-compatible storage provider configuration.
+// SFTP providers use Host, Port, Username, Password or PrivateKey instead of the
+// S3 fields, with PathPrefix as the base path on the server. Their HostKey is the
+// server's public key, trusted on first use when not set. Azure providers keep
+// the account name in AccessKey, the account key in SecretKey or a SASToken instead,
+// the container in Bucket and an optional service URL in Endpoint. GCS providers
+// authenticate with CredentialsJSON, a service-account key. Filesystem providers
+// only use PathPrefix, as the root directory backups are written under.
+//
+// S3 providers with an ObjectLockMode upload every object with Object Lock
+// retention for ObjectLockDays, and lock the objects of pinned backups; their bucket
+// must have Object Lock enabled.
 type S3Provider struct {
 	ID        uuid.UUID `json:"id"`
 	UserID    uuid.UUID `json:"user_id"`
 	Name      string    `json:"name"`
+	Type      string    `json:"type"`
 	Endpoint  string    `json:"endpoint"`
 	Region    *string   `json:"region,omitempty"`
 	Bucket    string    `json:"bucket"`
@@ -18,6 +39,16 @@ type S3Provider struct {
 	SecretKey string    `json:"secret_key,omitempty"`  // Omitted when returning to frontend for security
 	UseSSL    bool      `json:"use_ssl"`
 	PathPrefix *string  `json:"path_prefix,omitempty"`
+	Host       string   `json:"host,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Password   string   `json:"password,omitempty"`    // Omitted when returning to frontend for security
+	PrivateKey string   `json:"private_key,omitempty"` // Omitted when returning to frontend for security
+	HostKey    string   `json:"host_key,omitempty"`    // authorized_keys format
+	SASToken   string   `json:"sas_token,omitempty"`   // Omitted when returning to frontend for security
+	CredentialsJSON string `json:"credentials_json,omitempty"` // Omitted when returning to frontend for security
+	ObjectLockMode  string `json:"object_lock_mode,omitempty"` // GOVERNANCE or COMPLIANCE
+	ObjectLockDays  int    `json:"object_lock_days,omitempty"`
 	IsDefault bool      `json:"is_default"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -26,6 +57,7 @@ type S3Provider struct {
 // S3ProviderRequest represents a request to create or update an S3 provider
 type S3ProviderRequest struct {
 	Name      string  `json:"name"`
+	Type      string  `json:"type,omitempty"`
 	Endpoint  string  `json:"endpoint"`
 	Region    *string `json:"region,omitempty"`
 	Bucket    string  `json:"bucket"`
@@ -33,6 +65,16 @@ type S3ProviderRequest struct {
 	SecretKey string  `json:"secret_key"`
 	UseSSL    *bool   `json:"use_ssl,omitempty"`
 	PathPrefix *string `json:"path_prefix,omitempty"`
+	Host       string  `json:"host,omitempty"`
+	Port       *int    `json:"port,omitempty"`
+	Username   string  `json:"username,omitempty"`
+	Password   string  `json:"password,omitempty"`
+	PrivateKey string  `json:"private_key,omitempty"`
+	HostKey    *string `json:"host_key,omitempty"` // Empty trusts the server's key on first use; left out keeps it
+	SASToken   string  `json:"sas_token,omitempty"`
+	CredentialsJSON string `json:"credentials_json,omitempty"`
+	ObjectLockMode  *string `json:"object_lock_mode,omitempty"` // Empty turns Object Lock off; left out keeps it
+	ObjectLockDays  *int    `json:"object_lock_days,omitempty"`
 	IsDefault *bool   `json:"is_default,omitempty"`
 }
 
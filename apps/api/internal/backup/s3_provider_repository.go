@@ -24,11 +24,15 @@ func (r *S3ProviderRepository) CreateS3Provider(provider *S3Provider) error {
 	_, err := r.db.Exec(`
 		INSERT INTO s3_providers (
 			id, user_id, name, endpoint, region, bucket, access_key, secret_key,
-			use_ssl, path_prefix, is_default, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			use_ssl, path_prefix, is_default, created_at, updated_at,
+			type, host, port, username, password, private_key, sas_token, credentials_json,
+			object_lock_mode, object_lock_days, host_key
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)`,
 		provider.ID, provider.UserID, provider.Name, provider.Endpoint,
 		provider.Region, provider.Bucket, provider.AccessKey, provider.SecretKey,
-		provider.UseSSL, provider.PathPrefix, provider.IsDefault, now, now)
+		provider.UseSSL, provider.PathPrefix, provider.IsDefault, now, now,
+		provider.Type, provider.Host, provider.Port, provider.Username, provider.Password, provider.PrivateKey,
+		provider.SASToken, provider.CredentialsJSON, provider.ObjectLockMode, provider.ObjectLockDays, provider.HostKey)
 	return err
 }
 
@@ -43,13 +47,18 @@ func (r *S3ProviderRepository) GetS3Provider(id string, userID uuid.UUID) (*S3Pr
 	provider := &S3Provider{}
 	err := r.db.QueryRow(`
 		SELECT id, user_id, name, endpoint, region, bucket, access_key, secret_key,
-		       use_ssl, path_prefix, is_default, created_at, updated_at
+		       use_ssl, path_prefix, is_default, created_at, updated_at,
+		       type, host, port, username, password, private_key, sas_token, credentials_json,
+		       object_lock_mode, object_lock_days, host_key
 		FROM s3_providers
 		WHERE id = $1 AND user_id = $2`, id, userID).
 		Scan(&provider.ID, &provider.UserID, &provider.Name, &provider.Endpoint,
 			&regionStr, &provider.Bucket, &provider.AccessKey, &provider.SecretKey,
 			&provider.UseSSL, &pathPrefixStr, &provider.IsDefault,
-			&createdAtStr, &updatedAtStr)
+			&createdAtStr, &updatedAtStr,
+			&provider.Type, &provider.Host, &provider.Port, &provider.Username,
+			&provider.Password, &provider.PrivateKey, &provider.SASToken, &provider.CredentialsJSON,
+			&provider.ObjectLockMode, &provider.ObjectLockDays, &provider.HostKey)
 	
 	if err != nil {
 		return nil, err
@@ -80,7 +89,9 @@ func (r *S3ProviderRepository) GetS3Provider(id string, userID uuid.UUID) (*S3Pr
 func (r *S3ProviderRepository) ListS3Providers(userID uuid.UUID) ([]*S3Provider, error) {
 	rows, err := r.db.Query(`
 		SELECT id, user_id, name, endpoint, region, bucket, access_key, secret_key,
-		       use_ssl, path_prefix, is_default, created_at, updated_at
+		       use_ssl, path_prefix, is_default, created_at, updated_at,
+		       type, host, port, username, password, private_key, sas_token, credentials_json,
+		       object_lock_mode, object_lock_days, host_key
 		FROM s3_providers
 		WHERE user_id = $1
 		ORDER BY is_default DESC, created_at DESC`, userID)
@@ -102,7 +113,10 @@ func (r *S3ProviderRepository) ListS3Providers(userID uuid.UUID) ([]*S3Provider,
 		err := rows.Scan(&provider.ID, &provider.UserID, &provider.Name, &provider.Endpoint,
 			&regionStr, &provider.Bucket, &provider.AccessKey, &provider.SecretKey,
 			&provider.UseSSL, &pathPrefixStr, &provider.IsDefault,
-			&createdAtStr, &updatedAtStr)
+			&createdAtStr, &updatedAtStr,
+			&provider.Type, &provider.Host, &provider.Port, &provider.Username,
+			&provider.Password, &provider.PrivateKey, &provider.SASToken, &provider.CredentialsJSON,
+			&provider.ObjectLockMode, &provider.ObjectLockDays, &provider.HostKey)
 		if err != nil {
 			return nil, err
 		}
@@ -138,11 +152,24 @@ func (r *S3ProviderRepository) UpdateS3Provider(provider *S3Provider) error {
 		UPDATE s3_providers SET
 			name = $1, endpoint = $2, region = $3, bucket = $4,
 			access_key = $5, secret_key = $6, use_ssl = $7, path_prefix = $8,
-			is_default = $9, updated_at = $10
-		WHERE id = $11 AND user_id = $12`,
+			is_default = $9, updated_at = $10, host = $11, port = $12,
+			username = $13, password = $14, private_key = $15, sas_token = $16,
+			credentials_json = $17, object_lock_mode = $18, object_lock_days = $19,
+			host_key = $20
+		WHERE id = $21 AND user_id = $22`,
 		provider.Name, provider.Endpoint, provider.Region, provider.Bucket,
 		provider.AccessKey, provider.SecretKey, provider.UseSSL, provider.PathPrefix,
-		provider.IsDefault, now, provider.ID, provider.UserID)
+		provider.IsDefault, now, provider.Host, provider.Port,
+		provider.Username, provider.Password, provider.PrivateKey, provider.SASToken,
+		provider.CredentialsJSON, provider.ObjectLockMode, provider.ObjectLockDays,
+		provider.HostKey, provider.ID, provider.UserID)
+	return err
+}
+
+// SetHostKey stores the SFTP host key of a provider
+func (r *S3ProviderRepository) SetHostKey(id uuid.UUID, hostKey string) error {
+	_, err := r.db.Exec(`UPDATE s3_providers SET host_key = $1, updated_at = $2 WHERE id = $3`,
+		hostKey, time.Now().Format(time.RFC3339), id)
 	return err
 }
 
@@ -175,14 +202,19 @@ func (r *S3ProviderRepository) GetDefaultProvider(userID uuid.UUID) (*S3Provider
 	provider := &S3Provider{}
 	err := r.db.QueryRow(`
 		SELECT id, user_id, name, endpoint, region, bucket, access_key, secret_key,
-		       use_ssl, path_prefix, is_default, created_at, updated_at
+		       use_ssl, path_prefix, is_default, created_at, updated_at,
+		       type, host, port, username, password, private_key, sas_token, credentials_json,
+		       object_lock_mode, object_lock_days, host_key
 		FROM s3_providers
 		WHERE user_id = $1 AND is_default = 1
 		LIMIT 1`, userID).
 		Scan(&provider.ID, &provider.UserID, &provider.Name, &provider.Endpoint,
 			&regionStr, &provider.Bucket, &provider.AccessKey, &provider.SecretKey,
 			&provider.UseSSL, &pathPrefixStr, &provider.IsDefault,
-			&createdAtStr, &updatedAtStr)
+			&createdAtStr, &updatedAtStr,
+			&provider.Type, &provider.Host, &provider.Port, &provider.Username,
+			&provider.Password, &provider.PrivateKey, &provider.SASToken, &provider.CredentialsJSON,
+			&provider.ObjectLockMode, &provider.ObjectLockDays, &provider.HostKey)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil // No default provider
@@ -2,10 +2,16 @@ package backup
 
 import (
 	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
 )
 
 type S3ProviderService struct {
@@ -21,6 +27,21 @@ func NewS3ProviderService(repo *S3ProviderRepository, cryptoService *common.Encr
 }
 
 func (s *S3ProviderService) CreateS3Provider(userID uuid.UUID, req *S3ProviderRequest) (*S3Provider, error) {
+	providerType, err := normalizeProviderType(req.Type)
+	if err != nil {
+		return nil, err
+	}
+	if providerType == ProviderTypeSFTP && req.Password == "" && req.PrivateKey == "" {
+		return nil, fmt.Errorf("SFTP password or private key is required")
+	}
+	if providerType == ProviderTypeAzure && req.SecretKey == "" && req.SASToken == "" {
+		return nil, fmt.Errorf("Azure account key or SAS token is required")
+	}
+	// Only GCS emulators, which have a custom endpoint, are used without credentials
+	if providerType == ProviderTypeGCS && req.CredentialsJSON == "" && strings.TrimSpace(req.Endpoint) == "" {
+		return nil, fmt.Errorf("GCS service account key is required")
+	}
+
 	// Aggressively clean credentials before storing (prevents "malformed credential" errors)
 	// Use the same cleaning function used when retrieving credentials
 	cleanedSecretKey := cleanS3Credential(req.SecretKey)
@@ -55,6 +76,7 @@ func (s *S3ProviderService) CreateS3Provider(userID uuid.UUID, req *S3ProviderRe
 		ID:        uuid.New(),
 		UserID:    userID,
 		Name:      strings.TrimSpace(req.Name),
+		Type:      providerType,
 		Endpoint:  cleanedEndpoint,
 		Region:    req.Region, // Region can be nil, so we'll trim if not nil
 		Bucket:    cleanedBucket,
@@ -75,6 +97,10 @@ func (s *S3ProviderService) CreateS3Provider(userID uuid.UUID, req *S3ProviderRe
 		provider.PathPrefix = &trimmedPathPrefix
 	}
 
+	if err := s.setProviderLogin(provider, req); err != nil {
+		return nil, err
+	}
+
 	if err := s.repo.CreateS3Provider(provider); err != nil {
 		return nil, err
 	}
@@ -82,6 +108,10 @@ func (s *S3ProviderService) CreateS3Provider(userID uuid.UUID, req *S3ProviderRe
 	// Clear sensitive data before returning
 	provider.AccessKey = ""
 	provider.SecretKey = ""
+	provider.Password = ""
+	provider.PrivateKey = ""
+	provider.SASToken = ""
+	provider.CredentialsJSON = ""
 
 	return provider, nil
 }
@@ -95,6 +125,10 @@ func (s *S3ProviderService) GetS3Provider(id string, userID uuid.UUID) (*S3Provi
 	// Clear sensitive data before returning
 	provider.AccessKey = ""
 	provider.SecretKey = ""
+	provider.Password = ""
+	provider.PrivateKey = ""
+	provider.SASToken = ""
+	provider.CredentialsJSON = ""
 
 	return provider, nil
 }
@@ -109,6 +143,10 @@ func (s *S3ProviderService) ListS3Providers(userID uuid.UUID) ([]*S3Provider, er
 	for _, provider := range providers {
 		provider.AccessKey = ""
 		provider.SecretKey = ""
+		provider.Password = ""
+		provider.PrivateKey = ""
+		provider.SASToken = ""
+		provider.CredentialsJSON = ""
 	}
 
 	return providers, nil
@@ -165,6 +203,10 @@ func (s *S3ProviderService) UpdateS3Provider(id string, userID uuid.UUID, req *S
 		existing.PathPrefix = req.PathPrefix
 	}
 
+	if err := s.setProviderLogin(existing, req); err != nil {
+		return nil, err
+	}
+
 	// Handle default flag
 	if req.IsDefault != nil {
 		isDefault := *req.IsDefault
@@ -184,6 +226,10 @@ func (s *S3ProviderService) UpdateS3Provider(id string, userID uuid.UUID, req *S
 	// Clear sensitive data before returning
 	existing.AccessKey = ""
 	existing.SecretKey = ""
+	existing.Password = ""
+	existing.PrivateKey = ""
+	existing.SASToken = ""
+	existing.CredentialsJSON = ""
 
 	return existing, nil
 }
@@ -209,6 +255,10 @@ func (s *S3ProviderService) GetDefaultProvider(userID uuid.UUID) (*S3Provider, e
 	// Clear sensitive data before returning
 	provider.AccessKey = ""
 	provider.SecretKey = ""
+	provider.Password = ""
+	provider.PrivateKey = ""
+	provider.SASToken = ""
+	provider.CredentialsJSON = ""
 
 	return provider, nil
 }
@@ -233,6 +283,11 @@ func (s *S3ProviderService) GetS3ProviderForUpload(id string, userID uuid.UUID)
 	provider.Endpoint = strings.TrimSpace(provider.Endpoint) // Endpoint can have spaces in domain names
 	provider.Bucket = cleanS3Credential(provider.Bucket)
 
+	if err := s.decryptProviderLogin(provider); err != nil {
+		return nil, err
+	}
+	s.trustHostKey(provider)
+
 	return provider, nil
 }
 
@@ -262,8 +317,245 @@ func (s *S3ProviderService) GetAllS3ProvidersForUpload(userID uuid.UUID) ([]*S3P
 		provider.SecretKey = cleanS3Credential(decryptedSecretKey)
 		provider.Endpoint = strings.TrimSpace(provider.Endpoint)
 		provider.Bucket = cleanS3Credential(provider.Bucket)
+
+		if err := s.decryptProviderLogin(provider); err != nil {
+			return nil, fmt.Errorf("failed to decrypt login for provider %s: %w", provider.ID, err)
+		}
+		s.trustHostKey(provider)
 	}
 
 	return providers, nil
 }
 
+// trustHostKey stores the host key an SFTP provider's server presents if none is
+// stored yet, so later connections are refused when the server presents another.
+// Providers whose server can't be reached keep no key, and fail to connect.
+func (s *S3ProviderService) trustHostKey(provider *S3Provider) {
+	if provider.Type != ProviderTypeSFTP || provider.HostKey != "" {
+		return
+	}
+
+	hostKey, err := connection.FetchSSHHostKey(provider.Host, provider.Port)
+	if err != nil {
+		log.Printf("Failed to get SFTP host key of provider %s: %v", provider.ID, err)
+		return
+	}
+	if err := s.repo.SetHostKey(provider.ID, formatHostKey(hostKey)); err != nil {
+		log.Printf("Failed to store SFTP host key of provider %s: %v", provider.ID, err)
+		return
+	}
+	provider.HostKey = formatHostKey(hostKey)
+	log.Printf("Trusting SFTP host key %s of %s for provider %s", ssh.FingerprintSHA256(hostKey), provider.Host, provider.ID)
+}
+
+// normalizeProviderType checks the type of a provider request; providers without
+// one are S3
+func normalizeProviderType(providerType string) (string, error) {
+	providerType = strings.ToLower(strings.TrimSpace(providerType))
+	if providerType == "" {
+		return ProviderTypeS3, nil
+	}
+	if !isStorageType(providerType) {
+		return "", fmt.Errorf("unsupported provider type: %s", providerType)
+	}
+	return providerType, nil
+}
+
+// setProviderLogin sets the fields only providers of some types have from req
+func (s *S3ProviderService) setProviderLogin(provider *S3Provider, req *S3ProviderRequest) error {
+	if err := setObjectLock(provider, req); err != nil {
+		return err
+	}
+
+	switch provider.Type {
+	case ProviderTypeSFTP:
+		return s.setSFTPLogin(provider, req)
+	case ProviderTypeAzure:
+		return s.setAzureLogin(provider, req)
+	case ProviderTypeGCS:
+		return s.setGCSLogin(provider, req)
+	case ProviderTypeFilesystem:
+		return checkFilesystemRoot(provider)
+	default:
+		return nil
+	}
+}
+
+// setSFTPLogin sets the server and login of an SFTP provider from req, encrypting
+// the password and private key. They are kept when left empty in an update, as is
+// the host key unless the server changes.
+func (s *S3ProviderService) setSFTPLogin(provider *S3Provider, req *S3ProviderRequest) error {
+	server := net.JoinHostPort(provider.Host, strconv.Itoa(provider.Port))
+	provider.Host = strings.TrimSpace(req.Host)
+	provider.Username = strings.TrimSpace(req.Username)
+	if provider.Host == "" {
+		return fmt.Errorf("SFTP host is required")
+	}
+	if provider.Username == "" {
+		return fmt.Errorf("SFTP username is required")
+	}
+
+	provider.Port = 22
+	if req.Port != nil && *req.Port != 0 {
+		if *req.Port < 1 || *req.Port > 65535 {
+			return fmt.Errorf("invalid SFTP port: %d", *req.Port)
+		}
+		provider.Port = *req.Port
+	}
+
+	if req.HostKey != nil {
+		provider.HostKey = ""
+		if strings.TrimSpace(*req.HostKey) != "" {
+			hostKey, err := parseHostKey(*req.HostKey)
+			if err != nil {
+				return err
+			}
+			provider.HostKey = formatHostKey(hostKey)
+		}
+	} else if server != net.JoinHostPort(provider.Host, strconv.Itoa(provider.Port)) {
+		provider.HostKey = ""
+	}
+
+	if req.Password != "" {
+		encryptedPassword, err := s.cryptoService.Encrypt(req.Password)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt password: %w", err)
+		}
+		provider.Password = encryptedPassword
+	}
+	if req.PrivateKey != "" {
+		encryptedPrivateKey, err := s.cryptoService.Encrypt(strings.TrimSpace(req.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt private key: %w", err)
+		}
+		provider.PrivateKey = encryptedPrivateKey
+	}
+
+	return nil
+}
+
+// setAzureLogin checks an Azure provider and encrypts its SAS token. A provider
+// uses either its account key or a SAS token, so setting one clears the other.
+func (s *S3ProviderService) setAzureLogin(provider *S3Provider, req *S3ProviderRequest) error {
+	if provider.Bucket == "" {
+		return fmt.Errorf("Azure container is required")
+	}
+	if provider.AccessKey == "" && provider.Endpoint == "" {
+		return fmt.Errorf("Azure account name is required")
+	}
+
+	if req.SASToken != "" {
+		encryptedSASToken, err := s.cryptoService.Encrypt(strings.TrimPrefix(strings.TrimSpace(req.SASToken), "?"))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt SAS token: %w", err)
+		}
+		provider.SASToken = encryptedSASToken
+
+		encryptedSecretKey, err := s.cryptoService.Encrypt("")
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secret key: %w", err)
+		}
+		provider.SecretKey = encryptedSecretKey
+	} else if req.SecretKey != "" {
+		provider.SASToken = ""
+	}
+
+	return nil
+}
+
+// setGCSLogin checks a GCS provider and encrypts its service-account key, which is
+// kept when left empty in an update
+func (s *S3ProviderService) setGCSLogin(provider *S3Provider, req *S3ProviderRequest) error {
+	if provider.Bucket == "" {
+		return fmt.Errorf("GCS bucket is required")
+	}
+	if req.CredentialsJSON == "" {
+		return nil
+	}
+
+	if _, err := parseGCSServiceAccount(req.CredentialsJSON); err != nil {
+		return err
+	}
+	encryptedCredentials, err := s.cryptoService.Encrypt(strings.TrimSpace(req.CredentialsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt service account key: %w", err)
+	}
+	provider.CredentialsJSON = encryptedCredentials
+
+	return nil
+}
+
+// setObjectLock sets the Object Lock retention of a provider from req. Only S3
+// providers support it; an empty mode turns it off.
+func setObjectLock(provider *S3Provider, req *S3ProviderRequest) error {
+	// Updates that leave the fields out keep the stored Object Lock
+	if req.ObjectLockMode != nil {
+		provider.ObjectLockMode = strings.ToUpper(strings.TrimSpace(*req.ObjectLockMode))
+	}
+	if req.ObjectLockDays != nil {
+		provider.ObjectLockDays = *req.ObjectLockDays
+	}
+	if provider.ObjectLockMode == "" {
+		provider.ObjectLockDays = 0
+		return nil
+	}
+
+	if provider.Type != ProviderTypeS3 {
+		return fmt.Errorf("object lock is only supported by S3 providers")
+	}
+	if provider.ObjectLockMode != "GOVERNANCE" && provider.ObjectLockMode != "COMPLIANCE" {
+		return fmt.Errorf("invalid object lock mode: %s", provider.ObjectLockMode)
+	}
+	if provider.ObjectLockDays < 1 {
+		return fmt.Errorf("object lock days must be at least 1")
+	}
+
+	return nil
+}
+
+// checkFilesystemRoot checks the root path of a filesystem provider. Whether it
+// exists is only checked by the connection test, as a share may not be mounted yet.
+func checkFilesystemRoot(provider *S3Provider) error {
+	if provider.PathPrefix == nil || *provider.PathPrefix == "" {
+		return fmt.Errorf("filesystem root path is required")
+	}
+	if !filepath.IsAbs(*provider.PathPrefix) {
+		return fmt.Errorf("filesystem root path must be absolute: %s", *provider.PathPrefix)
+	}
+	return nil
+}
+
+// decryptProviderLogin decrypts the SFTP password and private key, the Azure SAS
+// token and the GCS service-account key of a provider
+func (s *S3ProviderService) decryptProviderLogin(provider *S3Provider) error {
+	if provider.Password != "" {
+		password, err := s.cryptoService.Decrypt(provider.Password)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt password: %w", err)
+		}
+		provider.Password = password
+	}
+	if provider.PrivateKey != "" {
+		privateKey, err := s.cryptoService.Decrypt(provider.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+		provider.PrivateKey = privateKey
+	}
+	if provider.SASToken != "" {
+		sasToken, err := s.cryptoService.Decrypt(provider.SASToken)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt SAS token: %w", err)
+		}
+		provider.SASToken = sasToken
+	}
+	if provider.CredentialsJSON != "" {
+		credentials, err := s.cryptoService.Decrypt(provider.CredentialsJSON)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt service account key: %w", err)
+		}
+		provider.CredentialsJSON = credentials
+	}
+	return nil
+}
+
@@ -1,15 +1,17 @@
 package backup
 
 import (
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/dendianugerah/velld/internal/common"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
@@ -37,12 +39,17 @@ type S3Config struct {
 	SecretKey  string
 	UseSSL     bool
 	PathPrefix string
+	// Object Lock retention applied to uploads, if ObjectLockMode is set
+	ObjectLockMode string
+	ObjectLockDays int
 }
 
 type S3Storage struct {
-	client *minio.Client
-	bucket string
-	prefix string
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	lockMode minio.RetentionMode
+	lockDays int
 }
 
 // GetBucket returns the bucket name (for logging purposes)
@@ -117,7 +124,8 @@ func NewS3Storage(config S3Config) (*S3Storage, error) {
 
 		if !exists {
 			err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{
-				Region: config.Region,
+				Region:        config.Region,
+				ObjectLocking: config.ObjectLockMode != "",
 			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to create bucket: %w", err)
@@ -126,9 +134,11 @@ func NewS3Storage(config S3Config) (*S3Storage, error) {
 	}
 
 	return &S3Storage{
-		client: client,
-		bucket: bucket,
-		prefix: config.PathPrefix,
+		client:   client,
+		bucket:   bucket,
+		prefix:   config.PathPrefix,
+		lockMode: minio.RetentionMode(config.ObjectLockMode),
+		lockDays: config.ObjectLockDays,
 	}, nil
 }
 
@@ -159,37 +169,18 @@ func (s *S3Storage) UploadFileWithLogging(ctx context.Context, localPath string,
 	}
 
 	fileName := filepath.Base(localPath)
-	// Extract connection name from local path if possible
-	// Path format: /backup/connection_name/filename or {backupDir}/connection_name/filename
-	connectionName := ""
-	// Normalize path separators for cross-platform compatibility
-	normalizedPath := filepath.ToSlash(localPath)
-	pathParts := strings.Split(normalizedPath, "/")
-	if len(pathParts) >= 2 {
-		// Try to find connection name in path (usually second-to-last part before filename)
-		// Skip common backup directory names
-		skipDirs := map[string]bool{
-			"backup": true, "backups": true, "": true,
-		}
-		for i := len(pathParts) - 2; i >= 0; i-- {
-			part := strings.TrimSpace(pathParts[i])
-			if part != "" && !skipDirs[strings.ToLower(part)] && !strings.Contains(part, ".") {
-				// This is likely the connection name (not a file extension)
-				connectionName = part
-				break
-			}
-		}
-	}
-	objectKey := s.getObjectKey(fileName, connectionName)
+	objectKey := s.getObjectKey(fileName, connectionNameFromPath(localPath))
 
 	if logFunc != nil {
 		logFunc(fmt.Sprintf("[INFO] Uploading to S3 bucket '%s' with key '%s'...", s.bucket, objectKey))
 		logFunc(fmt.Sprintf("[INFO] File size: %d bytes (%.2f MB)", fileInfo.Size(), float64(fileInfo.Size())/(1024*1024)))
 	}
 
-	_, err = s.client.PutObject(ctx, s.bucket, objectKey, file, fileInfo.Size(), minio.PutObjectOptions{
+	opts := minio.PutObjectOptions{
 		ContentType: "application/octet-stream",
-	})
+	}
+	s.applyObjectLock(&opts)
+	_, err = s.client.PutObject(ctx, s.bucket, objectKey, file, fileInfo.Size(), opts)
 	if err != nil {
 		if logFunc != nil {
 			logFunc(fmt.Sprintf("[ERROR] S3 upload failed: %v", err))
@@ -204,6 +195,30 @@ func (s *S3Storage) UploadFileWithLogging(ctx context.Context, localPath string,
 	return objectKey, nil
 }
 
+// connectionNameFromPath extracts the connection folder from a local backup path
+// Path format: /backup/connection_name/filename or {backupDir}/connection_name/filename
+func connectionNameFromPath(localPath string) string {
+	// Normalize path separators for cross-platform compatibility
+	pathParts := strings.Split(filepath.ToSlash(localPath), "/")
+	if len(pathParts) < 2 {
+		return ""
+	}
+
+	// Try to find connection name in path (usually second-to-last part before filename)
+	// Skip common backup directory names
+	skipDirs := map[string]bool{
+		"backup": true, "backups": true, "": true,
+	}
+	for i := len(pathParts) - 2; i >= 0; i-- {
+		part := strings.TrimSpace(pathParts[i])
+		if part != "" && !skipDirs[strings.ToLower(part)] && !strings.Contains(part, ".") {
+			// This is likely the connection name (not a file extension)
+			return part
+		}
+	}
+	return ""
+}
+
 func (s *S3Storage) DownloadFile(ctx context.Context, objectKey, localPath string) error {
 	object, err := s.client.GetObject(ctx, s.bucket, objectKey, minio.GetObjectOptions{})
 	if err != nil {
@@ -234,7 +249,31 @@ func (s *S3Storage) GetObject(ctx context.Context, objectKey string) (io.ReadClo
 	return object, nil
 }
 
+// GetObjectRange returns an io.ReadCloser for streaming part of an object from S3
+func (s *S3Storage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	opts := minio.GetObjectOptions{}
+	if length > 0 {
+		opts.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		opts.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	object, err := s.client.GetObject(ctx, s.bucket, objectKey, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	return object, nil
+}
+
 func (s *S3Storage) DeleteFile(ctx context.Context, objectKey string) error {
+	if s.lockMode != "" {
+		return s.deleteVersions(ctx, objectKey)
+	}
+
 	err := s.client.RemoveObject(ctx, s.bucket, objectKey, minio.RemoveObjectOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to delete object from S3: %w", err)
@@ -242,6 +281,31 @@ func (s *S3Storage) DeleteFile(ctx context.Context, objectKey string) error {
 	return nil
 }
 
+// deleteVersions deletes every version of an object. Object Lock buckets are
+// versioned, so a delete without a version only hides the object behind a delete
+// marker and keeps the locked version; deleting the version itself fails while it
+// is retained, so the deletion is retried once the retention ends.
+func (s *S3Storage) deleteVersions(ctx context.Context, objectKey string) error {
+	var versions []string
+	opts := minio.ListObjectsOptions{Prefix: objectKey, WithVersions: true}
+	for object := range s.client.ListObjects(ctx, s.bucket, opts) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list object versions: %w", object.Err)
+		}
+		if object.Key == objectKey {
+			versions = append(versions, object.VersionID)
+		}
+	}
+
+	for _, versionID := range versions {
+		err := s.client.RemoveObject(ctx, s.bucket, objectKey, minio.RemoveObjectOptions{VersionID: versionID})
+		if err != nil {
+			return fmt.Errorf("failed to delete object version %s from S3: %w", versionID, err)
+		}
+	}
+	return nil
+}
+
 func (s *S3Storage) ListFiles(ctx context.Context) ([]string, error) {
 	var files []string
 
@@ -260,12 +324,24 @@ func (s *S3Storage) ListFiles(ctx context.Context) ([]string, error) {
 	return files, nil
 }
 
-func (s *S3Storage) GetFileSize(ctx context.Context, objectKey string) (int64, error) {
+func (s *S3Storage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
 	info, err := s.client.StatObject(ctx, s.bucket, objectKey, minio.StatObjectOptions{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to stat object: %w", err)
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return &ObjectInfo{Key: objectKey, Size: info.Size, LastModified: info.LastModified}, nil
+}
+
+// PresignGetObject returns a presigned S3 URL for the object
+func (s *S3Storage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	presignedURL, err := s.client.PresignedGetObject(ctx, s.bucket, objectKey, expiry, url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign object: %w", err)
 	}
-	return info.Size, nil
+	return presignedURL.String(), nil
 }
 
 func (s *S3Storage) TestConnection(ctx context.Context) error {
@@ -276,33 +352,98 @@ func (s *S3Storage) TestConnection(ctx context.Context) error {
 	if !exists {
 		return fmt.Errorf("bucket does not exist: %s", s.bucket)
 	}
+	if s.lockMode != "" {
+		return checkObjectLock(ctx, s.client, s.bucket)
+	}
 	return nil
 }
 
-func (s *S3Storage) getObjectKey(fileName string, connectionName string) string {
-	// Sanitize connection name for use in S3 path
-	// Remove any leading/trailing slashes and ensure it's safe
-	connectionName = strings.Trim(connectionName, "/")
-	fileName = strings.TrimPrefix(fileName, "/")
-	
-	// Build path: prefix/connection_name/filename or connection_name/filename
-	parts := []string{}
-	if s.prefix != "" {
-		prefix := strings.TrimSuffix(s.prefix, "/")
-		parts = append(parts, prefix)
+// checkObjectLock checks that Object Lock is enabled on a bucket
+func checkObjectLock(ctx context.Context, client *minio.Client, bucket string) error {
+	enabled, _, _, _, err := client.GetObjectLockConfig(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get object lock configuration: %w", err)
 	}
-	if connectionName != "" {
-		parts = append(parts, connectionName)
+	if enabled != "Enabled" {
+		return fmt.Errorf("object lock is not enabled on bucket: %s", bucket)
 	}
-	parts = append(parts, fileName)
-	
-	return strings.Join(parts, "/")
+	return nil
+}
+
+// ObjectLockEnabled reports whether the provider locks its objects
+func (s *S3Storage) ObjectLockEnabled() bool {
+	return s.lockMode != ""
+}
+
+// SetLegalHold places or removes a legal hold on an object. A held object can't be
+// deleted until the hold is removed, whatever its retention.
+func (s *S3Storage) SetLegalHold(ctx context.Context, objectKey string, hold bool) error {
+	status := minio.LegalHoldDisabled
+	if hold {
+		status = minio.LegalHoldEnabled
+	}
+	err := s.client.PutObjectLegalHold(ctx, s.bucket, objectKey, minio.PutObjectLegalHoldOptions{Status: &status})
+	if err != nil {
+		return fmt.Errorf("failed to set legal hold: %w", err)
+	}
+	return nil
+}
+
+// RetainUntil keeps an object from being deleted until a date, in the provider's
+// lock mode. Retention is only ever extended, as S3 rejects shortening it.
+func (s *S3Storage) RetainUntil(ctx context.Context, objectKey string, until time.Time) error {
+	_, current, err := s.client.GetObjectRetention(ctx, s.bucket, objectKey, "")
+	if err == nil && current != nil && !current.Before(until) {
+		return nil
+	}
+
+	mode := s.lockMode
+	until = until.UTC()
+	err = s.client.PutObjectRetention(ctx, s.bucket, objectKey, minio.PutObjectRetentionOptions{
+		Mode:            &mode,
+		RetainUntilDate: &until,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set object retention: %w", err)
+	}
+	return nil
+}
+
+// RetainedUntil returns when an object's Object Lock retention ends
+func (s *S3Storage) RetainedUntil(ctx context.Context, objectKey string) (time.Time, error) {
+	_, until, err := s.client.GetObjectRetention(ctx, s.bucket, objectKey, "")
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchObjectLockConfiguration" {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to get object retention: %w", err)
+	}
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+// applyObjectLock adds the provider's Object Lock retention, counted from now, to
+// the options of an upload
+func (s *S3Storage) applyObjectLock(opts *minio.PutObjectOptions) {
+	if s.lockMode == "" {
+		return
+	}
+	opts.Mode = s.lockMode
+	opts.RetainUntilDate = time.Now().AddDate(0, 0, s.lockDays).UTC()
+	// Object Lock buckets require a checksum on every upload request
+	opts.SendContentMd5 = true
+}
+
+func (s *S3Storage) getObjectKey(fileName string, connectionName string) string {
+	return joinObjectKey(s.prefix, connectionName, fileName)
 }
 
 // UploadStream uploads data from an io.Reader directly to S3
 // This is useful for streaming backups without creating local files
 // connectionName is used to organize backups in folders per connection
-func (s *S3Storage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, logFunc func(string)) (string, error) {
+func (s *S3Storage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
 	// Apply path prefix and connection folder to the object key
 	finalKey := s.getObjectKey(objectKey, connectionName)
 	
@@ -310,11 +451,9 @@ func (s *S3Storage) UploadStream(ctx context.Context, reader io.Reader, objectKe
 		logFunc(fmt.Sprintf("[INFO] Starting streaming upload to S3 bucket '%s' with key '%s'...", s.bucket, finalKey))
 	}
 
-	// Use PutObject which accepts an io.Reader
-	// For unknown size, we use -1 (minio will determine size during upload)
-	_, err := s.client.PutObject(ctx, s.bucket, finalKey, reader, -1, minio.PutObjectOptions{
-		ContentType: "application/octet-stream",
-	})
+	// The size is unknown, so the stream is sent as a multipart upload whose parts
+	// are retried on their own
+	err := s.putStream(ctx, reader, finalKey, contentType, logFunc)
 	if err != nil {
 		if logFunc != nil {
 			logFunc(fmt.Sprintf("[ERROR] S3 streaming upload failed: %v", err))
@@ -330,62 +469,61 @@ func (s *S3Storage) UploadStream(ctx context.Context, reader io.Reader, objectKe
 	return finalKey, nil
 }
 
-// UploadCompressedStream uploads compressed data from an io.Reader directly to S3
-// The data is gzip-compressed on-the-fly during upload
-// connectionName is used to organize backups in folders per connection
-func (s *S3Storage) UploadCompressedStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, logFunc func(string)) (string, error) {
-	if logFunc != nil {
-		logFunc(fmt.Sprintf("[INFO] Starting compressed streaming upload to S3 bucket '%s' with key '%s'...", s.bucket, objectKey))
-		logFunc("[INFO] Compressing data on-the-fly during upload...")
-	}
-
-	// Create a pipe: gzip writer writes to pipe, pipe reader feeds S3
+// encodeStream compresses reader with compression, then encrypts it when encrypt is
+// set. The encoding runs in the background as the returned reader is read.
+func encodeStream(reader io.Reader, compression common.Compression, encrypt StreamEncrypter) *io.PipeReader {
+	// Create a pipe: the compressor writes to pipe, pipe reader feeds the upload
 	pr, pw := io.Pipe()
-	
-	// Gzip writer compresses data and writes to pipe
-	gzipWriter := gzip.NewWriter(pw)
-	
-	// Start goroutine to copy data from reader through gzip to pipe
+
+	// Start goroutine to copy data from reader through the compressor to pipe
 	go func() {
-		defer pw.Close()
-		defer gzipWriter.Close()
-		
-		_, err := io.Copy(gzipWriter, reader)
+		var out io.WriteCloser = pw
+		if encrypt != nil {
+			encWriter, err := encrypt.Encrypt(pw)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("encryption error: %w", err))
+				return
+			}
+			out = encWriter
+		}
+
+		// The compressor compresses data and writes to pipe
+		compressor, err := compression.NewWriter(out)
 		if err != nil {
 			pw.CloseWithError(fmt.Errorf("compression error: %w", err))
 			return
 		}
+		if _, err := io.Copy(compressor, reader); err != nil {
+			pw.CloseWithError(fmt.Errorf("compression error: %w", err))
+			return
+		}
+		if err := compressor.Close(); err != nil {
+			pw.CloseWithError(fmt.Errorf("compression error: %w", err))
+			return
+		}
+		// Closing the encrypter writes its final chunk before the pipe is closed
+		pw.CloseWithError(out.Close())
 	}()
 
-	// Upload compressed stream from pipe reader
-	// Use .gz extension for the object key if not already present
-	if !strings.HasSuffix(objectKey, ".gz") {
-		objectKey = objectKey + ".gz"
-	}
-	
-	// Apply path prefix and connection folder to the object key
-	finalKey := s.getObjectKey(objectKey, connectionName)
-	
-	if logFunc != nil {
-		logFunc(fmt.Sprintf("[INFO] Uploading to bucket '%s' with key '%s'", s.bucket, finalKey))
-		logFunc(fmt.Sprintf("[INFO] Full S3 path: s3://%s/%s", s.bucket, finalKey))
+	return pr
+}
+
+// encodedObjectKey adds the codec's extension to objectKey if not already present,
+// then the encryption's
+func encodedObjectKey(objectKey string, compression common.Compression, encrypt StreamEncrypter) string {
+	if !strings.HasSuffix(objectKey, compression.Extension()) {
+		objectKey += compression.Extension()
 	}
-	
-	_, err := s.client.PutObject(ctx, s.bucket, finalKey, pr, -1, minio.PutObjectOptions{
-		ContentType: "application/gzip",
-	})
-	if err != nil {
-		if logFunc != nil {
-			logFunc(fmt.Sprintf("[ERROR] S3 compressed streaming upload failed: %v", err))
-		}
-		pr.Close()
-		return "", fmt.Errorf("failed to stream compressed upload to S3: %w", err)
+	if encrypt != nil {
+		objectKey += encrypt.Extension()
 	}
+	return objectKey
+}
 
-	if logFunc != nil {
-		logFunc(fmt.Sprintf("[INFO] Compressed streaming upload completed successfully"))
-		logFunc(fmt.Sprintf("[INFO] File available at: s3://%s/%s", s.bucket, finalKey))
+// encodedContentType is the MIME type of objects encoded with compression and encrypt
+func encodedContentType(compression common.Compression, encrypt StreamEncrypter) string {
+	if encrypt != nil {
+		return "application/octet-stream"
 	}
-
-	return finalKey, nil
+	return compression.ContentType()
 }
@@ -0,0 +1,286 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+)
+
+const testS3Bucket = "backups"
+
+// s3Version is a version of an object in the fake bucket, or a delete marker
+type s3Version struct {
+	id           string
+	size         int
+	deleteMarker bool
+	retainUntil  time.Time
+	lastModified time.Time
+}
+
+// fakeS3Bucket serves the S3 calls deleting objects makes on a versioned bucket with
+// Object Lock enabled, as MinIO does: deletes without a version add a delete
+// marker, and deleting a version under retention is denied.
+type fakeS3Bucket struct {
+	mu       sync.Mutex
+	versions map[string][]*s3Version // oldest first
+	next     int
+	// denyVersionList denies listing versions, as to keys without
+	// s3:ListBucketVersions
+	denyVersionList bool
+}
+
+func newFakeS3Bucket(t *testing.T) (*fakeS3Bucket, string) {
+	t.Helper()
+	fake := &fakeS3Bucket{versions: make(map[string][]*s3Version)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, strings.TrimPrefix(server.URL, "http://")
+}
+
+// put stores a new version of an object, retained until retainUntil
+func (f *fakeS3Bucket) put(key string, retainUntil time.Time) {
+	f.add(key, &s3Version{size: 4, retainUntil: retainUntil})
+}
+
+func (f *fakeS3Bucket) add(key string, version *s3Version) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	version.id = fmt.Sprintf("v%d", f.next)
+	version.lastModified = time.Now().UTC().Truncate(time.Second)
+	f.versions[key] = append(f.versions[key], version)
+}
+
+// latest returns the current version of an object, which is nil when it has none
+func (f *fakeS3Bucket) latest(key string) *s3Version {
+	versions := f.versions[key]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+func (f *fakeS3Bucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	if r.URL.Path == "/"+testS3Bucket || r.URL.Path == "/"+testS3Bucket+"/" {
+		switch {
+		case r.Method == http.MethodHead:
+		case r.Method == http.MethodGet && query.Has("versions") && f.denyVersionList:
+			s3Error(w, http.StatusForbidden, "AccessDenied")
+		case r.Method == http.MethodGet && query.Has("versions"):
+			f.listVersions(w, query.Get("prefix"))
+		default:
+			s3Error(w, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testS3Bucket+"/")
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	latest := f.latest(key)
+	switch {
+	case r.Method == http.MethodHead:
+		if latest == nil || latest.deleteMarker {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(latest.size))
+		w.Header().Set("Last-Modified", latest.lastModified.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"`+latest.id+`"`)
+		w.Header().Set("x-amz-version-id", latest.id)
+	case r.Method == http.MethodGet && query.Has("retention"):
+		switch {
+		case latest == nil || latest.deleteMarker:
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+		case latest.retainUntil.IsZero():
+			s3Error(w, http.StatusNotFound, "NoSuchObjectLockConfiguration")
+		default:
+			fmt.Fprintf(w, `<Retention><Mode>COMPLIANCE</Mode><RetainUntilDate>%s</RetainUntilDate></Retention>`, latest.retainUntil.Format(time.RFC3339))
+		}
+	case r.Method == http.MethodDelete && query.Has("versionId"):
+		versions := f.versions[key]
+		for i, version := range versions {
+			if version.id != query.Get("versionId") {
+				continue
+			}
+			if !version.deleteMarker && version.retainUntil.After(time.Now()) {
+				s3Error(w, http.StatusForbidden, "AccessDenied")
+				return
+			}
+			f.versions[key] = append(versions[:i:i], versions[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s3Error(w, http.StatusNotFound, "NoSuchVersion")
+	case r.Method == http.MethodDelete:
+		f.next++
+		f.versions[key] = append(f.versions[key], &s3Version{id: fmt.Sprintf("v%d", f.next), deleteMarker: true, lastModified: time.Now().UTC()})
+		w.Header().Set("x-amz-delete-marker", "true")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// listVersions lists the versions of the objects under prefix, newest first
+func (f *fakeS3Bucket) listVersions(w http.ResponseWriter, prefix string) {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<ListVersionsResult><Name>%s</Name><Prefix>%s</Prefix><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`, testS3Bucket, prefix)
+	for key, versions := range f.versions {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for i := len(versions) - 1; i >= 0; i-- {
+			version := versions[i]
+			tag := "Version"
+			if version.deleteMarker {
+				tag = "DeleteMarker"
+			}
+			fmt.Fprintf(&body, `<%s><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%v</IsLatest><LastModified>%s</LastModified><Size>%d</Size></%s>`,
+				tag, key, version.id, i == len(versions)-1, version.lastModified.Format(time.RFC3339), version.size, tag)
+		}
+	}
+	body.WriteString(`</ListVersionsResult>`)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body.Bytes())
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message><RequestId>1</RequestId></Error>`, code, code)
+}
+
+func newTestS3Storage(t *testing.T, endpoint string) *S3Storage {
+	t.Helper()
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:       endpoint,
+		Region:         "us-east-1",
+		Bucket:         testS3Bucket,
+		AccessKey:      "velld",
+		SecretKey:      "velld-secret",
+		ObjectLockMode: "COMPLIANCE",
+		ObjectLockDays: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestS3StorageDeletesLockedVersions(t *testing.T) {
+	fake, endpoint := newFakeS3Bucket(t)
+	storage := newTestS3Storage(t, endpoint)
+	ctx := context.Background()
+
+	// An object whose retention ended is deleted for good, with the delete marker an
+	// earlier delete left over it
+	fake.put("app/expired.sql", time.Now().Add(-time.Hour))
+	fake.add("app/expired.sql", &s3Version{deleteMarker: true})
+	if err := storage.DeleteFile(ctx, "app/expired.sql"); err != nil {
+		t.Fatal(err)
+	}
+	if versions := fake.versions["app/expired.sql"]; len(versions) != 0 {
+		t.Errorf("deleting left %d versions of the object", len(versions))
+	}
+
+	// An object still retained can't be deleted, and isn't hidden behind a delete
+	// marker as though it were
+	fake.put("app/locked.sql", time.Now().Add(time.Hour))
+	if err := storage.DeleteFile(ctx, "app/locked.sql"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("deleting a retained object returned %v, want access denied", err)
+	}
+	if latest := fake.latest("app/locked.sql"); latest == nil || latest.deleteMarker || len(fake.versions["app/locked.sql"]) != 1 {
+		t.Error("deleting a retained object changed its versions")
+	}
+}
+
+func TestDeleteRetainedBackupLeavesTombstone(t *testing.T) {
+	service, connRepo, providerService := newTestBackupService(t)
+	userID := uuid.New()
+	ctx := context.Background()
+
+	fake, endpoint := newFakeS3Bucket(t)
+	RegisterStorage("test-s3-lock", func(provider *S3Provider) (Storage, error) {
+		return newTestS3Storage(t, endpoint), nil
+	})
+	provider, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{Name: "Locked", Type: "test-s3-lock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := connection.StoredConnection{ID: uuid.NewString(), Name: "App", Type: "sqlite", DatabaseName: filepath.Join(t.TempDir(), "app.sqlite"), UserID: userID}
+	if err := connRepo.Save(conn); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	backup := &Backup{ID: uuid.New(), ConnectionID: conn.ID, Status: "success", StartedTime: now, CompletedTime: &now, CreatedAt: now, UpdatedAt: now}
+	if err := service.backupRepo.CreateBackup(backup); err != nil {
+		t.Fatal(err)
+	}
+	objectKey := "app/app_20250101_000000.sql"
+	if err := service.backupRepo.AddBackupS3Provider(backup.ID.String(), BackupS3Provider{ProviderID: provider.ID.String(), ObjectKey: objectKey, Status: "success", Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	retainUntil := now.Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	fake.put(objectKey, retainUntil)
+
+	deletion := service.DeleteBackup(ctx, backup, userID)
+	if !deletion.Deleted || len(deletion.Objects) != 1 || deletion.Objects[0].Status != ObjectPending {
+		t.Fatalf("deletion returned %+v, want the backup deleted and its object pending", deletion)
+	}
+	tombstones, err := service.GetTombstones(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 1 {
+		t.Fatalf("left %d tombstones, want 1", len(tombstones))
+	}
+	if !tombstones[0].NextAttemptAt.Equal(retainUntil) || !strings.Contains(tombstones[0].LastError, "retained until") {
+		t.Errorf("tombstone is retried at %s after %q, want when the retention ends at %s", tombstones[0].NextAttemptAt, tombstones[0].LastError, retainUntil)
+	}
+}
+
+func TestDeleteHiddenLockedObjectFails(t *testing.T) {
+	fake, endpoint := newFakeS3Bucket(t)
+	storage := newTestS3Storage(t, endpoint)
+	ctx := context.Background()
+
+	// A retained object behind a delete marker stats as missing, but isn't deleted
+	fake.put("app/locked.sql", time.Now().Add(time.Hour))
+	fake.add("app/locked.sql", &s3Version{deleteMarker: true})
+	fake.denyVersionList = true
+	if _, err := storage.StatObject(ctx, "app/locked.sql"); !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("stat of an object behind a delete marker returned %v, want %v", err, ErrObjectNotFound)
+	}
+	if err := deleteStoredObject(ctx, storage, "app/locked.sql"); err == nil {
+		t.Error("deleting an object whose versions couldn't be deleted succeeded")
+	}
+
+	// Once its versions can be listed, it is deleted up to the retained version
+	fake.denyVersionList = false
+	if err := deleteStoredObject(ctx, storage, "app/locked.sql"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("deleting a retained object returned %v, want access denied", err)
+	}
+	if err := deleteStoredObject(ctx, storage, "app/gone.sql"); err != nil {
+		t.Errorf("deleting an object without versions returned %v", err)
+	}
+}
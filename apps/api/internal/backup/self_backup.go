@@ -0,0 +1,479 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// selfBackupFolder holds the snapshots of velld's own database on a provider. It
+	// can't clash with a connection's folder, as sanitized names have no hyphens.
+	selfBackupFolder = "velld-self-backup"
+	// selfBackupExtension ends the object keys of snapshots
+	selfBackupExtension = ".snapshot"
+	selfBackupFormat    = "velld-snapshot"
+	selfBackupVersion   = 1
+	// maxSelfBackupHeader is the size of the largest snapshot header that is read
+	maxSelfBackupHeader = 64 * 1024
+
+	// defaultSelfBackupSchedule and defaultSelfBackupKeepLast are offered until a
+	// self-backup is set up
+	defaultSelfBackupSchedule = "0 0 3 * * *"
+	defaultSelfBackupKeepLast = 7
+)
+
+var (
+	errSelfBackupOwner       = errors.New("self-backup is managed by another user")
+	errSelfBackupRunning     = errors.New("a self-backup is already running")
+	errSelfBackupUnavailable = errors.New("the database path isn't known, so velld can't back itself up")
+	errSelfBackupNotSetUp    = errors.New("self-backup isn't set up")
+)
+
+// A snapshot is a line of JSON, the selfBackupHeader, followed by the database
+// gzipped and then encrypted with newEncryptWriter. The header carries the data key
+// wrapped with the master key, so a snapshot restores with ENCRYPTION_KEY alone.
+type selfBackupHeader struct {
+	Format              string    `json:"format"`
+	Version             int       `json:"version"`
+	CreatedAt           time.Time `json:"created_at"`
+	DatabaseSize        int64     `json:"database_size"`
+	Compression         string    `json:"compression"`
+	EncryptionAlgorithm string    `json:"encryption_algorithm"`
+	EncryptionKeyID     string    `json:"encryption_key_id"`
+	EncryptedDataKey    string    `json:"encrypted_data_key"`
+}
+
+// isSelfBackupKey reports whether an object is a snapshot of velld's own database
+func isSelfBackupKey(objectKey string) bool {
+	return path.Base(path.Dir(objectKey)) == selfBackupFolder && strings.HasSuffix(objectKey, selfBackupExtension)
+}
+
+// ScheduleSelfBackups lets velld back up its own database at dbPath, and schedules
+// the self-backup when one is set up
+func (s *BackupService) ScheduleSelfBackups(dbPath string) {
+	s.dbPath = dbPath
+
+	settings, err := s.backupRepo.GetSelfBackupSettings()
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting self-backup settings: %v\n", err)
+		return
+	}
+	if err := s.scheduleSelfBackup(settings); err != nil {
+		fmt.Printf("Error scheduling self-backup: %v\n", err)
+	}
+}
+
+// scheduleSelfBackup replaces the cron job of the self-backup with one for settings
+func (s *BackupService) scheduleSelfBackup(settings *SelfBackupSettings) error {
+	if s.selfBackupEntry != 0 {
+		s.cronManager.Remove(s.selfBackupEntry)
+		s.selfBackupEntry = 0
+	}
+	if !settings.Enabled {
+		return nil
+	}
+
+	entryID, err := s.cronManager.AddFunc(settings.CronSchedule, s.runScheduledSelfBackup)
+	if err != nil {
+		return err
+	}
+	s.selfBackupEntry = entryID
+	return nil
+}
+
+// GetSelfBackupSettings returns the self-backup of the user, or the defaults it is
+// set up with when there is none yet
+func (s *BackupService) GetSelfBackupSettings(userID uuid.UUID) (*SelfBackupSettings, error) {
+	settings, err := s.backupRepo.GetSelfBackupSettings()
+	if err == sql.ErrNoRows {
+		return &SelfBackupSettings{
+			CronSchedule: defaultSelfBackupSchedule,
+			Retention:    RetentionPolicy{KeepLast: defaultSelfBackupKeepLast},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get self-backup settings: %v", err)
+	}
+	if settings.UserID != userID.String() {
+		return nil, errSelfBackupOwner
+	}
+
+	if settings.Enabled && s.selfBackupEntry != 0 {
+		next := s.cronManager.Entry(s.selfBackupEntry).Next
+		if !next.IsZero() {
+			settings.NextRunTime = &next
+		}
+	}
+	return settings, nil
+}
+
+// UpdateSelfBackupSettings sets up the self-backup to upload to a provider of the
+// user. Only the user who set it up can change it.
+func (s *BackupService) UpdateSelfBackupSettings(userID uuid.UUID, req *SelfBackupRequest) (*SelfBackupSettings, error) {
+	existing, err := s.backupRepo.GetSelfBackupSettings()
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get self-backup settings: %v", err)
+	}
+	if existing != nil && existing.UserID != userID.String() {
+		return nil, errSelfBackupOwner
+	}
+
+	if _, err := s.s3ProviderService.GetS3ProviderForDownload(req.ProviderID, userID); err != nil {
+		return nil, err
+	}
+
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+	if _, err := parser.Parse(req.CronSchedule); err != nil {
+		return nil, fmt.Errorf("invalid cron schedule: %v", err)
+	}
+
+	settings := &SelfBackupSettings{
+		UserID:        userID.String(),
+		ProviderID:    req.ProviderID,
+		Enabled:       req.Enabled,
+		CronSchedule:  req.CronSchedule,
+		RetentionDays: req.RetentionDays,
+		UpdatedAt:     time.Now(),
+	}
+	if req.Retention != nil {
+		settings.Retention = *req.Retention
+	}
+	if err := s.backupRepo.SaveSelfBackupSettings(settings); err != nil {
+		return nil, fmt.Errorf("failed to save self-backup settings: %v", err)
+	}
+	if err := s.scheduleSelfBackup(settings); err != nil {
+		return nil, fmt.Errorf("failed to schedule self-backup: %v", err)
+	}
+
+	return s.GetSelfBackupSettings(userID)
+}
+
+// RunSelfBackup backs up velld's own database now, whether or not the self-backup
+// is enabled
+func (s *BackupService) RunSelfBackup(ctx context.Context, userID uuid.UUID) (*SelfBackupRun, error) {
+	settings, err := s.backupRepo.GetSelfBackupSettings()
+	if err == sql.ErrNoRows {
+		return nil, errSelfBackupNotSetUp
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get self-backup settings: %v", err)
+	}
+	if settings.UserID != userID.String() {
+		return nil, errSelfBackupOwner
+	}
+
+	return s.runSelfBackup(ctx, settings)
+}
+
+// runScheduledSelfBackup is the cron job of the self-backup
+func (s *BackupService) runScheduledSelfBackup() {
+	settings, err := s.backupRepo.GetSelfBackupSettings()
+	if err != nil {
+		fmt.Printf("Error getting self-backup settings: %v\n", err)
+		return
+	}
+	if !settings.Enabled {
+		return
+	}
+
+	run, err := s.runSelfBackup(context.Background(), settings)
+	if err != nil {
+		fmt.Printf("Error backing up velld's database: %v\n", err)
+		return
+	}
+	fmt.Printf("Backed up velld's database to %s (%d bytes)\n", run.ObjectKey, run.Size)
+}
+
+// runSelfBackup backs up velld's own database and records how it went
+func (s *BackupService) runSelfBackup(ctx context.Context, settings *SelfBackupSettings) (*SelfBackupRun, error) {
+	if !s.selfBackupMutex.TryLock() {
+		return nil, errSelfBackupRunning
+	}
+	defer s.selfBackupMutex.Unlock()
+
+	run, err := s.backUpDatabase(ctx, settings)
+
+	status, errMsg, objectKey, size := "success", "", "", int64(0)
+	if err != nil {
+		status, errMsg = "failed", err.Error()
+	} else {
+		objectKey, size = run.ObjectKey, run.Size
+	}
+	if recordErr := s.backupRepo.UpdateSelfBackupRun(time.Now(), status, errMsg, objectKey, size); recordErr != nil {
+		fmt.Printf("Error recording self-backup: %v\n", recordErr)
+	}
+	return run, err
+}
+
+// backUpDatabase uploads an encrypted snapshot of velld's own database to the
+// self-backup's provider, then prunes the snapshots its retention doesn't keep
+func (s *BackupService) backUpDatabase(ctx context.Context, settings *SelfBackupSettings) (*SelfBackupRun, error) {
+	if s.dbPath == "" {
+		return nil, errSelfBackupUnavailable
+	}
+	userID, err := uuid.Parse(settings.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid self-backup user: %v", err)
+	}
+	storage, err := s.GetS3ProviderForDownload(settings.ProviderID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider %s: %v", settings.ProviderID, err)
+	}
+
+	run := &SelfBackupRun{StartedAt: time.Now(), Pruned: []string{}}
+
+	snapshot, err := os.CreateTemp(s.backupDir, "velld-snapshot-*.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	snapshotPath := snapshot.Name()
+	snapshot.Close()
+	defer os.Remove(snapshotPath)
+
+	if err := connection.SnapshotSQLite(ctx, s.dbPath, snapshotPath); err != nil {
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	info, err := os.Stat(snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access snapshot: %w", err)
+	}
+	run.DatabaseSize = info.Size()
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := s.cryptoService.Encrypt(base64.StdEncoding.EncodeToString(dataKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	compression := common.Compression{Codec: common.CompressionGzip}
+	header, err := json.Marshal(selfBackupHeader{
+		Format:              selfBackupFormat,
+		Version:             selfBackupVersion,
+		CreatedAt:           run.StartedAt.UTC(),
+		DatabaseSize:        run.DatabaseSize,
+		Compression:         compression.Codec,
+		EncryptionAlgorithm: backupEncryptionAlgorithm,
+		EncryptionKeyID:     s.cryptoService.KeyID(),
+		EncryptedDataKey:    wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot header: %w", err)
+	}
+
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	encoded := encodeStream(file, compression, dataKeyEncrypter(dataKey))
+	defer encoded.Close()
+
+	fileName := fmt.Sprintf("velld_%s%s", run.StartedAt.UTC().Format("20060102_150405"), selfBackupExtension)
+	reader := io.MultiReader(bytes.NewReader(append(header, '\n')), encoded)
+	objectKey, err := storage.UploadStream(ctx, reader, fileName, selfBackupFolder, "application/octet-stream", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	run.ObjectKey = objectKey
+
+	if stored, err := storage.StatObject(ctx, objectKey); err == nil {
+		run.Size = stored.Size
+	}
+
+	pruned, err := pruneSelfBackups(ctx, storage, settings, time.Now())
+	run.Pruned = append(run.Pruned, pruned...)
+	if err != nil {
+		fmt.Printf("Warning: Failed to prune self-backups: %v\n", err)
+	}
+
+	run.FinishedAt = time.Now()
+	return run, nil
+}
+
+// listSelfBackups returns the snapshots of velld's own database on a storage,
+// newest first
+func listSelfBackups(ctx context.Context, storage Storage) ([]SelfBackupSnapshot, error) {
+	keys, err := storage.ListFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	snapshots := []SelfBackupSnapshot{}
+	for _, key := range keys {
+		if !isSelfBackupKey(key) {
+			continue
+		}
+		info, err := storage.StatObject(ctx, key)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SelfBackupSnapshot{ObjectKey: key, Size: info.Size, LastModified: info.LastModified})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].LastModified.After(snapshots[j].LastModified)
+	})
+	return snapshots, nil
+}
+
+// pruneSelfBackups deletes the snapshots the self-backup's retention doesn't keep,
+// returning their keys. Retention decides as it does for a connection's backups.
+func pruneSelfBackups(ctx context.Context, storage Storage, settings *SelfBackupSettings, now time.Time) ([]string, error) {
+	snapshots, err := listSelfBackups(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*Backup, len(snapshots))
+	keys := make(map[*Backup]string, len(snapshots))
+	for i, snapshot := range snapshots {
+		backups[i] = &Backup{CreatedAt: snapshot.LastModified}
+		keys[backups[i]] = snapshot.ObjectKey
+	}
+
+	pruned := []string{}
+	for _, decision := range evaluateRetention(backups, settings.RetentionDays, settings.Retention, now) {
+		if decision.Keep {
+			continue
+		}
+		key := keys[decision.backup]
+		if err := deleteStoredObject(ctx, storage, key); err != nil {
+			fmt.Printf("Warning: Failed to delete self-backup %s: %v\n", key, err)
+			continue
+		}
+		pruned = append(pruned, key)
+	}
+	return pruned, nil
+}
+
+// ListSelfBackups returns the snapshots of velld's own database on the
+// self-backup's provider, newest first
+func (s *BackupService) ListSelfBackups(ctx context.Context, userID uuid.UUID) ([]SelfBackupSnapshot, error) {
+	settings, err := s.backupRepo.GetSelfBackupSettings()
+	if err == sql.ErrNoRows {
+		return nil, errSelfBackupNotSetUp
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get self-backup settings: %v", err)
+	}
+	if settings.UserID != userID.String() {
+		return nil, errSelfBackupOwner
+	}
+
+	storage, err := s.GetS3ProviderForDownload(settings.ProviderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return listSelfBackups(ctx, storage)
+}
+
+// RestoreSelfBackup writes the database in a snapshot read from r to dbPath,
+// decrypting it with cryptoService, which must use the master key the snapshot was
+// taken with. An existing database is only replaced when force is set. Velld must
+// not be running on dbPath.
+func RestoreSelfBackup(r io.Reader, cryptoService *common.EncryptionService, dbPath string, force bool) error {
+	if _, err := os.Stat(dbPath); err == nil && !force {
+		return fmt.Errorf("%s already exists; pass force to replace it", dbPath)
+	}
+
+	reader := bufio.NewReaderSize(r, maxSelfBackupHeader)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	var header selfBackupHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != selfBackupFormat {
+		return errors.New("file is not a velld snapshot")
+	}
+	if header.Version != selfBackupVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if header.EncryptionAlgorithm != backupEncryptionAlgorithm {
+		return fmt.Errorf("unsupported snapshot encryption algorithm: %s", header.EncryptionAlgorithm)
+	}
+	if header.EncryptionKeyID != cryptoService.KeyID() {
+		return fmt.Errorf("snapshot was encrypted with master key %s, but ENCRYPTION_KEY is key %s", header.EncryptionKeyID, cryptoService.KeyID())
+	}
+
+	encoded, err := cryptoService.Decrypt(header.EncryptedDataKey)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode data key: %w", err)
+	}
+
+	decrypted, err := newDecryptReader(reader, dataKey)
+	if err != nil {
+		return err
+	}
+	decompressed, err := common.NewDecompressReader(header.Compression, decrypted)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
+	}
+	restored, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create database file: %w", err)
+	}
+	restoredPath := restored.Name()
+	// Checking the snapshot, which keeps velld's WAL mode, opens a log next to it
+	defer func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(restoredPath + suffix)
+		}
+	}()
+
+	if _, err := io.Copy(restored, decompressed); err != nil {
+		restored.Close()
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	if err := restored.Close(); err != nil {
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err := connection.CheckSQLiteFile(restoredPath); err != nil {
+		return fmt.Errorf("restored database is invalid: %w", err)
+	}
+
+	// The write-ahead log of the replaced database would be replayed onto the snapshot
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", dbPath+suffix, err)
+		}
+	}
+	if err := os.Rename(restoredPath, dbPath); err != nil {
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+	return nil
+}
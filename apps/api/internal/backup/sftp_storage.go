@@ -0,0 +1,490 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/connection"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPConfig is the server, login and base path of an SFTP destination. HostKey
+// is the server's public key in authorized_keys or known_hosts format.
+type SFTPConfig struct {
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	PrivateKey string
+	HostKey    string
+	BasePath   string
+}
+
+// SFTPStorage stores backups as files on an SFTP server, under the base path.
+// Each upload and download opens its own SSH connection, so parallel transfers
+// don't share a session; listing, stats and deletes share one session, which is
+// reopened if its connection drops and closed once it has been idle for
+// sftpIdleTimeout.
+type SFTPStorage struct {
+	config   SFTPConfig
+	hostKey  ssh.PublicKey
+	basePath string
+
+	mu     sync.Mutex
+	shared *sftpSession
+	users  int
+	idle   *time.Timer
+}
+
+// sftpIdleTimeout is how long the shared session stays open after its last use
+const sftpIdleTimeout = 30 * time.Second
+
+func NewSFTPStorage(config SFTPConfig) (*SFTPStorage, error) {
+	config.Host = strings.TrimSpace(config.Host)
+	config.Username = strings.TrimSpace(config.Username)
+	if config.Host == "" {
+		return nil, fmt.Errorf("SFTP host is required")
+	}
+	if config.Username == "" {
+		return nil, fmt.Errorf("SFTP username is required")
+	}
+	if config.Password == "" && config.PrivateKey == "" {
+		return nil, fmt.Errorf("SFTP password or private key is required")
+	}
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if strings.TrimSpace(config.HostKey) == "" {
+		return nil, fmt.Errorf("SFTP host key of %s is unknown", config.Host)
+	}
+	hostKey, err := parseHostKey(config.HostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := strings.TrimSpace(config.BasePath)
+	if basePath != "" {
+		basePath = path.Clean(basePath)
+	}
+
+	return &SFTPStorage{
+		config:   config,
+		hostKey:  hostKey,
+		basePath: basePath,
+	}, nil
+}
+
+// newProviderSFTPStorage creates an SFTP client for a provider
+func newProviderSFTPStorage(provider *S3Provider) (*SFTPStorage, error) {
+	basePath := ""
+	if provider.PathPrefix != nil {
+		basePath = *provider.PathPrefix
+	}
+
+	return NewSFTPStorage(SFTPConfig{
+		Host:       provider.Host,
+		Port:       provider.Port,
+		Username:   provider.Username,
+		Password:   provider.Password,
+		PrivateKey: provider.PrivateKey,
+		HostKey:    provider.HostKey,
+		BasePath:   basePath,
+	})
+}
+
+// parseHostKey parses an SSH public key in authorized_keys format, or a known_hosts
+// line such as ssh-keyscan prints
+func parseHostKey(text string) (ssh.PublicKey, error) {
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+	if err == nil {
+		return hostKey, nil
+	}
+	if _, _, hostKey, _, _, err := ssh.ParseKnownHosts([]byte(text)); err == nil {
+		return hostKey, nil
+	}
+	return nil, fmt.Errorf("invalid SFTP host key: %w", err)
+}
+
+// formatHostKey returns hostKey in authorized_keys format, as providers store it
+func formatHostKey(hostKey ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(hostKey)))
+}
+
+// sftpSession is an SFTP client with the SSH connection it runs over; done is
+// closed once the connection is gone
+type sftpSession struct {
+	*sftp.Client
+	conn *ssh.Client
+	done chan struct{}
+}
+
+func (s *sftpSession) Close() error {
+	s.Client.Close()
+	return s.conn.Close()
+}
+
+func (s *sftpSession) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dial opens an SFTP session
+func (s *SFTPStorage) dial() (*sftpSession, error) {
+	conn, err := connection.DialSSHWithHostKey(s.config.Host, s.config.Port, s.config.Username, s.config.Password, s.config.PrivateKey, s.hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	session := &sftpSession{Client: client, conn: conn, done: make(chan struct{})}
+	go func() {
+		conn.Wait()
+		close(session.done)
+	}()
+	return session, nil
+}
+
+// connect opens an SFTP session of its own; it is closed when ctx is done, which
+// stops any transfer running on it
+func (s *SFTPStorage) connect(ctx context.Context) (*sftpSession, func() bool, error) {
+	session, err := s.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		session.Close()
+	})
+	return session, stop, nil
+}
+
+// withSession runs fn on the shared session, opening it first if it isn't open. The
+// session is closed if ctx is done while fn runs, and the next call reopens it.
+func (s *SFTPStorage) withSession(ctx context.Context, fn func(session *sftpSession) error) error {
+	session, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+
+	stop := context.AfterFunc(ctx, func() {
+		session.Close()
+	})
+	defer stop()
+	return fn(session)
+}
+
+func (s *SFTPStorage) acquire() (*sftpSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	if s.shared == nil || s.shared.closed() {
+		session, err := s.dial()
+		if err != nil {
+			return nil, err
+		}
+		s.shared = session
+	}
+	s.users++
+	return s.shared, nil
+}
+
+// release closes the shared session after sftpIdleTimeout, unless it is used again
+// before then
+func (s *SFTPStorage) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users--
+	if s.users > 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(sftpIdleTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.idle == timer {
+			s.shared.Close()
+			s.shared = nil
+			s.idle = nil
+		}
+	})
+	s.idle = timer
+}
+
+func (s *SFTPStorage) UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error) {
+	if logFunc != nil {
+		logFunc("[INFO] Opening backup file for upload...")
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Failed to open file: %v", err))
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return s.UploadStream(ctx, file, filepath.Base(localPath), connectionNameFromPath(localPath), "application/octet-stream", logFunc)
+}
+
+// UploadStream writes reader to a .partial file next to the object, then renames it
+// into place, so an interrupted upload never leaves a truncated backup behind
+func (s *SFTPStorage) UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error) {
+	finalKey := s.getObjectKey(objectKey, connectionName)
+
+	if logFunc != nil {
+		logFunc(fmt.Sprintf("[INFO] Starting streaming upload to SFTP server '%s' with path '%s'...", s.config.Host, finalKey))
+	}
+
+	if err := s.putFile(ctx, reader, finalKey); err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] SFTP streaming upload failed: %v", err))
+		}
+		return "", fmt.Errorf("failed to stream upload to SFTP: %w", err)
+	}
+
+	if logFunc != nil {
+		logFunc("[INFO] Streaming upload completed successfully")
+		logFunc(fmt.Sprintf("[INFO] File available at: sftp://%s/%s", s.config.Host, strings.TrimPrefix(finalKey, "/")))
+	}
+
+	return finalKey, nil
+}
+
+func (s *SFTPStorage) putFile(ctx context.Context, reader io.Reader, remotePath string) error {
+	session, stop, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer stop()
+
+	if dir := path.Dir(remotePath); dir != "." && dir != "/" {
+		if err := session.MkdirAll(dir); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	partialPath := remotePath + ".partial"
+	file, err := session.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.rename(session, partialPath, remotePath)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The session closed with ctx, so the partial file is removed over a new one
+			s.removePartial(partialPath)
+			return ctx.Err()
+		}
+		session.Remove(partialPath)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SFTPStorage) removePartial(partialPath string) {
+	s.withSession(context.Background(), func(session *sftpSession) error {
+		return session.Remove(partialPath)
+	})
+}
+
+// rename moves the uploaded file over the object, falling back to a plain rename on
+// servers without the posix-rename extension
+func (s *SFTPStorage) rename(session *sftpSession, from, to string) error {
+	if err := session.PosixRename(from, to); err == nil {
+		return nil
+	}
+
+	session.Remove(to)
+	if err := session.Rename(from, to); err != nil {
+		return fmt.Errorf("failed to rename uploaded file: %w", err)
+	}
+	return nil
+}
+
+// GetObject returns an io.ReadCloser for streaming download from the SFTP server.
+// Closing it closes the session.
+func (s *SFTPStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return s.GetObjectRange(ctx, objectKey, 0, -1)
+}
+
+// GetObjectRange returns an io.ReadCloser for streaming part of a file from the
+// SFTP server. Closing it closes the session.
+func (s *SFTPStorage) GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error) {
+	session, stop, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := session.Open(objectKey)
+	if err == nil && offset > 0 {
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		stop()
+		session.Close()
+		return nil, fmt.Errorf("failed to open file on SFTP server: %w", err)
+	}
+
+	return limitObject(&sftpObject{File: file, session: session, stop: stop}, length), nil
+}
+
+// sftpObject is a remote file being downloaded, with its session
+type sftpObject struct {
+	*sftp.File
+	session *sftpSession
+	stop    func() bool
+}
+
+func (o *sftpObject) Close() error {
+	o.stop()
+	err := o.File.Close()
+	o.session.Close()
+	return err
+}
+
+func (s *SFTPStorage) DeleteFile(ctx context.Context, objectKey string) error {
+	return s.withSession(ctx, func(session *sftpSession) error {
+		if err := session.Remove(objectKey); err != nil {
+			return fmt.Errorf("failed to delete file from SFTP server: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SFTPStorage) ListFiles(ctx context.Context) ([]string, error) {
+	var files []string
+	err := s.withSession(ctx, func(session *sftpSession) error {
+		var err error
+		files, err = s.listFiles(session)
+		return err
+	})
+	return files, err
+}
+
+// listFiles walks the base path over session
+func (s *SFTPStorage) listFiles(session *sftpSession) ([]string, error) {
+	root := s.basePath
+	if root == "" {
+		root = "."
+	}
+
+	var files []string
+	dirs := []string{root}
+	for len(dirs) > 0 {
+		dir := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+
+		entries, err := session.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		for _, entry := range entries {
+			entryPath := path.Join(dir, entry.Name())
+			switch {
+			case entry.IsDir():
+				dirs = append(dirs, entryPath)
+			case entry.Mode().IsRegular() && !strings.HasSuffix(entryPath, ".partial"):
+				files = append(files, entryPath)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func (s *SFTPStorage) StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error) {
+	var object *ObjectInfo
+	err := s.withSession(ctx, func(session *sftpSession) error {
+		info, err := session.Stat(objectKey)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%w: %s", ErrObjectNotFound, objectKey)
+			}
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		object = &ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()}
+		return nil
+	})
+	return object, err
+}
+
+// PresignGetObject is not supported, as files are only reachable over SSH
+func (s *SFTPStorage) PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+// TestConnection logs in and checks that the base path is a directory, creating it
+// if it doesn't exist yet
+func (s *SFTPStorage) TestConnection(ctx context.Context) error {
+	session, stop, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	defer stop()
+
+	if s.basePath == "" {
+		return nil
+	}
+
+	info, err := session.Stat(s.basePath)
+	if os.IsNotExist(err) {
+		if err := session.MkdirAll(s.basePath); err != nil {
+			return fmt.Errorf("failed to create base path %s: %w", s.basePath, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check base path: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("base path is not a directory: %s", s.basePath)
+	}
+	return nil
+}
+
+// getObjectKey builds the path base_path/connection_name/filename
+func (s *SFTPStorage) getObjectKey(fileName string, connectionName string) string {
+	connectionName = strings.Trim(connectionName, "/")
+	fileName = strings.TrimPrefix(fileName, "/")
+
+	if s.basePath == "" {
+		return path.Join(connectionName, fileName)
+	}
+	return path.Join(s.basePath, connectionName, fileName)
+}
@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestHostKey returns a new ed25519 SSH host key
+func newTestHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// startTestSFTPServer serves SFTP on a local port with hostKey, for user velld with
+// password secret, and returns the port and a count of the connections accepted
+func startTestSFTPServer(t *testing.T, hostKey ssh.Signer) (int, *atomic.Int32) {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "velld" || string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	connections := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go serveTestSFTP(conn, config)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, connections
+}
+
+func serveTestSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range channelRequests {
+				isSFTP := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(isSFTP, nil)
+				if !isSFTP {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func TestSFTPProviderTrustsFirstHostKey(t *testing.T) {
+	_, _, providerService := newTestBackupService(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	hostKey := newTestHostKey(t)
+	port, _ := startTestSFTPServer(t, hostKey)
+	root := t.TempDir()
+	created, err := providerService.CreateS3Provider(userID, &S3ProviderRequest{
+		Name: "SFTP", Type: ProviderTypeSFTP, Host: "127.0.0.1", Port: &port,
+		Username: "velld", Password: "secret", PathPrefix: &root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.HostKey != "" {
+		t.Fatalf("new provider has host key %q before connecting", created.HostKey)
+	}
+
+	provider, err := providerService.GetS3ProviderForUpload(created.ID.String(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := formatHostKey(hostKey.PublicKey()); provider.HostKey != want {
+		t.Fatalf("trusted host key %q, want %q", provider.HostKey, want)
+	}
+	stored, err := providerService.GetS3Provider(created.ID.String(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.HostKey != provider.HostKey {
+		t.Errorf("stored host key %q, want %q", stored.HostKey, provider.HostKey)
+	}
+
+	storage, err := newProviderStorage(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.UploadStream(ctx, strings.NewReader("dump"), "app_20250101_000000.sql", "app", "application/octet-stream", nil); err != nil {
+		t.Fatalf("upload with the trusted key failed: %v", err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(root, "app")); err != nil || len(entries) != 1 {
+		t.Errorf("upload left %d files in the connection folder (%v), want 1", len(entries), err)
+	}
+
+	// The same address serving another key is refused
+	otherPort, _ := startTestSFTPServer(t, newTestHostKey(t))
+	impostor, err := NewSFTPStorage(SFTPConfig{Host: "127.0.0.1", Port: otherPort, Username: "velld", Password: "secret", HostKey: provider.HostKey, BasePath: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := impostor.TestConnection(ctx); err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Errorf("connecting to a server with another host key returned %v", err)
+	}
+}
+
+func TestSFTPProviderHostKeyReset(t *testing.T) {
+	_, _, providerService := newTestBackupService(t)
+	userID := uuid.New()
+
+	hostKey := formatHostKey(newTestHostKey(t).PublicKey())
+	keyscanLine := "backups.example.com " + hostKey
+	port := 22
+	req := &S3ProviderRequest{Name: "SFTP", Type: ProviderTypeSFTP, Host: "backups.example.com", Port: &port, Username: "velld", Password: "secret", HostKey: &keyscanLine}
+	provider, err := providerService.CreateS3Provider(userID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.HostKey != hostKey {
+		t.Fatalf("pinned host key %q, want %q", provider.HostKey, hostKey)
+	}
+
+	req.HostKey = nil
+	if provider, err = providerService.UpdateS3Provider(provider.ID.String(), userID, req); err != nil {
+		t.Fatal(err)
+	}
+	if provider.HostKey != hostKey {
+		t.Errorf("update without a host key changed it to %q", provider.HostKey)
+	}
+
+	req.Host = "other.example.com"
+	if provider, err = providerService.UpdateS3Provider(provider.ID.String(), userID, req); err != nil {
+		t.Fatal(err)
+	}
+	if provider.HostKey != "" {
+		t.Errorf("moving to another server kept host key %q", provider.HostKey)
+	}
+
+	invalid := "not a key"
+	req.HostKey = &invalid
+	if _, err := providerService.UpdateS3Provider(provider.ID.String(), userID, req); err == nil {
+		t.Error("update with an invalid host key succeeded")
+	}
+}
+
+func TestSFTPStorageSharesSession(t *testing.T) {
+	ctx := context.Background()
+	hostKey := newTestHostKey(t)
+	port, connections := startTestSFTPServer(t, hostKey)
+	storage, err := NewSFTPStorage(SFTPConfig{Host: "127.0.0.1", Port: port, Username: "velld", Password: "secret", HostKey: formatHostKey(hostKey.PublicKey()), BasePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"app_1.sql", "app_2.sql", "app_3.sql"} {
+		if _, err := storage.UploadStream(ctx, strings.NewReader("dump"), name, "app", "application/octet-stream", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	connections.Store(0)
+
+	// Listing, stating every file and deleting one all run over a single connection
+	keys, err := storage.ListFiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("listed %d files, want 3", len(keys))
+	}
+	for _, key := range keys {
+		if info, err := storage.StatObject(ctx, key); err != nil || info.Size != 4 {
+			t.Errorf("stat of %s returned %+v, %v", key, info, err)
+		}
+	}
+	if err := storage.DeleteFile(ctx, keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.StatObject(ctx, keys[0]); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("stat of a deleted file returned %v", err)
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("opened %d connections, want 1", n)
+	}
+
+	// A dropped connection is reopened by the next call
+	storage.shared.conn.Close()
+	<-storage.shared.done
+	if _, err := storage.StatObject(ctx, keys[1]); err != nil {
+		t.Errorf("stat after the connection dropped failed: %v", err)
+	}
+	if n := connections.Load(); n != 2 {
+		t.Errorf("opened %d connections, want 2", n)
+	}
+
+	// A cancelled call closes the session rather than leaving it to the next one
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	storage.StatObject(cancelled, keys[1])
+	<-storage.shared.done
+	if _, err := storage.StatObject(ctx, keys[1]); err != nil {
+		t.Errorf("stat after a cancelled call failed: %v", err)
+	}
+}
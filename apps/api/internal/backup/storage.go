@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+)
+
+// Storage is a destination backups are uploaded to, such as an S3 bucket, an SFTP
+// server, an Azure container, a GCS bucket or a local directory. Objects are
+// addressed by the keys the uploads return.
+type Storage interface {
+	// UploadFileWithLogging uploads a local backup file into its connection's folder
+	UploadFileWithLogging(ctx context.Context, localPath string, logFunc func(string)) (string, error)
+	// UploadStream uploads reader as objectKey in the connection's folder
+	UploadStream(ctx context.Context, reader io.Reader, objectKey string, connectionName string, contentType string, logFunc func(string)) (string, error)
+	GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error)
+	// GetObjectRange returns length bytes of an object from offset on, or the rest of
+	// it when length is negative
+	GetObjectRange(ctx context.Context, objectKey string, offset, length int64) (io.ReadCloser, error)
+	DeleteFile(ctx context.Context, objectKey string) error
+	// ListFiles returns the keys of every object under the storage's path prefix
+	ListFiles(ctx context.Context) ([]string, error)
+	// StatObject describes an object; its error wraps ErrObjectNotFound when the
+	// object doesn't exist
+	StatObject(ctx context.Context, objectKey string) (*ObjectInfo, error)
+	// PresignGetObject returns a URL the object can be downloaded from without
+	// credentials until expiry, or ErrPresignNotSupported
+	PresignGetObject(ctx context.Context, objectKey string, expiry time.Duration) (string, error)
+	TestConnection(ctx context.Context) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ObjectLocker is implemented by storages that can lock objects against deletion,
+// even by velld itself, such as S3 buckets with Object Lock enabled
+type ObjectLocker interface {
+	// ObjectLockEnabled reports whether the storage is set up to lock objects
+	ObjectLockEnabled() bool
+	SetLegalHold(ctx context.Context, objectKey string, hold bool) error
+	RetainUntil(ctx context.Context, objectKey string, until time.Time) error
+	// RetainedUntil returns when an object's retention ends, or the zero time when it
+	// has none
+	RetainedUntil(ctx context.Context, objectKey string) (time.Time, error)
+}
+
+// ErrPresignNotSupported is returned by storages that can't hand out download URLs
+var ErrPresignNotSupported = errors.New("storage does not support presigned URLs")
+
+// ErrObjectNotFound is wrapped by the errors StatObject returns for objects that
+// don't exist, as opposed to objects it couldn't reach
+var ErrObjectNotFound = errors.New("object not found")
+
+// StorageFactory builds the storage of a stored provider record
+type StorageFactory func(provider *S3Provider) (Storage, error)
+
+// storageFactories builds the storages of each provider type
+var (
+	storageFactoriesMu sync.RWMutex
+	storageFactories   = map[string]StorageFactory{
+		ProviderTypeS3: func(provider *S3Provider) (Storage, error) {
+			return newProviderS3Storage(provider)
+		},
+		ProviderTypeSFTP: func(provider *S3Provider) (Storage, error) {
+			return newProviderSFTPStorage(provider)
+		},
+		ProviderTypeAzure: func(provider *S3Provider) (Storage, error) {
+			return newProviderAzureStorage(provider)
+		},
+		ProviderTypeGCS: func(provider *S3Provider) (Storage, error) {
+			return newProviderGCSStorage(provider)
+		},
+		ProviderTypeFilesystem: func(provider *S3Provider) (Storage, error) {
+			return newProviderFilesystemStorage(provider)
+		},
+	}
+)
+
+// RegisterStorage makes providers of providerType use the storages factory builds,
+// replacing any factory registered for it before
+func RegisterStorage(providerType string, factory StorageFactory) {
+	storageFactoriesMu.Lock()
+	defer storageFactoriesMu.Unlock()
+	storageFactories[providerType] = factory
+}
+
+// isStorageType reports whether a storage is registered for providerType
+func isStorageType(providerType string) bool {
+	storageFactoriesMu.RLock()
+	defer storageFactoriesMu.RUnlock()
+	_, ok := storageFactories[providerType]
+	return ok
+}
+
+// newProviderStorage creates the storage client for a provider
+func newProviderStorage(provider *S3Provider) (Storage, error) {
+	providerType := provider.Type
+	if providerType == "" {
+		providerType = ProviderTypeS3
+	}
+
+	storageFactoriesMu.RLock()
+	factory, ok := storageFactories[providerType]
+	storageFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider type: %s", providerType)
+	}
+
+	storage, err := factory(provider)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// limitObject limits a ranged read of object to length bytes, when length isn't
+// negative
+func limitObject(object io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return object
+	}
+	return &objectReader{Reader: io.LimitReader(object, length), closers: []io.Closer{object}}
+}
+
+// uploadEncodedFile uploads a local file through compression and encrypt, adding
+// their extensions to the object key
+func uploadEncodedFile(ctx context.Context, storage Storage, localPath string, compression common.Compression, encrypt StreamEncrypter, logFunc func(string)) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		if logFunc != nil {
+			logFunc(fmt.Sprintf("[ERROR] Failed to open file: %v", err))
+		}
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return uploadCompressedStream(ctx, storage, file, filepath.Base(localPath), connectionNameFromPath(localPath), compression, encrypt, logFunc)
+}
+
+// uploadCompressedStream uploads compressed data from an io.Reader directly to storage
+// The data is compressed on-the-fly during upload, then encrypted when encrypt is set
+// connectionName is used to organize backups in folders per connection
+func uploadCompressedStream(ctx context.Context, storage Storage, reader io.Reader, objectKey string, connectionName string, compression common.Compression, encrypt StreamEncrypter, logFunc func(string)) (string, error) {
+	if logFunc != nil {
+		if compression.Codec != common.CompressionNone {
+			logFunc(fmt.Sprintf("[INFO] Compressing data on-the-fly with %s during upload...", compression))
+		}
+		if encrypt != nil {
+			logFunc("[INFO] Encrypting data before upload...")
+		}
+	}
+
+	// Closing the encoded stream stops the compressor if the upload fails
+	encoded := encodeStream(reader, compression, encrypt)
+	defer encoded.Close()
+
+	return storage.UploadStream(ctx, encoded, encodedObjectKey(objectKey, compression, encrypt), connectionName, encodedContentType(compression, encrypt), logFunc)
+}
+
+// joinObjectKey builds the object key prefix/connection_name/filename, leaving out
+// the prefix and connection folder when they're empty
+func joinObjectKey(prefix, connectionName, fileName string) string {
+	// Sanitize connection name for use in the path
+	connectionName = strings.Trim(connectionName, "/")
+	fileName = strings.TrimPrefix(fileName, "/")
+
+	parts := []string{}
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		parts = append(parts, prefix)
+	}
+	if connectionName != "" {
+		parts = append(parts, connectionName)
+	}
+	parts = append(parts, fileName)
+
+	return strings.Join(parts, "/")
+}
@@ -70,6 +70,9 @@ func formatTelegramMessage(title string, databaseName string, databaseType strin
 	case "failed":
 		emoji = "❌"
 		statusText = "FAILED"
+	case "partial":
+		emoji = "⚠️"
+		statusText = "PARTIAL"
 	default:
 		emoji = "ℹ️"
 		statusText = status
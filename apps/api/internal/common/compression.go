@@ -0,0 +1,155 @@
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Codecs backups can be compressed with
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionLZ4  = "lz4"
+	CompressionNone = "none"
+)
+
+var compressionExtensions = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+	CompressionLZ4:  ".lz4",
+	CompressionNone: "",
+}
+
+// Compression is a codec and the level it compresses at. Level 0 is the codec's
+// default.
+type Compression struct {
+	Codec string
+	Level int
+}
+
+// ValidateCompression checks that codec is supported and level is valid for it.
+// An empty codec leaves the choice to the caller.
+func ValidateCompression(codec string, level int) error {
+	if codec == "" {
+		if level != 0 {
+			return fmt.Errorf("a compression level needs a compression codec")
+		}
+		return nil
+	}
+
+	switch codec {
+	case CompressionGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level must be between 1 and %d", gzip.BestCompression)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+	case CompressionLZ4, CompressionNone:
+		if level != 0 {
+			return fmt.Errorf("%s has no compression levels", codec)
+		}
+	default:
+		return fmt.Errorf("unsupported compression codec: %s", codec)
+	}
+	return nil
+}
+
+// Extension is appended to the names of files compressed with c
+func (c Compression) Extension() string {
+	return compressionExtensions[c.Codec]
+}
+
+// ContentType is the MIME type of data compressed with c
+func (c Compression) ContentType() string {
+	switch c.Codec {
+	case CompressionGzip:
+		return "application/gzip"
+	case CompressionZstd:
+		return "application/zstd"
+	}
+	return "application/octet-stream"
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return c.Codec
+	}
+	return fmt.Sprintf("%s (level %d)", c.Codec, c.Level)
+}
+
+// NewWriter returns a writer that compresses what is written to it into w.
+// Closing it flushes the compressed data but doesn't close w.
+func (c Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if err := ValidateCompression(c.Codec, c.Level); err != nil {
+		return nil, err
+	}
+
+	switch c.Codec {
+	case CompressionGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case CompressionLZ4:
+		// The lz4 command's frame format, with 4 MiB blocks and a content checksum
+		writer := lz4.NewWriter(w)
+		if err := writer.Apply(lz4.BlockSizeOption(lz4.Block4Mb), lz4.ChecksumOption(true)); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// NewDecompressReader returns a reader of the data compressed in r with codec.
+// Closing it releases the decompressor but doesn't close r.
+func NewDecompressReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressionLZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec: %s", codec)
+}
+
+// CompressionFromExtension returns the codec of a file from its name, or
+// CompressionNone when it isn't compressed
+func CompressionFromExtension(name string) string {
+	for codec, extension := range compressionExtensions {
+		if extension != "" && strings.HasSuffix(name, extension) {
+			return codec
+		}
+	}
+	return CompressionNone
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
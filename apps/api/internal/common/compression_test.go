@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// compressTest compresses data with c
+func compressTest(t *testing.T, c Compression, data []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer, err := c.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+// decompressTest decompresses data compressed with codec
+func decompressTest(codec string, compressed []byte) ([]byte, error) {
+	reader, err := NewDecompressReader(codec, bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// testPayloads are empty, small, compressible, incompressible and multi-block data
+func testPayloads(t *testing.T) map[string][]byte {
+	t.Helper()
+	random := make([]byte, 1<<20)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		"empty":          {},
+		"small":          []byte("INSERT INTO items VALUES ('velld');\n"),
+		"compressible":   []byte(strings.Repeat("INSERT INTO items VALUES ('velld');\n", 300000)),
+		"incompressible": random,
+	}
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	payloads := testPayloads(t)
+	for _, codec := range []string{CompressionGzip, CompressionZstd, CompressionLZ4, CompressionNone} {
+		for name, data := range payloads {
+			compressed := compressTest(t, Compression{Codec: codec}, data)
+			decompressed, err := decompressTest(codec, compressed)
+			if err != nil {
+				t.Errorf("%s %s: %v", codec, name, err)
+				continue
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("%s %s: decompressed %d bytes that differ from the %d compressed", codec, name, len(decompressed), len(data))
+			}
+		}
+	}
+}
+
+func TestDecompressDamagedLZ4(t *testing.T) {
+	data := testPayloads(t)["compressible"]
+	compressed := compressTest(t, Compression{Codec: CompressionLZ4}, data)
+
+	corrupt := bytes.Clone(compressed)
+	corrupt[len(corrupt)/2] ^= 0xff
+	damaged := map[string][]byte{
+		"corrupt":   corrupt,
+		"truncated": compressed[:len(compressed)/2],
+		"not lz4":   []byte("INSERT INTO items VALUES ('velld');\n"),
+	}
+	for name, input := range damaged {
+		if _, err := decompressTest(CompressionLZ4, input); err == nil {
+			t.Errorf("%s input decompressed without an error", name)
+		}
+	}
+
+	// Cut anywhere, a frame either fails or, when only its end mark and checksum are
+	// missing, still holds all of the data
+	for cut := 1; cut <= 16; cut++ {
+		decompressed, err := decompressTest(CompressionLZ4, compressed[:len(compressed)-cut])
+		if err == nil && !bytes.Equal(decompressed, data) {
+			t.Errorf("frame missing its last %d bytes decompressed to %d bytes without an error", cut, len(decompressed))
+		}
+	}
+}
+
+func TestLZ4MatchesCLI(t *testing.T) {
+	cli, err := exec.LookPath("lz4")
+	if err != nil {
+		t.Skip("lz4 command not installed")
+	}
+	data := testPayloads(t)["compressible"]
+
+	// velld's files decompress with the lz4 command
+	cmd := exec.Command(cli, "-d", "-c")
+	cmd.Stdin = bytes.NewReader(compressTest(t, Compression{Codec: CompressionLZ4}, data))
+	decompressed, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("lz4 -d failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("lz4 -d output differs from what was compressed")
+	}
+
+	// and velld decompresses the lz4 command's files
+	cmd = exec.Command(cli, "-c", "--content-size")
+	cmd.Stdin = bytes.NewReader(data)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("lz4 failed: %v", err)
+	}
+	if decompressed, err = decompressTest(CompressionLZ4, compressed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("decompressed lz4 command output differs from what was compressed")
+	}
+}
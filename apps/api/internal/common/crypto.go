@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -27,6 +28,13 @@ func NewEncryptionService(hexKey string) (*EncryptionService, error) {
 	return &EncryptionService{key: key}, nil
 }
 
+// KeyID identifies the key without revealing it, so data encrypted with it can
+// later be matched to the key it needs
+func (s *EncryptionService) KeyID() string {
+	sum := sha256.Sum256(s.key)
+	return hex.EncodeToString(sum[:8])
+}
+
 func (s *EncryptionService) Encrypt(plaintext string) (string, error) {
 	block, err := aes.NewCipher(s.key)
 	if err != nil {
@@ -0,0 +1,136 @@
+package common
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Kinds of public keys data can be encrypted to
+const (
+	RecipientAge     = "age"
+	RecipientOpenPGP = "openpgp"
+)
+
+const pgpPublicKeyHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+// Recipients are the public keys data is encrypted to. Only the holders of the
+// matching private keys can decrypt it; the keys are all age X25519 keys or all
+// OpenPGP keys.
+type Recipients struct {
+	Kind string
+	IDs  []string // age recipients or OpenPGP key fingerprints, for display
+	age  []age.Recipient
+	pgp  openpgp.EntityList
+}
+
+// ParseRecipients parses age recipients ("age1...", one per line) or ASCII-armored
+// OpenPGP public keys. Each entry may hold several keys.
+func ParseRecipients(keys []string) (*Recipients, error) {
+	recipients := &Recipients{}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		if strings.Contains(key, "PRIVATE KEY") || strings.Contains(strings.ToUpper(key), "AGE-SECRET-KEY-") {
+			return nil, errors.New("recipients must be public keys; keep private keys off the server")
+		}
+
+		kind := RecipientAge
+		if strings.HasPrefix(key, pgpPublicKeyHeader) {
+			kind = RecipientOpenPGP
+		}
+		if recipients.Kind != "" && recipients.Kind != kind {
+			return nil, errors.New("recipients must all be age keys or all be OpenPGP keys")
+		}
+		recipients.Kind = kind
+
+		if kind == RecipientOpenPGP {
+			entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+			if err != nil {
+				return nil, fmt.Errorf("invalid OpenPGP public key: %w", err)
+			}
+			for _, entity := range entities {
+				if _, ok := entity.EncryptionKey(time.Now()); !ok {
+					return nil, fmt.Errorf("OpenPGP key %X has no valid encryption key", entity.PrimaryKey.Fingerprint)
+				}
+				recipients.pgp = append(recipients.pgp, entity)
+				recipients.IDs = append(recipients.IDs, strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint)))
+			}
+			continue
+		}
+
+		for _, line := range strings.Split(key, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			recipient, err := age.ParseX25519Recipient(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient %q: %w", line, err)
+			}
+			recipients.age = append(recipients.age, recipient)
+			recipients.IDs = append(recipients.IDs, line)
+		}
+	}
+
+	if len(recipients.IDs) == 0 {
+		return nil, errors.New("no recipients given")
+	}
+	return recipients, nil
+}
+
+// Encrypt returns a writer that encrypts what is written to it to the recipients
+// and writes it to w. Closing it finishes the message but doesn't close w.
+func (r *Recipients) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if r.Kind == RecipientOpenPGP {
+		return openpgp.Encrypt(w, r.pgp, nil, nil, nil)
+	}
+	return age.Encrypt(w, r.age...)
+}
+
+// DecryptWithPrivateKey decrypts data encrypted to recipients of the given kind.
+// privateKey holds age identities ("AGE-SECRET-KEY-1...") or an ASCII-armored
+// OpenPGP private key, which is unlocked with passphrase when it is protected.
+// For OpenPGP, a modified message is only detected once the reader reaches the end.
+func DecryptWithPrivateKey(kind string, r io.Reader, privateKey, passphrase string) (io.Reader, error) {
+	switch kind {
+	case RecipientAge:
+		identities, err := age.ParseIdentities(strings.NewReader(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age private key: %w", err)
+		}
+		reader, err := age.Decrypt(r, identities...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt with the age private key: %w", err)
+		}
+		return reader, nil
+
+	case RecipientOpenPGP:
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewBufferString(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenPGP private key: %w", err)
+		}
+		for _, entity := range keyring {
+			if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to unlock OpenPGP private key: %w", err)
+			}
+		}
+		message, err := openpgp.ReadMessage(r, keyring, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt with the OpenPGP private key: %w", err)
+		}
+		return message.UnverifiedBody, nil
+	}
+
+	return nil, fmt.Errorf("unsupported recipient kind: %s", kind)
+}
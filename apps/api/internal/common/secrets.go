@@ -57,6 +57,13 @@ func loadOrGenerateSecrets() *Secrets {
 	}
 }
 
+// GetEncryptionKey returns ENCRYPTION_KEY from the environment or the .env file,
+// without generating one when it's missing
+func GetEncryptionKey() (string, error) {
+	godotenv.Load(secretsFilePath)
+	return getWithoutGenerateSecret("ENCRYPTION_KEY")
+}
+
 // getWithoutGenerateSecret retrieves a secret from the environment variables without generating a new one
 func getWithoutGenerateSecret(envVar string) (string, error) {
 	if secret := os.Getenv(envVar); secret != "" {
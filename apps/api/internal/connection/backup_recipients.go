@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dendianugerah/velld/internal/common"
+)
+
+// ValidateBackupRecipients checks that the public keys backups are encrypted to
+// can be parsed, and that they aren't combined with master key encryption
+func ValidateBackupRecipients(config *ConnectionConfig) error {
+	if len(config.BackupRecipients) == 0 {
+		return nil
+	}
+	if config.EncryptBackups {
+		return errors.New("backups are encrypted either with the server's key or to recipients, not both")
+	}
+	_, err := common.ParseRecipients(config.BackupRecipients)
+	return err
+}
+
+// normalizeBackupRecipients trims the keys and drops empty ones
+func normalizeBackupRecipients(recipients []string) []string {
+	var normalized []string
+	for _, recipient := range recipients {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			normalized = append(normalized, recipient)
+		}
+	}
+	return normalized
+}
+
+// encodeBackupRecipients stores the keys as JSON, as OpenPGP keys span several
+// lines, or NULL when there are none
+func encodeBackupRecipients(recipients []string) (interface{}, error) {
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(recipients)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decodeBackupRecipients(data string) ([]string, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var recipients []string
+	if err := json.Unmarshal([]byte(data), &recipients); err != nil {
+		return nil, fmt.Errorf("invalid backup recipients: %w", err)
+	}
+	return recipients, nil
+}
@@ -1,50 +1,50 @@
 package connection
 
 import (
-	"context"
-	"crypto/tls"
 	"database/sql"
 	"fmt"
-
-	"github.com/go-sql-driver/mysql"
-	"github.com/lib/pq"
-	"github.com/mattn/go-sqlite3"
-	"github.com/redis/go-redis/v9"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"path"
+	"strings"
+	"sync"
 )
 
 type ConnectionManager struct {
-	connections map[string]interface{}
+	connections map[string]Session
+	mu          sync.Mutex // Backups list databases while requests test connections
 }
 
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
-		connections: make(map[string]interface{}),
+		connections: make(map[string]Session),
 	}
 }
 
 func (cm *ConnectionManager) Connect(config ConnectionConfig) error {
-	if config.SSHEnabled {
+	// File-based drivers handle SSH themselves
+	if config.SSHEnabled && NeedsSSHTunnel(config.Type) {
 		return cm.connectWithSSH(config)
 	}
 
-	switch config.Type {
-	case "mysql":
-		return cm.connectMySQL(config)
-	case "postgresql":
-		return cm.connectPostgres(config)
-	case "mongodb":
-		return cm.connectMongoDB(config)
-	case "redis":
-		return cm.connectRedis(config)
-	default:
-		return fmt.Errorf("unsupported database type: %s", config.Type)
+	driver, err := GetDriver(config.Type)
+	if err != nil {
+		return err
+	}
+
+	session, err := driver.Connect(config)
+	if err != nil {
+		return err
 	}
+
+	cm.setSession(config.ID, session)
+	return nil
 }
 
 func (cm *ConnectionManager) connectWithSSH(config ConnectionConfig) error {
+	driver, err := GetDriver(config.Type)
+	if err != nil {
+		return err
+	}
+
 	tunnel, err := NewSSHTunnel(
 		config.SSHHost,
 		config.SSHPort,
@@ -66,218 +66,177 @@ func (cm *ConnectionManager) connectWithSSH(config ConnectionConfig) error {
 	tunnelConfig.Host = "127.0.0.1"
 	tunnelConfig.Port = tunnel.GetLocalPort()
 
-	var connErr error
-	switch config.Type {
-	case "mysql":
-		connErr = cm.connectMySQL(tunnelConfig)
-	case "postgresql":
-		connErr = cm.connectPostgres(tunnelConfig)
-	case "mongodb":
-		connErr = cm.connectMongoDB(tunnelConfig)
-	case "redis":
-		connErr = cm.connectRedis(tunnelConfig)
-	default:
-		tunnel.Stop()
-		return fmt.Errorf("unsupported database type: %s", config.Type)
-	}
-
-	if connErr != nil {
+	session, err := driver.Connect(tunnelConfig)
+	if err != nil {
 		tunnel.Stop()
-		return connErr
+		return err
 	}
 
-	// Store tunnel reference (we'll need to close it later)
-	// For now, we'll let it clean up when the connection is closed
+	// Keep the tunnel open until the session is closed
+	cm.setSession(config.ID, &tunneledSession{Session: session, tunnel: tunnel})
 	return nil
 }
 
-func (cm *ConnectionManager) connectMySQL(config ConnectionConfig) error {
-	sslMode := "false"
-	if config.SSL {
-		sslMode = "true"
-	}
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%s",
-		config.Username, config.Password, config.Host, config.Port, config.Database, sslMode)
+func (cm *ConnectionManager) setSession(id string, session Session) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.connections[id] = session
+}
 
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return err
-	}
+func (cm *ConnectionManager) getSession(id string) (Session, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 
-	if err = db.Ping(); err != nil {
-		return err
+	session, exists := cm.connections[id]
+	if !exists {
+		return nil, fmt.Errorf("connection not found: %s", id)
 	}
-
-	cm.connections[config.ID] = db
-	return nil
+	return session, nil
 }
 
-func (cm *ConnectionManager) connectPostgres(config ConnectionConfig) error {
-	sslMode := "disable"
-	if config.SSL {
-		sslMode = "require"
-	}
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.Username, config.Password, config.Database, sslMode)
-
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return err
-	}
+func (cm *ConnectionManager) Disconnect(id string) error {
+	cm.mu.Lock()
+	session, exists := cm.connections[id]
+	delete(cm.connections, id)
+	cm.mu.Unlock()
 
-	if err = db.Ping(); err != nil {
-		return err
+	if !exists {
+		return fmt.Errorf("connection not found: %s", id)
 	}
-
-	cm.connections[config.ID] = db
-	return nil
+	return session.Close()
 }
 
-func (cm *ConnectionManager) connectMongoDB(config ConnectionConfig) error {
-	ctx := context.Background()
-	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/%s",
-		config.Username, config.Password, config.Host, config.Port, config.Database)
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+func (cm *ConnectionManager) GetDatabaseSize(id string) (int64, error) {
+	session, err := cm.getSession(id)
 	if err != nil {
-		return err
-	}
-
-	if err = client.Ping(ctx, nil); err != nil {
-		return err
+		return 0, err
 	}
 
-	cm.connections[config.ID] = client
-	return nil
+	return session.Size()
 }
 
-func (cm *ConnectionManager) connectRedis(config ConnectionConfig) error {
-	ctx := context.Background()
-
-	opts := &redis.Options{
-		Addr: fmt.Sprintf("%s:%d", config.Host, config.Port),
-	}
-
-	if config.SSL {
-		opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+// ListDatabases returns the user databases on the server of a connected session
+func (cm *ConnectionManager) ListDatabases(id string) ([]string, error) {
+	session, err := cm.getSession(id)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.Password != "" {
-		opts.Password = config.Password
+	if tunneled, ok := session.(*tunneledSession); ok {
+		session = tunneled.Session
 	}
 
-	if config.Database != "" {
-		var db int
-		_, err := fmt.Sscanf(config.Database, "%d", &db)
-		if err == nil && db >= 0 && db <= 15 {
-			opts.DB = db
-		}
-	}
-
-	client := redis.NewClient(opts)
-
-	if err := client.Ping(ctx).Err(); err != nil {
-		return fmt.Errorf("failed to connect to Redis: %w", err)
+	lister, ok := session.(DatabaseLister)
+	if !ok {
+		return nil, fmt.Errorf("listing databases is not supported for this connection type")
 	}
-
-	cm.connections[config.ID] = client
-	return nil
+	return lister.ListDatabases()
 }
 
-func (cm *ConnectionManager) Disconnect(id string) error {
-	conn, exists := cm.connections[id]
-	if !exists {
-		return fmt.Errorf("connection not found: %s", id)
-	}
-
-	switch c := conn.(type) {
-	case *sql.DB:
-		return c.Close()
-	case *mongo.Client:
-		return c.Disconnect(context.Background())
-	case *redis.Client:
-		return c.Close()
-	default:
-		return fmt.Errorf("unknown connection type for id: %s", id)
+// Config returns the settings needed to open a session for a stored connection
+func (c *StoredConnection) Config() ConnectionConfig {
+	return ConnectionConfig{
+		ID:               c.ID,
+		Name:             c.Name,
+		Type:             c.Type,
+		Host:             c.Host,
+		Port:             c.Port,
+		Username:         c.Username,
+		Password:         c.Password,
+		Database:         c.DatabaseName,
+		ConnectionURI:    c.ConnectionURI,
+		SSL:              c.SSL,
+		SSHEnabled:       c.SSHEnabled,
+		SSHHost:          c.SSHHost,
+		SSHPort:          c.SSHPort,
+		SSHUsername:      c.SSHUsername,
+		SSHPassword:      c.SSHPassword,
+		SSHPrivateKey:    c.SSHPrivateKey,
+		RedisMode:        c.RedisMode,
+		RedisMasterName:  c.RedisMasterName,
+		RedisNodes:       c.RedisNodes,
+		AllDatabases:     c.AllDatabases,
+		IncludeDatabases: c.IncludeDatabases,
+		ExcludeDatabases: c.ExcludeDatabases,
+		DumpFilters:      c.DumpFilters,
+		EncryptBackups:   c.EncryptBackups,
+		BackupRecipients: c.BackupRecipients,
+		CompressionCodec: c.CompressionCodec,
+		CompressionLevel: c.CompressionLevel,
 	}
 }
 
-func (cm *ConnectionManager) GetDatabaseSize(id string) (int64, error) {
-	conn, exists := cm.connections[id]
-	if !exists {
-		return 0, fmt.Errorf("connection not found: %s", id)
-	}
-
-	switch c := conn.(type) {
-	case *sql.DB:
-		return cm.getSQLDatabaseSize(c)
-	case *mongo.Client:
-		return cm.getMongoDBSize(c)
-	case *redis.Client:
-		return cm.getRedisSize(c)
-	default:
-		return 0, fmt.Errorf("unknown connection type for id: %s", id)
+// SelectDatabases filters names down to the databases an all-databases connection
+// backs up: those matching an include pattern (every name when there are none) and
+// no exclude pattern. Patterns use path.Match syntax, e.g. "app_*".
+func (c *StoredConnection) SelectDatabases(names []string) []string {
+	selected := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(c.IncludeDatabases) > 0 && !matchesAny(c.IncludeDatabases, name) {
+			continue
+		}
+		if matchesAny(c.ExcludeDatabases, name) {
+			continue
+		}
+		selected = append(selected, name)
 	}
+	return selected
 }
 
-func (cm *ConnectionManager) getSQLDatabaseSize(db *sql.DB) (int64, error) {
-	var query string
-
-	switch db.Driver().(type) {
-	case *pq.Driver:
-		query = "SELECT pg_database_size(current_database())"
-	case *mysql.MySQLDriver:
-		query = `SELECT SUM(data_length + index_length) 
-				 FROM information_schema.tables 
-				 WHERE table_schema = DATABASE()`
-	case *sqlite3.SQLiteDriver:
-		query = "SELECT page_count * page_size as size FROM pragma_page_count, pragma_page_size"
-	default:
-		return 0, fmt.Errorf("unsupported database type for size calculation")
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), name); matched {
+			return true
+		}
 	}
-
-	var size int64
-	err := db.QueryRow(query).Scan(&size)
-	return size, err
+	return false
 }
 
-func (cm *ConnectionManager) getMongoDBSize(client *mongo.Client) (int64, error) {
-	ctx := context.Background()
-	result := client.Database("admin").RunCommand(ctx, bson.D{
-		{Key: "dbStats", Value: 1},
-		{Key: "scale", Value: 1},
-	})
+// sqlSession is a Session backed by database/sql, sized and listed with driver-specific queries
+type sqlSession struct {
+	db        *sql.DB
+	sizeQuery string
+	listQuery string // Returns one database name per row
+}
 
-	var stats bson.M
-	if err := result.Decode(&stats); err != nil {
+func (s *sqlSession) Size() (int64, error) {
+	var size sql.NullInt64
+	if err := s.db.QueryRow(s.sizeQuery).Scan(&size); err != nil {
 		return 0, err
 	}
-
-	return int64(stats["dataSize"].(float64)), nil
+	return size.Int64, nil
 }
 
-func (cm *ConnectionManager) getRedisSize(client *redis.Client) (int64, error) {
-	ctx := context.Background()
-
-	info, err := client.Info(ctx, "memory").Result()
+func (s *sqlSession) ListDatabases() ([]string, error) {
+	rows, err := s.db.Query(s.listQuery)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get Redis memory info: %w", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	var usedMemory int64
-	lines := []byte(info)
-	start := 0
-	for i := 0; i < len(lines); i++ {
-		if lines[i] == '\n' {
-			line := string(lines[start:i])
-			start = i + 1
-
-			if len(line) > 12 && line[:12] == "used_memory:" {
-				fmt.Sscanf(line[12:], "%d", &usedMemory)
-				return usedMemory, nil
-			}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
 		}
+		names = append(names, name)
 	}
+	return names, rows.Err()
+}
+
+func (s *sqlSession) Close() error {
+	return s.db.Close()
+}
+
+// tunneledSession closes its SSH tunnel together with the wrapped session
+type tunneledSession struct {
+	Session
+	tunnel *SSHTunnel
+}
 
-	return 0, nil
+func (s *tunneledSession) Close() error {
+	err := s.Session.Close()
+	s.tunnel.Stop()
+	return err
 }
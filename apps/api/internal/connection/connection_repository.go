@@ -2,6 +2,7 @@ package connection
 
 import (
 	"database/sql"
+	"strings"
 
 	"github.com/dendianugerah/velld/internal/common"
 	"github.com/google/uuid"
@@ -46,6 +47,14 @@ func (r *ConnectionRepository) Save(conn StoredConnection) error {
 		}
 	}
 
+	connectionURI := ""
+	if conn.ConnectionURI != "" {
+		connectionURI, err = r.crypto.Encrypt(conn.ConnectionURI)
+		if err != nil {
+			return err
+		}
+	}
+
 	sslInt := 0
 	if conn.SSL {
 		sslInt = 1
@@ -56,14 +65,38 @@ func (r *ConnectionRepository) Save(conn StoredConnection) error {
 		sshEnabledInt = 1
 	}
 
+	allDatabasesInt := 0
+	if conn.AllDatabases {
+		allDatabasesInt = 1
+	}
+
+	encryptBackupsInt := 0
+	if conn.EncryptBackups {
+		encryptBackupsInt = 1
+	}
+
+	dumpFilters, err := encodeDumpFilters(conn.DumpFilters)
+	if err != nil {
+		return err
+	}
+
+	backupRecipients, err := encodeBackupRecipients(conn.BackupRecipients)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO connections (
 			id, name, type, host, port, username, password, 
 			database_name, ssl, database_size, created_at, updated_at, 
 			last_connected_at, user_id, status, ssh_enabled, ssh_host, 
-			ssh_port, ssh_username, ssh_password, ssh_private_key
+			ssh_port, ssh_username, ssh_password, ssh_private_key,
+			redis_mode, redis_master_name, redis_nodes, connection_uri,
+			all_databases, include_databases, exclude_databases, dump_filters, encrypt_backups,
+			backup_recipients, compression_codec, compression_level
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25,
+			$26, $27, $28, $29, $30, $31, $32, $33
 		)`
 
 	_, err = r.db.Exec(
@@ -89,6 +122,18 @@ func (r *ConnectionRepository) Save(conn StoredConnection) error {
 		conn.SSHUsername,
 		sshPassword,
 		sshPrivateKey,
+		conn.RedisMode,
+		conn.RedisMasterName,
+		strings.Join(conn.RedisNodes, ","),
+		connectionURI,
+		allDatabasesInt,
+		strings.Join(conn.IncludeDatabases, ","),
+		strings.Join(conn.ExcludeDatabases, ","),
+		dumpFilters,
+		encryptBackupsInt,
+		backupRecipients,
+		conn.CompressionCodec,
+		conn.CompressionLevel,
 	)
 
 	return err
@@ -98,12 +143,24 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 	var conn StoredConnection
 	var encryptedUsername, encryptedPassword string
 	var encryptedSSHPassword, encryptedSSHPrivateKey sql.NullString
+	var redisMode, redisMasterName, redisNodes sql.NullString
+	var encryptedConnectionURI sql.NullString
+	var allDatabasesInt sql.NullInt64
+	var includeDatabases, excludeDatabases sql.NullString
+	var dumpFilters sql.NullString
+	var encryptBackupsInt sql.NullInt64
+	var backupRecipients sql.NullString
+	var compressionCodec sql.NullString
+	var compressionLevel sql.NullInt64
 	var sslInt, sshEnabledInt int
 
 	query := `SELECT 
 		id, name, type, host, port, username, password, database_name, ssl, 
 		database_size, created_at, updated_at, last_connected_at, user_id, status,
-		ssh_enabled, ssh_host, ssh_port, ssh_username, ssh_password, ssh_private_key
+		ssh_enabled, ssh_host, ssh_port, ssh_username, ssh_password, ssh_private_key,
+		redis_mode, redis_master_name, redis_nodes, connection_uri,
+		all_databases, include_databases, exclude_databases, dump_filters, encrypt_backups,
+		backup_recipients, compression_codec, compression_level
 	FROM connections WHERE id = $1`
 
 	err := r.db.QueryRow(query, id).Scan(
@@ -128,6 +185,18 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 		&conn.SSHUsername,
 		&encryptedSSHPassword,
 		&encryptedSSHPrivateKey,
+		&redisMode,
+		&redisMasterName,
+		&redisNodes,
+		&encryptedConnectionURI,
+		&allDatabasesInt,
+		&includeDatabases,
+		&excludeDatabases,
+		&dumpFilters,
+		&encryptBackupsInt,
+		&backupRecipients,
+		&compressionCodec,
+		&compressionLevel,
 	)
 	if err != nil {
 		return nil, err
@@ -135,6 +204,27 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 
 	conn.SSL = sslInt != 0
 	conn.SSHEnabled = sshEnabledInt != 0
+	conn.RedisMode = redisMode.String
+	conn.RedisMasterName = redisMasterName.String
+	if redisNodes.String != "" {
+		conn.RedisNodes = strings.Split(redisNodes.String, ",")
+	}
+	conn.AllDatabases = allDatabasesInt.Int64 != 0
+	if includeDatabases.String != "" {
+		conn.IncludeDatabases = strings.Split(includeDatabases.String, ",")
+	}
+	if excludeDatabases.String != "" {
+		conn.ExcludeDatabases = strings.Split(excludeDatabases.String, ",")
+	}
+	if conn.DumpFilters, err = decodeDumpFilters(dumpFilters.String); err != nil {
+		return nil, err
+	}
+	conn.EncryptBackups = encryptBackupsInt.Int64 != 0
+	if conn.BackupRecipients, err = decodeBackupRecipients(backupRecipients.String); err != nil {
+		return nil, err
+	}
+	conn.CompressionCodec = compressionCodec.String
+	conn.CompressionLevel = int(compressionLevel.Int64)
 
 	conn.Username, err = r.crypto.Decrypt(encryptedUsername)
 	if err != nil {
@@ -160,6 +250,13 @@ func (r *ConnectionRepository) GetConnection(id string) (*StoredConnection, erro
 		}
 	}
 
+	if encryptedConnectionURI.Valid && encryptedConnectionURI.String != "" {
+		conn.ConnectionURI, err = r.crypto.Decrypt(encryptedConnectionURI.String)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &conn, nil
 }
 
@@ -190,6 +287,14 @@ func (r *ConnectionRepository) Update(conn StoredConnection) error {
 		}
 	}
 
+	connectionURI := ""
+	if conn.ConnectionURI != "" {
+		connectionURI, err = r.crypto.Encrypt(conn.ConnectionURI)
+		if err != nil {
+			return err
+		}
+	}
+
 	sslInt := 0
 	if conn.SSL {
 		sslInt = 1
@@ -200,14 +305,38 @@ func (r *ConnectionRepository) Update(conn StoredConnection) error {
 		sshEnabledInt = 1
 	}
 
+	allDatabasesInt := 0
+	if conn.AllDatabases {
+		allDatabasesInt = 1
+	}
+
+	encryptBackupsInt := 0
+	if conn.EncryptBackups {
+		encryptBackupsInt = 1
+	}
+
+	dumpFilters, err := encodeDumpFilters(conn.DumpFilters)
+	if err != nil {
+		return err
+	}
+
+	backupRecipients, err := encodeBackupRecipients(conn.BackupRecipients)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE connections SET 
 			name = $1, type = $2, host = $3, port = $4, 
 			username = $5, password = $6, database_name = $7, 
 			ssl = $8, ssh_enabled = $9, ssh_host = $10, ssh_port = $11,
 			ssh_username = $12, ssh_password = $13, ssh_private_key = $14,
-			database_size = $15, updated_at = CURRENT_TIMESTAMP
-		WHERE id = $16`
+			database_size = $15, redis_mode = $16, redis_master_name = $17,
+			redis_nodes = $18, connection_uri = $19, all_databases = $20,
+			include_databases = $21, exclude_databases = $22, dump_filters = $23,
+			encrypt_backups = $24, backup_recipients = $25, compression_codec = $26,
+			compression_level = $27, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $28`
 
 	_, err = r.db.Exec(
 		query,
@@ -226,6 +355,18 @@ func (r *ConnectionRepository) Update(conn StoredConnection) error {
 		sshPassword,
 		sshPrivateKey,
 		conn.DatabaseSize,
+		conn.RedisMode,
+		conn.RedisMasterName,
+		strings.Join(conn.RedisNodes, ","),
+		connectionURI,
+		allDatabasesInt,
+		strings.Join(conn.IncludeDatabases, ","),
+		strings.Join(conn.ExcludeDatabases, ","),
+		dumpFilters,
+		encryptBackupsInt,
+		backupRecipients,
+		conn.CompressionCodec,
+		conn.CompressionLevel,
 		conn.ID,
 	)
 
@@ -244,7 +385,8 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 			b.completed_time as last_backup_time,
 			COALESCE(bs.enabled, false) as backup_enabled,
 			bs.cron_schedule,
-			bs.retention_days
+			bs.retention_days,
+			bs.retention_enforced
 		FROM connections c
 		LEFT JOIN backup_schedules bs ON c.id = bs.connection_id AND bs.enabled = true
 		LEFT JOIN backups b ON c.id = b.connection_id
@@ -254,7 +396,7 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 				WHERE connection_id = c.id
 			)
 		WHERE c.user_id = $1
-		GROUP BY c.id, c.name, c.type, c.host, c.status, c.database_size, b.completed_time, bs.enabled, bs.cron_schedule, bs.retention_days
+		GROUP BY c.id, c.name, c.type, c.host, c.status, c.database_size, b.completed_time, bs.enabled, bs.cron_schedule, bs.retention_days, bs.retention_enforced
 	`
 
 	rows, err := r.db.Query(query, userID)
@@ -269,6 +411,7 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 		var lastBackupTime sql.NullString
 		var cronSchedule sql.NullString
 		var retentionDays sql.NullInt64
+		var retentionEnforced sql.NullBool
 
 		err := rows.Scan(
 			&conn.ID,
@@ -281,6 +424,7 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 			&conn.BackupEnabled,
 			&cronSchedule,
 			&retentionDays,
+			&retentionEnforced,
 		)
 		if err != nil {
 			return nil, err
@@ -296,6 +440,9 @@ func (r *ConnectionRepository) ListByUserID(userID uuid.UUID) ([]ConnectionListI
 			days := int(retentionDays.Int64)
 			conn.RetentionDays = &days
 		}
+		if retentionEnforced.Valid {
+			conn.RetentionEnforced = &retentionEnforced.Bool
+		}
 
 		connections = append(connections, conn)
 	}
@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"github.com/dendianugerah/velld/internal/common"
 	"github.com/google/uuid"
 )
 
@@ -30,6 +31,20 @@ func (s *ConnectionService) SaveConnection(config ConnectionConfig, userID uuid.
 		config.ID = uuid.New().String()
 	}
 
+	config.DumpFilters = normalizeDumpFilters(config.DumpFilters)
+	if err := ValidateDumpFilters(config.Type, config.DumpFilters); err != nil {
+		return nil, err
+	}
+
+	config.BackupRecipients = normalizeBackupRecipients(config.BackupRecipients)
+	if err := ValidateBackupRecipients(&config); err != nil {
+		return nil, err
+	}
+
+	if err := common.ValidateCompression(config.CompressionCodec, config.CompressionLevel); err != nil {
+		return nil, err
+	}
+
 	if err := s.manager.Connect(config); err != nil {
 		return nil, err
 	}
@@ -41,24 +56,36 @@ func (s *ConnectionService) SaveConnection(config ConnectionConfig, userID uuid.
 	}
 
 	storedConn := StoredConnection{
-		ID:            config.ID,
-		Name:          config.Name,
-		Type:          config.Type,
-		Host:          config.Host,
-		Port:          config.Port,
-		Username:      config.Username,
-		Password:      config.Password,
-		DatabaseName:  config.Database,
-		SSL:           config.SSL,
-		SSHEnabled:    config.SSHEnabled,
-		SSHHost:       config.SSHHost,
-		SSHPort:       config.SSHPort,
-		SSHUsername:   config.SSHUsername,
-		SSHPassword:   config.SSHPassword,
-		SSHPrivateKey: config.SSHPrivateKey,
-		UserID:        userID,
-		Status:        "connected",
-		DatabaseSize:  dbSize,
+		ID:               config.ID,
+		Name:             config.Name,
+		Type:             config.Type,
+		Host:             config.Host,
+		Port:             config.Port,
+		Username:         config.Username,
+		Password:         config.Password,
+		DatabaseName:     config.Database,
+		ConnectionURI:    config.ConnectionURI,
+		SSL:              config.SSL,
+		SSHEnabled:       config.SSHEnabled,
+		SSHHost:          config.SSHHost,
+		SSHPort:          config.SSHPort,
+		SSHUsername:      config.SSHUsername,
+		SSHPassword:      config.SSHPassword,
+		SSHPrivateKey:    config.SSHPrivateKey,
+		RedisMode:        config.RedisMode,
+		RedisMasterName:  config.RedisMasterName,
+		RedisNodes:       config.RedisNodes,
+		AllDatabases:     config.AllDatabases,
+		IncludeDatabases: config.IncludeDatabases,
+		ExcludeDatabases: config.ExcludeDatabases,
+		DumpFilters:      config.DumpFilters,
+		EncryptBackups:   config.EncryptBackups,
+		BackupRecipients: config.BackupRecipients,
+		CompressionCodec: config.CompressionCodec,
+		CompressionLevel: config.CompressionLevel,
+		UserID:           userID,
+		Status:           "connected",
+		DatabaseSize:     dbSize,
 	}
 
 	if err := s.repo.Save(storedConn); err != nil {
@@ -77,6 +104,20 @@ func (s *ConnectionService) GetConnection(id string) (*StoredConnection, error)
 }
 
 func (s *ConnectionService) UpdateConnection(config ConnectionConfig, userID uuid.UUID) (*StoredConnection, error) {
+	config.DumpFilters = normalizeDumpFilters(config.DumpFilters)
+	if err := ValidateDumpFilters(config.Type, config.DumpFilters); err != nil {
+		return nil, err
+	}
+
+	config.BackupRecipients = normalizeBackupRecipients(config.BackupRecipients)
+	if err := ValidateBackupRecipients(&config); err != nil {
+		return nil, err
+	}
+
+	if err := common.ValidateCompression(config.CompressionCodec, config.CompressionLevel); err != nil {
+		return nil, err
+	}
+
 	if err := s.manager.Connect(config); err != nil {
 		return nil, err
 	}
@@ -88,24 +129,36 @@ func (s *ConnectionService) UpdateConnection(config ConnectionConfig, userID uui
 	}
 
 	storedConn := StoredConnection{
-		ID:            config.ID,
-		Name:          config.Name,
-		Type:          config.Type,
-		Host:          config.Host,
-		Port:          config.Port,
-		Username:      config.Username,
-		Password:      config.Password,
-		DatabaseName:  config.Database,
-		SSL:           config.SSL,
-		SSHEnabled:    config.SSHEnabled,
-		SSHHost:       config.SSHHost,
-		SSHPort:       config.SSHPort,
-		SSHUsername:   config.SSHUsername,
-		SSHPassword:   config.SSHPassword,
-		SSHPrivateKey: config.SSHPrivateKey,
-		UserID:        userID,
-		Status:        "connected",
-		DatabaseSize:  dbSize,
+		ID:               config.ID,
+		Name:             config.Name,
+		Type:             config.Type,
+		Host:             config.Host,
+		Port:             config.Port,
+		Username:         config.Username,
+		Password:         config.Password,
+		DatabaseName:     config.Database,
+		ConnectionURI:    config.ConnectionURI,
+		SSL:              config.SSL,
+		SSHEnabled:       config.SSHEnabled,
+		SSHHost:          config.SSHHost,
+		SSHPort:          config.SSHPort,
+		SSHUsername:      config.SSHUsername,
+		SSHPassword:      config.SSHPassword,
+		SSHPrivateKey:    config.SSHPrivateKey,
+		RedisMode:        config.RedisMode,
+		RedisMasterName:  config.RedisMasterName,
+		RedisNodes:       config.RedisNodes,
+		AllDatabases:     config.AllDatabases,
+		IncludeDatabases: config.IncludeDatabases,
+		ExcludeDatabases: config.ExcludeDatabases,
+		DumpFilters:      config.DumpFilters,
+		EncryptBackups:   config.EncryptBackups,
+		BackupRecipients: config.BackupRecipients,
+		CompressionCodec: config.CompressionCodec,
+		CompressionLevel: config.CompressionLevel,
+		UserID:           userID,
+		Status:           "connected",
+		DatabaseSize:     dbSize,
 	}
 
 	if err := s.repo.Update(storedConn); err != nil {
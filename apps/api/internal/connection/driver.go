@@ -0,0 +1,263 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dendianugerah/velld/internal/common"
+)
+
+// DumpDriver is implemented once per database engine. It covers everything velld
+// needs from an engine: testing and sizing connections, finding the client tools,
+// and restoring backups. Dumping is provided through StreamDumper or FileDumper.
+type DumpDriver interface {
+	// Type returns the connection type handled by this driver, e.g. "postgresql"
+	Type() string
+	// Tools returns the client binaries this driver shells out to
+	Tools() DriverTools
+	// FileExtension returns the extension used for backup files, including the dot
+	FileExtension() string
+	// Format names the dump format the driver writes; it is recorded on each backup
+	Format() string
+	// Connect opens a live connection used for connection tests and size checks
+	Connect(config ConnectionConfig) (Session, error)
+	// Restore loads the backup file at backupPath into opts.Database
+	Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error
+}
+
+// StreamDumper is implemented by drivers whose dump tool can write to stdout
+type StreamDumper interface {
+	DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error
+}
+
+// FileDumper is implemented by drivers whose dump tool can only write to a local file
+type FileDumper interface {
+	DumpFile(ctx context.Context, conn *StoredConnection, outputPath string, logFunc func(string)) error
+}
+
+// StreamRestorer is implemented by drivers that can restore from a stream, so backups
+// in S3 can be restored without downloading them first
+type StreamRestorer interface {
+	RestoreStream(ctx context.Context, conn *StoredConnection, r io.Reader, opts RestoreOptions) error
+}
+
+// FileDriver is implemented by drivers whose databases are files rather than network
+// services. Their connections use DatabaseName as the file path, and SSH settings
+// reach the file on the SSH host instead of forwarding a port.
+type FileDriver interface {
+	DumpDriver
+	isFileDriver()
+}
+
+// CompressedDumpDriver is implemented by drivers whose dump tool compresses its own
+// output. Their streamed dumps aren't compressed again unless the connection picks a
+// codec, in which case the tool is told not to compress.
+type CompressedDumpDriver interface {
+	DumpDriver
+	isCompressedDump()
+}
+
+// ConnectionFormatter is implemented by drivers whose backup format depends on the
+// connection, rather than being fixed for the engine
+type ConnectionFormatter interface {
+	ConnectionFormat(conn *StoredConnection) (format, extension string)
+}
+
+// Session is a live connection opened by a driver
+type Session interface {
+	Size() (int64, error)
+	Close() error
+}
+
+// DatabaseLister is implemented by sessions that can enumerate the user databases on
+// their server, leaving out system databases. It is used by all-databases backups.
+type DatabaseLister interface {
+	ListDatabases() ([]string, error)
+}
+
+// GlobalsDumper is implemented by drivers that can dump server-wide objects, such as
+// roles and users, which aren't part of any single database's dump
+type GlobalsDumper interface {
+	DumpGlobals(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error
+	RestoreGlobals(ctx context.Context, conn *StoredConnection, r io.Reader) error
+}
+
+// DriverTools names the local client binaries used by a driver. A field is empty
+// when the driver doesn't need a binary for that step.
+type DriverTools struct {
+	Dump    string
+	Restore string
+}
+
+// RestoreOptions controls how a backup is restored
+type RestoreOptions struct {
+	Database       string // Target database name
+	SourceDatabase string // Database the backup was taken from
+	Format         string // Format recorded on the backup, empty for backups that predate it
+	Jobs           int    // Parallel restore jobs, for drivers that support them
+}
+
+var (
+	drivers      = make(map[string]DumpDriver)
+	driversMutex sync.RWMutex
+)
+
+// RegisterDriver makes a driver available for its connection type
+func RegisterDriver(driver DumpDriver) {
+	driversMutex.Lock()
+	defer driversMutex.Unlock()
+
+	if _, exists := drivers[driver.Type()]; exists {
+		panic(fmt.Sprintf("driver already registered for database type: %s", driver.Type()))
+	}
+	drivers[driver.Type()] = driver
+}
+
+// GetDriver returns the driver registered for a connection type
+func GetDriver(dbType string) (DumpDriver, error) {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+
+	driver, exists := drivers[dbType]
+	if !exists {
+		return nil, fmt.Errorf("unsupported database type: %s", dbType)
+	}
+	return driver, nil
+}
+
+// HasBackupExtension reports whether a file name ends in the extension of backups a
+// driver writes
+func HasBackupExtension(name string) bool {
+	driversMutex.RLock()
+	defer driversMutex.RUnlock()
+
+	for _, driver := range drivers {
+		if strings.HasSuffix(name, driver.FileExtension()) {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, redisShardsExtension)
+}
+
+// NeedsSSHTunnel reports whether SSH connections of this type go through a forwarded port
+func NeedsSSHTunnel(dbType string) bool {
+	driver, err := GetDriver(dbType)
+	if err != nil {
+		return true
+	}
+	_, isFile := driver.(FileDriver)
+	return !isFile
+}
+
+// BackupFormat returns the format and file extension of backups taken from conn
+func BackupFormat(driver DumpDriver, conn *StoredConnection) (format, extension string) {
+	if formatter, ok := driver.(ConnectionFormatter); ok {
+		return formatter.ConnectionFormat(conn)
+	}
+	return driver.Format(), driver.FileExtension()
+}
+
+// FindTool returns the full path of a client binary for a database type
+func FindTool(dbType, toolName string) (string, error) {
+	binaryPath := common.FindBinaryPath(dbType, toolName)
+	if binaryPath == "" {
+		return "", fmt.Errorf("%s not found for %s. Please ensure %s is installed and available in PATH", toolName, dbType, toolName)
+	}
+	return filepath.Join(binaryPath, common.GetPlatformExecutableName(toolName)), nil
+}
+
+// newToolCommand creates a command for a client binary. Cancelling ctx sends an
+// interrupt first and kills the process if it is still running two seconds later.
+func newToolCommand(ctx context.Context, dbType, toolName string, args ...string) (*exec.Cmd, error) {
+	binPath, err := FindTool(dbType, toolName)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 2 * time.Second
+	return cmd, nil
+}
+
+// runDumpCommand runs a dump command, copying stdout to w (when set) and passing
+// every stderr line to logFunc. The last stderr line is included in the error.
+func runDumpCommand(cmd *exec.Cmd, w io.Writer, logFunc func(string)) error {
+	if w != nil {
+		cmd.Stdout = w
+	}
+
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start backup command: %w", err)
+	}
+
+	lastLine := ""
+	scanner := bufio.NewScanner(stderrPipe)
+	for scanner.Scan() {
+		lastLine = scanner.Text()
+		logFunc(lastLine)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if lastLine != "" {
+			return fmt.Errorf("%w: %s", err, lastLine)
+		}
+		return err
+	}
+	return nil
+}
+
+// GlobalsFormat is recorded on backups of server-wide objects taken by GlobalsDriver
+const GlobalsFormat = "globals"
+
+// GlobalsDriver wraps a driver so its server-wide objects are dumped and restored
+// like a database. ok is false when the engine has nothing velld dumps that way.
+func GlobalsDriver(driver DumpDriver) (globals DumpDriver, ok bool) {
+	dumper, ok := driver.(GlobalsDumper)
+	if !ok {
+		return nil, false
+	}
+	return &globalsDriver{DumpDriver: driver, dumper: dumper}, true
+}
+
+type globalsDriver struct {
+	DumpDriver
+	dumper GlobalsDumper
+}
+
+func (d *globalsDriver) FileExtension() string { return ".sql" }
+
+func (d *globalsDriver) Format() string { return GlobalsFormat }
+
+func (d *globalsDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	return d.dumper.DumpGlobals(ctx, conn, w, logFunc)
+}
+
+func (d *globalsDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	return d.RestoreStream(ctx, conn, file, opts)
+}
+
+func (d *globalsDriver) RestoreStream(ctx context.Context, conn *StoredConnection, r io.Reader, opts RestoreOptions) error {
+	return d.dumper.RestoreGlobals(ctx, conn, r)
+}
@@ -0,0 +1,376 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func init() {
+	RegisterDriver(&mongoDriver{})
+}
+
+type mongoDriver struct{}
+
+func (d *mongoDriver) Type() string { return "mongodb" }
+
+func (d *mongoDriver) Tools() DriverTools {
+	return DriverTools{Dump: "mongodump", Restore: "mongorestore"}
+}
+
+func (d *mongoDriver) FileExtension() string { return ".archive" }
+
+const (
+	mongoFormatArchive   = "archive"
+	mongoFormatDirectory = "directory" // mongodump --out, used before archive backups
+)
+
+func (d *mongoDriver) Format() string { return mongoFormatArchive }
+
+func (d *mongoDriver) Connect(config ConnectionConfig) (Session, error) {
+	ctx := context.Background()
+	uri := fmt.Sprintf("mongodb://%s:%s@%s:%d/%s",
+		config.Username, config.Password, config.Host, config.Port, config.Database)
+
+	if config.ConnectionURI != "" {
+		if config.SSHEnabled {
+			return nil, errMongoURIOverSSH
+		}
+		uri = config.ConnectionURI
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return &mongoSession{client: client}, nil
+}
+
+// DumpStream runs mongodump --archive, which writes a single archive to stdout.
+// --gzip is left off because the upload already compresses the stream.
+func (d *mongoDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	args, uriDatabase, err := d.toolArgs(conn, false)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--archive")
+
+	database := conn.DatabaseName
+	if database == "" {
+		database = uriDatabase
+	}
+	if database != "" {
+		args = append(args, "--db", database)
+	}
+
+	filterArgs, err := d.filterArgs(ctx, conn, database)
+	if err != nil {
+		return err
+	}
+	args = append(args, filterArgs...)
+
+	if conn.ConnectionURI != "" {
+		if readPreference := mongoURIOption(conn.ConnectionURI, "readPreference"); readPreference != "" {
+			logFunc(fmt.Sprintf("[INFO] Dumping with readPreference=%s", readPreference))
+		}
+	}
+
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Dump, args...)
+	if err != nil {
+		return err
+	}
+
+	return runDumpCommand(cmd, w, logFunc)
+}
+
+// ValidateFilters only accepts collection rules; collections have no separate structure to dump
+func (d *mongoDriver) ValidateFilters(filters *DumpFilters) error {
+	if len(filters.IncludeSchemas) > 0 || len(filters.ExcludeSchemas) > 0 {
+		return fmt.Errorf("schema filters are not supported for MongoDB; use collection rules instead")
+	}
+	if len(filters.SchemaOnlyTables) > 0 || len(filters.DataOnlyTables) > 0 {
+		return fmt.Errorf("schema-only and data-only rules are not supported for MongoDB")
+	}
+	return nil
+}
+
+// filterArgs translates collection rules into --excludeCollection flags. mongodump
+// can include only a single collection, so included collections are dumped by
+// excluding every other collection in the database.
+func (d *mongoDriver) filterArgs(ctx context.Context, conn *StoredConnection, database string) ([]string, error) {
+	filters := conn.DumpFilters
+	if filters.IsEmpty() {
+		return nil, nil
+	}
+	if database == "" {
+		return nil, fmt.Errorf("collection filters need a database name")
+	}
+
+	excluded := append([]string{}, filters.ExcludeTables...)
+	if len(filters.IncludeTables) > 0 {
+		collections, err := d.listCollections(ctx, conn, database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list collections: %w", err)
+		}
+
+		included := make(map[string]bool, len(filters.IncludeTables))
+		for _, name := range filters.IncludeTables {
+			included[name] = true
+		}
+		for _, name := range collections {
+			if !included[name] {
+				excluded = append(excluded, name)
+			}
+		}
+	}
+
+	var args []string
+	for _, name := range excluded {
+		args = append(args, "--excludeCollection", name)
+	}
+	return args, nil
+}
+
+func (d *mongoDriver) listCollections(ctx context.Context, conn *StoredConnection, database string) ([]string, error) {
+	session, err := d.Connect(conn.Config())
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return session.(*mongoSession).client.Database(database).ListCollectionNames(ctx, bson.D{})
+}
+
+func (d *mongoDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	if opts.Format == "" || opts.Format == mongoFormatDirectory {
+		return d.restoreDirectory(ctx, conn, backupPath, opts)
+	}
+
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	return d.RestoreStream(ctx, conn, file, opts)
+}
+
+// RestoreStream feeds an archive to mongorestore on stdin. Collections are renamed
+// into the target database when it differs from the one the backup was taken from.
+func (d *mongoDriver) RestoreStream(ctx context.Context, conn *StoredConnection, r io.Reader, opts RestoreOptions) error {
+	args, _, err := d.toolArgs(conn, true)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--archive")
+
+	if opts.SourceDatabase != "" {
+		args = append(args, "--nsInclude", opts.SourceDatabase+".*")
+		if opts.Database != "" && opts.Database != opts.SourceDatabase {
+			args = append(args,
+				"--nsFrom", opts.SourceDatabase+".*",
+				"--nsTo", opts.Database+".*",
+			)
+		}
+	}
+
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Restore, args...)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = r
+
+	output, err := cmd.CombinedOutput()
+	return validateToolRestore(opts.Database, output, err)
+}
+
+// restoreDirectory restores a mongodump directory from the folder containing backupPath
+func (d *mongoDriver) restoreDirectory(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	args, _, err := d.toolArgs(conn, true)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Restore, append(args,
+		"--db", opts.Database,
+		filepath.Dir(backupPath),
+	)...)
+	if err != nil {
+		return err
+	}
+
+	output, err := cmd.CombinedOutput()
+	return validateToolRestore(opts.Database, output, err)
+}
+
+var errMongoURIOverSSH = errors.New("SSH tunnels are not supported for MongoDB connection URIs")
+
+// toolArgs returns the connection flags for mongodump and mongorestore, and the
+// database named in the connection URI, if any
+func (d *mongoDriver) toolArgs(conn *StoredConnection, restore bool) ([]string, string, error) {
+	if conn.ConnectionURI == "" {
+		return d.authArgs(conn,
+			"--host", conn.Host,
+			"--port", fmt.Sprintf("%d", conn.Port),
+		), "", nil
+	}
+
+	if conn.SSHEnabled {
+		return nil, "", errMongoURIOverSSH
+	}
+
+	uri, err := parseMongoURI(conn.ConnectionURI)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The database is passed with --db or --nsInclude instead, which conflict with a
+	// database in the URI. It stays the authentication database unless one is set.
+	database := uri.database
+	if database != "" {
+		if uri.option("authSource") == "" {
+			uri.query.Set("authSource", database)
+		}
+		uri.database = ""
+	}
+
+	// Restores write, so they always go to the primary
+	if restore {
+		uri.deleteOption("readPreference")
+	}
+
+	return []string{"--uri", uri.String()}, database, nil
+}
+
+// authArgs appends credential flags to args when the connection has them
+func (d *mongoDriver) authArgs(conn *StoredConnection, args ...string) []string {
+	if conn.Username != "" {
+		args = append(args, "--username", conn.Username)
+	}
+
+	if conn.Password != "" {
+		args = append(args, "--password", conn.Password)
+	}
+
+	return args
+}
+
+type mongoSession struct {
+	client *mongo.Client
+}
+
+func (s *mongoSession) Size() (int64, error) {
+	ctx := context.Background()
+	result := s.client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "dbStats", Value: 1},
+		{Key: "scale", Value: 1},
+	})
+
+	var stats bson.M
+	if err := result.Decode(&stats); err != nil {
+		return 0, err
+	}
+
+	return int64(stats["dataSize"].(float64)), nil
+}
+
+// ListDatabases leaves out admin, local and config, which hold server state rather than user data
+func (s *mongoSession) ListDatabases() ([]string, error) {
+	return s.client.ListDatabaseNames(context.Background(), bson.D{
+		{Key: "name", Value: bson.D{{Key: "$nin", Value: bson.A{"admin", "local", "config"}}}},
+	})
+}
+
+func (s *mongoSession) Close() error {
+	return s.client.Disconnect(context.Background())
+}
+
+// mongoURI is a MongoDB connection string split into the parts velld rewrites
+type mongoURI struct {
+	base     string // Scheme, credentials and hosts
+	database string
+	query    url.Values
+}
+
+// parseMongoURI splits a mongodb:// or mongodb+srv:// URI. It doesn't use net/url,
+// which rejects the comma-separated host lists of replica sets.
+func parseMongoURI(raw string) (*mongoURI, error) {
+	schemeEnd := strings.Index(raw, "://")
+	if schemeEnd < 0 {
+		return nil, fmt.Errorf("invalid MongoDB connection URI")
+	}
+	if scheme := raw[:schemeEnd]; scheme != "mongodb" && scheme != "mongodb+srv" {
+		return nil, fmt.Errorf("unsupported MongoDB connection URI scheme %q", scheme)
+	}
+
+	rest := raw[schemeEnd+3:]
+	var rawQuery, rawDatabase string
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest, rawQuery = rest[:i], rest[i+1:]
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest, rawDatabase = rest[:i], rest[i+1:]
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MongoDB connection URI options: %w", err)
+	}
+
+	database, err := url.PathUnescape(rawDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database in MongoDB connection URI: %w", err)
+	}
+
+	return &mongoURI{base: raw[:schemeEnd+3] + rest, database: database, query: query}, nil
+}
+
+// option returns a URI option; option names are case-insensitive
+func (u *mongoURI) option(name string) string {
+	for key, values := range u.query {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (u *mongoURI) deleteOption(name string) {
+	for key := range u.query {
+		if strings.EqualFold(key, name) {
+			u.query.Del(key)
+		}
+	}
+}
+
+func (u *mongoURI) String() string {
+	uri := u.base + "/" + url.PathEscape(u.database)
+	if query := u.query.Encode(); query != "" {
+		uri += "?" + query
+	}
+	return uri
+}
+
+// mongoURIOption returns an option of a connection URI, or "" if the URI is invalid
+func mongoURIOption(raw, name string) string {
+	uri, err := parseMongoURI(raw)
+	if err != nil {
+		return ""
+	}
+	return uri.option(name)
+}
@@ -0,0 +1,310 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	RegisterDriver(&mysqlDriver{dbType: "mysql"})
+	RegisterDriver(&mysqlDriver{dbType: "mariadb"})
+}
+
+// mysqlDriver handles MySQL and MariaDB, which share client tools and wire protocol
+type mysqlDriver struct {
+	dbType string
+}
+
+func (d *mysqlDriver) Type() string { return d.dbType }
+
+func (d *mysqlDriver) Tools() DriverTools {
+	return DriverTools{Dump: "mysqldump", Restore: "mysql"}
+}
+
+func (d *mysqlDriver) FileExtension() string { return ".sql" }
+
+func (d *mysqlDriver) Format() string { return "sql" }
+
+func (d *mysqlDriver) Connect(config ConnectionConfig) (Session, error) {
+	db, err := sql.Open("mysql", mysqlDSN(config.Username, config.Password, config.Host, config.Port, config.Database, config.SSL))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	sizeQuery := `SELECT SUM(data_length + index_length)
+				 FROM information_schema.tables
+				 WHERE table_schema = DATABASE()`
+	if config.AllDatabases {
+		sizeQuery = `SELECT SUM(data_length + index_length)
+				 FROM information_schema.tables
+				 WHERE table_schema NOT IN ('information_schema', 'performance_schema', 'sys', 'mysql')`
+	}
+
+	return &sqlSession{
+		db:        db,
+		sizeQuery: sizeQuery,
+		listQuery: `SELECT schema_name
+				 FROM information_schema.schemata
+				 WHERE schema_name NOT IN ('information_schema', 'performance_schema', 'sys', 'mysql')
+				 ORDER BY schema_name`,
+	}, nil
+}
+
+func mysqlDSN(username, password, host string, port int, database string, ssl bool) string {
+	sslMode := "false"
+	if ssl {
+		sslMode = "true"
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?tls=%s", username, password, host, port, database, sslMode)
+}
+
+// DumpStream runs mysqldump, which writes to stdout when no -r flag is given.
+// mysqldump applies --no-data and --no-create-info to every table it dumps, so
+// schema-only and data-only tables are dumped by further runs appended to the
+// same stream. Those runs are separate transactions from the main dump.
+func (d *mysqlDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	filters := conn.DumpFilters
+	if filters == nil {
+		filters = &DumpFilters{}
+	}
+
+	args := append(d.connectionArgs(conn),
+		"--single-transaction", // Consistent backup for InnoDB
+		"--quick",              // Retrieve rows one at a time (reduces memory usage)
+		"--lock-tables=false",  // Don't lock all tables (works with --single-transaction)
+		"--routines",           // Include stored procedures and functions
+		"--triggers",           // Include triggers
+		"--events",             // Include events
+	)
+	for _, rule := range [][]string{filters.ExcludeTables, filters.SchemaOnlyTables, filters.DataOnlyTables} {
+		for _, table := range rule {
+			args = append(args, fmt.Sprintf("--ignore-table=%s.%s", conn.DatabaseName, table))
+		}
+	}
+	args = append(args, conn.DatabaseName)
+	args = append(args, filters.IncludeTables...)
+
+	if err := d.runDump(ctx, w, logFunc, args); err != nil {
+		return err
+	}
+
+	if len(filters.SchemaOnlyTables) > 0 {
+		logFunc(fmt.Sprintf("[INFO] Dumping table definitions without rows: %s", strings.Join(filters.SchemaOnlyTables, ", ")))
+		args := append(d.connectionArgs(conn), "--single-transaction", "--lock-tables=false", "--no-data", conn.DatabaseName)
+		if err := d.runDump(ctx, w, logFunc, append(args, filters.SchemaOnlyTables...)); err != nil {
+			return err
+		}
+	}
+
+	if len(filters.DataOnlyTables) > 0 {
+		logFunc(fmt.Sprintf("[INFO] Dumping rows without table definitions: %s", strings.Join(filters.DataOnlyTables, ", ")))
+		args := append(d.connectionArgs(conn), "--single-transaction", "--quick", "--lock-tables=false", "--no-create-info", "--skip-triggers", conn.DatabaseName)
+		if err := d.runDump(ctx, w, logFunc, append(args, filters.DataOnlyTables...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *mysqlDriver) runDump(ctx context.Context, w io.Writer, logFunc func(string), args []string) error {
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Dump, args...)
+	if err != nil {
+		return err
+	}
+
+	return runDumpCommand(cmd, w, logFunc)
+}
+
+// connectionArgs returns the host and credential flags shared by mysqldump and mysql
+func (d *mysqlDriver) connectionArgs(conn *StoredConnection) []string {
+	return []string{
+		"-h", conn.Host,
+		"-P", fmt.Sprintf("%d", conn.Port),
+		"-u", conn.Username,
+		fmt.Sprintf("-p%s", conn.Password),
+	}
+}
+
+// ValidateFilters only accepts table rules. Schemas are databases in MySQL, which
+// are chosen with the connection's database or all-databases patterns instead.
+// mysqldump matches table names exactly, without patterns.
+func (d *mysqlDriver) ValidateFilters(filters *DumpFilters) error {
+	if len(filters.IncludeSchemas) > 0 || len(filters.ExcludeSchemas) > 0 {
+		return fmt.Errorf("schema filters are not supported for %s; schemas are databases, chosen with the database name or all-databases patterns", d.Type())
+	}
+
+	for _, rule := range [][]string{filters.IncludeTables, filters.ExcludeTables, filters.SchemaOnlyTables, filters.DataOnlyTables} {
+		for _, table := range rule {
+			if strings.ContainsAny(table, ".*?%") {
+				return fmt.Errorf("invalid table name %q: mysqldump needs plain table names, without database prefixes or patterns", table)
+			}
+		}
+	}
+	return nil
+}
+
+// mysqlSkippedAccounts are left out of globals dumps: internal accounts created by
+// the server itself, and root, which every target server already has
+var mysqlSkippedAccounts = map[string]bool{
+	"root":             true,
+	"mysql.sys":        true,
+	"mysql.session":    true,
+	"mysql.infoschema": true,
+	"mariadb.sys":      true,
+}
+
+// DumpGlobals writes the server's user accounts and their grants as SQL.
+// mysqldump --all-databases only carries them as rows of the mysql schema, whose
+// layout changes between versions, so they are read with SHOW CREATE USER and
+// SHOW GRANTS instead.
+func (d *mysqlDriver) DumpGlobals(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	db, err := sql.Open("mysql", mysqlDSN(conn.Username, conn.Password, conn.Host, conn.Port, "", conn.SSL))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Session variables only apply to the connection they were set on
+	session, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// MySQL 8 password hashes can contain bytes that aren't valid in a SQL string;
+	// older servers and MariaDB don't have this variable and don't need it
+	session.ExecContext(ctx, "SET SESSION print_identified_with_as_hex = ON")
+
+	accounts, err := mysqlAccounts(ctx, session)
+	if err != nil {
+		return fmt.Errorf("failed to list user accounts: %w", err)
+	}
+
+	dumped := 0
+	for _, account := range accounts {
+		var createUser string
+		if err := session.QueryRowContext(ctx, "SHOW CREATE USER "+account).Scan(&createUser); err != nil {
+			// MariaDB roles are listed in mysql.user but have no CREATE USER statement
+			logFunc(fmt.Sprintf("[WARNING] Skipping %s: %v", account, err))
+			continue
+		}
+
+		grants, err := mysqlGrants(ctx, session, account)
+		if err != nil {
+			return fmt.Errorf("failed to read grants for %s: %w", account, err)
+		}
+
+		createUser = strings.Replace(createUser, "CREATE USER ", "CREATE USER IF NOT EXISTS ", 1)
+		if _, err := fmt.Fprintf(w, "%s;\n", createUser); err != nil {
+			return err
+		}
+		for _, grant := range grants {
+			if _, err := fmt.Fprintf(w, "%s;\n", grant); err != nil {
+				return err
+			}
+		}
+		dumped++
+	}
+
+	logFunc(fmt.Sprintf("[INFO] Dumped %d user account(s) and their grants", dumped))
+	return nil
+}
+
+// mysqlAccounts returns the quoted 'user'@'host' names of the accounts to dump
+func mysqlAccounts(ctx context.Context, session *sql.Conn) ([]string, error) {
+	rows, err := session.QueryContext(ctx, "SELECT user, host FROM mysql.user ORDER BY user, host")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []string
+	for rows.Next() {
+		var user, host string
+		if err := rows.Scan(&user, &host); err != nil {
+			return nil, err
+		}
+		if user == "" || mysqlSkippedAccounts[user] {
+			continue
+		}
+		accounts = append(accounts, fmt.Sprintf("'%s'@'%s'", mysqlQuote(user), mysqlQuote(host)))
+	}
+	return accounts, rows.Err()
+}
+
+func mysqlGrants(ctx context.Context, session *sql.Conn, account string) ([]string, error) {
+	rows, err := session.QueryContext(ctx, "SHOW GRANTS FOR "+account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []string
+	for rows.Next() {
+		var grant string
+		if err := rows.Scan(&grant); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// mysqlQuote escapes a value for use inside a single-quoted SQL string
+func mysqlQuote(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", "''").Replace(value)
+}
+
+// RestoreGlobals replays a globals dump with the mysql client. Accounts that already
+// exist are kept and given the dumped grants.
+func (d *mysqlDriver) RestoreGlobals(ctx context.Context, conn *StoredConnection, r io.Reader) error {
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Restore, d.connectionArgs(conn)...)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = r
+
+	output, err := cmd.CombinedOutput()
+	return validateToolRestore("globals", output, err)
+}
+
+func (d *mysqlDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Restore, append(d.connectionArgs(conn), opts.Database)...)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+	cmd.Stdin = file
+
+	output, err := cmd.CombinedOutput()
+	return validateToolRestore(opts.Database, output, err)
+}
+
+// validateToolRestore turns a failed restore command into an error that includes the tool output
+func validateToolRestore(dbName string, output []byte, cmdErr error) error {
+	if cmdErr != nil {
+		outputStr := string(output)
+		if outputStr == "" {
+			outputStr = cmdErr.Error()
+		}
+		return fmt.Errorf("restore failed for database '%s': %s", dbName, outputStr)
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package connection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dendianugerah/velld/internal/common"
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	RegisterDriver(&postgresDriver{})
+}
+
+// PostgreSQL dump formats recorded on backups
+const (
+	pgFormatCustom = "custom"
+	pgFormatPlain  = "plain"
+)
+
+// pgMaintenanceDatabase is connected to when a connection doesn't name a database
+const pgMaintenanceDatabase = "postgres"
+
+// pgCustomFormatMagic is the header every custom-format archive starts with
+const pgCustomFormatMagic = "PGDMP"
+
+type postgresDriver struct{}
+
+func (d *postgresDriver) Type() string { return "postgresql" }
+
+// Tools lists pg_restore for custom-format backups; plain SQL backups are restored with psql
+func (d *postgresDriver) Tools() DriverTools {
+	return DriverTools{Dump: "pg_dump", Restore: "pg_restore"}
+}
+
+func (d *postgresDriver) FileExtension() string { return ".dump" }
+
+func (d *postgresDriver) Format() string { return pgFormatCustom }
+
+func (d *postgresDriver) isCompressedDump() {}
+
+func (d *postgresDriver) Connect(config ConnectionConfig) (Session, error) {
+	sslMode := "disable"
+	if config.SSL {
+		sslMode = "require"
+	}
+	database := config.Database
+	if database == "" {
+		database = pgMaintenanceDatabase
+	}
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.Host, config.Port, config.Username, config.Password, database, sslMode)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	sizeQuery := "SELECT pg_database_size(current_database())"
+	if config.AllDatabases {
+		sizeQuery = "SELECT SUM(pg_database_size(datname)) FROM pg_database WHERE NOT datistemplate"
+	}
+
+	return &sqlSession{
+		db:        db,
+		sizeQuery: sizeQuery,
+		listQuery: "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname",
+	}, nil
+}
+
+// DumpStream runs pg_dump in custom format, which pg_dump writes to stdout when no -f is given
+func (d *postgresDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	d.logServerInfo(conn, logFunc)
+
+	args := []string{
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", conn.DatabaseName,
+		"-F", "c", // Custom format (compressed internally, allows parallel restore with pg_restore)
+		"--no-owner",      // Don't dump ownership commands (helps with TimescaleDB and cross-database restores)
+		"--no-privileges", // Don't dump access privileges (helps with TimescaleDB and cross-database restores)
+		"--verbose",       // Verbose output shows progress: what tables/schemas are being dumped
+	}
+	if conn.CompressionCodec != "" && conn.CompressionCodec != common.CompressionNone {
+		// velld compresses the archive with the connection's codec instead
+		args = append(args, "-Z", "0")
+	}
+	args = append(args, d.filterArgs(conn.DumpFilters)...)
+
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Dump, args...)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+
+	versionMismatch := false
+	err = runDumpCommand(cmd, w, func(line string) {
+		if isPostgreSQLVersionMismatch(line) {
+			versionMismatch = true
+		}
+		logFunc(line)
+	})
+	if err != nil && versionMismatch {
+		return fmt.Errorf("%w (install PostgreSQL client tools matching your server version)", err)
+	}
+	return err
+}
+
+// ValidateFilters rejects data-only tables: pg_dump can only leave out table
+// definitions for a whole dump, not for some of its tables
+func (d *postgresDriver) ValidateFilters(filters *DumpFilters) error {
+	if len(filters.DataOnlyTables) > 0 {
+		return fmt.Errorf("pg_dump can't dump only the rows of some tables; use schema-only or exclude rules instead")
+	}
+	return nil
+}
+
+// filterArgs translates dump filters into pg_dump's -n/-N/-t/-T/--exclude-table-data flags
+func (d *postgresDriver) filterArgs(filters *DumpFilters) []string {
+	if filters == nil {
+		return nil
+	}
+
+	var args []string
+	for _, schema := range filters.IncludeSchemas {
+		args = append(args, "-n", schema)
+	}
+	for _, schema := range filters.ExcludeSchemas {
+		args = append(args, "-N", schema)
+	}
+	for _, table := range filters.IncludeTables {
+		args = append(args, "-t", table)
+	}
+	for _, table := range filters.ExcludeTables {
+		args = append(args, "-T", table)
+	}
+	for _, table := range filters.SchemaOnlyTables {
+		args = append(args, "--exclude-table-data="+table)
+	}
+	return args
+}
+
+// DumpGlobals runs pg_dumpall --globals-only, which writes the roles and tablespaces
+// of the server as SQL. Reading role passwords needs a superuser.
+func (d *postgresDriver) DumpGlobals(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	cmd, err := newToolCommand(ctx, d.Type(), "pg_dumpall",
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"--globals-only",
+		"--verbose",
+	)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+
+	return runDumpCommand(cmd, w, logFunc)
+}
+
+// RestoreGlobals replays a globals dump with psql. ON_ERROR_STOP is left off so roles
+// that already exist on the target don't stop the rest from being created.
+func (d *postgresDriver) RestoreGlobals(ctx context.Context, conn *StoredConnection, r io.Reader) error {
+	database := conn.DatabaseName
+	if database == "" {
+		database = pgMaintenanceDatabase
+	}
+
+	cmd, err := newToolCommand(ctx, d.Type(), "psql",
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", database,
+	)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	cmd.Stdin = r
+
+	output, err := cmd.CombinedOutput()
+	return validateToolRestore("globals", output, err)
+}
+
+// logServerInfo logs client/server versions and warns about anything that may affect the dump
+func (d *postgresDriver) logServerInfo(conn *StoredConnection, logFunc func(string)) {
+	// Check client version
+	clientVersion := "unknown"
+	if version, err := d.getPgDumpVersion(); err == nil {
+		clientVersion = version
+		logFunc(fmt.Sprintf("[INFO] pg_dump client version: %s", version))
+	} else {
+		logFunc(fmt.Sprintf("[WARNING] Could not determine pg_dump version: %v", err))
+	}
+
+	// Check server version
+	if serverVersion, err := d.getServerVersion(conn); err == nil {
+		logFunc(fmt.Sprintf("[INFO] PostgreSQL server version: %s", serverVersion))
+
+		// Extract major version numbers for comparison
+		clientMajor := extractPostgreSQLMajorVersion(clientVersion)
+		serverMajor := extractPostgreSQLMajorVersion(serverVersion)
+
+		if clientMajor != "" && serverMajor != "" && clientMajor != serverMajor {
+			logFunc(fmt.Sprintf("[WARNING] Version mismatch detected! Client: %s, Server: %s", clientMajor, serverMajor))
+			logFunc("[WARNING] The backup may fail. Please install PostgreSQL client tools matching your server version.")
+		}
+	} else {
+		logFunc(fmt.Sprintf("[INFO] Could not determine server version: %v (this is not critical)", err))
+	}
+
+	// For TimescaleDB, the warnings about circular foreign keys in hypertable, chunk, and continuous_agg
+	// tables are expected and safe to ignore. We don't exclude these tables as they contain important
+	// metadata for hypertables.
+	if d.isTimescaleDBInstalled(conn) {
+		logFunc("[INFO] TimescaleDB extension detected in database")
+		logFunc("[INFO] Warnings about circular foreign keys in hypertable, chunk, and continuous_agg tables are expected and safe to ignore")
+		logFunc("[INFO] These warnings are part of TimescaleDB's internal architecture and do not affect backup integrity")
+	}
+}
+
+// Restore uses pg_restore for custom-format backups and psql for plain SQL ones
+func (d *postgresDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	format := opts.Format
+	if format == "" {
+		// Older backups don't record their format, so check the file header
+		var err error
+		if format, err = detectPgDumpFormat(backupPath); err != nil {
+			return err
+		}
+	}
+
+	if format == pgFormatCustom {
+		return d.restoreCustom(ctx, conn, backupPath, opts)
+	}
+	return d.restorePlain(ctx, conn, backupPath, opts)
+}
+
+// restoreCustom restores a custom-format archive with pg_restore, in parallel when opts.Jobs > 1
+func (d *postgresDriver) restoreCustom(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	args := []string{
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", opts.Database,
+		"--no-owner",
+		"--no-privileges",
+	}
+	if opts.Jobs > 1 {
+		args = append(args, "--jobs", fmt.Sprintf("%d", opts.Jobs))
+	}
+	args = append(args, backupPath)
+
+	cmd, err := newToolCommand(ctx, d.Type(), "pg_restore", args...)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+
+	output, err := cmd.CombinedOutput()
+	return d.validateRestore(output, err)
+}
+
+// restorePlain replays a plain SQL dump with psql
+func (d *postgresDriver) restorePlain(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	// Use -v ON_ERROR_STOP=1 to exit immediately on first error
+	// This ensures errors are properly caught
+	cmd, err := newToolCommand(ctx, d.Type(), "psql",
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", opts.Database,
+		"-f", backupPath,
+		"-v", "ON_ERROR_STOP=1",
+	)
+	if err != nil {
+		return err
+	}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+
+	output, err := cmd.CombinedOutput()
+	return d.validateRestore(output, err)
+}
+
+func (d *postgresDriver) validateRestore(output []byte, cmdErr error) error {
+	lines := strings.Split(string(output), "\n")
+
+	var criticalErrors []string
+	for _, line := range lines {
+		// psql reports server errors as "ERROR:", pg_restore adds its own "pg_restore: error:" lines
+		if !strings.Contains(line, "ERROR:") && !strings.Contains(line, "pg_restore: error:") {
+			continue
+		}
+
+		if isCriticalPostgreSQLError(line) {
+			criticalErrors = append(criticalErrors, line)
+		}
+	}
+
+	if len(criticalErrors) > 0 {
+		for _, errLine := range criticalErrors {
+			if strings.Contains(errLine, "already exists") {
+				return fmt.Errorf("restore failed: target database must be empty. See documentation for restore best practices")
+			}
+		}
+		return fmt.Errorf("restore failed with %d error(s)", len(criticalErrors))
+	}
+
+	return nil
+}
+
+// detectPgDumpFormat tells custom-format archives from plain SQL by their header
+func detectPgDumpFormat(backupPath string) (string, error) {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(pgCustomFormatMagic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		// Too short to be an archive; let psql deal with it
+		return pgFormatPlain, nil
+	}
+
+	if string(header) == pgCustomFormatMagic {
+		return pgFormatCustom, nil
+	}
+	return pgFormatPlain, nil
+}
+
+func isCriticalPostgreSQLError(line string) bool {
+	nonCriticalPatterns := []string{
+		"WARNING:",
+		"must be member of role",
+		"no privileges",
+		"NOTICE:",
+	}
+
+	for _, pattern := range nonCriticalPatterns {
+		if strings.Contains(line, pattern) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPostgreSQLVersionMismatch checks if a pg_dump output line reports a server version mismatch
+func isPostgreSQLVersionMismatch(line string) bool {
+	return strings.Contains(strings.ToLower(line), "server version mismatch")
+}
+
+// psqlCommand creates a psql command that runs a single query against the connection's database
+func (d *postgresDriver) psqlCommand(conn *StoredConnection, query string) (*exec.Cmd, error) {
+	binPath, err := FindTool(d.Type(), "psql")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(binPath,
+		"-h", conn.Host,
+		"-p", fmt.Sprintf("%d", conn.Port),
+		"-U", conn.Username,
+		"-d", conn.DatabaseName,
+		"-t", "-A", // terse, aligned output
+		"-c", query,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	return cmd, nil
+}
+
+// isTimescaleDBInstalled checks if TimescaleDB extension is installed in the database
+func (d *postgresDriver) isTimescaleDBInstalled(conn *StoredConnection) bool {
+	cmd, err := d.psqlCommand(conn, "SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'timescaledb');")
+	if err != nil {
+		return false
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	result := strings.TrimSpace(string(output))
+	return result == "t" || result == "true" || result == "1"
+}
+
+// getPgDumpVersion returns the version of pg_dump being used
+func (d *postgresDriver) getPgDumpVersion() (string, error) {
+	binPath, err := FindTool(d.Type(), d.Tools().Dump)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command(binPath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get pg_dump version: %v", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// getServerVersion returns the PostgreSQL server version
+func (d *postgresDriver) getServerVersion(conn *StoredConnection) (string, error) {
+	cmd, err := d.psqlCommand(conn, "SELECT version();")
+	if err != nil {
+		return "", err
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %v", err)
+	}
+
+	version := strings.TrimSpace(string(output))
+	// Extract just the version number (e.g., "PostgreSQL 16.1" from full version string)
+	if strings.Contains(version, "PostgreSQL") {
+		parts := strings.Fields(version)
+		for i, part := range parts {
+			if part == "PostgreSQL" && i+1 < len(parts) {
+				return strings.TrimSpace(parts[i+1]), nil
+			}
+		}
+	}
+
+	return version, nil
+}
+
+// extractPostgreSQLMajorVersion extracts the major version number from a PostgreSQL version string
+func extractPostgreSQLMajorVersion(versionStr string) string {
+	// Look for patterns like "16.1", "PostgreSQL 16.1", "pg_dump (PostgreSQL) 16.1", etc.
+	parts := strings.Fields(versionStr)
+	for _, part := range parts {
+		// Check if part starts with a number (major version)
+		if len(part) > 0 && part[0] >= '0' && part[0] <= '9' {
+			// Extract just the major version (first number before dot)
+			if dotIndex := strings.Index(part, "."); dotIndex > 0 {
+				return part[:dotIndex]
+			}
+			// If no dot, return first digit sequence
+			var major strings.Builder
+			for _, r := range part {
+				if r >= '0' && r <= '9' {
+					major.WriteRune(r)
+				} else {
+					break
+				}
+			}
+			if major.Len() > 0 {
+				return major.String()
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,717 @@
+package connection
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func init() {
+	RegisterDriver(&redisDriver{})
+}
+
+// Redis deployment modes. Sentinel connections list sentinels and the master name;
+// cluster connections list seed nodes. Host and Port are the first sentinel or seed.
+const (
+	redisModeStandalone = "standalone"
+	redisModeSentinel   = "sentinel"
+	redisModeCluster    = "cluster"
+)
+
+// Backup formats. Sentinel and cluster backups are tar archives holding one RDB per
+// shard and a manifest describing them.
+const (
+	redisFormatRDB       = "rdb"
+	redisFormatShards    = "rdb-shards"
+	redisShardsExtension = ".tar"
+	redisManifestName    = "manifest.json"
+)
+
+type redisDriver struct{}
+
+func (d *redisDriver) Type() string { return "redis" }
+
+// Tools has no restore binary; RDB snapshots are replayed key by key with RESTORE
+func (d *redisDriver) Tools() DriverTools {
+	return DriverTools{Dump: "redis-cli"}
+}
+
+func (d *redisDriver) FileExtension() string { return ".rdb" }
+
+func (d *redisDriver) Format() string { return redisFormatRDB }
+
+func (d *redisDriver) ConnectionFormat(conn *StoredConnection) (string, string) {
+	if redisMode(conn) == redisModeStandalone {
+		return redisFormatRDB, ".rdb"
+	}
+	return redisFormatShards, redisShardsExtension
+}
+
+func (d *redisDriver) Connect(config ConnectionConfig) (Session, error) {
+	ctx := context.Background()
+
+	conn := &StoredConnection{
+		Host:            config.Host,
+		Port:            config.Port,
+		Password:        config.Password,
+		SSL:             config.SSL,
+		SSHEnabled:      config.SSHEnabled,
+		RedisMode:       config.RedisMode,
+		RedisMasterName: config.RedisMasterName,
+		RedisNodes:      config.RedisNodes,
+	}
+
+	db := 0
+	if config.Database != "" && redisMode(conn) != redisModeCluster {
+		var n int
+		_, err := fmt.Sscanf(config.Database, "%d", &n)
+		if err == nil && n >= 0 && n <= 15 {
+			db = n
+		}
+	}
+
+	client, err := newRedisClient(conn, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &redisSession{client: client}, nil
+}
+
+// DumpStream writes an RDB snapshot of a standalone server to w. For sentinel and
+// cluster connections it resolves the current master of every shard and writes a
+// tar archive of their snapshots instead.
+func (d *redisDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	if err := checkRedisTopology(conn); err != nil {
+		return err
+	}
+
+	if redisMode(conn) == redisModeStandalone {
+		return d.dumpNode(ctx, conn, net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)), w, logFunc)
+	}
+
+	shards, err := d.resolveShards(ctx, conn, logFunc)
+	if err != nil {
+		return err
+	}
+
+	return d.dumpShards(ctx, conn, shards, w, logFunc)
+}
+
+// dumpNode runs redis-cli --rdb against one server and copies the snapshot to w
+func (d *redisDriver) dumpNode(ctx context.Context, conn *StoredConnection, addr string, w io.Writer, logFunc func(string)) error {
+	snapshot, err := os.CreateTemp("", "velld-redis-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	snapshotPath := snapshot.Name()
+	snapshot.Close()
+	defer os.Remove(snapshotPath)
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid Redis address %q: %w", addr, err)
+	}
+
+	args := []string{
+		"-h", host,
+		"-p", port,
+	}
+
+	if conn.Password != "" {
+		args = append(args, "-a", conn.Password)
+	}
+
+	args = append(args, "--rdb", snapshotPath)
+
+	cmd, err := newToolCommand(ctx, d.Type(), d.Tools().Dump, args...)
+	if err != nil {
+		return err
+	}
+
+	if err := runDumpCommand(cmd, nil, logFunc); err != nil {
+		return err
+	}
+
+	snapshot, err = os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer snapshot.Close()
+
+	if _, err := io.Copy(w, snapshot); err != nil {
+		return fmt.Errorf("failed to stream snapshot: %w", err)
+	}
+	return nil
+}
+
+// redisManifest describes the shards in a sentinel or cluster backup archive
+type redisManifest struct {
+	Mode       string       `json:"mode"`
+	MasterName string       `json:"master_name,omitempty"`
+	CreatedAt  string       `json:"created_at"`
+	Shards     []redisShard `json:"shards"`
+}
+
+type redisShard struct {
+	File   string   `json:"file"`
+	Addr   string   `json:"addr"`
+	NodeID string   `json:"node_id,omitempty"`
+	Slots  [][2]int `json:"slots,omitempty"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256"`
+}
+
+// resolveShards finds the current master of each shard: the master a sentinel reports,
+// or every master that owns cluster slots
+func (d *redisDriver) resolveShards(ctx context.Context, conn *StoredConnection, logFunc func(string)) ([]redisShard, error) {
+	if redisMode(conn) == redisModeSentinel {
+		addr, err := sentinelMasterAddr(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+		logFunc(fmt.Sprintf("[INFO] Sentinel reports master '%s' at %s", conn.RedisMasterName, addr))
+		return []redisShard{{Addr: addr}}, nil
+	}
+
+	client, err := newRedisClient(conn, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	slots, err := client.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster slots: %w", err)
+	}
+
+	// The first node of each slot range is the master serving it
+	byNode := make(map[string]*redisShard)
+	var shards []*redisShard
+	for _, slot := range slots {
+		if len(slot.Nodes) == 0 {
+			continue
+		}
+		master := slot.Nodes[0]
+		shard, ok := byNode[master.ID]
+		if !ok {
+			shard = &redisShard{Addr: master.Addr, NodeID: master.ID}
+			byNode[master.ID] = shard
+			shards = append(shards, shard)
+		}
+		shard.Slots = append(shard.Slots, [2]int{slot.Start, slot.End})
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("cluster has no slots assigned")
+	}
+
+	result := make([]redisShard, len(shards))
+	for i, shard := range shards {
+		sort.Slice(shard.Slots, func(a, b int) bool { return shard.Slots[a][0] < shard.Slots[b][0] })
+		result[i] = *shard
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Slots[0][0] < result[b].Slots[0][0] })
+
+	logFunc(fmt.Sprintf("[INFO] Found %d cluster shards", len(result)))
+	return result, nil
+}
+
+// dumpShards writes a tar archive with one RDB per shard followed by the manifest.
+// Shards are dumped one at a time, so only one snapshot is on local disk at once.
+func (d *redisDriver) dumpShards(ctx context.Context, conn *StoredConnection, shards []redisShard, w io.Writer, logFunc func(string)) error {
+	archive := tar.NewWriter(w)
+
+	for i := range shards {
+		shard := &shards[i]
+		shard.File = fmt.Sprintf("shard-%03d.rdb", i)
+
+		logFunc(fmt.Sprintf("[INFO] Dumping shard %d/%d from %s", i+1, len(shards), shard.Addr))
+		if err := d.dumpShard(ctx, conn, shard, archive, logFunc); err != nil {
+			return fmt.Errorf("failed to dump shard %s: %w", shard.Addr, err)
+		}
+	}
+
+	manifest, err := json.MarshalIndent(redisManifest{
+		Mode:       redisMode(conn),
+		MasterName: conn.RedisMasterName,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		Shards:     shards,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := archive.WriteHeader(&tar.Header{
+		Name:    redisManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := archive.Write(manifest); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// dumpShard snapshots one shard into a temporary file and adds it to the archive
+func (d *redisDriver) dumpShard(ctx context.Context, conn *StoredConnection, shard *redisShard, archive *tar.Writer, logFunc func(string)) error {
+	snapshot, err := os.CreateTemp("", "velld-redis-shard-*.rdb")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
+
+	if err := d.dumpNode(ctx, conn, shard.Addr, snapshot, logFunc); err != nil {
+		return err
+	}
+
+	size, err := snapshot.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := archive.WriteHeader(&tar.Header{
+		Name:    shard.File,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hasher), snapshot); err != nil {
+		return err
+	}
+
+	shard.Size = size
+	shard.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
+
+func (d *redisDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	return d.RestoreStream(ctx, conn, file, opts)
+}
+
+// RestoreStream replays the keys of an RDB snapshot with RESTORE ... REPLACE, so keys
+// in the snapshot overwrite existing ones and other keys are left alone. The snapshot
+// holds every logical DB. When the backup connection had a DB number, only that DB
+// is restored, into opts.Database if set; otherwise each DB keeps its number.
+//
+// Shard archives from sentinel and cluster backups are replayed shard by shard. Keys
+// are routed by the target connection, so a cluster backup can be restored into a
+// cluster with a different layout, or into a single server.
+func (d *redisDriver) RestoreStream(ctx context.Context, conn *StoredConnection, r io.Reader, opts RestoreOptions) error {
+	if err := checkRedisTopology(conn); err != nil {
+		return err
+	}
+
+	mapping, err := newRedisDBMapping(opts.SourceDatabase, opts.Database)
+	if err != nil {
+		return err
+	}
+
+	restorer := &redisRestorer{conn: conn, clients: make(map[int]redis.UniversalClient), pending: make(map[int][]*rdbEntry)}
+	defer restorer.close()
+
+	if opts.Format == redisFormatShards {
+		err = d.restoreShards(ctx, r, restorer, mapping)
+	} else {
+		err = d.restoreRDB(ctx, r, restorer, mapping)
+	}
+	if err != nil {
+		return err
+	}
+
+	return restorer.flush(ctx)
+}
+
+func (d *redisDriver) restoreRDB(ctx context.Context, r io.Reader, restorer *redisRestorer, mapping redisDBMapping) error {
+	reader, err := newRDBReader(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read RDB snapshot: %w", err)
+		}
+
+		db, ok := mapping.target(entry.DB)
+		if !ok {
+			continue
+		}
+
+		if err := restorer.add(ctx, db, entry); err != nil {
+			return err
+		}
+	}
+}
+
+// restoreShards replays every shard in the archive, then checks them against the manifest
+func (d *redisDriver) restoreShards(ctx context.Context, r io.Reader, restorer *redisRestorer, mapping redisDBMapping) error {
+	archive := tar.NewReader(r)
+	checksums := make(map[string]string)
+	var manifest *redisManifest
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read backup archive: %w", err)
+		}
+
+		switch {
+		case header.Name == redisManifestName:
+			manifest = &redisManifest{}
+			if err := json.NewDecoder(archive).Decode(manifest); err != nil {
+				return fmt.Errorf("failed to read backup manifest: %w", err)
+			}
+		case strings.HasSuffix(header.Name, ".rdb"):
+			hasher := sha256.New()
+			shard := io.TeeReader(archive, hasher)
+			if err := d.restoreRDB(ctx, shard, restorer, mapping); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", header.Name, err)
+			}
+			// Hash the RDB trailer the reader stops short of
+			if _, err := io.Copy(io.Discard, shard); err != nil {
+				return err
+			}
+			checksums[header.Name] = hex.EncodeToString(hasher.Sum(nil))
+		}
+	}
+
+	if manifest == nil {
+		return fmt.Errorf("backup archive has no %s", redisManifestName)
+	}
+
+	for _, shard := range manifest.Shards {
+		checksum, ok := checksums[shard.File]
+		if !ok {
+			return fmt.Errorf("shard %s from %s is missing from the backup archive", shard.File, shard.Addr)
+		}
+		if !strings.EqualFold(checksum, shard.SHA256) {
+			return fmt.Errorf("checksum mismatch for shard %s: expected %s, got %s", shard.File, shard.SHA256, checksum)
+		}
+	}
+
+	return nil
+}
+
+// redisDBMapping decides which logical DBs of a snapshot are restored, and where to
+type redisDBMapping struct {
+	source int // -1 restores every DB
+	dest   int // -1 keeps each DB's number
+}
+
+func newRedisDBMapping(sourceDatabase, targetDatabase string) (redisDBMapping, error) {
+	source, err := parseRedisDB(sourceDatabase)
+	if err != nil {
+		return redisDBMapping{}, err
+	}
+	dest, err := parseRedisDB(targetDatabase)
+	if err != nil {
+		return redisDBMapping{}, err
+	}
+	if source < 0 && dest >= 0 {
+		source = 0
+	}
+	return redisDBMapping{source: source, dest: dest}, nil
+}
+
+func (m redisDBMapping) target(db int) (int, bool) {
+	if m.source >= 0 && db != m.source {
+		return 0, false
+	}
+	if m.dest >= 0 {
+		return m.dest, true
+	}
+	return db, true
+}
+
+// parseRedisDB parses a logical DB number, returning -1 when value is empty
+func parseRedisDB(value string) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+
+	db, err := strconv.Atoi(value)
+	if err != nil || db < 0 {
+		return 0, fmt.Errorf("invalid Redis database number %q", value)
+	}
+	return db, nil
+}
+
+// redisRestoreBatchSize is the number of RESTORE commands sent in one pipeline
+const redisRestoreBatchSize = 500
+
+// redisRestorer pipelines RESTORE commands, with one client per logical DB
+type redisRestorer struct {
+	conn    *StoredConnection
+	clients map[int]redis.UniversalClient
+	pending map[int][]*rdbEntry
+	count   int
+}
+
+func (r *redisRestorer) add(ctx context.Context, db int, entry *rdbEntry) error {
+	r.pending[db] = append(r.pending[db], entry)
+	r.count++
+
+	if r.count >= redisRestoreBatchSize {
+		return r.flush(ctx)
+	}
+	return nil
+}
+
+func (r *redisRestorer) flush(ctx context.Context) error {
+	for db, entries := range r.pending {
+		client, ok := r.clients[db]
+		if !ok {
+			var err error
+			client, err = newRedisClient(r.conn, db)
+			if err != nil {
+				return err
+			}
+			r.clients[db] = client
+		}
+
+		now := time.Now().UnixMilli()
+		pipe := client.Pipeline()
+		cmds := make(map[string]*redis.StatusCmd, len(entries))
+		for _, entry := range entries {
+			var ttl time.Duration
+			if entry.ExpireAt > 0 {
+				// Keys that expired since the backup was taken are not restored
+				if entry.ExpireAt <= now {
+					continue
+				}
+				ttl = time.Duration(entry.ExpireAt-now) * time.Millisecond
+			}
+			cmds[entry.Key] = pipe.RestoreReplace(ctx, entry.Key, ttl, string(entry.Payload))
+		}
+
+		if _, err := pipe.Exec(ctx); err != nil {
+			for key, cmd := range cmds {
+				if cmd.Err() != nil {
+					return fmt.Errorf("restore failed for key '%s' in database %d: %w", key, db, cmd.Err())
+				}
+			}
+			return fmt.Errorf("restore failed for database %d: %w", db, err)
+		}
+	}
+
+	r.pending = make(map[int][]*rdbEntry)
+	r.count = 0
+	return nil
+}
+
+func (r *redisRestorer) close() {
+	for _, client := range r.clients {
+		client.Close()
+	}
+}
+
+// redisMode returns the connection's deployment mode, defaulting to standalone
+func redisMode(conn *StoredConnection) string {
+	if conn.RedisMode == "" {
+		return redisModeStandalone
+	}
+	return conn.RedisMode
+}
+
+// checkRedisTopology validates the sentinel and cluster settings of a connection
+func checkRedisTopology(conn *StoredConnection) error {
+	switch redisMode(conn) {
+	case redisModeStandalone:
+		return nil
+	case redisModeSentinel:
+		if conn.RedisMasterName == "" {
+			return fmt.Errorf("master name is required for Redis Sentinel connections")
+		}
+	case redisModeCluster:
+	default:
+		return fmt.Errorf("unknown Redis mode %q", conn.RedisMode)
+	}
+
+	// A tunnel forwards a single port, but sentinels and cluster nodes hand out
+	// addresses of other servers
+	if conn.SSHEnabled {
+		return fmt.Errorf("SSH tunnels are not supported for Redis %s connections", conn.RedisMode)
+	}
+	return nil
+}
+
+// redisAddrs returns the connection's host followed by its additional nodes
+func redisAddrs(conn *StoredConnection) []string {
+	var addrs []string
+	if conn.Host != "" {
+		addrs = append(addrs, net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)))
+	}
+	for _, node := range conn.RedisNodes {
+		if node = strings.TrimSpace(node); node != "" {
+			addrs = append(addrs, node)
+		}
+	}
+	return addrs
+}
+
+// newRedisClient returns a client for logical DB db that follows the connection's
+// topology: failover to the current master for sentinel, slot routing for cluster
+func newRedisClient(conn *StoredConnection, db int) (redis.UniversalClient, error) {
+	if err := checkRedisTopology(conn); err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if conn.SSL {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	switch redisMode(conn) {
+	case redisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conn.RedisMasterName,
+			SentinelAddrs: redisAddrs(conn),
+			Password:      conn.Password,
+			DB:            db,
+			TLSConfig:     tlsConfig,
+		}), nil
+	case redisModeCluster:
+		if db != 0 {
+			return nil, fmt.Errorf("redis cluster only has database 0, got %d", db)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     redisAddrs(conn),
+			Password:  conn.Password,
+			TLSConfig: tlsConfig,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)),
+			Password:  conn.Password,
+			DB:        db,
+			TLSConfig: tlsConfig,
+		}), nil
+	}
+}
+
+// sentinelMasterAddr asks each sentinel in turn for the current master's address
+func sentinelMasterAddr(ctx context.Context, conn *StoredConnection) (string, error) {
+	var lastErr error
+	for _, addr := range redisAddrs(conn) {
+		opts := &redis.Options{Addr: addr}
+		if conn.SSL {
+			opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+
+		sentinel := redis.NewSentinelClient(opts)
+		master, err := sentinel.GetMasterAddrByName(ctx, conn.RedisMasterName).Result()
+		sentinel.Close()
+
+		if err == nil && len(master) == 2 {
+			return net.JoinHostPort(master[0], master[1]), nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected reply %v", master)
+		}
+		lastErr = err
+	}
+
+	return "", fmt.Errorf("failed to resolve master '%s' from sentinels: %w", conn.RedisMasterName, lastErr)
+}
+
+type redisSession struct {
+	client redis.UniversalClient
+}
+
+// Size returns used memory, summed over the masters for cluster connections
+func (s *redisSession) Size() (int64, error) {
+	ctx := context.Background()
+
+	cluster, ok := s.client.(*redis.ClusterClient)
+	if !ok {
+		return usedMemory(ctx, s.client)
+	}
+
+	var total int64
+	var mu sync.Mutex
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		size, err := usedMemory(ctx, client)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total += size
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+func (s *redisSession) Close() error {
+	return s.client.Close()
+}
+
+// usedMemory reads used_memory from INFO memory
+func usedMemory(ctx context.Context, client redis.Cmdable) (int64, error) {
+	info, err := client.Info(ctx, "memory").Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get Redis memory info: %w", err)
+	}
+
+	var usedMemory int64
+	lines := []byte(info)
+	start := 0
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == '\n' {
+			line := string(lines[start:i])
+			start = i + 1
+
+			if len(line) > 12 && line[:12] == "used_memory:" {
+				fmt.Sscanf(line[12:], "%d", &usedMemory)
+				return usedMemory, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,142 @@
+package connection
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisDBMapping(t *testing.T) {
+	tests := []struct {
+		source, target string
+		want           map[int]int // snapshot DB to restored DB; missing DBs are skipped
+	}{
+		{"", "", map[int]int{0: 0, 1: 1, 2: 2, 3: 3}},
+		{"3", "", map[int]int{3: 3}},
+		{"3", "5", map[int]int{3: 5}},
+		{"", "5", map[int]int{0: 5}},
+		{"0", "0", map[int]int{0: 0}},
+	}
+	for _, tt := range tests {
+		mapping, err := newRedisDBMapping(tt.source, tt.target)
+		if err != nil {
+			t.Errorf("source %q, target %q: %v", tt.source, tt.target, err)
+			continue
+		}
+		for db := 0; db < 4; db++ {
+			target, ok := mapping.target(db)
+			want, wantOK := tt.want[db]
+			if ok != wantOK || target != want {
+				t.Errorf("source %q, target %q: DB %d is restored to %d (%v), want %d (%v)", tt.source, tt.target, db, target, ok, want, wantOK)
+			}
+		}
+	}
+
+	for _, invalid := range [][2]string{{"x", ""}, {"", "-1"}, {"1.5", "0"}} {
+		if _, err := newRedisDBMapping(invalid[0], invalid[1]); err == nil {
+			t.Errorf("source %q, target %q was accepted", invalid[0], invalid[1])
+		}
+	}
+}
+
+// shardArchive builds a sentinel or cluster backup: the snapshots in a tar archive,
+// followed by a manifest listing each with its checksum. edit changes the manifest
+// before it is written; the archive has no manifest when edit returns false.
+func shardArchive(t *testing.T, snapshots [][]byte, edit func(manifest *redisManifest) bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	addFile := func(name string, data []byte) {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := &redisManifest{Mode: redisModeCluster}
+	for i, snapshot := range snapshots {
+		sum := sha256.Sum256(snapshot)
+		shard := redisShard{File: fmt.Sprintf("shard-%03d.rdb", i), Addr: fmt.Sprintf("10.0.0.%d:6379", i+1), Size: int64(len(snapshot)), SHA256: hex.EncodeToString(sum[:])}
+		manifest.Shards = append(manifest.Shards, shard)
+		addFile(shard.File, snapshot)
+	}
+
+	if edit == nil || edit(manifest) {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addFile(redisManifestName, data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestRedisRestorer returns a restorer that only queues keys; nothing is sent to
+// Redis until a batch fills up
+func newTestRedisRestorer() *redisRestorer {
+	return &redisRestorer{conn: &StoredConnection{}, clients: make(map[int]redis.UniversalClient), pending: make(map[int][]*rdbEntry)}
+}
+
+func TestRedisRestoreShardsChecksManifest(t *testing.T) {
+	ctx := context.Background()
+	d := &redisDriver{}
+	mapping, err := newRedisDBMapping("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshots [][]byte
+	for _, key := range []string{"a", "b"} {
+		f := newRDBFixture(11)
+		f.selectDB(0)
+		f.key(key, rdbString(key), rdbTypeString, rdbString("value"), 0)
+		snapshots = append(snapshots, f.end())
+	}
+
+	restorer := newTestRedisRestorer()
+	intact := shardArchive(t, snapshots, func(manifest *redisManifest) bool {
+		manifest.Shards[0].SHA256 = strings.ToUpper(manifest.Shards[0].SHA256)
+		return true
+	})
+	if err := d.restoreShards(ctx, bytes.NewReader(intact), restorer, mapping); err != nil {
+		t.Fatal(err)
+	}
+	if len(restorer.pending[0]) != 2 {
+		t.Errorf("queued %d keys from the shards, want 2", len(restorer.pending[0]))
+	}
+
+	damaged := map[string]struct {
+		edit func(manifest *redisManifest) bool
+		want string
+	}{
+		"checksum mismatch": {func(manifest *redisManifest) bool {
+			manifest.Shards[1].SHA256 = strings.Repeat("0", sha256.Size*2)
+			return true
+		}, "checksum mismatch for shard shard-001.rdb"},
+		"missing shard": {func(manifest *redisManifest) bool {
+			manifest.Shards = append(manifest.Shards, redisShard{File: "shard-002.rdb", Addr: "10.0.0.3:6379"})
+			return true
+		}, "shard shard-002.rdb from 10.0.0.3:6379 is missing"},
+		"no manifest": {func(manifest *redisManifest) bool { return false }, "has no " + redisManifestName},
+	}
+	for name, tt := range damaged {
+		archive := shardArchive(t, snapshots, tt.edit)
+		err := d.restoreShards(ctx, bytes.NewReader(archive), newTestRedisRestorer(), mapping)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: restore returned %v, want an error containing %q", name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	RegisterDriver(&sqliteDriver{})
+}
+
+// sqliteDriver backs up SQLite files, either local to the API server or on the SSH
+// host. The file path is stored as the connection's database name.
+type sqliteDriver struct{}
+
+func (d *sqliteDriver) Type() string { return "sqlite" }
+
+// Tools is empty: local files use the linked SQLite library, and remote files use
+// the sqlite3 CLI on the SSH host
+func (d *sqliteDriver) Tools() DriverTools { return DriverTools{} }
+
+func (d *sqliteDriver) FileExtension() string { return ".sqlite" }
+
+func (d *sqliteDriver) Format() string { return "sqlite" }
+
+func (d *sqliteDriver) isFileDriver() {}
+
+func (d *sqliteDriver) Connect(config ConnectionConfig) (Session, error) {
+	if config.Database == "" {
+		return nil, fmt.Errorf("database file path is required for SQLite connections")
+	}
+
+	if config.SSHEnabled {
+		client, err := DialSSH(config.SSHHost, config.SSHPort, config.SSHUsername, config.SSHPassword, config.SSHPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		session := &remoteSQLiteSession{client: client, path: config.Database}
+		if _, err := session.Size(); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to read SQLite file on SSH host: %w", err)
+		}
+		return session, nil
+	}
+
+	db, err := openSQLiteReadOnly(config.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlSession{db: db, sizeQuery: "SELECT page_count * page_size as size FROM pragma_page_count, pragma_page_size"}, nil
+}
+
+// DumpStream takes a consistent snapshot of the database and writes it to w
+func (d *sqliteDriver) DumpStream(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	if conn.SSHEnabled {
+		return d.dumpRemote(ctx, conn, w, logFunc)
+	}
+
+	// Snapshot into a temporary file first so the database is only read once
+	snapshot, err := os.CreateTemp("", "velld-sqlite-*.snapshot")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	snapshotPath := snapshot.Name()
+	snapshot.Close()
+	defer os.Remove(snapshotPath)
+
+	logFunc(fmt.Sprintf("[INFO] Taking snapshot of %s using the SQLite online backup API", conn.DatabaseName))
+	if err := SnapshotSQLite(ctx, conn.DatabaseName, snapshotPath); err != nil {
+		return err
+	}
+
+	snapshot, err = os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %w", err)
+	}
+	defer snapshot.Close()
+
+	if _, err := io.Copy(w, snapshot); err != nil {
+		return fmt.Errorf("failed to stream snapshot: %w", err)
+	}
+	return nil
+}
+
+// dumpRemote snapshots the file on the SSH host with the sqlite3 CLI and streams it back
+func (d *sqliteDriver) dumpRemote(ctx context.Context, conn *StoredConnection, w io.Writer, logFunc func(string)) error {
+	client, err := DialSSH(conn.SSHHost, conn.SSHPort, conn.SSHUsername, conn.SSHPassword, conn.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	logFunc(fmt.Sprintf("[INFO] Taking snapshot of %s on %s using sqlite3 .backup", conn.DatabaseName, conn.SSHHost))
+
+	// .backup uses the online backup API, so writers on the SSH host are not blocked
+	script := fmt.Sprintf(`tmp=$(mktemp) || exit 1
+sqlite3 %s ".backup '$tmp'" && cat "$tmp"
+status=$?
+rm -f "$tmp"
+exit $status`, shellQuote(conn.DatabaseName))
+
+	return runSSHCommand(ctx, client, script, nil, w)
+}
+
+// Restore replaces the database file with the backup. The backup is written next
+// to the target and renamed over it, so the file is never left half-written.
+func (d *sqliteDriver) Restore(ctx context.Context, conn *StoredConnection, backupPath string, opts RestoreOptions) error {
+	if err := CheckSQLiteFile(backupPath); err != nil {
+		return fmt.Errorf("backup is not a valid SQLite database: %w", err)
+	}
+
+	if conn.SSHEnabled {
+		return d.restoreRemote(ctx, conn, backupPath, opts.Database)
+	}
+
+	targetPath := opts.Database
+	tmpFile, err := os.CreateTemp(filepath.Dir(targetPath), filepath.Base(targetPath)+".velld-restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary restore file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	backupFile, err := os.Open(backupPath)
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer backupFile.Close()
+
+	if _, err := io.Copy(tmpFile, backupFile); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync restore file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close restore file: %w", err)
+	}
+
+	// A WAL or shared-memory file left from the old database would be applied to the
+	// restored one, so remove them before the new file takes its place
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(targetPath + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s file: %w", suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmpPath, targetPath); err != nil {
+		return fmt.Errorf("failed to replace database file: %w", err)
+	}
+	return nil
+}
+
+// restoreRemote uploads the backup next to the target on the SSH host and renames it into place
+func (d *sqliteDriver) restoreRemote(ctx context.Context, conn *StoredConnection, backupPath, targetPath string) error {
+	backupFile, err := os.Open(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer backupFile.Close()
+
+	client, err := DialSSH(conn.SSHHost, conn.SSHPort, conn.SSHUsername, conn.SSHPassword, conn.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target := shellQuote(targetPath)
+	tmp := shellQuote(fmt.Sprintf("%s.velld-restore-%d", targetPath, time.Now().UnixNano()))
+	script := fmt.Sprintf(`cat > %[2]s || { rm -f %[2]s; exit 1; }
+rm -f %[1]s-wal %[1]s-shm && mv -f %[2]s %[1]s`, target, tmp)
+
+	if err := runSSHCommand(ctx, client, script, backupFile, io.Discard); err != nil {
+		return fmt.Errorf("restore failed for database '%s': %w", targetPath, err)
+	}
+	return nil
+}
+
+// openSQLiteReadOnly opens a SQLite file without creating it when it doesn't exist
+func openSQLiteReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to access SQLite file: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	// The file only counts as a database once SQLite can read its schema
+	if _, err := db.Exec("SELECT count(*) FROM sqlite_master"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// CheckSQLiteFile runs a quick integrity check on a SQLite file
+func CheckSQLiteFile(path string) error {
+	db, err := openSQLiteReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+// SnapshotSQLite copies srcPath to destPath with the SQLite online backup API. The
+// copy is consistent even while other connections write to the source.
+func SnapshotSQLite(ctx context.Context, srcPath, destPath string) error {
+	srcDB, err := openSQLiteReadOnly(srcPath)
+	if err != nil {
+		return err
+	}
+	defer srcDB.Close()
+
+	destDB, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			backup, err := destDriverConn.(*sqlite3.SQLiteConn).Backup("main", srcDriverConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("failed to start SQLite backup: %w", err)
+			}
+
+			for {
+				if err := ctx.Err(); err != nil {
+					backup.Close()
+					return err
+				}
+
+				remaining := backup.Remaining()
+				done, err := backup.Step(1024)
+				if err != nil {
+					backup.Close()
+					return fmt.Errorf("SQLite backup step failed: %w", err)
+				}
+				if done {
+					break
+				}
+
+				// The source is busy or locked; give the writer a moment before retrying
+				if backup.Remaining() == remaining {
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+
+			return backup.Finish()
+		})
+	})
+}
+
+// remoteSQLiteSession is a Session for a SQLite file on an SSH host
+type remoteSQLiteSession struct {
+	client *ssh.Client
+	path   string
+}
+
+func (s *remoteSQLiteSession) Size() (int64, error) {
+	var output bytes.Buffer
+	if err := runSSHCommand(context.Background(), s.client, "wc -c < "+shellQuote(s.path), nil, &output); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(output.String()), 10, 64)
+}
+
+func (s *remoteSQLiteSession) Close() error {
+	return s.client.Close()
+}
+
+// runSSHCommand runs a shell script on the SSH host. Cancelling ctx closes the session.
+func runSSHCommand(ctx context.Context, client *ssh.Client, script string, stdin io.Reader, stdout io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	if err := session.Run(script); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// shellQuote quotes a value for use as a single POSIX shell word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,127 @@
+package connection
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DumpFilters narrows down what a backup dumps. Names are passed to the dump tool
+// as they are, so PostgreSQL also accepts its own patterns such as "audit_*".
+// Tables covers collections for MongoDB.
+type DumpFilters struct {
+	IncludeSchemas   []string `json:"include_schemas,omitempty"`
+	ExcludeSchemas   []string `json:"exclude_schemas,omitempty"`
+	IncludeTables    []string `json:"include_tables,omitempty"`
+	ExcludeTables    []string `json:"exclude_tables,omitempty"`
+	SchemaOnlyTables []string `json:"schema_only_tables,omitempty"` // Dump the structure but not the rows
+	DataOnlyTables   []string `json:"data_only_tables,omitempty"`   // Dump the rows but not the structure
+}
+
+// FilterDumper is implemented by drivers that can apply DumpFilters. Validation
+// runs when a connection is saved, so unsupported rules are rejected up front.
+type FilterDumper interface {
+	ValidateFilters(filters *DumpFilters) error
+}
+
+// IsEmpty reports whether f leaves the dump unchanged
+func (f *DumpFilters) IsEmpty() bool {
+	return f == nil || len(f.IncludeSchemas)+len(f.ExcludeSchemas)+len(f.IncludeTables)+
+		len(f.ExcludeTables)+len(f.SchemaOnlyTables)+len(f.DataOnlyTables) == 0
+}
+
+// String describes the rules for backup logs
+func (f *DumpFilters) String() string {
+	if f.IsEmpty() {
+		return "none"
+	}
+
+	var parts []string
+	for _, rule := range []struct {
+		name  string
+		names []string
+	}{
+		{"include schemas", f.IncludeSchemas},
+		{"exclude schemas", f.ExcludeSchemas},
+		{"include tables", f.IncludeTables},
+		{"exclude tables", f.ExcludeTables},
+		{"schema only", f.SchemaOnlyTables},
+		{"data only", f.DataOnlyTables},
+	} {
+		if len(rule.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", rule.name, strings.Join(rule.names, ", ")))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ValidateDumpFilters checks that the driver for dbType can apply filters
+func ValidateDumpFilters(dbType string, filters *DumpFilters) error {
+	if filters.IsEmpty() {
+		return nil
+	}
+
+	driver, err := GetDriver(dbType)
+	if err != nil {
+		return err
+	}
+
+	filterDumper, ok := driver.(FilterDumper)
+	if !ok {
+		return fmt.Errorf("table and schema filters are not supported for %s", dbType)
+	}
+	return filterDumper.ValidateFilters(filters)
+}
+
+// normalizeDumpFilters trims names and drops empty ones, returning nil when no rules are left
+func normalizeDumpFilters(filters *DumpFilters) *DumpFilters {
+	if filters == nil {
+		return nil
+	}
+
+	clean := func(names []string) []string {
+		var cleaned []string
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				cleaned = append(cleaned, name)
+			}
+		}
+		return cleaned
+	}
+
+	normalized := &DumpFilters{
+		IncludeSchemas:   clean(filters.IncludeSchemas),
+		ExcludeSchemas:   clean(filters.ExcludeSchemas),
+		IncludeTables:    clean(filters.IncludeTables),
+		ExcludeTables:    clean(filters.ExcludeTables),
+		SchemaOnlyTables: clean(filters.SchemaOnlyTables),
+		DataOnlyTables:   clean(filters.DataOnlyTables),
+	}
+	if normalized.IsEmpty() {
+		return nil
+	}
+	return normalized
+}
+
+// encodeDumpFilters stores filters as JSON, or NULL when there are none
+func encodeDumpFilters(filters *DumpFilters) (interface{}, error) {
+	if filters.IsEmpty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decodeDumpFilters(data string) (*DumpFilters, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var filters DumpFilters
+	if err := json.Unmarshal([]byte(data), &filters); err != nil {
+		return nil, fmt.Errorf("invalid dump filters: %w", err)
+	}
+	return &filters, nil
+}
@@ -7,27 +7,39 @@ import (
 )
 
 type StoredConnection struct {
-	ID              string     `json:"id"`
-	Name            string     `json:"name"`
-	Type            string     `json:"type"`
-	Host            string     `json:"host"`
-	Port            int        `json:"port"`
-	Username        string     `json:"username"`
-	Password        string     `json:"password"`
-	DatabaseName    string     `json:"database_name"`
-	SSL             bool       `json:"ssl"`
-	SSHEnabled      bool       `json:"ssh_enabled"`
-	SSHHost         string     `json:"ssh_host"`
-	SSHPort         int        `json:"ssh_port"`
-	SSHUsername     string     `json:"ssh_username"`
-	SSHPassword     string     `json:"ssh_password"`
-	SSHPrivateKey   string     `json:"ssh_private_key"`
-	CreatedAt       string     `json:"created_at"`
-	UpdatedAt       string     `json:"updated_at"`
-	LastConnectedAt *time.Time `json:"last_connected_at"`
-	UserID          uuid.UUID  `json:"user_id"`
-	Status          string     `json:"status"`
-	DatabaseSize    int64      `json:"database_size"`
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	Type             string       `json:"type"`
+	Host             string       `json:"host"`
+	Port             int          `json:"port"`
+	Username         string       `json:"username"`
+	Password         string       `json:"password"`
+	DatabaseName     string       `json:"database_name"`
+	ConnectionURI    string       `json:"connection_uri,omitempty"`
+	SSL              bool         `json:"ssl"`
+	SSHEnabled       bool         `json:"ssh_enabled"`
+	SSHHost          string       `json:"ssh_host"`
+	SSHPort          int          `json:"ssh_port"`
+	SSHUsername      string       `json:"ssh_username"`
+	SSHPassword      string       `json:"ssh_password"`
+	SSHPrivateKey    string       `json:"ssh_private_key"`
+	RedisMode        string       `json:"redis_mode,omitempty"`
+	RedisMasterName  string       `json:"redis_master_name,omitempty"`
+	RedisNodes       []string     `json:"redis_nodes,omitempty"`
+	AllDatabases     bool         `json:"all_databases"`
+	IncludeDatabases []string     `json:"include_databases,omitempty"`
+	ExcludeDatabases []string     `json:"exclude_databases,omitempty"`
+	DumpFilters      *DumpFilters `json:"dump_filters,omitempty"`
+	EncryptBackups   bool         `json:"encrypt_backups"`
+	BackupRecipients []string     `json:"backup_recipients,omitempty"`
+	CompressionCodec string       `json:"compression_codec,omitempty"`
+	CompressionLevel int          `json:"compression_level,omitempty"`
+	CreatedAt        string       `json:"created_at"`
+	UpdatedAt        string       `json:"updated_at"`
+	LastConnectedAt  *time.Time   `json:"last_connected_at"`
+	UserID           uuid.UUID    `json:"user_id"`
+	Status           string       `json:"status"`
+	DatabaseSize     int64        `json:"database_size"`
 }
 
 type ConnectionConfig struct {
@@ -39,6 +51,7 @@ type ConnectionConfig struct {
 	Username      string `json:"username"`
 	Password      string `json:"password"`
 	Database      string `json:"database"`
+	ConnectionURI string `json:"connection_uri,omitempty"` // Used instead of host and port when set (MongoDB)
 	SSL           bool   `json:"ssl"`
 	SSHEnabled    bool   `json:"ssh_enabled"`
 	SSHHost       string `json:"ssh_host"`
@@ -46,6 +59,23 @@ type ConnectionConfig struct {
 	SSHUsername   string `json:"ssh_username"`
 	SSHPassword   string `json:"ssh_password"`
 	SSHPrivateKey string `json:"ssh_private_key"`
+	// Redis deployments other than a single server; see RedisMode
+	RedisMode       string   `json:"redis_mode,omitempty"`
+	RedisMasterName string   `json:"redis_master_name,omitempty"`
+	RedisNodes      []string `json:"redis_nodes,omitempty"`
+	// Back up every database on the server, filtered by glob patterns; see SelectDatabases
+	AllDatabases     bool     `json:"all_databases"`
+	IncludeDatabases []string `json:"include_databases,omitempty"`
+	ExcludeDatabases []string `json:"exclude_databases,omitempty"`
+	// Schema and table rules applied to every dump of the connection
+	DumpFilters *DumpFilters `json:"dump_filters,omitempty"`
+	// Encrypt backups with a per-backup key before they are uploaded
+	EncryptBackups bool `json:"encrypt_backups"`
+	// age or OpenPGP public keys to encrypt backups to, so the server can't read them
+	BackupRecipients []string `json:"backup_recipients,omitempty"`
+	// Codec and level uploads are compressed with; empty keeps the default
+	CompressionCodec string `json:"compression_codec,omitempty"`
+	CompressionLevel int    `json:"compression_level,omitempty"`
 }
 
 type ConnectionStats struct {
@@ -59,14 +89,15 @@ type ConnectionStats struct {
 }
 
 type ConnectionListItem struct {
-	ID             string  `json:"id"`
-	Name           string  `json:"name"`
-	Type           string  `json:"type"`
-	Host           string  `json:"host"`
-	Status         string  `json:"status"`
-	DatabaseSize   int64   `json:"database_size"`
-	LastBackupTime *string `json:"last_backup_time"`
-	BackupEnabled  bool    `json:"backup_enabled"`
-	CronSchedule   *string `json:"cron_schedule"`
-	RetentionDays  *int    `json:"retention_days"`
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	Type              string  `json:"type"`
+	Host              string  `json:"host"`
+	Status            string  `json:"status"`
+	DatabaseSize      int64   `json:"database_size"`
+	LastBackupTime    *string `json:"last_backup_time"`
+	BackupEnabled     bool    `json:"backup_enabled"`
+	CronSchedule      *string `json:"cron_schedule"`
+	RetentionDays     *int    `json:"retention_days"`
+	RetentionEnforced *bool   `json:"retention_enforced,omitempty"` // Whether the schedule's retention deletes backups
 }
@@ -0,0 +1,585 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RDB opcodes that can appear between keys
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpFunction2    = 0xF5
+	rdbOpModuleAux    = 0xF7
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMs = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+)
+
+// RDB value types
+const (
+	rdbTypeString          = 0
+	rdbTypeList            = 1
+	rdbTypeSet             = 2
+	rdbTypeZset            = 3
+	rdbTypeHash            = 4
+	rdbTypeZset2           = 5
+	rdbTypeModule2         = 7
+	rdbTypeHashZipmap      = 9
+	rdbTypeListZiplist     = 10
+	rdbTypeSetIntset       = 11
+	rdbTypeZsetZiplist     = 12
+	rdbTypeHashZiplist     = 13
+	rdbTypeListQuicklist   = 14
+	rdbTypeStreamListpacks = 15
+	rdbTypeHashListpack    = 16
+	rdbTypeZsetListpack    = 17
+	rdbTypeListQuicklist2  = 18
+	rdbTypeStreamListpack2 = 19
+	rdbTypeSetListpack     = 20
+	rdbTypeStreamListpack3 = 21
+)
+
+// rdbEntry is a key read from an RDB snapshot. Payload is the value in the DUMP
+// format, so it can be loaded with RESTORE without decoding it.
+type rdbEntry struct {
+	DB       int
+	Key      string
+	Payload  []byte
+	ExpireAt int64 // Unix milliseconds, 0 when the key doesn't expire
+}
+
+// rdbReader walks the keys of an RDB snapshot, such as the one redis-cli --rdb writes
+type rdbReader struct {
+	r       *bufio.Reader
+	version uint16
+	db      int
+	capture *bytes.Buffer // raw bytes of the value being read
+}
+
+func newRDBReader(r io.Reader) (*rdbReader, error) {
+	reader := &rdbReader{r: bufio.NewReaderSize(r, 64*1024)}
+
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		return nil, fmt.Errorf("failed to read RDB header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("not an RDB file")
+	}
+
+	version, err := strconv.ParseUint(string(header[5:]), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+	reader.version = uint16(version)
+
+	return reader, nil
+}
+
+// Next returns the next key in the snapshot, or io.EOF once every key has been read
+func (p *rdbReader) Next() (*rdbEntry, error) {
+	var expireAt int64
+
+	for {
+		opcode, err := p.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case rdbOpEOF:
+			return nil, io.EOF
+		case rdbOpSelectDB:
+			db, err := p.readLength()
+			if err != nil {
+				return nil, err
+			}
+			p.db = int(db)
+		case rdbOpResizeDB:
+			err = p.skipLengths(2)
+		case rdbOpSlotInfo:
+			err = p.skipLengths(3)
+		case rdbOpAux:
+			if err = p.skipString(); err == nil {
+				err = p.skipString()
+			}
+		case rdbOpFunction2:
+			// Functions are server state rather than keys, so they aren't restored
+			err = p.skipString()
+		case rdbOpModuleAux:
+			if err = p.skipLengths(3); err == nil {
+				err = p.skipModuleValue()
+			}
+		case rdbOpFreq:
+			_, err = p.readByte()
+		case rdbOpIdle:
+			err = p.skipLengths(1)
+		case rdbOpExpireTime:
+			var seconds uint32
+			seconds, err = p.readUint32()
+			expireAt = int64(seconds) * 1000
+		case rdbOpExpireTimeMs:
+			var millis uint64
+			millis, err = p.readUint64()
+			expireAt = int64(millis)
+		default:
+			key, err := p.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read key: %w", err)
+			}
+
+			payload, err := p.readPayload(opcode)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read value of key %q: %w", key, err)
+			}
+
+			return &rdbEntry{DB: p.db, Key: string(key), Payload: payload, ExpireAt: expireAt}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readPayload reads a value and wraps it the way DUMP does: the type and raw value,
+// followed by the RDB version and a CRC64 of everything before it
+func (p *rdbReader) readPayload(valueType byte) ([]byte, error) {
+	p.capture = bytes.NewBuffer([]byte{valueType})
+	defer func() { p.capture = nil }()
+
+	if err := p.skipValue(valueType); err != nil {
+		return nil, err
+	}
+
+	payload := p.capture.Bytes()
+	payload = binary.LittleEndian.AppendUint16(payload, p.version)
+	payload = binary.LittleEndian.AppendUint64(payload, redisCRC64(payload))
+	return payload, nil
+}
+
+func (p *rdbReader) skipValue(valueType byte) error {
+	switch valueType {
+	case rdbTypeString, rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset,
+		rdbTypeZsetZiplist, rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZsetListpack,
+		rdbTypeSetListpack:
+		return p.skipString()
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		return p.skipStrings(1)
+	case rdbTypeHash:
+		return p.skipStrings(2)
+	case rdbTypeZset:
+		return p.skipCollection(func() error {
+			if err := p.skipString(); err != nil {
+				return err
+			}
+			// Scores are stored as text, with special lengths for NaN and infinities
+			length, err := p.readByte()
+			if err != nil || length >= 253 {
+				return err
+			}
+			return p.skipBytes(int(length))
+		})
+	case rdbTypeZset2:
+		return p.skipCollection(func() error {
+			if err := p.skipString(); err != nil {
+				return err
+			}
+			return p.skipBytes(8)
+		})
+	case rdbTypeListQuicklist2:
+		return p.skipCollection(func() error {
+			if err := p.skipLengths(1); err != nil {
+				return err
+			}
+			return p.skipString()
+		})
+	case rdbTypeModule2:
+		if err := p.skipLengths(1); err != nil {
+			return err
+		}
+		return p.skipModuleValue()
+	case rdbTypeStreamListpacks, rdbTypeStreamListpack2, rdbTypeStreamListpack3:
+		return p.skipStream(valueType)
+	default:
+		return fmt.Errorf("unsupported RDB value type %d", valueType)
+	}
+}
+
+// skipStream skips a stream: its listpacks, metadata and consumer groups
+func (p *rdbReader) skipStream(valueType byte) error {
+	if err := p.skipStrings(2); err != nil {
+		return err
+	}
+
+	// Length and last ID, then first ID, max deleted ID and entries added in newer versions
+	metadata := 3
+	if valueType >= rdbTypeStreamListpack2 {
+		metadata += 5
+	}
+	if err := p.skipLengths(metadata); err != nil {
+		return err
+	}
+
+	return p.skipCollection(func() error {
+		if err := p.skipString(); err != nil {
+			return err
+		}
+
+		groupMetadata := 2
+		if valueType >= rdbTypeStreamListpack2 {
+			groupMetadata++
+		}
+		if err := p.skipLengths(groupMetadata); err != nil {
+			return err
+		}
+
+		// Pending entries: raw ID, delivery time and delivery count
+		if err := p.skipCollection(func() error {
+			if err := p.skipBytes(16 + 8); err != nil {
+				return err
+			}
+			return p.skipLengths(1)
+		}); err != nil {
+			return err
+		}
+
+		// Consumers: name, seen time, active time in newer versions, and their pending IDs
+		return p.skipCollection(func() error {
+			if err := p.skipString(); err != nil {
+				return err
+			}
+			times := 8
+			if valueType >= rdbTypeStreamListpack3 {
+				times += 8
+			}
+			if err := p.skipBytes(times); err != nil {
+				return err
+			}
+			return p.skipCollection(func() error { return p.skipBytes(16) })
+		})
+	})
+}
+
+// skipModuleValue skips module data, which is a series of typed fields ending with EOF
+func (p *rdbReader) skipModuleValue() error {
+	for {
+		opcode, err := p.readLength()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case 0: // EOF
+			return nil
+		case 1, 2: // Signed and unsigned integers
+			err = p.skipLengths(1)
+		case 3: // Float
+			err = p.skipBytes(4)
+		case 4: // Double
+			err = p.skipBytes(8)
+		case 5: // String
+			err = p.skipString()
+		default:
+			return fmt.Errorf("unknown module opcode %d", opcode)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// skipCollection reads a length and calls skipItem that many times
+func (p *rdbReader) skipCollection(skipItem func() error) error {
+	count, err := p.readLength()
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		if err := skipItem(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipStrings skips a collection whose items are perItem strings each
+func (p *rdbReader) skipStrings(perItem int) error {
+	return p.skipCollection(func() error {
+		for i := 0; i < perItem; i++ {
+			if err := p.skipString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *rdbReader) skipLengths(count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := p.readLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readString reads a string, expanding integer and LZF encodings
+func (p *rdbReader) readString() ([]byte, error) {
+	length, encoded, err := p.readEncodedLength()
+	if err != nil {
+		return nil, err
+	}
+
+	if !encoded {
+		return p.readBytes(int(length))
+	}
+
+	switch length {
+	case 0:
+		b, err := p.readByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case 1:
+		buf, err := p.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case 2:
+		v, err := p.readUint32()
+		return []byte(strconv.Itoa(int(int32(v)))), err
+	case 3:
+		compressedLen, err := p.readLength()
+		if err != nil {
+			return nil, err
+		}
+		uncompressedLen, err := p.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := p.readBytes(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(uncompressedLen))
+	default:
+		return nil, fmt.Errorf("unknown string encoding %d", length)
+	}
+}
+
+func (p *rdbReader) skipString() error {
+	length, encoded, err := p.readEncodedLength()
+	if err != nil {
+		return err
+	}
+
+	if !encoded {
+		return p.skipBytes(int(length))
+	}
+
+	switch length {
+	case 0:
+		return p.skipBytes(1)
+	case 1:
+		return p.skipBytes(2)
+	case 2:
+		return p.skipBytes(4)
+	case 3:
+		compressedLen, err := p.readLength()
+		if err != nil {
+			return err
+		}
+		if err := p.skipLengths(1); err != nil {
+			return err
+		}
+		return p.skipBytes(int(compressedLen))
+	default:
+		return fmt.Errorf("unknown string encoding %d", length)
+	}
+}
+
+func (p *rdbReader) readLength() (uint64, error) {
+	length, encoded, err := p.readEncodedLength()
+	if err == nil && encoded {
+		return 0, fmt.Errorf("unexpected encoded length")
+	}
+	return length, err
+}
+
+// readEncodedLength reads a length. When encoded is true the value is a string
+// encoding type instead of a length.
+func (p *rdbReader) readEncodedLength() (length uint64, encoded bool, err error) {
+	first, err := p.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3F), false, nil
+	case 1:
+		next, err := p.readByte()
+		return uint64(first&0x3F)<<8 | uint64(next), false, err
+	case 2:
+		switch first {
+		case 0x80:
+			buf, err := p.readBytes(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := p.readBytes(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding 0x%x", first)
+	default:
+		return uint64(first & 0x3F), true, nil
+	}
+}
+
+func (p *rdbReader) readUint32() (uint32, error) {
+	buf, err := p.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+func (p *rdbReader) readUint64() (uint64, error) {
+	buf, err := p.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+func (p *rdbReader) readByte() (byte, error) {
+	b, err := p.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	if p.capture != nil {
+		p.capture.WriteByte(b)
+	}
+	return b, nil
+}
+
+func (p *rdbReader) readBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if p.capture != nil {
+		p.capture.Write(buf)
+	}
+	return buf, nil
+}
+
+func (p *rdbReader) skipBytes(n int) error {
+	var err error
+	if p.capture != nil {
+		_, err = io.CopyN(p.capture, p.r, int64(n))
+	} else {
+		_, err = p.r.Discard(n)
+	}
+	return unexpectedEOF(err)
+}
+
+// unexpectedEOF reports a snapshot that ends mid-record as truncated
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// lzfDecompress expands an LZF-compressed string
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes
+			end := i + ctrl + 1
+			if end > len(in) {
+				return nil, fmt.Errorf("corrupt LZF data")
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// Back reference
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("corrupt LZF data")
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("corrupt LZF data")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("corrupt LZF data")
+		}
+		// Copy byte by byte, since the reference can overlap the output
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, fmt.Errorf("corrupt LZF data: expected %d bytes, got %d", outLen, len(out))
+	}
+	return out, nil
+}
+
+// redisCRC64Table is for the reflected Jones polynomial Redis uses for DUMP payloads
+var redisCRC64Table = func() [256]uint64 {
+	const poly = 0x95AC9329AC4BC9B5
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// redisCRC64 is the CRC64 Redis checks on RESTORE. Unlike hash/crc64 it starts from
+// zero and doesn't invert the result.
+func redisCRC64(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc = redisCRC64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,287 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// rdbFixture builds an RDB snapshot byte by byte, the way Redis writes one, and
+// records the keys it holds
+type rdbFixture struct {
+	bytes.Buffer
+	version uint16
+	db      int
+	keys    []rdbFixtureKey
+}
+
+type rdbFixtureKey struct {
+	db        int
+	key       string
+	valueType byte
+	value     []byte
+	expireAt  int64
+}
+
+func newRDBFixture(version uint16) *rdbFixture {
+	f := &rdbFixture{version: version}
+	fmt.Fprintf(f, "REDIS%04d", version)
+	f.Write(rdbBytes([]byte{rdbOpAux}, rdbString("redis-ver"), rdbString("7.2.4")))
+	f.Write(rdbBytes([]byte{rdbOpAux}, rdbString("ctime"), []byte{0xC2, 0x80, 0x3B, 0x55, 0x69}))
+	return f
+}
+
+func (f *rdbFixture) selectDB(db int) {
+	f.db = db
+	f.Write(rdbBytes([]byte{rdbOpSelectDB}, rdbLength(db), []byte{rdbOpResizeDB}, rdbLength(10), rdbLength(1)))
+}
+
+// key adds a key; encodedKey is the key as written, which may be integer or LZF encoded
+func (f *rdbFixture) key(key string, encodedKey []byte, valueType byte, value []byte, expireAt int64) {
+	f.WriteByte(valueType)
+	f.Write(encodedKey)
+	f.Write(value)
+	f.keys = append(f.keys, rdbFixtureKey{db: f.db, key: key, valueType: valueType, value: value, expireAt: expireAt})
+}
+
+// end writes the EOF opcode and the snapshot checksum, which velld doesn't check
+func (f *rdbFixture) end() []byte {
+	f.WriteByte(rdbOpEOF)
+	f.Write(binary.LittleEndian.AppendUint64(nil, redisCRC64(f.Bytes())))
+	return f.Bytes()
+}
+
+// rdbLength encodes n the way Redis writes lengths
+func rdbLength(n int) []byte {
+	switch {
+	case n < 1<<6:
+		return []byte{byte(n)}
+	case n < 1<<14:
+		return []byte{0x40 | byte(n>>8), byte(n)}
+	case n < 1<<32:
+		return binary.BigEndian.AppendUint32([]byte{0x80}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{0x81}, uint64(n))
+	}
+}
+
+func rdbString(s string) []byte {
+	return append(rdbLength(len(s)), s...)
+}
+
+func rdbBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// listpack encodes short strings as a listpack, the blob Redis 7 stores small
+// hashes, sets, sorted sets, lists and stream entries in
+func listpack(items ...string) []byte {
+	var entries []byte
+	for _, item := range items {
+		entries = append(entries, 0x80|byte(len(item)))
+		entries = append(entries, item...)
+		entries = append(entries, byte(1+len(item)))
+	}
+	lp := binary.LittleEndian.AppendUint32(nil, uint32(6+len(entries)+1))
+	lp = binary.LittleEndian.AppendUint16(lp, uint16(len(items)))
+	lp = append(lp, entries...)
+	return append(lp, 0xFF)
+}
+
+// streamID is a raw stream ID, as stored in node keys and pending entry lists
+func streamID(ms, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, ms), seq)
+}
+
+// dumpPayload is what DUMP returns for a value: what RESTORE expects
+func dumpPayload(valueType byte, value []byte, version uint16) []byte {
+	payload := append([]byte{valueType}, value...)
+	payload = binary.LittleEndian.AppendUint16(payload, version)
+	return binary.LittleEndian.AppendUint64(payload, redisCRC64(payload))
+}
+
+// lzfRepeated is "abc" six times, LZF compressed as a literal run and a long,
+// overlapping back reference
+var lzfRepeated = []byte{0x02, 'a', 'b', 'c', 0xE0, 0x06, 0x02}
+
+func newValuesFixture() *rdbFixture {
+	f := newRDBFixture(11)
+	f.Write(rdbBytes([]byte{rdbOpFunction2}, rdbString("#!lua name=lib\nredis.register_function('f', function() return 1 end)")))
+	f.Write(rdbBytes([]byte{rdbOpModuleAux}, []byte{0x81, 0, 0, 0, 0, 0, 0, 0x12, 0x34}, rdbLength(2), rdbLength(2),
+		rdbLength(2), rdbLength(5), rdbLength(5), rdbString("module state"), rdbLength(0)))
+
+	f.selectDB(0)
+	f.key("greeting", rdbString("greeting"), rdbTypeString, rdbString("hello"), 0)
+	f.key("counter", rdbString("counter"), rdbTypeString, []byte{0xC1, 0xE8, 0x03}, 0)
+	f.key("large", rdbString("large"), rdbTypeString, rdbString(strings.Repeat("x", 20000)), 0)
+	f.key("compressed", rdbString("compressed"), rdbTypeString, rdbBytes([]byte{0xC3}, rdbLength(len(lzfRepeated)), rdbLength(18), lzfRepeated), 0)
+	f.key(strings.Repeat("abc", 6), rdbBytes([]byte{0xC3}, rdbLength(len(lzfRepeated)), rdbLength(18), lzfRepeated), rdbTypeString, rdbString("lzf key"), 0)
+	f.key("-5", []byte{0xC0, 0xFB}, rdbTypeString, rdbString("int8 key"), 0)
+	f.key("-70000", []byte{0xC2, 0x90, 0xEE, 0xFE, 0xFF}, rdbTypeString, rdbString("int32 key"), 0)
+
+	// Expiry in milliseconds, with LRU and LFU info in between
+	f.Write(binary.LittleEndian.AppendUint64([]byte{rdbOpExpireTimeMs}, 1767225600123))
+	f.Write(rdbBytes([]byte{rdbOpIdle}, rdbLength(300), []byte{rdbOpFreq, 5}))
+	f.key("session", rdbString("session"), rdbTypeString, rdbString("token"), 1767225600123)
+	// Expiry in seconds, from RDB versions before 3
+	f.Write(binary.LittleEndian.AppendUint32([]byte{rdbOpExpireTime}, 1767225600))
+	f.key("legacy-session", rdbString("legacy-session"), rdbTypeString, rdbString("token"), 1767225600000)
+
+	f.key("queue", rdbString("queue"), rdbTypeList, rdbBytes(rdbLength(2), rdbString("a"), rdbString("b")), 0)
+	f.key("packed-queue", rdbString("packed-queue"), rdbTypeListQuicklist2, rdbBytes(rdbLength(2),
+		rdbLength(2), rdbString(string(listpack("a", "b"))),
+		rdbLength(1), rdbString("plain node"),
+	), 0)
+	f.key("tags", rdbString("tags"), rdbTypeSet, rdbBytes(rdbLength(2), rdbString("red"), rdbString("blue")), 0)
+	f.key("ids", rdbString("ids"), rdbTypeSetIntset, rdbString("\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\x02\x00"), 0)
+	f.key("packed-tags", rdbString("packed-tags"), rdbTypeSetListpack, rdbString(string(listpack("red", "blue"))), 0)
+	f.key("user", rdbString("user"), rdbTypeHash, rdbBytes(rdbLength(1), rdbString("name"), rdbString("velld")), 0)
+	f.key("packed-user", rdbString("packed-user"), rdbTypeHashListpack, rdbString(string(listpack("name", "velld"))), 0)
+	f.key("scores", rdbString("scores"), rdbTypeZset, rdbBytes(rdbLength(2),
+		rdbString("alice"), rdbString("1.5"),
+		rdbString("bob"), []byte{253}, // NaN has no digits
+	), 0)
+	f.key("ranks", rdbString("ranks"), rdbTypeZset2, rdbBytes(rdbLength(1),
+		rdbString("alice"), binary.LittleEndian.AppendUint64(nil, 0x3FF8000000000000),
+	), 0)
+	f.key("packed-ranks", rdbString("packed-ranks"), rdbTypeZsetListpack, rdbString(string(listpack("alice", "1.5"))), 0)
+	f.key("events", rdbString("events"), rdbTypeStreamListpack3, rdbBytes(
+		rdbLength(1), rdbString(string(streamID(1767225600000, 0))), rdbString(string(listpack("1", "0", "type", "login"))),
+		rdbLength(1), rdbLength(1767225600000), rdbLength(0), // length and last ID
+		rdbLength(1767225600000), rdbLength(0), rdbLength(0), rdbLength(0), rdbLength(1), // first ID, max deleted ID, entries added
+		rdbLength(1), rdbString("workers"), rdbLength(1767225600000), rdbLength(0), rdbLength(1), // group, last ID, entries read
+		rdbLength(1), streamID(1767225600000, 0), binary.LittleEndian.AppendUint64(nil, 1767225600500), rdbLength(1),
+		rdbLength(1), rdbString("alice"), binary.LittleEndian.AppendUint64(nil, 1767225600500), binary.LittleEndian.AppendUint64(nil, 1767225600500), // seen and active times
+		rdbLength(1), streamID(1767225600000, 0),
+	), 0)
+
+	f.selectDB(3)
+	f.key("greeting", rdbString("greeting"), rdbTypeString, rdbString("hello from 3"), 0)
+	return f
+}
+
+func TestRDBReaderReadsEveryValueType(t *testing.T) {
+	f := newValuesFixture()
+	reader, err := newRDBReader(bytes.NewReader(f.end()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range f.keys {
+		entry, err := reader.Next()
+		if err != nil {
+			t.Fatalf("reading key %q: %v", want.key, err)
+		}
+		if entry.Key != want.key || entry.DB != want.db || entry.ExpireAt != want.expireAt {
+			t.Errorf("read key %q in DB %d expiring at %d, want %q in DB %d expiring at %d",
+				entry.Key, entry.DB, entry.ExpireAt, want.key, want.db, want.expireAt)
+		}
+		if payload := dumpPayload(want.valueType, want.value, f.version); !bytes.Equal(entry.Payload, payload) {
+			t.Errorf("key %q: payload %q, want %q", want.key, entry.Payload, payload)
+		}
+	}
+	if entry, err := reader.Next(); err != io.EOF {
+		t.Errorf("after the last key read %v, %v, want EOF", entry, err)
+	}
+}
+
+func TestRDBReaderPayloadMatchesDump(t *testing.T) {
+	// SET mykey 10 followed by DUMP mykey on Redis 5, as documented for DUMP
+	f := newRDBFixture(9)
+	f.selectDB(0)
+	f.key("mykey", rdbString("mykey"), rdbTypeString, []byte{0xC0, 0x0A}, 0)
+
+	reader, err := newRDBReader(bytes.NewReader(f.end()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"; string(entry.Payload) != want {
+		t.Errorf("payload %q, want %q", entry.Payload, want)
+	}
+}
+
+func TestRDBReaderRejectsDamagedSnapshots(t *testing.T) {
+	snapshot := newValuesFixture().end()
+
+	if _, err := newRDBReader(strings.NewReader("SQLite format 3\x00")); err == nil {
+		t.Error("read a file that isn't an RDB snapshot")
+	}
+
+	unknown := newRDBFixture(11)
+	unknown.selectDB(0)
+	unknown.key("module", rdbString("module"), 6, nil, 0)
+	reader, err := newRDBReader(bytes.NewReader(unknown.end()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil || !strings.Contains(err.Error(), "unsupported RDB value type") {
+		t.Errorf("reading an unsupported value type returned %v", err)
+	}
+
+	// Cut inside the last key, a snapshot ends with an error rather than EOF
+	reader, err = newRDBReader(bytes.NewReader(snapshot[:len(snapshot)-20]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err := reader.Next()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated snapshot ended with %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		break
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []byte
+		outLen int
+		want   string
+	}{
+		{"literal", []byte{0x04, 'v', 'e', 'l', 'l', 'd'}, 5, "velld"},
+		{"short back reference", []byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, 6, "abcabc"},
+		{"long overlapping back reference", lzfRepeated, 18, strings.Repeat("abc", 6)},
+		{"back reference between literals", []byte{0x01, 'a', 'b', 0x20, 0x01, 0x00, '!'}, 6, "ababa!"},
+	}
+	for _, tt := range tests {
+		out, err := lzfDecompress(tt.in, tt.outLen)
+		if err != nil || string(out) != tt.want {
+			t.Errorf("%s: decompressed to %q, %v, want %q", tt.name, out, err, tt.want)
+		}
+	}
+
+	corrupt := map[string][]byte{
+		"truncated literal":           {0x04, 'v', 'e'},
+		"reference before the start":  {0x00, 'a', 0x20, 0x05},
+		"missing reference offset":    {0x00, 'a', 0x20},
+		"missing reference length":    {0x00, 'a', 0xE0},
+		"longer than the stored size": {0x02, 'a', 'b', 'c', 0x20, 0x02, 0x20, 0x02},
+	}
+	for name, in := range corrupt {
+		if out, err := lzfDecompress(in, 6); err == nil {
+			t.Errorf("%s: decompressed to %q without an error", name, out)
+		}
+	}
+}
+
+func TestRedisCRC64(t *testing.T) {
+	// The CRC-64/Jones check value, as in Redis' own crc64 test
+	if got := redisCRC64([]byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("CRC of 123456789 is %x, want e9c6d914c4b8d9ca", got)
+	}
+	if got := redisCRC64(nil); got != 0 {
+		t.Errorf("CRC of nothing is %x, want 0", got)
+	}
+}
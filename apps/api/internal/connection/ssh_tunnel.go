@@ -1,6 +1,8 @@
 package connection
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -36,6 +38,90 @@ func NewSSHTunnel(sshHost string, sshPort int, sshUsername, sshPassword, sshPriv
 		return nil, fmt.Errorf("failed to resolve local address: %w", err)
 	}
 
+	config, err := newSSHClientConfig(sshUsername, sshPassword, sshPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHTunnel{
+		Local:  localAddr,
+		Server: serverAddr,
+		Remote: remoteAddr,
+		Config: config,
+	}, nil
+}
+
+// DialSSH opens an SSH client connection, used where a command has to run on the
+// SSH host itself rather than through a forwarded port
+func DialSSH(sshHost string, sshPort int, sshUsername, sshPassword, sshPrivateKey string) (*ssh.Client, error) {
+	config, err := newSSHClientConfig(sshUsername, sshPassword, sshPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(sshHost, fmt.Sprintf("%d", sshPort)), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial SSH server: %w", err)
+	}
+	return client, nil
+}
+
+// DialSSHWithHostKey is DialSSH for a server whose host key is known; the
+// connection fails if the server presents any other key
+func DialSSHWithHostKey(sshHost string, sshPort int, sshUsername, sshPassword, sshPrivateKey string, hostKey ssh.PublicKey) (*ssh.Client, error) {
+	config, err := newSSHClientConfig(sshUsername, sshPassword, sshPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), hostKey.Marshal()) {
+			return fmt.Errorf("host key of %s changed: got %s, expected %s", hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(hostKey))
+		}
+		return nil
+	}
+	config.HostKeyAlgorithms = hostKeyAlgorithms(hostKey)
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(sshHost, fmt.Sprintf("%d", sshPort)), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial SSH server: %w", err)
+	}
+	return client, nil
+}
+
+// FetchSSHHostKey returns the host key an SSH server presents, without logging in
+func FetchSSHHostKey(sshHost string, sshPort int) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyFetched
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	client, err := ssh.Dial("tcp", net.JoinHostPort(sshHost, fmt.Sprintf("%d", sshPort)), config)
+	if err == nil {
+		client.Close()
+	}
+	if hostKey == nil {
+		return nil, fmt.Errorf("failed to get SSH host key: %w", err)
+	}
+	return hostKey, nil
+}
+
+// errHostKeyFetched ends the handshake of FetchSSHHostKey once it has the key
+var errHostKeyFetched = errors.New("host key fetched")
+
+// hostKeyAlgorithms returns the algorithms a server can prove it holds hostKey
+// with, so one that has several host keys presents that one
+func hostKeyAlgorithms(hostKey ssh.PublicKey) []string {
+	if hostKey.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{hostKey.Type()}
+}
+
+func newSSHClientConfig(sshUsername, sshPassword, sshPrivateKey string) (*ssh.ClientConfig, error) {
 	var authMethods []ssh.AuthMethod
 
 	if sshPassword != "" {
@@ -54,18 +140,11 @@ func NewSSHTunnel(sshHost string, sshPort int, sshUsername, sshPassword, sshPriv
 		return nil, fmt.Errorf("no SSH authentication method provided (password or private key required)")
 	}
 
-	config := &ssh.ClientConfig{
+	return &ssh.ClientConfig{
 		User:            sshUsername,
 		Auth:            authMethods,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Add proper host key verification
 		Timeout:         10 * time.Second,
-	}
-
-	return &SSHTunnel{
-		Local:  localAddr,
-		Server: serverAddr,
-		Remote: remoteAddr,
-		Config: config,
 	}, nil
 }
 
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding format field to backups table';
+
+-- Dump format written by the database driver (e.g. custom or plain for PostgreSQL)
+-- NULL for backups created before the format was recorded
+ALTER TABLE backups ADD COLUMN format TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing format field from backups table';
+
+ALTER TABLE backups DROP COLUMN format;
+
+-- +goose StatementEnd
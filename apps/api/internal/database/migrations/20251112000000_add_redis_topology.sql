@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding Redis topology fields to connections table';
+
+-- standalone, sentinel or cluster; NULL is treated as standalone
+ALTER TABLE connections ADD COLUMN redis_mode TEXT;
+-- Name of the monitored master for sentinel connections
+ALTER TABLE connections ADD COLUMN redis_master_name TEXT;
+-- Comma-separated host:port list of additional sentinels or cluster seed nodes
+ALTER TABLE connections ADD COLUMN redis_nodes TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing Redis topology fields from connections table';
+
+ALTER TABLE connections DROP COLUMN redis_mode;
+ALTER TABLE connections DROP COLUMN redis_master_name;
+ALTER TABLE connections DROP COLUMN redis_nodes;
+
+-- +goose StatementEnd
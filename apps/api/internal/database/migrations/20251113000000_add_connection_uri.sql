@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding connection_uri field to connections table';
+
+-- Full connection string (e.g. mongodb+srv://...), encrypted like the password
+-- Used instead of host and port when set
+ALTER TABLE connections ADD COLUMN connection_uri TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing connection_uri field from connections table';
+
+ALTER TABLE connections DROP COLUMN connection_uri;
+
+-- +goose StatementEnd
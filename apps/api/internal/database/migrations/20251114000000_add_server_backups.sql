@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding all-databases backup fields to connections and backups tables';
+
+-- Back up every database on the server instead of database_name
+ALTER TABLE connections ADD COLUMN all_databases INTEGER DEFAULT 0;
+
+-- Comma-separated glob patterns choosing which databases are backed up
+ALTER TABLE connections ADD COLUMN include_databases TEXT;
+ALTER TABLE connections ADD COLUMN exclude_databases TEXT;
+
+-- Per-database backups of an all-databases run point at the run's backup
+ALTER TABLE backups ADD COLUMN parent_id TEXT REFERENCES backups(id);
+
+-- Database a child backup was taken from, empty for server-wide objects
+ALTER TABLE backups ADD COLUMN database_name TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_backups_parent_id ON backups(parent_id);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing all-databases backup fields from connections and backups tables';
+
+DROP INDEX IF EXISTS idx_backups_parent_id;
+ALTER TABLE backups DROP COLUMN database_name;
+ALTER TABLE backups DROP COLUMN parent_id;
+ALTER TABLE connections DROP COLUMN exclude_databases;
+ALTER TABLE connections DROP COLUMN include_databases;
+ALTER TABLE connections DROP COLUMN all_databases;
+
+-- +goose StatementEnd
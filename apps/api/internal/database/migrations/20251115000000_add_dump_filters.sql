@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding dump_filters field to connections and backups tables';
+
+-- Schema and table include/exclude rules applied to dumps, as JSON
+ALTER TABLE connections ADD COLUMN dump_filters TEXT;
+
+-- The rules a backup was taken with, kept for auditing
+ALTER TABLE backups ADD COLUMN dump_filters TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing dump_filters field from connections and backups tables';
+
+ALTER TABLE backups DROP COLUMN dump_filters;
+ALTER TABLE connections DROP COLUMN dump_filters;
+
+-- +goose StatementEnd
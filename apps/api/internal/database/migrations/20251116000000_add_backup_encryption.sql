@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding encryption fields to connections and backups tables';
+
+-- Encrypt uploaded backups of the connection
+ALTER TABLE connections ADD COLUMN encrypt_backups INTEGER DEFAULT 0;
+
+-- Algorithm and master key the backup's object was encrypted with
+ALTER TABLE backups ADD COLUMN encryption_algorithm TEXT;
+ALTER TABLE backups ADD COLUMN encryption_key_id TEXT;
+
+-- Per-backup data key, wrapped with the master key
+ALTER TABLE backups ADD COLUMN encrypted_data_key TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing encryption fields from connections and backups tables';
+
+ALTER TABLE backups DROP COLUMN encrypted_data_key;
+ALTER TABLE backups DROP COLUMN encryption_key_id;
+ALTER TABLE backups DROP COLUMN encryption_algorithm;
+ALTER TABLE connections DROP COLUMN encrypt_backups;
+
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding backup recipients to connections table';
+
+-- JSON list of age or OpenPGP public keys the connection's backups are encrypted to
+ALTER TABLE connections ADD COLUMN backup_recipients TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing backup recipients from connections table';
+
+ALTER TABLE connections DROP COLUMN backup_recipients;
+
+-- +goose StatementEnd
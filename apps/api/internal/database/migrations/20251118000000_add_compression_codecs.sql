@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding compression codecs to connections and backups tables';
+
+-- Codec and level the connection's uploads are compressed with; NULL keeps the default
+ALTER TABLE connections ADD COLUMN compression_codec TEXT;
+ALTER TABLE connections ADD COLUMN compression_level INTEGER DEFAULT 0;
+
+-- Codec and level a backup's uploaded objects were compressed with
+ALTER TABLE backups ADD COLUMN compression TEXT;
+ALTER TABLE backups ADD COLUMN compression_level INTEGER;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing compression codecs from connections and backups tables';
+
+ALTER TABLE backups DROP COLUMN compression_level;
+ALTER TABLE backups DROP COLUMN compression;
+ALTER TABLE connections DROP COLUMN compression_level;
+ALTER TABLE connections DROP COLUMN compression_codec;
+
+-- +goose StatementEnd
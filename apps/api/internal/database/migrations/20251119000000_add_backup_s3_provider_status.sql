@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding upload status to backup_s3_providers table';
+
+-- Whether the upload to the provider succeeded, how many bytes it stored and why it failed
+ALTER TABLE backup_s3_providers ADD COLUMN status TEXT NOT NULL DEFAULT 'success';
+ALTER TABLE backup_s3_providers ADD COLUMN size INTEGER DEFAULT 0;
+ALTER TABLE backup_s3_providers ADD COLUMN error TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing upload status from backup_s3_providers table';
+
+ALTER TABLE backup_s3_providers DROP COLUMN error;
+ALTER TABLE backup_s3_providers DROP COLUMN size;
+ALTER TABLE backup_s3_providers DROP COLUMN status;
+
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding SFTP destinations to s3_providers table';
+
+-- Kind of destination: 's3' or 'sftp'
+ALTER TABLE s3_providers ADD COLUMN type TEXT NOT NULL DEFAULT 's3';
+
+-- SFTP server and login; password and private_key are encrypted. Backups are stored
+-- under path_prefix on the server.
+ALTER TABLE s3_providers ADD COLUMN host TEXT NOT NULL DEFAULT '';
+ALTER TABLE s3_providers ADD COLUMN port INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE s3_providers ADD COLUMN username TEXT NOT NULL DEFAULT '';
+ALTER TABLE s3_providers ADD COLUMN password TEXT NOT NULL DEFAULT '';
+ALTER TABLE s3_providers ADD COLUMN private_key TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing SFTP destinations from s3_providers table';
+
+ALTER TABLE s3_providers DROP COLUMN private_key;
+ALTER TABLE s3_providers DROP COLUMN password;
+ALTER TABLE s3_providers DROP COLUMN username;
+ALTER TABLE s3_providers DROP COLUMN port;
+ALTER TABLE s3_providers DROP COLUMN host;
+ALTER TABLE s3_providers DROP COLUMN type;
+
+-- +goose StatementEnd
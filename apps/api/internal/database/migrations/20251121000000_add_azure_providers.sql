@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding Azure Blob Storage destinations to s3_providers table';
+
+-- Azure providers keep the account name in access_key, the account key in secret_key
+-- and the container in bucket; a SAS token (encrypted) can replace the account key
+ALTER TABLE s3_providers ADD COLUMN sas_token TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing Azure Blob Storage destinations from s3_providers table';
+
+ALTER TABLE s3_providers DROP COLUMN sas_token;
+
+-- +goose StatementEnd
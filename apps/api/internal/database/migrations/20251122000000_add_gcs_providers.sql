@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding Google Cloud Storage destinations to s3_providers table';
+
+-- Service-account key (encrypted) GCS providers authenticate with
+ALTER TABLE s3_providers ADD COLUMN credentials_json TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing Google Cloud Storage destinations from s3_providers table';
+
+ALTER TABLE s3_providers DROP COLUMN credentials_json;
+
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding grandfather-father-son retention rules to backup_schedules table';
+
+-- Newest backups always kept, whatever their age
+ALTER TABLE backup_schedules ADD COLUMN keep_last INTEGER NOT NULL DEFAULT 0;
+
+-- Number of days, weeks, months and years whose newest backup is kept
+ALTER TABLE backup_schedules ADD COLUMN keep_daily INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backup_schedules ADD COLUMN keep_weekly INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backup_schedules ADD COLUMN keep_monthly INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backup_schedules ADD COLUMN keep_yearly INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing grandfather-father-son retention rules from backup_schedules table';
+
+ALTER TABLE backup_schedules DROP COLUMN keep_last;
+ALTER TABLE backup_schedules DROP COLUMN keep_daily;
+ALTER TABLE backup_schedules DROP COLUMN keep_weekly;
+ALTER TABLE backup_schedules DROP COLUMN keep_monthly;
+ALTER TABLE backup_schedules DROP COLUMN keep_yearly;
+
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding pins and legal holds to backups and Object Lock to s3_providers table';
+
+-- Pinned backups are kept forever, held backups until hold_until; retention skips both
+ALTER TABLE backups ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backups ADD COLUMN hold_until TEXT;
+ALTER TABLE backups ADD COLUMN pin_reason TEXT;
+ALTER TABLE backups ADD COLUMN pinned_by TEXT;
+ALTER TABLE backups ADD COLUMN pinned_at TEXT;
+
+-- Object Lock retention (GOVERNANCE or COMPLIANCE) applied to every upload of an S3
+-- provider, for object_lock_days
+ALTER TABLE s3_providers ADD COLUMN object_lock_mode TEXT NOT NULL DEFAULT '';
+ALTER TABLE s3_providers ADD COLUMN object_lock_days INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing pins and legal holds from backups and Object Lock from s3_providers table';
+
+ALTER TABLE backups DROP COLUMN pinned;
+ALTER TABLE backups DROP COLUMN hold_until;
+ALTER TABLE backups DROP COLUMN pin_reason;
+ALTER TABLE backups DROP COLUMN pinned_by;
+ALTER TABLE backups DROP COLUMN pinned_at;
+
+ALTER TABLE s3_providers DROP COLUMN object_lock_mode;
+ALTER TABLE s3_providers DROP COLUMN object_lock_days;
+
+-- +goose StatementEnd
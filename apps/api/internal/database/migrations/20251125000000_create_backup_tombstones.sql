@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Stored objects of deleted backups that couldn't be deleted yet. The backup row is
+-- gone, so backup_id has no reference; deletion is retried at next_attempt_at.
+CREATE TABLE IF NOT EXISTS backup_tombstones (
+    id TEXT PRIMARY KEY,
+    backup_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    s3_provider_id TEXT NOT NULL,
+    s3_object_key TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    last_error TEXT,
+    next_attempt_at TEXT NOT NULL,
+    created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(s3_provider_id, s3_object_key)
+);
+
+CREATE INDEX idx_backup_tombstones_user_id ON backup_tombstones(user_id);
+CREATE INDEX idx_backup_tombstones_next_attempt_at ON backup_tombstones(next_attempt_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_backup_tombstones_next_attempt_at;
+DROP INDEX IF EXISTS idx_backup_tombstones_user_id;
+DROP TABLE IF EXISTS backup_tombstones;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The schedule snapshotting velld's own database to a provider. There is only one,
+-- owned by the user whose provider it uploads to, so id is always 1.
+CREATE TABLE IF NOT EXISTS self_backup_settings (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    user_id TEXT NOT NULL,
+    s3_provider_id TEXT NOT NULL,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    cron_schedule TEXT NOT NULL,
+    retention_days INTEGER NOT NULL DEFAULT 0,
+    keep_last INTEGER NOT NULL DEFAULT 0,
+    keep_daily INTEGER NOT NULL DEFAULT 0,
+    keep_weekly INTEGER NOT NULL DEFAULT 0,
+    keep_monthly INTEGER NOT NULL DEFAULT 0,
+    keep_yearly INTEGER NOT NULL DEFAULT 0,
+    last_run_at TEXT,
+    last_status TEXT,
+    last_error TEXT,
+    last_object_key TEXT,
+    last_size INTEGER,
+    updated_at TEXT DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS self_backup_settings;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding retention_enforced to backup_schedules table';
+
+-- Retention only deletes backups of schedules that enforce it. Until now it looked
+-- for a status no backup has and never deleted anything, so existing schedules
+-- keep not deleting until their owner turns it on.
+ALTER TABLE backup_schedules ADD COLUMN retention_enforced INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing retention_enforced from backup_schedules table';
+
+ALTER TABLE backup_schedules DROP COLUMN retention_enforced;
+
+-- +goose StatementEnd
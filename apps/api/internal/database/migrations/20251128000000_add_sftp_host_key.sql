@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'Adding SFTP host keys to s3_providers table';
+
+-- Public key of an SFTP server in authorized_keys format. Connections presenting
+-- another key are refused; an empty key is trusted on first use and stored here.
+ALTER TABLE s3_providers ADD COLUMN host_key TEXT NOT NULL DEFAULT '';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'Removing SFTP host keys from s3_providers table';
+
+ALTER TABLE s3_providers DROP COLUMN host_key;
+
+-- +goose StatementEnd
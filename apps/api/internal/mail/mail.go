@@ -23,7 +23,7 @@ type Message struct {
 }
 
 func SendEmail(config *SMTPConfig, msg *Message) error {
-	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	addr := net.JoinHostPort(config.Host, fmt.Sprintf("%d", config.Port))
 	auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
 
 	emailMsg := fmt.Sprintf("From: %s\r\n"+
@@ -12,6 +12,8 @@ type NotificationType string
 const (
 	BackupFailed    NotificationType = "backup_failed"
 	BackupCompleted NotificationType = "backup_completed"
+	// BackupPartial backups were stored, but not on every provider
+	BackupPartial NotificationType = "backup_partial"
 )
 
 type NotificationStatus string
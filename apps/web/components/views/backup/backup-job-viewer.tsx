@@ -65,7 +65,7 @@ export function BackupJobViewer({ open, onOpenChange, backupId, backup: initialB
       console.log("Backup status in BackupJobViewer:", { 
         id: backup.id, 
         status: backup.status, 
-        canDownload: backup.status === "completed" || backup.status === "success" 
+        canDownload: backup.status === "completed" || backup.status === "success" || backup.status === "partial"
       });
     }
   }, [backup]);
@@ -481,7 +481,7 @@ export function BackupJobViewer({ open, onOpenChange, backupId, backup: initialB
                   <Copy className="h-4 w-4 mr-2" />
                   Copy
                 </Button>
-                {backup && (backup.status === "completed" || backup.status === "success") && (
+                {backup && (backup.status === "completed" || backup.status === "success" || backup.status === "partial") && (
                   <Button 
                     type="button"
                     variant="outline" 
@@ -148,7 +148,8 @@ export function DownloadBackupDialog({ open, onOpenChange, backupId, backupPath
     }
   };
 
-  const availableProviders = backupProviders || [];
+  const availableProviders = (backupProviders || []).filter((bp) => bp.status !== 'failed');
+  const failedProviders = (backupProviders || []).filter((bp) => bp.status === 'failed');
 
   return (
     <Dialog open={open} onOpenChange={onOpenChange}>
@@ -182,6 +183,11 @@ export function DownloadBackupDialog({ open, onOpenChange, backupId, backupPath
               <p className="text-xs text-muted-foreground">
                 {availableProviders.length} provider{availableProviders.length !== 1 ? 's' : ''} available
               </p>
+              {failedProviders.map((bp) => (
+                <p key={bp.provider_id} className="text-xs text-destructive">
+                  Upload to {getProviderName(bp.provider_id)} failed{bp.error ? `: ${bp.error}` : ''}
+                </p>
+              ))}
             </div>
           ) : (
             <div className="text-sm text-muted-foreground">
@@ -0,0 +1,63 @@
+'use client';
+
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from "@/components/ui/select";
+import { CompressionCodec } from "@/types/connection";
+
+interface BackupCompressionFieldProps {
+  codec?: CompressionCodec;
+  level?: number;
+  onChange: (codec: CompressionCodec, level: number) => void;
+  idPrefix?: string;
+}
+
+// Highest level of each codec that has levels
+const MAX_LEVELS: Record<string, number> = {
+  gzip: 9,
+  zstd: 22,
+};
+
+export function BackupCompressionField({ codec, level, onChange, idPrefix = "" }: BackupCompressionFieldProps) {
+  const maxLevel = codec ? MAX_LEVELS[codec] : undefined;
+
+  return (
+    <div className="space-y-2 rounded-lg border p-3">
+      <div className="space-y-0.5">
+        <Label htmlFor={`${idPrefix}compression-codec`}>Compression</Label>
+        <p className="text-xs text-muted-foreground">
+          Codec uploaded backups are compressed with. By default streamed dumps are gzipped, except PostgreSQL archives pg_dump already compresses, and dump files are uploaded as they are.
+        </p>
+      </div>
+      <div className="flex gap-2">
+        <Select
+          value={codec || "default"}
+          onValueChange={(value) => onChange(value === "default" ? "" : value as CompressionCodec, 0)}
+        >
+          <SelectTrigger id={`${idPrefix}compression-codec`} className="flex-1">
+            <SelectValue />
+          </SelectTrigger>
+          <SelectContent>
+            <SelectItem value="default">Default</SelectItem>
+            <SelectItem value="gzip">gzip</SelectItem>
+            <SelectItem value="zstd">zstd</SelectItem>
+            <SelectItem value="lz4">LZ4</SelectItem>
+            <SelectItem value="none">None</SelectItem>
+          </SelectContent>
+        </Select>
+        {maxLevel && (
+          <Input
+            id={`${idPrefix}compression-level`}
+            type="number"
+            min={0}
+            max={maxLevel}
+            placeholder={`Level (1-${maxLevel})`}
+            value={level || ""}
+            onChange={(e) => onChange(codec || "", parseInt(e.target.value) || 0)}
+            className="w-36"
+          />
+        )}
+      </div>
+    </div>
+  );
+}
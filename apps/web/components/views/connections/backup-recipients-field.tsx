@@ -0,0 +1,61 @@
+'use client';
+
+import { useEffect, useState } from "react";
+import { Label } from "@/components/ui/label";
+
+interface BackupRecipientsFieldProps {
+  value?: string[];
+  onChange: (value: string[]) => void;
+  idPrefix?: string;
+}
+
+const PGP_BLOCK = /-----BEGIN PGP PUBLIC KEY BLOCK-----[\s\S]*?-----END PGP PUBLIC KEY BLOCK-----/g;
+
+// Splits pasted keys into armored OpenPGP blocks and age recipients, one per line
+function parseRecipients(text: string): string[] {
+  const blocks = text.match(PGP_BLOCK) || [];
+  const lines = text
+    .replace(PGP_BLOCK, '')
+    .split('\n')
+    .map((line) => line.trim())
+    .filter((line) => line && !line.startsWith('#'));
+  return [...blocks, ...lines];
+}
+
+export function BackupRecipientsField({ value, onChange, idPrefix = "" }: BackupRecipientsFieldProps) {
+  const [text, setText] = useState((value || []).join('\n'));
+
+  // Keep what is being typed, but pick up keys loaded from elsewhere
+  useEffect(() => {
+    if (parseRecipients(text).join('\n') !== (value || []).join('\n')) {
+      setText((value || []).join('\n'));
+    }
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, [value]);
+
+  return (
+    <div className="space-y-2 rounded-lg border p-3">
+      <div className="space-y-0.5">
+        <Label htmlFor={`${idPrefix}backup-recipients`}>
+          Backup Recipients
+          <span className="text-xs text-muted-foreground ml-1">(optional)</span>
+        </Label>
+        <p className="text-xs text-muted-foreground">
+          age public keys, one per line, or ASCII-armored OpenPGP public keys. Uploaded backups are encrypted to them,
+          so the server can&apos;t read them and the private key must be given to restore.
+        </p>
+      </div>
+      <textarea
+        id={`${idPrefix}backup-recipients`}
+        placeholder="age1... or -----BEGIN PGP PUBLIC KEY BLOCK-----"
+        value={text}
+        onChange={(e) => {
+          setText(e.target.value);
+          onChange(parseRecipients(e.target.value));
+        }}
+        rows={4}
+        className="w-full px-3 py-2 text-sm rounded-md border border-input bg-background font-mono"
+      />
+    </div>
+  );
+}
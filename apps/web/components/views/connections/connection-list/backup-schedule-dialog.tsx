@@ -59,12 +59,15 @@ export function BackupScheduleDialog({
 
   const [schedule, setSchedule] = useState(getScheduleFrequency(connection?.cron_schedule) || 'daily');
   const [retention, setRetention] = useState(getRetentionFromDays(connection?.retention_days));
+  // New schedules enforce retention; older ones only once it is turned on
+  const [enforced, setEnforced] = useState(connection?.retention_enforced ?? true);
 
   useEffect(() => {
     if (connection) {
       setEnabled(connection.backup_enabled);
       setSchedule(getScheduleFrequency(connection.cron_schedule) || 'daily');
       setRetention(getRetentionFromDays(connection.retention_days));
+      setEnforced(connection.retention_enforced ?? true);
     }
   }, [connection]);
 
@@ -90,7 +93,7 @@ export function BackupScheduleDialog({
     }
   };
 
-  const handleScheduleSubmit = async (newSchedule: string, newRetention: string) => {
+  const handleScheduleSubmit = async (newSchedule: string, newRetention: string, newEnforced: boolean = enforced) => {
     try {
       if (enabled) {
         // Update existing schedule
@@ -98,7 +101,8 @@ export function BackupScheduleDialog({
           connectionId,
           params: {
             cron_schedule: CRON_SCHEDULES[newSchedule as keyof typeof CRON_SCHEDULES],
-            retention_days: RETENTION_DAYS[newRetention as keyof typeof RETENTION_DAYS]
+            retention_days: RETENTION_DAYS[newRetention as keyof typeof RETENTION_DAYS],
+            enforce_retention: newEnforced
           }
         });
       } else {
@@ -106,12 +110,14 @@ export function BackupScheduleDialog({
         await createSchedule({
           connection_id: connectionId,
           cron_schedule: CRON_SCHEDULES[newSchedule as keyof typeof CRON_SCHEDULES],
-          retention_days: RETENTION_DAYS[newRetention as keyof typeof RETENTION_DAYS]
+          retention_days: RETENTION_DAYS[newRetention as keyof typeof RETENTION_DAYS],
+          enforce_retention: newEnforced
         });
         setEnabled(true);
       }
       setSchedule(newSchedule);
       setRetention(newRetention);
+      setEnforced(newEnforced);
     } catch (error) {
       console.error('Failed to update schedule:', error);
     }
@@ -186,6 +192,20 @@ export function BackupScheduleDialog({
                   </SelectContent>
                 </Select>
               </div>
+
+              <div className="flex items-center justify-between space-x-4">
+                <div className="space-y-0.5">
+                  <Label className="text-sm">Delete Old Backups</Label>
+                  <div className="text-sm text-muted-foreground">
+                    Delete backups older than the retention period
+                  </div>
+                </div>
+                <Switch
+                  checked={enforced}
+                  disabled={isScheduling || isUpdating}
+                  onCheckedChange={(checked) => handleScheduleSubmit(schedule, retention, checked)}
+                />
+              </div>
             </>
           )}
         </div>
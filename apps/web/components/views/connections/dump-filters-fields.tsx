@@ -0,0 +1,65 @@
+'use client';
+
+import { Label } from "@/components/ui/label";
+import { Input } from "@/components/ui/input";
+import { type DumpFilters } from "@/types/connection";
+import { type DatabaseType } from "@/types/base";
+
+export const DUMP_FILTER_TYPES: DatabaseType[] = ['postgresql', 'mysql', 'mariadb', 'mongodb'];
+
+interface DumpFiltersFieldsProps {
+  type: DatabaseType;
+  value?: DumpFilters;
+  onChange: (value: DumpFilters) => void;
+  idPrefix?: string;
+}
+
+interface FilterField {
+  key: keyof DumpFilters;
+  label: string;
+  placeholder: string;
+  types: DatabaseType[];
+}
+
+const fields: FilterField[] = [
+  { key: 'include_schemas', label: 'Include schemas', placeholder: 'public,billing', types: ['postgresql'] },
+  { key: 'exclude_schemas', label: 'Exclude schemas', placeholder: 'audit_*', types: ['postgresql'] },
+  { key: 'include_tables', label: 'Include tables', placeholder: 'users,orders', types: ['postgresql', 'mysql', 'mariadb', 'mongodb'] },
+  { key: 'exclude_tables', label: 'Exclude tables', placeholder: 'sessions,audit_log', types: ['postgresql', 'mysql', 'mariadb', 'mongodb'] },
+  { key: 'schema_only_tables', label: 'Schema only', placeholder: 'events', types: ['postgresql', 'mysql', 'mariadb'] },
+  { key: 'data_only_tables', label: 'Data only', placeholder: 'lookup_values', types: ['mysql', 'mariadb'] },
+];
+
+export function DumpFiltersFields({ type, value, onChange, idPrefix = "" }: DumpFiltersFieldsProps) {
+  const visible = fields.filter((field) => field.types.includes(type));
+  const filters = value || {};
+
+  return (
+    <div className="space-y-3 rounded-lg border p-3">
+      <div className="space-y-0.5">
+        <Label>Dump filters</Label>
+        <p className="text-xs text-muted-foreground">
+          Comma-separated {type === 'mongodb' ? 'collections' : 'names'} to include in or leave out of every backup.
+          {type === 'postgresql' && ' Schema only tables are dumped without their rows.'}
+          {(type === 'mysql' || type === 'mariadb') && ' Schema only tables are dumped without their rows, and data only tables without their structure.'}
+        </p>
+      </div>
+      <div className="grid grid-cols-2 gap-4">
+        {visible.map((field) => (
+          <div key={field.key} className="space-y-2">
+            <Label htmlFor={`${idPrefix}dump-${field.key}`}>
+              {type === 'mongodb' ? field.label.replace('tables', 'collections') : field.label}
+              <span className="text-xs text-muted-foreground ml-1">(optional)</span>
+            </Label>
+            <Input
+              id={`${idPrefix}dump-${field.key}`}
+              placeholder={field.placeholder}
+              value={(filters[field.key] || []).join(',')}
+              onChange={(e) => onChange({ ...filters, [field.key]: e.target.value ? e.target.value.split(',') : [] })}
+            />
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+}
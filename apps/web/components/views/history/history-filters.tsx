@@ -74,6 +74,7 @@ export function HistoryFilters({
             <SelectItem value="all">All Status</SelectItem>
             <SelectItem value="success">Success</SelectItem>
             <SelectItem value="completed">Completed</SelectItem>
+            <SelectItem value="partial">Partial</SelectItem>
             <SelectItem value="completed_with_errors">Completed With Errors</SelectItem>
             <SelectItem value="failed">Failed</SelectItem>
             <SelectItem value="in_progress">In Progress</SelectItem>
@@ -91,6 +92,7 @@ export function HistoryFilters({
             <SelectItem value="postgresql">PostgreSQL</SelectItem>
             <SelectItem value="mongodb">MongoDB</SelectItem>
             <SelectItem value="redis">Redis</SelectItem>
+            <SelectItem value="sqlite">SQLite</SelectItem>
           </SelectContent>
         </Select>
 
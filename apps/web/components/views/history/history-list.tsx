@@ -4,7 +4,7 @@ import { Card } from "@/components/ui/card";
 import { Badge } from "@/components/ui/badge";
 import { Button } from "@/components/ui/button";
 import { EmptyState } from "@/components/ui/empty-state";
-import { Database, Download, History, GitCompare, RefreshCw, RotateCcw, Terminal, Link2, Square } from "lucide-react";
+import { Database, Download, History, GitCompare, RefreshCw, RotateCcw, Terminal, Link2, Square, Pin } from "lucide-react";
 import { BackupJobViewer } from "@/components/views/backup/backup-job-viewer";
 import { DownloadBackupDialog } from "@/components/views/backup/download-backup-dialog";
 import { formatDistanceToNow, parseISO, subDays, isAfter } from "date-fns";
@@ -160,6 +160,12 @@ export function HistoryList() {
                               >
                                 {formatBackupStatus(item.status)}
                               </Badge>
+                              {(item.pinned || (item.hold_until && new Date(item.hold_until) > new Date())) && (
+                                <Badge variant="outline" className="text-xs">
+                                  <Pin className="h-3 w-3 mr-1" />
+                                  {item.pinned ? "Pinned" : "Held"}
+                                </Badge>
+                              )}
                             </div>
                             <p className="text-xs text-muted-foreground mt-2">
                               {formatDistanceToNow(parseISO(item.created_at), { addSuffix: true })}
@@ -238,6 +244,12 @@ export function HistoryList() {
                               <Badge variant="secondary" className="text-xs shrink-0">
                                 {item.database_type}
                               </Badge>
+                              {(item.pinned || (item.hold_until && new Date(item.hold_until) > new Date())) && (
+                                <Badge variant="outline" className="text-xs shrink-0">
+                                  <Pin className="h-3 w-3 mr-1" />
+                                  {item.pinned ? "Pinned" : "Held"}
+                                </Badge>
+                              )}
                             </div>
                             <p className="text-sm text-muted-foreground mt-1">
                               {formatDistanceToNow(parseISO(item.created_at), { addSuffix: true })} | {formatSize(item.size)}
@@ -0,0 +1,147 @@
+"use client";
+
+import { useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { HelpCircle } from "lucide-react";
+import { Tooltip, TooltipContent, TooltipProvider, TooltipTrigger } from "@/components/ui/tooltip";
+
+// Azure providers keep the account name in access_key, the account key in
+// secret_key and the container in bucket
+export interface AzureProviderValues {
+  endpoint: string;
+  bucket: string;
+  access_key: string;
+  secret_key: string;
+  sas_token: string;
+}
+
+interface AzureProviderFieldsProps {
+  values: AzureProviderValues;
+  onChange: (values: Partial<AzureProviderValues>) => void;
+  isEditing: boolean;
+}
+
+export function AzureProviderFields({ values, onChange, isEditing }: AzureProviderFieldsProps) {
+  const [authMethod, setAuthMethod] = useState<"key" | "sas">(values.sas_token ? "sas" : "key");
+
+  return (
+    <>
+      {/* Account Name and Container */}
+      <div className="grid grid-cols-2 gap-4">
+        <div className="space-y-2">
+          <Label htmlFor="azure-account">
+            Storage Account <span className="text-destructive">*</span>
+          </Label>
+          <Input
+            id="azure-account"
+            placeholder="velldbackups"
+            value={values.access_key}
+            onChange={(e) => onChange({ access_key: e.target.value })}
+          />
+        </div>
+
+        <div className="space-y-2">
+          <Label htmlFor="azure-container">
+            Container <span className="text-destructive">*</span>
+          </Label>
+          <Input
+            id="azure-container"
+            placeholder="backups"
+            value={values.bucket}
+            onChange={(e) => onChange({ bucket: e.target.value })}
+          />
+        </div>
+      </div>
+
+      <div className="space-y-2">
+        <Label>Authentication Method</Label>
+        <div className="grid grid-cols-2 gap-2">
+          <Button
+            type="button"
+            variant={authMethod === "key" ? "default" : "outline"}
+            size="sm"
+            onClick={() => {
+              setAuthMethod("key");
+              onChange({ sas_token: "" });
+            }}
+          >
+            Account Key
+          </Button>
+          <Button
+            type="button"
+            variant={authMethod === "sas" ? "default" : "outline"}
+            size="sm"
+            onClick={() => {
+              setAuthMethod("sas");
+              onChange({ secret_key: "" });
+            }}
+          >
+            SAS Token
+          </Button>
+        </div>
+      </div>
+
+      {authMethod === "key" ? (
+        <div className="space-y-2">
+          <Label htmlFor="azure-key">
+            Account Key {!isEditing && <span className="text-destructive">*</span>}
+            {isEditing && (
+              <span className="text-xs text-muted-foreground ml-1">(leave empty to keep existing)</span>
+            )}
+          </Label>
+          <Input
+            id="azure-key"
+            type="password"
+            placeholder={isEditing ? "Leave empty to keep existing" : "Base64 account key"}
+            value={values.secret_key}
+            onChange={(e) => onChange({ secret_key: e.target.value })}
+          />
+        </div>
+      ) : (
+        <div className="space-y-2">
+          <Label htmlFor="azure-sas">
+            SAS Token {!isEditing && <span className="text-destructive">*</span>}
+            {isEditing && (
+              <span className="text-xs text-muted-foreground ml-1">(leave empty to keep existing)</span>
+            )}
+          </Label>
+          <Input
+            id="azure-sas"
+            type="password"
+            placeholder={isEditing ? "Leave empty to keep existing" : "sv=2022-11-02&ss=b&srt=co&sp=rwdl..."}
+            value={values.sas_token}
+            onChange={(e) => onChange({ sas_token: e.target.value })}
+          />
+          <p className="text-xs text-muted-foreground">
+            The token needs read, write, delete and list permissions on the container.
+          </p>
+        </div>
+      )}
+
+      {/* Service URL */}
+      <div className="space-y-2">
+        <Label htmlFor="azure-endpoint">
+          Service URL (Optional)
+          <TooltipProvider>
+            <Tooltip>
+              <TooltipTrigger asChild>
+                <HelpCircle className="inline w-3.5 h-3.5 ml-1.5 text-muted-foreground" />
+              </TooltipTrigger>
+              <TooltipContent>
+                <p>Defaults to https://&lt;account&gt;.blob.core.windows.net. Set it for Azurite or sovereign clouds.</p>
+              </TooltipContent>
+            </Tooltip>
+          </TooltipProvider>
+        </Label>
+        <Input
+          id="azure-endpoint"
+          placeholder="http://127.0.0.1:10000/devstoreaccount1"
+          value={values.endpoint}
+          onChange={(e) => onChange({ endpoint: e.target.value })}
+        />
+      </div>
+    </>
+  );
+}
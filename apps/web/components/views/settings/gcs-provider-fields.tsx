@@ -0,0 +1,80 @@
+"use client";
+
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { HelpCircle } from "lucide-react";
+import { Tooltip, TooltipContent, TooltipProvider, TooltipTrigger } from "@/components/ui/tooltip";
+
+export interface GCSProviderValues {
+  endpoint: string;
+  bucket: string;
+  credentials_json: string;
+}
+
+interface GCSProviderFieldsProps {
+  values: GCSProviderValues;
+  onChange: (values: Partial<GCSProviderValues>) => void;
+  isEditing: boolean;
+}
+
+export function GCSProviderFields({ values, onChange, isEditing }: GCSProviderFieldsProps) {
+  return (
+    <>
+      <div className="space-y-2">
+        <Label htmlFor="gcs-bucket">
+          Bucket <span className="text-destructive">*</span>
+        </Label>
+        <Input
+          id="gcs-bucket"
+          placeholder="velld-backups"
+          value={values.bucket}
+          onChange={(e) => onChange({ bucket: e.target.value })}
+        />
+      </div>
+
+      <div className="space-y-2">
+        <Label htmlFor="gcs-credentials">
+          Service Account Key {!isEditing && <span className="text-destructive">*</span>}
+          {isEditing && (
+            <span className="text-xs text-muted-foreground ml-1">(leave empty to keep existing)</span>
+          )}
+        </Label>
+        <textarea
+          id="gcs-credentials"
+          placeholder={'{\n  "type": "service_account",\n  "client_email": "...",\n  "private_key": "..."\n}'}
+          value={values.credentials_json}
+          onChange={(e) => onChange({ credentials_json: e.target.value })}
+          rows={6}
+          className="w-full px-3 py-2 text-sm rounded-md border border-input bg-background font-mono"
+        />
+        <p className="text-xs text-muted-foreground">
+          Paste the JSON key of a service account with the Storage Object Admin role on the bucket. It will be
+          encrypted and stored securely.
+        </p>
+      </div>
+
+      {/* Endpoint */}
+      <div className="space-y-2">
+        <Label htmlFor="gcs-endpoint">
+          Endpoint (Optional)
+          <TooltipProvider>
+            <Tooltip>
+              <TooltipTrigger asChild>
+                <HelpCircle className="inline w-3.5 h-3.5 ml-1.5 text-muted-foreground" />
+              </TooltipTrigger>
+              <TooltipContent>
+                <p>Defaults to https://storage.googleapis.com. Set it for emulators such as fake-gcs-server.</p>
+              </TooltipContent>
+            </Tooltip>
+          </TooltipProvider>
+        </Label>
+        <Input
+          id="gcs-endpoint"
+          placeholder="http://localhost:4443"
+          value={values.endpoint}
+          onChange={(e) => onChange({ endpoint: e.target.value })}
+        />
+      </div>
+    </>
+  );
+}
@@ -99,20 +99,61 @@ export function S3ProvidersList() {
                         )}
                       </div>
                       <div className="space-y-1 text-sm text-muted-foreground">
-                        <p>
-                          <span className="font-medium">Endpoint:</span> {provider.endpoint}
-                        </p>
-                        <p>
-                          <span className="font-medium">Bucket:</span> {provider.bucket}
-                        </p>
-                        {provider.region && (
+                        {provider.type === "sftp" ? (
+                          <>
+                            <p>
+                              <span className="font-medium">SFTP:</span> {provider.username}@{provider.host}:{provider.port}
+                            </p>
+                            {provider.path_prefix && (
+                              <p>
+                                <span className="font-medium">Base Path:</span> {provider.path_prefix}
+                              </p>
+                            )}
+                          </>
+                        ) : provider.type === "filesystem" ? (
                           <p>
-                            <span className="font-medium">Region:</span> {provider.region}
+                            <span className="font-medium">Directory:</span> {provider.path_prefix}
                           </p>
+                        ) : provider.type === "gcs" ? (
+                          <>
+                            <p>
+                              <span className="font-medium">GCS Bucket:</span> {provider.bucket}
+                            </p>
+                            {provider.endpoint && (
+                              <p>
+                                <span className="font-medium">Endpoint:</span> {provider.endpoint}
+                              </p>
+                            )}
+                          </>
+                        ) : provider.type === "azure" ? (
+                          <>
+                            <p>
+                              <span className="font-medium">Azure Container:</span> {provider.bucket}
+                            </p>
+                            {provider.endpoint && (
+                              <p>
+                                <span className="font-medium">Service URL:</span> {provider.endpoint}
+                              </p>
+                            )}
+                          </>
+                        ) : (
+                          <>
+                            <p>
+                              <span className="font-medium">Endpoint:</span> {provider.endpoint}
+                            </p>
+                            <p>
+                              <span className="font-medium">Bucket:</span> {provider.bucket}
+                            </p>
+                            {provider.region && (
+                              <p>
+                                <span className="font-medium">Region:</span> {provider.region}
+                              </p>
+                            )}
+                            <p>
+                              <span className="font-medium">SSL:</span> {provider.use_ssl ? "Enabled" : "Disabled"}
+                            </p>
+                          </>
                         )}
-                        <p>
-                          <span className="font-medium">SSL:</span> {provider.use_ssl ? "Enabled" : "Disabled"}
-                        </p>
                       </div>
                     </div>
                     <div className="flex items-center gap-2 ml-4">
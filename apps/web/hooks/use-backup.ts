@@ -7,6 +7,7 @@ export interface ScheduleBackupParams {
   connection_id: string;
   cron_schedule: string;
   retention_days: number;
+  enforce_retention?: boolean;
 }
 
 export function useBackup() {
@@ -145,12 +146,16 @@ export function useBackup() {
   });
 
   const { mutate: restoreBackupToDatabase, isPending: isRestoring } = useMutation({
-    mutationFn: async (params: { backupId: string; connectionId: string; targetDatabaseName?: string; skipChecksumVerification?: boolean }) => {
+    mutationFn: async (params: { backupId: string; connectionId: string; targetDatabaseName?: string; skipChecksumVerification?: boolean; databases?: string[]; restoreGlobals?: boolean; privateKey?: string; privateKeyPassphrase?: string }) => {
       await restoreBackup({ 
         backup_id: params.backupId, 
         connection_id: params.connectionId,
         target_database_name: params.targetDatabaseName,
         skip_checksum_verification: params.skipChecksumVerification,
+        databases: params.databases,
+        restore_globals: params.restoreGlobals,
+        private_key: params.privateKey,
+        private_key_passphrase: params.privateKeyPassphrase,
       });
     },
     onSuccess: () => {
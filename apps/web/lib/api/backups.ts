@@ -1,4 +1,4 @@
-import { BackupListResponse, BackupStatsResponse, BackupDiffResponse, Backup } from '@/types/backup';
+import { BackupListResponse, BackupStatsResponse, BackupDiffResponse, Backup, PinResult, BackupDeletion } from '@/types/backup';
 import { apiRequest } from '../api-client';
 
 export interface GetBackupsParams {
@@ -12,6 +12,11 @@ export interface RestoreBackupParams {
   connection_id: string;
   target_database_name?: string; // Optional: restore to different database name
   skip_checksum_verification?: boolean; // Optional: skip checksum verification
+  jobs?: number; // Optional: parallel restore jobs (PostgreSQL custom-format backups)
+  databases?: string[]; // Optional: databases to restore from an all-databases backup
+  restore_globals?: boolean; // Optional: also restore roles and users from an all-databases backup
+  private_key?: string; // Required for backups encrypted to age or OpenPGP recipients
+  private_key_passphrase?: string; // Optional: unlocks a protected OpenPGP private key
 }
 
 export async function saveBackup(connectionId: string, s3ProviderIds?: string[]): Promise<{ id: string }> {
@@ -70,6 +75,9 @@ export async function downloadBackup(backupId: string, providerId?: string): Pro
 export interface BackupS3Provider {
   provider_id: string;
   object_key: string;
+  status: 'success' | 'failed' | 'missing' | 'corrupted'; // Only successful uploads can be downloaded
+  size: number;
+  error?: string;
 }
 
 export async function getBackupS3Providers(backupId: string): Promise<BackupS3Provider[]> {
@@ -100,6 +108,7 @@ export interface ScheduleBackupParams {
   connection_id: string;
   cron_schedule: string;
   retention_days: number;
+  enforce_retention?: boolean; // Left out keeps the schedule's setting; new schedules enforce retention
 }
 
 export async function scheduleBackup(params: ScheduleBackupParams): Promise<void> {
@@ -160,6 +169,39 @@ export async function stopBackup(backupId: string): Promise<void> {
   });
 }
 
+export async function pinBackup(backupId: string, reason: string, holdUntil?: string): Promise<PinResult> {
+  const response = await apiRequest<{ data: PinResult }>(`/api/backups/${backupId}/pin`, {
+    method: 'POST',
+    body: JSON.stringify({
+      reason,
+      hold_until: holdUntil, // Optional: hold until this date instead of pinning forever
+    }),
+  });
+  return response.data;
+}
+
+export async function unpinBackup(backupId: string): Promise<PinResult> {
+  const response = await apiRequest<{ data: PinResult }>(`/api/backups/${backupId}/pin`, {
+    method: 'DELETE',
+  });
+  return response.data;
+}
+
+export async function deleteBackup(backupId: string): Promise<BackupDeletion> {
+  const response = await apiRequest<{ data: BackupDeletion }>(`/api/backups/${backupId}`, {
+    method: 'DELETE',
+  });
+  return response.data;
+}
+
+export async function deleteBackups(backupIds: string[]): Promise<BackupDeletion[]> {
+  const response = await apiRequest<{ data: BackupDeletion[] }>('/api/backups/bulk-delete', {
+    method: 'POST',
+    body: JSON.stringify({ backup_ids: backupIds }),
+  });
+  return response.data;
+}
+
 export function streamBackupLogs(backupId: string, onLog: (log: string) => void, onError?: (error: Error) => void, onClose?: () => void): () => void {
   let abortController: AbortController | null = null;
   let isClosed = false;
@@ -1,14 +1,23 @@
 import { apiRequest } from "@/lib/api-client";
 
+export type ProviderType = "s3" | "sftp" | "azure" | "gcs" | "filesystem";
+
 export interface S3Provider {
   id: string;
   user_id: string;
   name: string;
+  type: ProviderType;
   endpoint: string;
   region?: string;
   bucket: string;
   use_ssl: boolean;
   path_prefix?: string;
+  host?: string;
+  port?: number;
+  username?: string;
+  host_key?: string; // SFTP server's public key, trusted on first connection
+  object_lock_mode?: "GOVERNANCE" | "COMPLIANCE"; // Set when uploads are locked with S3 Object Lock
+  object_lock_days?: number;
   is_default: boolean;
   created_at: string;
   updated_at: string;
@@ -16,16 +25,80 @@ export interface S3Provider {
 
 export interface S3ProviderRequest {
   name: string;
+  type?: ProviderType;
   endpoint: string;
   region?: string;
   bucket: string;
   access_key: string;
-  secret_key: string;
+  secret_key?: string;
   use_ssl?: boolean;
   path_prefix?: string;
+  host?: string;
+  port?: number;
+  username?: string;
+  password?: string;
+  private_key?: string;
+  host_key?: string; // Empty trusts the server's key on the next connection; left out keeps it
+  sas_token?: string;
+  credentials_json?: string;
+  object_lock_mode?: string; // Empty turns Object Lock off; left out keeps it
+  object_lock_days?: number;
   is_default?: boolean;
 }
 
+export interface ReconcileRequest {
+  delete_orphans?: boolean; // Only objects velld uploaded, on providers with a path prefix or base path
+  repair_missing?: boolean; // Copy broken objects back from a provider with an intact copy
+  mark_unrestorable?: boolean;
+}
+
+export interface OrphanObject {
+  object_key: string;
+  size: number;
+  last_modified: string;
+  deleted: boolean;
+  error?: string;
+}
+
+export interface ObjectIssue {
+  backup_id: string;
+  object_key: string;
+  expected_size: number;
+  actual_size: number;
+  repaired: boolean;
+  repaired_from?: string;
+  marked: boolean;
+  error?: string;
+}
+
+export interface ReconcileReport {
+  provider_id: string;
+  provider_name: string;
+  objects_listed: number;
+  uploads_checked: number;
+  orphans: OrphanObject[];
+  missing: ObjectIssue[];
+  size_mismatches: ObjectIssue[];
+  unrestorable: string[]; // Backups marked unrestorable
+  started_at: string;
+  finished_at: string;
+}
+
+export interface ManifestImport {
+  manifest_key: string;
+  backup_id?: string;
+  status: 'imported' | 'linked' | 'exists' | 'skipped';
+  error?: string;
+}
+
+export interface CatalogImport {
+  provider_id: string;
+  provider_name: string;
+  manifests_found: number;
+  imported: number;
+  results: ManifestImport[];
+}
+
 export async function listS3Providers(): Promise<S3Provider[]> {
   const response = await apiRequest<{ data: S3Provider[] }>('/api/s3-providers');
   return response.data || [];
@@ -70,3 +143,17 @@ export async function testS3Provider(id: string): Promise<void> {
   });
 }
 
+export async function reconcileS3Provider(id: string, request: ReconcileRequest = {}): Promise<ReconcileReport> {
+  const response = await apiRequest<{ data: ReconcileReport }>(`/api/s3-providers/${id}/reconcile`, {
+    method: 'POST',
+    body: JSON.stringify(request),
+  });
+  return response.data;
+}
+
+export async function importS3ProviderCatalog(id: string): Promise<CatalogImport> {
+  const response = await apiRequest<{ data: CatalogImport }>(`/api/s3-providers/${id}/import`, {
+    method: 'POST',
+  });
+  return response.data;
+}
@@ -1,5 +1,13 @@
 import { apiRequest } from "@/lib/api-client";
-import { UpdateSettingsRequest, GetSettingsResponse, UpdateSettingsResponse } from "@/types/settings";
+import {
+  UpdateSettingsRequest,
+  GetSettingsResponse,
+  UpdateSettingsResponse,
+  SelfBackupSettings,
+  SelfBackupRequest,
+  SelfBackupRun,
+  SelfBackupSnapshot,
+} from "@/types/settings";
 
 export async function getUserSettings(): Promise<GetSettingsResponse> {
   return apiRequest<GetSettingsResponse>('/api/settings');
@@ -62,3 +70,28 @@ export async function testTelegramConnection(params: TestTelegramConnectionReque
 export async function getTelegramChats(botToken: string): Promise<{ data: TelegramChat[] }> {
   return apiRequest<{ data: TelegramChat[] }>(`/api/settings/telegram-chats?bot_token=${encodeURIComponent(botToken)}`);
 }
+
+export async function getSelfBackupSettings(): Promise<SelfBackupSettings> {
+  const response = await apiRequest<{ data: SelfBackupSettings }>('/api/settings/self-backup');
+  return response.data;
+}
+
+export async function updateSelfBackupSettings(request: SelfBackupRequest): Promise<SelfBackupSettings> {
+  const response = await apiRequest<{ data: SelfBackupSettings }>('/api/settings/self-backup', {
+    method: 'PUT',
+    body: JSON.stringify(request),
+  });
+  return response.data;
+}
+
+export async function runSelfBackup(): Promise<SelfBackupRun> {
+  const response = await apiRequest<{ data: SelfBackupRun }>('/api/settings/self-backup/run', {
+    method: 'POST',
+  });
+  return response.data;
+}
+
+export async function listSelfBackupSnapshots(): Promise<SelfBackupSnapshot[]> {
+  const response = await apiRequest<{ data: SelfBackupSnapshot[] }>('/api/settings/self-backup/snapshots');
+  return response.data;
+}
@@ -19,8 +19,10 @@ export function formatBackupStatus(status: string): string {
   const statusMap: Record<string, string> = {
     'success': 'Success',
     'completed': 'Completed',
+    'partial': 'Partial',
     'completed_with_errors': 'Completed With Errors',
     'failed': 'Failed',
+    'unrestorable': 'Unrestorable',
     'in_progress': 'In Progress',
     'pending': 'Pending',
   };
@@ -15,6 +15,46 @@ export interface Backup {
   completed_time?: string;
   created_at: string;
   updated_at: string;
+  format?: string;
+  parent_id?: string;
+  children?: Backup[]; // Per-database backups of an all-databases backup
+  encryption_algorithm?: string; // Set when the uploaded backup is encrypted
+  encryption_key_id?: string;
+  compression?: string; // Codec the uploaded backup is compressed with
+  compression_level?: number;
+  pinned?: boolean; // Pinned backups are never deleted by retention
+  hold_until?: string; // Held backups are kept until this date
+  pin_reason?: string;
+  pinned_by?: string;
+  pinned_at?: string;
+}
+
+export interface ObjectLockResult {
+  backup_id: string;
+  provider_id: string;
+  object_key: string;
+  success: boolean;
+  error?: string;
+}
+
+export interface PinResult {
+  backup: Backup;
+  object_locks: ObjectLockResult[]; // Objects on providers with Object Lock
+}
+
+export interface ObjectDeletion {
+  backup_id: string;
+  provider_id: string;
+  object_key: string;
+  status: 'deleted' | 'pending' | 'skipped'; // Pending objects are retried later
+  error?: string;
+}
+
+export interface BackupDeletion {
+  backup_id: string;
+  deleted: boolean;
+  error?: string;
+  objects: ObjectDeletion[];
 }
 
 export interface BackupStats {
@@ -10,6 +10,7 @@ export interface Connection {
   password: string;
   database: string;
   database_name: string;
+  connection_uri?: string;
   database_size: number;
   ssl: boolean;
   ssh_enabled: boolean;
@@ -18,11 +19,23 @@ export interface Connection {
   ssh_username?: string;
   ssh_password?: string;
   ssh_private_key?: string;
+  redis_mode?: RedisMode;
+  redis_master_name?: string;
+  redis_nodes?: string[];
+  all_databases?: boolean;
+  include_databases?: string[];
+  exclude_databases?: string[];
+  dump_filters?: DumpFilters;
+  encrypt_backups?: boolean;
+  backup_recipients?: string[];
+  compression_codec?: CompressionCodec;
+  compression_level?: number;
   status: StatusColor;
   last_backup_time?: string;
   backup_enabled: boolean;
   cron_schedule?: string;
   retention_days?: number;
+  retention_enforced?: boolean; // Whether retention deletes the backups it doesn't keep
 }
 
 export type ConnectionForm = Pick<Connection, 
@@ -33,6 +46,7 @@ export type ConnectionForm = Pick<Connection,
   | "username" 
   | "password" 
   | "database" 
+  | "connection_uri"
   | "ssl"
   | "ssh_enabled"
   | "ssh_host"
@@ -40,8 +54,33 @@ export type ConnectionForm = Pick<Connection,
   | "ssh_username"
   | "ssh_password"
   | "ssh_private_key"
+  | "redis_mode"
+  | "redis_master_name"
+  | "redis_nodes"
+  | "all_databases"
+  | "include_databases"
+  | "exclude_databases"
+  | "dump_filters"
+  | "encrypt_backups"
+  | "backup_recipients"
+  | "compression_codec"
+  | "compression_level"
 >;
 
+export interface DumpFilters {
+  include_schemas?: string[];
+  exclude_schemas?: string[];
+  include_tables?: string[];
+  exclude_tables?: string[];
+  schema_only_tables?: string[];
+  data_only_tables?: string[];
+}
+
+export type RedisMode = 'standalone' | 'sentinel' | 'cluster';
+
+// An empty codec keeps the default compression
+export type CompressionCodec = '' | 'gzip' | 'zstd' | 'lz4' | 'none';
+
 export type ConnectionListResponse = Base<Connection[]>;
 
 export type SortBy = 'name' | 'status' | 'type' | 'lastBackup';
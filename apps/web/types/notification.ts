@@ -1,6 +1,6 @@
 import { Base } from "./base";
 
-export type NotificationType = 'backup_failed' | 'backup_completed';
+export type NotificationType = 'backup_failed' | 'backup_completed' | 'backup_partial';
 export type NotificationStatus = 'read' | 'unread';
 
 export interface Notification {
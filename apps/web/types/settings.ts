@@ -33,3 +33,50 @@ export type UpdateSettingsRequest = Partial<Omit<UserSettings, 'id' | 'user_id'>
 export type SettingsResponse = Base<UserSettings>;
 export type GetSettingsResponse = SettingsResponse;
 export type UpdateSettingsResponse = SettingsResponse;
+
+// Rules deciding which snapshots of Velld's own database are kept
+export interface SelfBackupRetention {
+  keep_last: number;
+  keep_daily: number;
+  keep_weekly: number;
+  keep_monthly: number;
+  keep_yearly: number;
+}
+
+export interface SelfBackupSettings {
+  s3_provider_id: string;
+  enabled: boolean;
+  cron_schedule: string;
+  retention_days: number;
+  retention: SelfBackupRetention;
+  next_run_time?: string;
+  last_run_at?: string;
+  last_status?: 'success' | 'failed';
+  last_error?: string;
+  last_object_key?: string;
+  last_size?: number;
+  updated_at: string;
+}
+
+export interface SelfBackupRequest {
+  s3_provider_id: string;
+  enabled: boolean;
+  cron_schedule: string;
+  retention_days: number;
+  retention?: SelfBackupRetention;
+}
+
+export interface SelfBackupRun {
+  object_key: string;
+  size: number;
+  database_size: number;
+  pruned: string[];
+  started_at: string;
+  finished_at: string;
+}
+
+export interface SelfBackupSnapshot {
+  object_key: string;
+  size: number;
+  last_modified: string;
+}